@@ -0,0 +1,321 @@
+"""
+Throughput benchmarks for Flashare's transfer paths.
+
+Runs the real ASGI app in-process (no sockets) against a temporary uploads
+directory, so numbers reflect the server code rather than the network.
+Paste the printed table into PRs that touch the transfer paths, once
+before and once after the change.
+
+Usage:
+    pip install httpx brotli
+    python benchmarks/transfers.py [--scale 64MB] [--repeat 3] [--memory] [--uploads-dir PATH]
+
+Point --uploads-dir at a slow disk (e.g. an HDD) to compare writing
+uploads directly against staging them in memory.
+"""
+
+import argparse
+import asyncio
+import random
+import statistics
+import sys
+import tempfile
+import time
+import tracemalloc
+from dataclasses import dataclass
+from pathlib import Path
+
+import httpx
+
+# Run against the source tree without installing
+sys.path.insert(0, str(Path(__file__).resolve().parent.parent / "src"))
+
+from flashare.config import config  # noqa: E402
+from flashare.core.compression import available_encodings  # noqa: E402
+from flashare.core.metadata import save_meta  # noqa: E402
+from flashare.core.staging import StagedStorage  # noqa: E402
+from flashare.core.storage import get_storage  # noqa: E402
+from flashare.core.units import parse_size  # noqa: E402
+from flashare.cli.ui import _format_size as format_size  # noqa: E402
+
+
+SEED = 1234
+SMALL_FILES = 200
+SMALL_FILE_SIZE = 16 * 1024
+CONCURRENCY = 8
+LIST_FILES = 20000
+
+_WORDS = (
+    "flashare share file upload download phone laptop network local fast "
+    "stream chunk compress folder photo video document archive transfer"
+).split()
+
+
+# ==================== Corpora ====================
+
+def compressible_corpus(size: int, seed: int = SEED) -> bytes:
+    """Deterministic text-like data that compresses well."""
+    rng = random.Random(seed)
+    lines = []
+    length = 0
+    while length < size:
+        line = " ".join(rng.choice(_WORDS) for _ in range(12)) + "\n"
+        lines.append(line)
+        length += len(line)
+    return "".join(lines).encode()[:size]
+
+
+def incompressible_corpus(size: int, seed: int = SEED) -> bytes:
+    """Deterministic random bytes that don't compress at all."""
+    return random.Random(seed).randbytes(size)
+
+
+# ==================== Harness ====================
+
+@dataclass
+class Result:
+    """Timing of one scenario across its runs."""
+    name: str
+    bytes_per_op: int  # Or items, for scenarios not measured in bytes
+    seconds: list[float]
+    peak_memory: int = 0
+    unit: str = "MB"
+    unit_size: int = 1024**2
+
+    def rate(self, seconds: float) -> float:
+        return self.bytes_per_op / seconds / self.unit_size
+
+    @property
+    def throughput(self) -> float:
+        """Units per second for the median run."""
+        return self.rate(statistics.median(self.seconds))
+
+
+def make_client() -> httpx.AsyncClient:
+    """Client wired straight to a fresh app instance, bypassing the network."""
+    from flashare.server import create_app
+
+    transport = httpx.ASGITransport(app=create_app(), client=("127.0.0.1", 50000))
+    return httpx.AsyncClient(transport=transport, base_url="http://flashare", timeout=None)
+
+
+def reset_uploads():
+    """Empty the uploads directory between runs so names never collide."""
+    storage = get_storage()
+    if isinstance(storage, StagedStorage):
+        storage.drain()  # Otherwise the last run's flushes would land in this one
+    for path in config.uploads_dir.iterdir():
+        if path.is_file():
+            path.unlink()
+
+
+async def measure(name: str, bytes_per_op: int, scenario, repeat: int, memory: bool, unit: str = "MB") -> Result:
+    """
+    Run a scenario several times against a fresh share.
+
+    Args:
+        name: Row label.
+        bytes_per_op: Payload bytes moved by one run, for MB/s.
+        scenario: Coroutine function taking an AsyncClient.
+        repeat: Number of timed runs.
+        memory: Also trace peak Python memory (slows the run down).
+        unit: "MB", or "files" when bytes_per_op counts files.
+    """
+    result = Result(name, bytes_per_op, [], unit=unit, unit_size=1024**2 if unit == "MB" else 1)
+    async with make_client() as client:
+        for _ in range(repeat):
+            reset_uploads()
+            await scenario(client, prepare=True)
+            if memory:
+                tracemalloc.start()
+            started = time.perf_counter()
+            await scenario(client, prepare=False)
+            result.seconds.append(time.perf_counter() - started)
+            if memory:
+                result.peak_memory = max(result.peak_memory, tracemalloc.get_traced_memory()[1])
+                tracemalloc.stop()
+    return result
+
+
+# ==================== Scenarios ====================
+
+def upload_large(data: bytes):
+    async def scenario(client: httpx.AsyncClient, prepare: bool):
+        if not prepare:
+            response = await client.post("/api/upload", files={"file": ("large.bin", data)})
+            response.raise_for_status()
+    return scenario
+
+
+def upload_many(files: list[bytes]):
+    async def scenario(client: httpx.AsyncClient, prepare: bool):
+        if not prepare:
+            payload = [("files", (f"small_{i}.txt", data)) for i, data in enumerate(files)]
+            response = await client.post("/api/upload-multiple", files=payload)
+            response.raise_for_status()
+    return scenario
+
+
+def download(data: bytes, encoding: str):
+    async def scenario(client: httpx.AsyncClient, prepare: bool):
+        if prepare:
+            response = await client.post("/api/upload", files={"file": ("download.txt", data)})
+            response.raise_for_status()
+            return
+        # Stream the raw body so client-side decompression isn't timed
+        headers = {"Accept-Encoding": encoding}
+        async with client.stream("GET", "/api/download/download.txt", headers=headers) as response:
+            response.raise_for_status()
+            async for _ in response.aiter_raw():
+                pass
+    return scenario
+
+
+def mixed(data: bytes):
+    async def scenario(client: httpx.AsyncClient, prepare: bool):
+        if prepare:
+            response = await client.post("/api/upload", files={"file": ("shared.bin", data)})
+            response.raise_for_status()
+            return
+
+        async def upload(i: int):
+            response = await client.post("/api/upload", files={"file": (f"mixed_{i}.bin", data)})
+            response.raise_for_status()
+
+        async def fetch():
+            async with client.stream("GET", "/api/download/shared.bin") as response:
+                response.raise_for_status()
+                async for _ in response.aiter_raw():
+                    pass
+
+        await asyncio.gather(*(
+            upload(i) if i % 2 else fetch() for i in range(CONCURRENCY)
+        ))
+    return scenario
+
+
+def list_many(count: int):
+    async def scenario(client: httpx.AsyncClient, prepare: bool):
+        if prepare:
+            # Uploads always leave a sidecar, which the listing reads per file
+            for i in range(count):
+                name = f"listed_{i}.txt"
+                (config.uploads_dir / name).write_bytes(b"x")
+                save_meta(name, {"sha256": {"hex": "0" * 64, "mtime": 0, "size": 1}})
+            return
+        response = await client.get("/api/files")
+        response.raise_for_status()
+    return scenario
+
+
+async def measure_staged(name: str, size: int, scenario, repeat: int, memory: bool) -> Result:
+    """measure() with uploads staged in memory (--staging memory) instead of written straight to disk."""
+    config.staging_mode = "memory"
+    get_storage.cache_clear()
+    try:
+        return await measure(name, size, scenario, repeat, memory)
+    finally:
+        get_storage().drain()
+        config.staging_mode = "disk"
+        get_storage.cache_clear()
+
+
+async def run(scale: int, repeat: int, memory: bool, only: list[str]) -> list[Result]:
+    text = compressible_corpus(scale)
+    noise = incompressible_corpus(scale)
+    small = [compressible_corpus(SMALL_FILE_SIZE, seed=SEED + i) for i in range(SMALL_FILES)]
+    mixed_size = max(scale // CONCURRENCY, 1)
+    # Larger uploads skip staging, so compare at a size that is staged
+    staged_size = min(scale, config.staging_threshold)
+
+    scenarios = {
+        "upload-large": (f"upload 1 × {format_size(scale)}", scale, upload_large(noise)),
+        "upload-many": (
+            f"upload {SMALL_FILES} × {format_size(SMALL_FILE_SIZE)} (multipart)",
+            SMALL_FILES * SMALL_FILE_SIZE, upload_many(small),
+        ),
+        "download-identity": (f"download {format_size(scale)} text, identity", scale, download(text, "identity")),
+        "download-zstd": (f"download {format_size(scale)} text, zstd", scale, download(text, "zstd")),
+        "download-br": (f"download {format_size(scale)} text, br", scale, download(text, "br")),
+        "download-gzip": (f"download {format_size(scale)} text, gzip", scale, download(text, "gzip")),
+        "mixed": (
+            f"mixed {CONCURRENCY} concurrent × {format_size(mixed_size)}",
+            CONCURRENCY * mixed_size, mixed(incompressible_corpus(mixed_size)),
+        ),
+    }
+
+    results = []
+    for key, (name, size, scenario) in scenarios.items():
+        if only and key not in only:
+            continue
+        if key == "download-br" and "br" not in available_encodings():
+            print("skipping download-br: brotli isn't installed", file=sys.stderr)
+            continue
+        print(f"running {key}...", file=sys.stderr)
+        results.append(await measure(name, size, scenario, repeat, memory))
+    # Same upload both ways, timed until the client has its response; staged
+    # files are flushed afterwards, so the disk catches up between runs
+    if not only or "upload-staged" in only:
+        print("running upload-staged...", file=sys.stderr)
+        staged_noise = noise[:staged_size]
+        results.append(await measure(
+            f"upload 1 × {format_size(staged_size)}, direct to disk", staged_size,
+            upload_large(staged_noise), repeat, memory,
+        ))
+        results.append(await measure_staged(
+            f"upload 1 × {format_size(staged_size)}, staged in memory", staged_size,
+            upload_large(staged_noise), repeat, memory,
+        ))
+    # Run once with --list-concurrency 1 (file by file) and once with more threads to compare
+    if not only or "list-many" in only:
+        print("running list-many...", file=sys.stderr)
+        name = f"list {LIST_FILES} files, {config.list_concurrency} thread(s)"
+        results.append(await measure(name, LIST_FILES, list_many(LIST_FILES), repeat, memory, unit="files"))
+    return results
+
+
+def print_table(results: list[Result], memory: bool):
+    """Markdown table ready to paste into a PR description."""
+    header = "| scenario | per second (median) | best | worst |"
+    rule = "|---|---:|---:|---:|"
+    if memory:
+        header += " peak memory |"
+        rule += "---:|"
+    print(header)
+    print(rule)
+    for r in results:
+        best, worst = min(r.seconds), max(r.seconds)
+        row = (
+            f"| {r.name} | {r.throughput:.1f} {r.unit} "
+            f"| {r.rate(best):.1f} {r.unit} | {r.rate(worst):.1f} {r.unit} |"
+        )
+        if memory:
+            row += f" {format_size(r.peak_memory)} |"
+        print(row)
+
+
+def main():
+    parser = argparse.ArgumentParser(description="Benchmark Flashare's transfer paths in-process")
+    parser.add_argument("--scale", type=parse_size, default=parse_size("64MB"),
+                        help="Size of the large-file scenarios (default: 64MB)")
+    parser.add_argument("--repeat", type=int, default=3, help="Timed runs per scenario (default: 3)")
+    parser.add_argument("--memory", action="store_true", help="Report peak Python memory per run")
+    parser.add_argument("--only", nargs="*", default=[], metavar="SCENARIO",
+                        help="Run only these scenarios, e.g. upload-large list-many")
+    parser.add_argument("--uploads-dir", type=Path, default=None, metavar="PATH",
+                        help="Create the share's temporary folder here, e.g. on the disk being compared")
+    parser.add_argument("--list-concurrency", type=int, default=config.list_concurrency, metavar="N",
+                        help=f"Threads for the list-many scenario (default: {config.list_concurrency})")
+    args = parser.parse_args()
+    config.list_concurrency = args.list_concurrency
+
+    with tempfile.TemporaryDirectory(prefix="flashare-bench-", dir=args.uploads_dir) as tmp:
+        config.uploads_dir = Path(tmp)
+        get_storage.cache_clear()
+        results = asyncio.run(run(args.scale, args.repeat, args.memory, args.only))
+
+    print_table(results, args.memory)
+
+
+if __name__ == "__main__":
+    main()
@@ -1,20 +1,89 @@
 """API routes for Flashare - Enhanced with parallel processing and batch uploads."""
 
 import os
+import re
 import asyncio
-from pathlib import Path
-from typing import Optional, List
+import hashlib
+import secrets
+import shutil
+import threading
+import time
+import zipfile
+from pathlib import Path, PurePosixPath
+from typing import Optional, List, Iterator, AsyncIterator, BinaryIO, Callable
 from concurrent.futures import ThreadPoolExecutor
 import functools
+import contextvars
+import itertools
+from contextlib import closing
+from datetime import datetime, timezone
+from email.utils import formatdate
+from urllib.parse import quote
 
-from fastapi import APIRouter, HTTPException, UploadFile, File, BackgroundTasks
-from fastapi.responses import StreamingResponse, HTMLResponse, Response
-import aiofiles
+from fastapi import APIRouter, Depends, HTTPException, UploadFile, BackgroundTasks, Query, Request
+from fastapi.responses import StreamingResponse, HTMLResponse, Response, JSONResponse
+from pydantic import BaseModel
+from starlette.background import BackgroundTask
+from starlette.datastructures import FormData
+from starlette.exceptions import HTTPException as StarletteHTTPException
+from starlette.requests import ClientDisconnect
 
 from flashare.config import config
-from flashare.core.compression import generate_compressed_stream
+from flashare.api.semantics import parse_range, select_representation, Representation
+from flashare.core.compression import (
+    available_encodings,
+    buffer_pool,
+    compress_whole,
+    generate_encoded_stream,
+    worth_compressing,
+)
+from flashare.core.access import share_access, share_url, short_url, qr_url
+from flashare.core.announcements import announcements, Announcement
+from flashare.core.batch import run_batch, BatchStep, BatchInvalid, MAX_BATCH_OPERATIONS
+from flashare.core.branding import get_branding, load_logo
+from flashare.core.capabilities import get_capabilities
+from flashare.core.checksums import chunk_manifest, file_checksum, ALGORITHMS
+from flashare.core.claims import claims
+from flashare.core.collect import collection_links, Collection
+from flashare.core.correlation import log
+from flashare.core.debug import build_bundle
+from flashare.core.dedupe import link_duplicate
+from flashare.core.encodedcache import encoded_cache
+from flashare.core.devices import devices, has_role, resolve_device_id, DEVICE_COOKIE
+from flashare.core.dirsize import dir_sizes
+from flashare.core.estimate import estimate_archive
+from flashare.core.events import hub, format_event
+from flashare.core.excludes import PathFilter
+from flashare.core.extract import is_zip, expanded_size, extract_zip, unique_folder, UnsafeArchiveError
+from flashare.core.fairness import fair_share
+from flashare.core.health import storage_health
+from flashare.core.ffmpeg import is_video_file
+from flashare.core.locks import file_locks
+from flashare.core.logbook import logbook, LEVELS
+from flashare.core.metadata import delete_meta, load_meta, update_meta, find_by_sha256, sha256_index
+from flashare.core.mime import guess_mime_type, mime_mismatch
+from flashare.core.ndjson import ndjson
 from flashare.core.qr import get_qr_data, generate_qr_png_bytes
+from flashare.core.quarantine import quarantine, list_failed
+from flashare.core.relay import relay
 from flashare.core.network import get_server_url
+from flashare.core.organize import render_folder, date_folder, capture_time, move_into
+from flashare.core.paths import sanitize_filename, content_disposition, is_hidden_name
+from flashare.core.pipeline import pipeline
+from flashare.core.permissions import restrict
+from flashare.core.progress import ProgressCounter
+from flashare.core.schedule import deletions
+from flashare.core.security import security
+from flashare.core.sorting import natural_key
+from flashare.core.stats import stats, Transfer
+from flashare.core.storage import get_storage, Storage, LocalStorage, StorageEntry
+from flashare.core.transcode import transcoder, web_path
+from flashare.core.tus import tus_uploads, TusUpload, TUS_VERSION, TUS_EXTENSIONS, parse_metadata, format_metadata
+from flashare.core.units import parse_size, parse_duration
+from flashare.core.verify import sweep, IntegritySweep, FIX_ACTIONS
+from flashare.core.watcher import watcher
+from flashare.core.zipjobs import zip_jobs, ZipJob, JobRejected
+from flashare.core.zipstream import stream_archive, walk_folder, TooDeep, ARCHIVE_FORMATS
 
 
 router = APIRouter()
@@ -22,6 +91,12 @@ router = APIRouter()
 # Thread pool for CPU-bound operations
 executor = ThreadPoolExecutor(max_workers=4)
 
+# Client-chosen transfer IDs must be long enough to be unguessable
+TRANSFER_TOKEN_PATTERN = re.compile(r"[A-Za-z0-9_-]{16,64}")
+
+# Seconds between progress events on the live-update stream
+PROGRESS_INTERVAL = 1.0
+
 
 # ==================== Utility Functions (Lambda-style) ====================
 
@@ -32,6 +107,12 @@ format_size = lambda size_bytes: (
     f"{size_bytes/1024**3:.1f} GB"
 )
 
+# Unix float by default for backward compatibility; RFC 3339 (UTC) on request
+format_timestamp = lambda ts: (
+    datetime.fromtimestamp(ts, timezone.utc).isoformat(timespec="milliseconds").replace("+00:00", "Z")
+    if config.time_format == "rfc3339" else ts
+)
+
 get_file_extension = lambda filename: Path(filename).suffix.lower()[1:] if Path(filename).suffix else ""
 
 is_image = lambda filename: get_file_extension(filename) in {"jpg", "jpeg", "png", "gif", "webp", "svg", "heic", "bmp"}
@@ -40,319 +121,3290 @@ is_audio = lambda filename: get_file_extension(filename) in {"mp3", "wav", "flac
 is_document = lambda filename: get_file_extension(filename) in {"pdf", "doc", "docx", "txt", "rtf", "md", "xls", "xlsx", "csv"}
 
 
+FILE_TYPE_PREDICATES = [
+    (is_image, "image"),
+    (is_video, "video"),
+    (is_audio, "audio"),
+    (is_document, "document"),
+]
+
+# Group order for sort=type, in the order types are checked
+FILE_TYPE_ORDER = [category for _, category in FILE_TYPE_PREDICATES] + ["file"]
+
+
 def get_file_type(filename: str) -> str:
     """Categorize file by type using lambda predicates."""
-    predicates = [
-        (is_image, "image"),
-        (is_video, "video"),
-        (is_audio, "audio"),
-        (is_document, "document"),
-    ]
-    return next((category for predicate, category in predicates if predicate(filename)), "file")
+    return next((category for predicate, category in FILE_TYPE_PREDICATES if predicate(filename)), "file")
+
+
+def sort_files(files: list[dict], sort: str) -> list[dict]:
+    """
+    Order a file listing deterministically.
+    
+    Every order ends with the natural name order and then the file ID, so
+    files sharing a timestamp or type never swap places between listings.
+    
+    Args:
+        files: File info dicts from _get_file_info.
+        sort: "modified" (newest first), "name" or "type".
+    """
+    by_name = sorted(files, key=lambda f: (natural_key(f["name"]), f["id"]))
+    if sort == "modified":
+        # Stable sort keeps the name order within equal timestamps
+        return sorted(by_name, key=lambda f: f["modified"], reverse=True)
+    if sort == "type":
+        return sorted(by_name, key=lambda f: FILE_TYPE_ORDER.index(f["type"]))
+    return by_name
+
+
+def require_storage():
+    """
+    Make sure the uploads directory is still there before touching it.
+    
+    If it was deleted or its drive unmounted while running, it is either
+    re-created (config.auto_create_dir, only when missing) or the request
+    fails with 503 'storage_unavailable', instead of lists coming back
+    empty and uploads failing obscurely. storage_health tracks the state.
+    """
+    if config.storage_backend != "local":
+        return
+    
+    if config.auto_create_dir and not config.uploads_dir.exists():
+        config.uploads_dir.mkdir(parents=True, exist_ok=True)
+        restrict(config.uploads_dir)
+        storage_health.watch(config.uploads_dir)
+        log(f"⚠️  Uploads directory was missing, re-created {config.uploads_dir}", "warning")
+    
+    if not storage_health.probe(config.uploads_dir):
+        raise CodedHTTPException(503, f"Storage unavailable: {storage_health.reason}", "storage_unavailable")
+
+
+# Since when the host has paused new uploads (None while accepting them)
+_uploads_paused_at: Optional[float] = None
+
+# Seconds clients are told to wait before retrying a paused upload
+PAUSED_RETRY_AFTER = 30
+
+
+def require_uploads_open():
+    """Refuse new uploads with 503 while the host has them paused."""
+    if _uploads_paused_at is not None:
+        raise HTTPException(
+            status_code=503,
+            detail="Uploads are paused by the host; try again shortly",
+            headers={"Retry-After": str(PAUSED_RETRY_AFTER)},
+        )
+
+
+def _get_path_filter() -> PathFilter:
+    """Build the share filter from the configured exclude/include rules."""
+    return PathFilter(
+        config.exclude_patterns,
+        config.include_patterns,
+        config.use_default_excludes,
+    )
 
 
 async def run_in_executor(func, *args):
-    """Run blocking function in thread pool executor."""
+    """Run blocking function in thread pool executor, keeping the request's ID for its log lines."""
     loop = asyncio.get_event_loop()
-    return await loop.run_in_executor(executor, functools.partial(func, *args))
+    context = contextvars.copy_context()
+    return await loop.run_in_executor(executor, functools.partial(context.run, func, *args))
 
 
-# ==================== File Operations ====================
+def get_device_id(request: Request) -> str:
+    """Identify the requesting device by its cookie, falling back to IP."""
+    return resolve_device_id(request.cookies.get(DEVICE_COOKIE), request.client.host)
 
-async def _save_uploaded_file(file: UploadFile) -> dict:
+
+def _is_host(request: Request) -> bool:
+    """Requests from this machine itself come from the host."""
+    return request.client is not None and request.client.host in ("127.0.0.1", "::1")
+
+
+def _has_admin_token(request: Request) -> bool:
+    """Whether the request carries the configured admin token."""
+    supplied = request.headers.get("authorization", "").removeprefix("Bearer ").strip()
+    return bool(config.admin_token) and secrets.compare_digest(supplied, config.admin_token)
+
+
+def is_host(request: Request) -> bool:
+    """The host machine, or anyone presenting the admin token."""
+    return _is_host(request) or _has_admin_token(request)
+
+
+def _require_admin(request: Request):
+    """Reject requests that don't carry the configured admin token."""
+    if not config.admin_token:
+        raise HTTPException(status_code=403, detail="Admin endpoints are disabled (set FLASHARE_ADMIN_TOKEN)")
+    
+    if not _has_admin_token(request):
+        raise HTTPException(status_code=401, detail="Invalid admin token")
+
+
+def get_transfer_token(request: Request) -> Optional[str]:
     """
-    Save an uploaded file and return result.
+    Client-chosen transfer ID from X-Transfer-Id or ?transfer=, if well formed.
     
-    Uses efficient chunked writing for large files.
+    Plain download links can't set headers, hence the query parameter.
     """
-    if not file.filename:
-        return {"success": False, "error": "No filename provided"}
+    token = request.headers.get("x-transfer-id") or request.query_params.get("transfer")
+    return token if token and TRANSFER_TOKEN_PATTERN.fullmatch(token) else None
+
+
+def get_client_mtime(request: Request) -> Optional[float]:
+    """File modification time the client sent in X-File-Modified (milliseconds, like File.lastModified)."""
+    try:
+        return int(request.headers.get("x-file-modified", "")) / 1000
+    except ValueError:
+        return None
+
+
+def get_role(request: Request) -> str:
+    """
+    The role the request acts with.
     
-    # Sanitize filename
-    safe_filename = Path(file.filename).name
-    file_path = config.uploads_dir / safe_filename
+    The host machine and the admin token are always 'host'. Without
+    config.device_roles every other device is 'trusted', as before roles
+    existed; with it, devices are guests until promoted.
+    """
+    if is_host(request):
+        return "host"
+    if not config.device_roles:
+        return "trusted"
+    return devices.role(get_device_id(request), request.client.host if request.client else "")
+
+
+def require_role(needed: str) -> Callable[[Request], None]:
+    """Dependency refusing devices below the needed role with 403."""
+    def check(request: Request):
+        if not has_role(get_role(request), needed):
+            raise HTTPException(status_code=403, detail=f"This needs the '{needed}' role; ask the host")
+    return check
+
+
+def _require_host(request: Request):
+    """Allow the host machine, host devices, or anyone presenting the admin token."""
+    if get_role(request) != "host":
+        _require_admin(request)
+
+
+class CodedHTTPException(HTTPException):
+    """An HTTP error whose JSON body also carries a machine-readable 'code'."""
     
-    # Handle duplicate filenames
-    counter = 1
-    original_stem = file_path.stem
-    while file_path.exists():
-        file_path = config.uploads_dir / f"{original_stem}_{counter}{file_path.suffix}"
-        counter += 1
+    def __init__(self, status_code: int, detail: str, code: str):
+        super().__init__(status_code=status_code, detail=detail)
+        self.code = code
+
+
+def _owner_problem(filename: str, role: str, device: str) -> Optional[str]:
+    """
+    Why the device may not delete or replace a file, or None if it may.
     
-    try:
-        # Save the file with async I/O
-        async with aiofiles.open(file_path, 'wb') as f:
-            while chunk := await file.read(config.chunk_size):
-                await f.write(chunk)
-        
-        stat = file_path.stat()
-        return {
-            "success": True,
-            "filename": file_path.name,
-            "size": stat.st_size,
-            "size_human": format_size(stat.st_size),
-            "type": get_file_type(file_path.name),
-        }
-    except Exception as e:
-        return {"success": False, "error": str(e), "filename": safe_filename}
+    Only applies with config.owner_only_modify, and only to files whose
+    upload recorded an owner; the host may always act.
+    """
+    if not config.owner_only_modify or role == "host":
+        return None
+    owner = load_meta(filename).get("owner")
+    if owner and owner != device:
+        return "Only the device that uploaded this file can change it; ask the host"
+    return None
 
 
-async def _get_file_info(file_path: Path) -> dict:
-    """Get file info dictionary - async-optimized."""
-    stat = await run_in_executor(file_path.stat)
-    return {
-        "name": file_path.name,
-        "size": stat.st_size,
-        "size_human": format_size(stat.st_size),
-        "modified": stat.st_mtime,
-        "type": get_file_type(file_path.name),
-    }
+async def _check_owner(filename: str, role: str, device: str):
+    """Reject changes to another device's file with 403 'not_owner'."""
+    problem = await run_in_executor(_owner_problem, filename, role, device)
+    if problem:
+        raise CodedHTTPException(403, problem, "not_owner")
 
 
-# ==================== API Endpoints ====================
+def remove_file(filename: str):
+    """Delete a stored file together with its metadata and claim codes."""
+    storage = get_storage()
+    with file_locks.hold([filename]):  # Waits for a batch changing the file
+        size = storage.stat(filename).size
+        storage.delete(filename)
+        delete_meta(filename)
+    forget_file(filename, size)
+
+
+def forget_file(filename: str, size: int):
+    """Drop everything that refers to a file that is gone (size bytes), and tell the clients."""
+    dir_sizes.add(filename, -size)
+    claims.invalidate_file(filename)
+    announcements.invalidate_file(filename)
+    deletions.cancel(filename)
+    transcoder.forget(filename)
+    hub.publish("files", {"removed": filename})
+
 
-@router.get("/api/files")
-async def list_files():
+def run_scheduled_deletions() -> int:
     """
-    List all available files in the uploads directory.
-    
-    Uses parallel processing for faster file stat operations.
+    Delete every file whose scheduled time has passed.
     
     Returns:
-        List of file information dictionaries sorted by modification time.
+        Number of files deleted.
     """
-    if not config.uploads_dir.exists():
-        return []
-    
-    # Get list of file paths
-    file_paths = [
-        f for f in config.uploads_dir.iterdir() 
-        if f.is_file() and not f.name.startswith('.')
-    ]
-    
-    if not file_paths:
-        return []
-    
-    # Process files in parallel using asyncio.gather
-    tasks = [_get_file_info(fp) for fp in file_paths]
-    files = await asyncio.gather(*tasks)
+    count = 0
+    for filename in deletions.due():
+        try:
+            remove_file(filename)
+            count += 1
+        except FileNotFoundError:
+            # Already gone some other way; just forget it
+            deletions.cancel(filename)
+    return count
+
+
+# ==================== File Operations ====================
+
+# Zero chunks are skipped with a seek so sparse files stay sparse on disk
+_is_zero_chunk = lambda chunk: chunk.count(0) == len(chunk)
+
+
+class UploadRejected(Exception):
+    """An upload refused by policy, carrying the HTTP status to report."""
     
-    # Sort by modification time (newest first) using lambda
-    files_sorted = sorted(files, key=lambda x: x["modified"], reverse=True)
+    def __init__(self, status: int, message: str, remaining: Optional[int] = None):
+        super().__init__(message)
+        self.status = status
+        self.message = message
+        self.remaining = remaining
     
-    return files_sorted
+    def body(self) -> dict:
+        """JSON error body, including the device's remaining allowance if relevant."""
+        body = {"detail": self.message}
+        if self.remaining is not None:
+            body["remaining"] = self.remaining
+        return body
+
 
+def _quota_exceeded(remaining: int) -> UploadRejected:
+    """Build the error for a device that has used up its upload quota."""
+    return UploadRejected(429, f"Device upload quota exceeded ({format_size(remaining)} remaining)", remaining)
 
-@router.get("/api/download/{filename}")
-async def download_file(filename: str, compressed: bool = True):
+
+def check_declared_upload_size(declared: Optional[str], device: Optional[str] = None) -> Optional[UploadRejected]:
     """
-    Download a file with optional Zstandard compression.
+    Validate a client's X-File-Size hint before the body is read.
+    
+    Lets cooperating clients fail fast instead of streaming gigabytes
+    that would be rejected anyway. Malformed hints are ignored.
     
     Args:
-        filename: Name of the file to download.
-        compressed: Whether to use Zstd compression (default: True).
+        declared: Raw X-File-Size header value.
+        device: Uploading device, for per-device quota checks.
         
     Returns:
-        StreamingResponse with the file content.
+        The rejection if the upload should be refused, otherwise None.
     """
-    file_path = config.uploads_dir / filename
+    try:
+        size = int(declared or "")
+    except ValueError:
+        return None
     
-    if not file_path.exists():
-        raise HTTPException(status_code=404, detail="File not found")
+    if config.max_upload_size and size > config.max_upload_size:
+        return UploadRejected(413, f"File too large ({format_size(size)}), limit is {format_size(config.max_upload_size)}")
     
-    if not file_path.is_file():
-        raise HTTPException(status_code=400, detail="Not a file")
+    remaining = devices.remaining(device) if device else None
+    if remaining is not None and size > remaining:
+        return _quota_exceeded(remaining)
     
-    # Security: ensure the path is within uploads directory
-    try:
-        file_path.resolve().relative_to(config.uploads_dir.resolve())
-    except ValueError:
-        raise HTTPException(status_code=403, detail="Access denied")
+    if config.storage_backend == "local" and config.uploads_dir.exists():
+        free = shutil.disk_usage(config.uploads_dir).free
+        if size > free:
+            return UploadRejected(507, f"Not enough disk space ({format_size(size)} needed, {format_size(free)} free)")
     
-    if compressed:
-        return StreamingResponse(
-            generate_compressed_stream(file_path),
-            media_type="application/octet-stream",
-            headers={
-                "Content-Encoding": "zstd",
-                "Content-Disposition": f'attachment; filename="{filename}"',
-            }
-        )
-    else:
-        async def file_iterator():
-            async with aiofiles.open(file_path, 'rb') as f:
-                while chunk := await f.read(config.chunk_size):
-                    yield chunk
-        
-        return StreamingResponse(
-            file_iterator(),
-            media_type="application/octet-stream",
-            headers={
-                "Content-Disposition": f'attachment; filename="{filename}"',
-                "Content-Length": str(file_path.stat().st_size),
-            }
-        )
+    return None
 
 
-@router.post("/api/upload")
-async def upload_file(file: UploadFile = File(...)):
+def find_existing_upload(if_none_match: Optional[str]) -> Optional[tuple[str, str]]:
     """
-    Upload a single file from the phone to the laptop.
+    Resolve an If-None-Match content hash to an already-stored file.
+    
+    Accepts one or more (optionally quoted or weak) ETags, each the hex
+    SHA-256 of the file, optionally prefixed with 'sha256:'.
     
     Args:
-        file: The uploaded file.
+        if_none_match: Raw If-None-Match header value.
         
     Returns:
-        Upload result information.
+        (stored file name, hex digest) for matching content, or None.
     """
-    result = await _save_uploaded_file(file)
-    
-    if not result["success"]:
-        raise HTTPException(status_code=400, detail=result.get("error", "Upload failed"))
-    
-    return result
+    for tag in (if_none_match or "").split(","):
+        digest = tag.strip().removeprefix("W/").strip('"').removeprefix("sha256:").lower()
+        if re.fullmatch(r"[0-9a-f]{64}", digest):
+            existing = find_by_sha256(digest)
+            if existing:
+                return existing, digest
+    return None
+
 
+def _candidate_names(filename: str) -> Iterator[str]:
+    """filename, then filename with _1, _2, ... before the extension."""
+    stem, suffix = Path(filename).stem, Path(filename).suffix
+    yield filename
+    for counter in itertools.count(1):
+        yield f"{stem}_{counter}{suffix}"
 
-@router.post("/api/upload-multiple")
-async def upload_multiple_files(files: List[UploadFile] = File(...)):
+
+async def _create_unique(storage: Storage, filename: str) -> tuple[str, BinaryIO]:
     """
-    Upload multiple files simultaneously with parallel processing.
+    Create a new file, appending _1, _2, ... until the name is free.
     
-    Uses asyncio.gather for concurrent file saving operations.
-    
-    Args:
-        files: List of files to upload.
-        
-    Returns:
-        Batch upload results with summary.
+    Creation is exclusive, so two uploads racing for the same name (or
+    names differing only in case on case-insensitive volumes) never
+    overwrite each other.
     """
-    if not files:
-        raise HTTPException(status_code=400, detail="No files provided")
+    for target_name in _candidate_names(filename):
+        if not await run_in_executor(storage.exists, target_name):
+            try:
+                return target_name, await run_in_executor(storage.create, target_name)
+            except FileExistsError:
+                pass
+
+
+async def _place_unique(storage: LocalStorage, temp: Path, filename: str) -> str:
+    """Give a finished upload in a temp file its name, appending _1, _2, ... until the name is free."""
+    for target_name in _candidate_names(filename):
+        if not await run_in_executor(storage.exists, target_name):
+            try:
+                await run_in_executor(storage.place, temp, target_name)
+                return target_name
+            except FileExistsError:
+                pass
+
+
+def _wants_web_version(display_name: str) -> bool:
+    """Whether an uploaded file should get a browser-playable version."""
+    return (
+        config.transcode_videos
+        and config.storage_backend == "local"
+        and not config.burn_after_download
+        and is_video_file(display_name)
+    )
+
+
+async def _save_uploaded_file(
+    file: UploadFile,
+    client: str = "",
+    device: str = "",
+    transfer_token: Optional[str] = None,
+    modified: Optional[float] = None,
+    collection: Optional[Collection] = None,
+) -> dict:
+    """
+    Save an uploaded file and return result.
     
-    # Process all files in parallel
-    tasks = [_save_uploaded_file(file) for file in files]
-    results = await asyncio.gather(*tasks)
+    Uses efficient chunked writing for large files. Bytes are counted
+    against the device's quota as they arrive and given back on failure.
+    transfer_token is the client-chosen ID its progress is published under;
+    modified is the client's file time, used to organize uploads by date.
+    Files arriving through a collection link go into its folder instead,
+    counted against its quota.
+    """
+    if not file.filename:
+        return {"success": False, "error": "No filename provided"}
     
-    # Compute summary using filter lambdas
-    successful = list(filter(lambda r: r["success"], results))
-    failed = list(filter(lambda r: not r["success"], results))
+    storage = get_storage()
     
-    total_size = sum(map(lambda r: r.get("size", 0), successful))
+    # Sanitize filename
+    safe_filename = pipeline.sanitize("upload", file.filename)
     
-    return {
-        "success": len(failed) == 0,
-        "files": results,
-        "summary": {
-            "total": len(results),
-            "successful": len(successful),
-            "failed": len(failed),
-            "total_size": total_size,
-            "total_size_human": format_size(total_size),
+    try:
+        chunk = await file.read(config.chunk_size)
+        
+        # Zero-byte uploads are allowed unless configured otherwise
+        if not chunk and config.reject_empty:
+            pipeline.decide("upload", file.filename, "policy", "rejected", "empty file")
+            return {"success": False, "error": "Empty file rejected", "filename": safe_filename}
+        
+        header = chunk[:4]
+        
+        # A disguised program (e.g. an .exe named .jpg) is refused before anything is written
+        if config.strict_mime and (mismatch := mime_mismatch(safe_filename, chunk)):
+            pipeline.decide("upload", file.filename, "policy", "rejected", mismatch)
+            log(f"🚫 Upload from {client or 'unknown'} refused: {mismatch}", "warning")
+            return {
+                "success": False,
+                "error": f"File content doesn't match its extension: {mismatch}",
+                "filename": safe_filename,
+                "status": 415,
+            }
+        
+        # Only real files can hold holes; other backends get plain writes
+        sparse = isinstance(storage, LocalStorage)
+        
+        # Save the file, leaving holes for all-zero chunks
+        # Obfuscated shares store files under a random ID; the real name lives in the sidecar
+        stored_name = secrets.token_hex(16) if config.obfuscate_names else safe_filename
+        if sparse:
+            # Written under a hidden name and only given its own once complete,
+            # so a half-received file never shows up in listings or downloads
+            temp_path, f = await run_in_executor(storage.create_temp)
+            target_name = stored_name
+        else:
+            target_name, f = await _create_unique(storage, stored_name)
+        transfer = stats.start_transfer(target_name, "upload", client, file.size, device, transfer_token)
+        progress = ProgressCounter(lambda delta, _: stats.add_bytes(transfer, delta))
+        digest = hashlib.sha256()
+        written = 0
+        reserved = 0
+        collected = 0
+        try:
+            while chunk:
+                written += len(chunk)
+                if config.max_upload_size and written > config.max_upload_size:
+                    raise UploadRejected(413, f"File exceeds the {format_size(config.max_upload_size)} upload limit")
+                
+                if device and not devices.reserve_upload(device, len(chunk)):
+                    raise _quota_exceeded(devices.remaining(device) or 0)
+                reserved += len(chunk)
+                if collection and not collection_links.reserve(collection, len(chunk)):
+                    raise UploadRejected(
+                        413, f"This upload link is full ({format_size(collection.remaining)} left)", collection.remaining
+                    )
+                collected += len(chunk)
+                
+                digest.update(chunk)
+                if sparse and _is_zero_chunk(chunk):
+                    await run_in_executor(f.seek, len(chunk), os.SEEK_CUR)
+                else:
+                    await run_in_executor(f.write, chunk)
+                progress.update(len(chunk))
+                chunk = await file.read(config.chunk_size)
+            
+            # Extend to the final size if the file ends in a hole
+            if sparse:
+                await run_in_executor(f.truncate)
+            await run_in_executor(f.close)
+            if sparse:
+                target_name = await _place_unique(storage, temp_path, stored_name)
+        except BaseException as e:
+            if device:
+                devices.release_upload(device, reserved)
+            if collection:
+                collection_links.release(collection, collected)
+            progress.close()
+            stats.finish_transfer(transfer, success=False)
+            await run_in_executor(f.close)
+            # Rejections are deliberate; anything else may need investigating
+            if config.keep_failed_uploads and sparse and not isinstance(e, UploadRejected):
+                await run_in_executor(
+                    quarantine, temp_path, safe_filename,
+                    written, file.size, str(e) or type(e).__name__, client,
+                )
+                pipeline.decide("upload", file.filename, "stored", "quarantined", str(e) or type(e).__name__)
+            elif sparse:
+                await run_in_executor(functools.partial(temp_path.unlink, missing_ok=True))
+            else:
+                await run_in_executor(storage.delete, target_name)
+            raise
+        if target_name != stored_name:
+            pipeline.decide("upload", file.filename, "duplicate", "renamed", target_name)
+        progress.close()
+        stats.finish_transfer(transfer)
+        
+        if collection and sparse:
+            moved = await run_in_executor(
+                move_into, storage.path(target_name), config.uploads_dir / collection.folder
+            )
+            target_name = moved.relative_to(config.uploads_dir).as_posix()
+            pipeline.decide("upload", file.filename, "organize", "moved", target_name)
+        elif (config.organize_uploads or config.date_folders) and sparse:
+            target_name = await run_in_executor(_organize_upload, target_name, safe_filename, device, modified)
+            pipeline.decide("upload", file.filename, "organize", "moved", target_name)
+        
+        entry = await run_in_executor(storage.stat, target_name)
+        
+        # Identical content already stored: keep one copy on disk
+        deduplicated = ""
+        if config.dedupe_uploads and sparse:
+            existing = await run_in_executor(find_by_sha256, digest.hexdigest())
+            if existing and existing != entry.name:
+                deduplicated = await run_in_executor(
+                    link_duplicate, storage.path(entry.name), storage.path(existing)
+                )
+                if deduplicated:
+                    pipeline.decide("upload", file.filename, "dedupe", deduplicated, existing)
+                entry = await run_in_executor(storage.stat, target_name)
+        
+        # Record the content hash so later If-None-Match uploads can skip it
+        sha256 = {"hex": digest.hexdigest(), "mtime": entry.modified, "size": entry.size}
+        original_name = safe_filename if config.obfuscate_names else None
+        await run_in_executor(functools.partial(
+            update_meta, entry.name, sha256=sha256, original_name=original_name,
+            collection=collection.id if collection else None,
+            owner=device if config.owner_only_modify and device else None,
+        ))
+        
+        display_name = original_name or entry.name
+        pipeline.decide("upload", file.filename, "stored", "saved", entry.name)
+        result = {
+            "success": True,
+            "filename": display_name,
+            "id": entry.name,
+            "path": entry.name,
+            "size": entry.size,
+            "size_human": format_size(entry.size),
+            "type": get_file_type(display_name),
+            "empty": entry.size == 0,
+            "sha256": sha256["hex"],
+            "transfer_id": transfer.token,
         }
-    }
+        if deduplicated:
+            result["deduplicated"] = deduplicated
+        if collection:
+            await run_in_executor(collection_links.record, collection)
+        # Collection uploads stay inside the link's folder, so their zips are kept as they are
+        if config.auto_extract_zip and config.storage_backend == "local" and is_zip(header) and not collection:
+            result.update(await run_in_executor(_auto_extract, entry.name, display_name, device))
+        if not result.get("zip_removed"):
+            dir_sizes.add(entry.name, entry.size)
+            hub.publish("files", {"added": entry.name})
+            ndjson.emit("upload", file=display_name, id=entry.name, size=entry.size, client=client)
+            if _wants_web_version(display_name):
+                transcoder.submit(entry.name)
+        return result
+    except UploadRejected as e:
+        pipeline.decide("upload", file.filename, "policy", "rejected", e.message)
+        result = {"success": False, "error": e.message, "filename": safe_filename, "status": e.status}
+        if e.remaining is not None:
+            result["remaining"] = e.remaining
+        return result
+    except Exception as e:
+        pipeline.decide("upload", file.filename, "stored", "failed", str(e))
+        return {"success": False, "error": str(e), "filename": safe_filename}
 
 
-@router.get("/api/qr")
-async def get_qr():
+class _RawBodyUpload:
     """
-    Get QR code data for connecting to the server.
+    A request's raw body, read like an UploadFile so PUT uploads share the save path.
     
-    Returns:
-        QR code information including URL and encodings.
+    Bodies declared no larger than config.stream_threshold are read in one
+    go, saving per-chunk overhead for small files; others are streamed.
     """
-    return get_qr_data(config.port)
+    
+    def __init__(self, request: Request, filename: str, size: Optional[int]):
+        self.filename = filename
+        self.size = size
+        self._request = request
+        self._chunks = request.stream()
+        self._buffer = bytearray()
+        self._done = False
+    
+    async def read(self, size: int) -> bytes:
+        if not self._done and self.size is not None and self.size <= config.stream_threshold:
+            self._buffer += await self._request.body()
+            self._done = True
+        while len(self._buffer) < size and not self._done:
+            try:
+                self._buffer += await self._chunks.__anext__()
+            except StopAsyncIteration:
+                self._done = True
+        chunk = bytes(self._buffer[:size])
+        del self._buffer[:size]
+        return chunk
 
 
-@router.get("/api/qr.png")
-async def get_qr_image():
+def _organize_upload(stored_name: str, display_name: str, device: str, modified: Optional[float]) -> str:
     """
-    Get QR code as PNG image.
+    Move a finished upload into the folder config.date_folders and config.organize_uploads name for it.
     
-    Runs PNG generation in executor to avoid blocking.
+    The date folder is the day the upload arrived, so a long-running
+    share rolls over to a new folder every day; the template applies
+    inside it. Template dates come from the photo's EXIF capture time,
+    else the client's file time, else now.
     
     Returns:
-        PNG image of the QR code.
+        The file's new stored name, relative to the uploads directory.
     """
-    png_bytes = await run_in_executor(generate_qr_png_bytes, config.port)
-    return Response(content=png_bytes, media_type="image/png")
+    path = get_storage().path(stored_name)
+    folders = []
+    if config.date_folders:
+        folders.append(date_folder(config.date_folders))
+    if config.organize_uploads:
+        when = capture_time(path) or modified or time.time()
+        folders.append(render_folder(config.organize_uploads, get_file_type(display_name), when, device))
+    moved = move_into(path, config.uploads_dir.joinpath(*folders))
+    return moved.relative_to(config.uploads_dir).as_posix()
 
 
-@router.get("/api/status")
-async def get_status():
+def _auto_extract(stored_name: str, display_name: str, device: str = "") -> dict:
     """
-    Get server status and information.
+    Unpack an uploaded zip into a folder named after it.
     
-    Returns:
-        Server status information including file count and storage stats.
+    A zip that can't be extracted safely is kept as an ordinary upload,
+    with the reason reported alongside the result. The extracted bytes
+    are new data, so they count against the uploading device's quota.
+    """
+    folder = unique_folder(config.uploads_dir, sanitize_filename(Path(display_name).stem))
+    reserved = 0
+    try:
+        with get_storage().open(stored_name) as f:
+            size = expanded_size(f, config.max_upload_size)
+            if device and not devices.reserve_upload(device, size):
+                remaining = devices.remaining(device) or 0
+                return {"extracted": 0, "extract_error": _quota_exceeded(remaining).message}
+            reserved = size
+            count = extract_zip(f, folder, config.max_upload_size)
+    except (UnsafeArchiveError, zipfile.BadZipFile, OSError) as e:
+        if device:
+            devices.release_upload(device, reserved)
+        return {"extracted": 0, "extract_error": str(e)}
+    
+    dir_sizes.add_tree(folder)
+    if config.auto_extract_remove_zip:
+        remove_file(stored_name)
+    return {"extracted": count, "folder": folder.name, "zip_removed": config.auto_extract_remove_zip}
+
+
+def get_display_name(stored_name: str, meta: Optional[dict] = None) -> str:
+    """Name to show users for a stored file (the original name when obfuscated)."""
+    if not config.obfuscate_names:
+        return stored_name
+    return (meta if meta is not None else load_meta(stored_name)).get("original_name", stored_name)
+
+
+def _get_file_info(entry: StorageEntry) -> dict:
     """
-    files = list(config.uploads_dir.glob("*")) if config.uploads_dir.exists() else []
-    total_size = sum(f.stat().st_size for f in files if f.is_file())
+    Get file info dictionary from a storage entry.
     
+    'name' is what users see; 'id' is what URLs must use.
+    """
+    meta = load_meta(entry.name)
+    name = get_display_name(entry.name, meta)
+    transcode = transcoder.state(entry.name) if is_video_file(name) else None
     return {
-        "status": "online",
-        "url": get_server_url(config.port),
-        "uploads_dir": str(config.uploads_dir),
-        "file_count": len(files),
-        "total_size": total_size,
-        "total_size_human": format_size(total_size),
+        "name": name,
+        "id": entry.name,
+        "size": entry.size,
+        "size_human": format_size(entry.size),
+        "modified": format_timestamp(entry.modified),
+        "type": get_file_type(name),
+        "burn_after_download": _is_burn_after_download(meta),
+        "deletes_at": format_timestamp(deletes_at) if (deletes_at := deletions.deletes_at(entry.name)) else None,
+        "transcode": transcode,
+        "play_url": f"/api/play/{quote(entry.name)}" if transcode == "ready" and not _is_burn_after_download(meta) else None,
     }
 
 
-@router.delete("/api/files/{filename}")
-async def delete_file(filename: str):
+# Listings with fewer files read their metadata in the calling thread
+PARALLEL_LISTING_MIN = 64
+
+
+# How often a listing checks whether its client is still there, in seconds
+DISCONNECT_POLL_INTERVAL = 0.1
+
+
+def _visible_files(
+    entries: list[StorageEntry],
+    path_filter: PathFilter,
+    stop: Optional[threading.Event] = None,
+) -> list[dict]:
     """
-    Delete a file from the uploads directory.
+    File info for every entry not hidden or excluded from the share.
     
-    Args:
-        filename: Name of the file to delete.
-        
-    Returns:
-        Deletion result.
+    Each file's sidecar is read from disk, so big listings spread that
+    over config.list_concurrency threads. map() keeps the results in
+    entry order, so no locking is needed to collect them. Once stop is
+    set, the remaining entries are skipped and the listing comes back
+    incomplete.
     """
-    file_path = config.uploads_dir / filename
+    def info(entry: StorageEntry) -> Optional[dict]:
+        return None if stop and stop.is_set() else _get_file_info(entry)
     
-    if not file_path.exists():
-        raise HTTPException(status_code=404, detail="File not found")
+    visible = [entry for entry in entries if not entry.name.startswith('.')]
+    if config.list_concurrency > 1 and len(visible) >= PARALLEL_LISTING_MIN:
+        with ThreadPoolExecutor(max_workers=config.list_concurrency, thread_name_prefix="flashare-list") as pool:
+            files = list(pool.map(info, visible))
+    else:
+        files = [info(entry) for entry in visible]
+    return [info for info in files if info and not path_filter.is_excluded(info["name"])]
+
+
+async def _list_visible(request: Request, entries: list[StorageEntry], path_filter: PathFilter) -> list[dict]:
+    """
+    Run _visible_files in the thread pool, stopping it if the client disconnects.
     
-    # Security check
+    The web UI polls the listing, and a phone that gives up on a slow
+    one would otherwise leave the listing threads reading sidecars for
+    nobody.
+    """
+    stop = threading.Event()
+    listing = asyncio.ensure_future(run_in_executor(_visible_files, entries, path_filter, stop))
     try:
-        file_path.resolve().relative_to(config.uploads_dir.resolve())
-    except ValueError:
+        while not (await asyncio.wait({listing}, timeout=DISCONNECT_POLL_INTERVAL))[0]:
+            if await request.is_disconnected():
+                stop.set()
+        return await listing
+    finally:
+        stop.set()
+
+
+def _close_stream(stream: Iterator[bytes], reading: Optional[asyncio.Task]):
+    """Close a relayed stream after its last read has returned."""
+    if reading and not reading.cancelled():
+        reading.exception()  # Retrieved, so asyncio doesn't report a read that failed after the client left
+    stream.close()
+
+
+async def _relay_stream(
+    stream: Iterator[bytes],
+    transfer: Transfer,
+    device: str = "",
+    on_finish: Optional[Callable[[bool], None]] = None,
+) -> AsyncIterator[bytes]:
+    """
+    Relay a blocking chunk stream to the client, accounting each chunk.
+    
+    Chunks are paced by fair_share, so devices downloading at the same
+    time share the bandwidth. Chunks are produced in a worker thread. When the client disconnects,
+    the response task is cancelled and the source generator is closed as
+    soon as its thread hands back the chunk it was reading, releasing its
+    file and compressor instead of waiting for garbage collection. The
+    transfer then counts as aborted, not as a download.
+    
+    on_finish, if given, is called with whether the stream completed.
+    """
+    success = False
+    reading: Optional[asyncio.Task] = None
+    lane = fair_share.open(device or transfer.client, transfer.total)
+    try:
+        while True:
+            # Shielded so a disconnect leaves the read running, to be waited for below
+            reading = asyncio.ensure_future(asyncio.to_thread(next, stream, None))
+            chunk = await asyncio.shield(reading)
+            if chunk is None:
+                break
+            delay = lane.take(len(chunk))
+            if delay:
+                await asyncio.sleep(delay)
+            stats.add_bytes(transfer, len(chunk))
+            if device:
+                devices.add_download(device, len(chunk))
+            yield chunk
+        success = True
+    finally:
+        lane.close()
+        stats.finish_transfer(transfer, success)
+        if reading and not reading.done():
+            # Still running in its thread, and a running generator can't be closed
+            reading.add_done_callback(lambda _: _close_stream(stream, reading))
+        else:
+            _close_stream(stream, reading)
+        if on_finish:
+            on_finish(success)
+
+
+# Single-use files with a download in flight, so only one can consume them
+_burn_claims: set[str] = set()
+
+
+def _is_burn_after_download(meta: dict) -> bool:
+    """Whether a file is deleted after its first complete download."""
+    return config.burn_after_download or meta.get("burn_after_download", False)
+
+
+def _burn_on_complete(filename: str, complete: Callable[[], bool]) -> Callable[[bool], None]:
+    """
+    Build the on_finish hook for a single-use download.
+    
+    The file is deleted only if the stream finished and complete() agrees
+    every byte was sent; otherwise the claim is released for another try.
+    """
+    def on_finish(success: bool):
+        try:
+            if success and complete():
+                remove_file(filename)
+        except FileNotFoundError:
+            pass
+        finally:
+            _burn_claims.discard(filename)
+    return on_finish
+
+
+def _read_range(f: BinaryIO, start: int, length: int) -> Iterator[bytes]:
+    """Yield length bytes of f starting at start, skipping ahead if f can't seek."""
+    if getattr(f, "seekable", lambda: False)():
+        f.seek(start)
+    else:
+        while start and (skipped := f.read(min(config.chunk_size, start))):
+            start -= len(skipped)
+    
+    while length and (chunk := f.read(min(config.chunk_size, length))):
+        length -= len(chunk)
+        yield chunk
+
+
+async def _stat_or_raise(filename: str) -> StorageEntry:
+    """Stat a stored file, mapping storage errors to HTTP errors."""
+    try:
+        return await run_in_executor(get_storage().stat, filename)
+    except FileNotFoundError:
+        raise HTTPException(status_code=404, detail="File not found")
+    except PermissionError:
         raise HTTPException(status_code=403, detail="Access denied")
+
+
+# ==================== API Endpoints ====================
+
+# Folder levels ?recursive=true descends at most; deeper folders are listed empty and marked truncated
+MAX_TREE_DEPTH = 16
+
+
+def _folder_tree(path: str, depth: int, max_depth: int, path_filter: PathFilter, sort: str) -> dict:
+    """
+    One folder of a recursive listing, its subfolders nested inside.
     
-    # Use executor for file deletion (blocking I/O)
-    await run_in_executor(file_path.unlink)
+    Hidden and excluded entries are left out like in flat listings, and
+    symlinks are never followed, so a link can't loop or leave the share.
     
-    return {"success": True, "deleted": filename}
+    Args:
+        path: Folder relative to the uploads directory ('' for the root).
+        depth: Nesting level of this folder.
+        max_depth: Levels to descend below the root.
+    """
+    files = []
+    subfolders = []
+    for entry in os.scandir(config.uploads_dir / path):
+        rel = f"{path}/{entry.name}" if path else entry.name
+        if entry.name.startswith(".") or entry.is_symlink():
+            continue
+        if entry.is_dir():
+            if not path_filter.is_excluded(rel, is_dir=True):
+                subfolders.append(rel)
+        elif entry.is_file():
+            stat = entry.stat()
+            files.append(StorageEntry(name=rel, size=stat.st_size, modified=stat.st_mtime))
+    
+    folders = []
+    for rel in sorted(subfolders, key=natural_key):
+        if depth < max_depth:
+            folders.append(_folder_tree(rel, depth + 1, max_depth, path_filter, sort))
+        else:
+            folders.append({"name": Path(rel).name, "path": rel, "files": [], "folders": [], "truncated": True})
+    return {
+        "name": Path(path).name,
+        "path": path,
+        "files": sort_files(_visible_files(files, path_filter), sort),
+        "folders": folders,
+        "truncated": False,
+    }
 
 
-@router.delete("/api/files")
-async def delete_multiple_files(filenames: List[str]):
+def _group_by_folder(tree: dict, sort: str) -> list[dict]:
     """
-    Delete multiple files from the uploads directory.
+    Flatten a recursive listing into one {folder, files} group per folder that holds files.
     
-    Uses parallel processing for batch deletions.
+    Groups are in name order, or with sort=modified newest first by their
+    newest file, which puts date folders in reverse chronological order.
+    """
+    groups = []
+    pending = [tree]
+    while pending:
+        folder = pending.pop()
+        if folder["files"]:
+            groups.append({"folder": folder["path"], "files": folder["files"]})
+        pending.extend(folder["folders"])
+    groups.sort(key=lambda group: natural_key(group["folder"]))
+    if sort == "modified":
+        groups.sort(key=lambda group: max(f["modified"] for f in group["files"]), reverse=True)
+    return groups
+
+
+@router.get("/api/files", dependencies=[Depends(require_storage)])
+async def list_files(
+    request: Request,
+    sort: str = "modified",
+    recursive: bool = False,
+    depth: int = MAX_TREE_DEPTH,
+    group: Optional[str] = None,
+):
+    """
+    List all available files in the uploads directory.
+    
+    The storage listing runs in the thread pool so slow backends
+    don't block the event loop.
     
     Args:
-        filenames: List of filenames to delete.
-        
+        sort: "modified" (newest first, default), "name" (natural,
+            case-insensitive) or "type" (grouped by file type).
+        recursive: Return the whole folder tree instead of the top level.
+        depth: With recursive, folder levels to descend (at most
+            MAX_TREE_DEPTH).
+        group: "folder" to list every file in the tree as {folder,
+            files} groups instead (e.g. one per date folder).
+    
     Returns:
-        Batch deletion results.
+        List of file information dictionaries in the requested order.
+        With recursive, the root folder as {name, path, files, folders,
+        truncated}, every folder in 'folders' having the same shape;
+        folders are in name order, and 'truncated' marks those too deep
+        to list. Other storage backends only have a top level.
+    """
+    if sort not in ("modified", "name", "type"):
+        raise HTTPException(status_code=400, detail="sort must be 'modified', 'name' or 'type'")
+    if group not in (None, "folder"):
+        raise HTTPException(status_code=400, detail="group must be 'folder'")
+    
+    path_filter = _get_path_filter()
+    if group and config.storage_backend == "local":
+        tree = await run_in_executor(_folder_tree, "", 0, MAX_TREE_DEPTH, path_filter, sort)
+        return _group_by_folder(tree, sort)
+    if recursive and config.storage_backend == "local":
+        if depth < 0:
+            raise HTTPException(status_code=400, detail="depth must not be negative")
+        return await run_in_executor(_folder_tree, "", 0, min(depth, MAX_TREE_DEPTH), path_filter, sort)
+    
+    entries = await run_in_executor(get_storage().list)
+    files = await _list_visible(request, entries, path_filter)
+    
+    if group:
+        return [{"folder": "", "files": sort_files(files, sort)}] if files else []
+    if recursive:
+        return {"name": "", "path": "", "files": sort_files(files, sort), "folders": [], "truncated": False}
+    return sort_files(files, sort)
+
+
+def _file_etag(entry: StorageEntry, meta: dict) -> str:
+    """
+    Validator for a stored file's content.
+    
+    Strong when the recorded SHA-256 still matches the file, otherwise
+    weak, derived from size and modification time.
+    """
+    recorded = meta.get("sha256") or {}
+    if recorded.get("hex") and recorded.get("mtime") == entry.modified and recorded.get("size") == entry.size:
+        return f'"{recorded["hex"]}"'
+    return f'W/"{entry.size:x}-{int(entry.modified * 1000):x}"'
+
+
+@router.api_route("/api/download/{filename:path}", methods=["GET", "HEAD"], dependencies=[Depends(require_storage)])
+async def download_file(request: Request, filename: str, compressed: bool = True):
+    """
+    Download a file with optional compression.
+    
+    What is sent is decided by select_representation(): the encoding is
+    negotiated from Accept-Encoding (zstd > br > gzip), a single-range
+    Range header is honoured (subject to If-Range) on the cached
+    compressed copy if there is one, else on the uncompressed file, and
+    If-None-Match against the ETag yields 304. HEAD
+    returns the same headers without a body and without counting as a
+    download.
+    
+    Args:
+        filename: ID of the file to download (its stored name).
+        compressed: Whether to allow compression (default: True).
+        
+    Returns:
+        StreamingResponse with the file content.
+    """
+    # Also rejects names escaping the uploads directory
+    entry = await _stat_or_raise(filename)
+    meta = await run_in_executor(load_meta, filename)
+    display_name = get_display_name(filename, meta)
+    if _get_path_filter().is_excluded(display_name):
+        raise HTTPException(status_code=404, detail="File not found")
+    
+    # Single-use files: full downloads only, one at a time
+    burn = _is_burn_after_download(meta)
+    if burn and parse_range(request.headers.get("range"), entry.size):
+        raise HTTPException(status_code=403, detail="Single-use files can't be downloaded in parts")
+    
+    def encoded_size(encoding: str) -> Optional[int]:
+        return encoded_cache.size(filename, entry.size, entry.modified, encoding)
+    
+    representation = select_representation(
+        request.headers, entry.size, _file_etag(entry, meta), compressible=compressed, ranges=not burn,
+        encoded_size=encoded_size if not burn else None,
+    )
+    headers = {
+        **representation.headers,
+        "Content-Disposition": content_disposition(display_name),
+        # The type comes from the name, so browsers mustn't second-guess it
+        "X-Content-Type-Options": "nosniff",
+    }
+    if representation.status == 304 or request.method == "HEAD":
+        return Response(status_code=representation.status, headers=headers)
+    
+    if burn:
+        if filename in _burn_claims:
+            raise HTTPException(status_code=410, detail="File is already being downloaded")
+        _burn_claims.add(filename)
+    
+    try:
+        return await _file_response(request, filename, entry, meta, display_name, representation, headers, burn)
+    except BaseException:
+        # Nothing was sent, so the single-use file must stay available
+        if burn:
+            _burn_claims.discard(filename)
+        raise
+
+
+async def _file_response(
+    request: Request,
+    filename: str,
+    entry: StorageEntry,
+    meta: dict,
+    display_name: str,
+    representation: Representation,
+    headers: dict,
+    burn: bool,
+) -> Response:
+    """The body of a download_file response, once the file is claimed if single-use."""
+    storage = get_storage()
+    encoding = representation.encoding
+    
+    # Files small enough are compressed up front: the response then has a
+    # Content-Length for progress bars, and files that barely shrink (most
+    # media) go out uncompressed instead
+    buffered = None
+    reserved = entry.size if encoding and not representation.cached else 0
+    if reserved and entry.size <= config.compress_buffer_threshold and buffer_pool.reserve(reserved):
+        try:
+            buffered = await run_in_executor(compress_whole, storage.open(filename), encoding)
+        except BaseException:
+            buffer_pool.release(reserved)
+            raise
+        if not worth_compressing(entry.size, len(buffered)):
+            buffered = None
+            buffer_pool.release(reserved)
+            representation = select_representation(
+                {**request.headers, "accept-encoding": "identity"}, entry.size, _file_etag(entry, meta),
+            )
+            headers.update(representation.headers)
+            headers.pop("Content-Encoding", None)
+            encoding = None
+            if representation.status == 304:
+                _burn_claims.discard(filename)
+                return Response(status_code=304, headers=headers)
+        else:
+            headers["Content-Length"] = str(len(buffered))
+    else:
+        reserved = 0
+    
+    device = get_device_id(request)
+    transfer = stats.start_transfer(
+        filename, "download", request.client.host,
+        len(buffered) if buffered is not None else representation.length, device, get_transfer_token(request),
+    )
+    headers["X-Transfer-Id"] = transfer.token
+    
+    # Encoded streams only finish once the whole file has been read;
+    # identity streams must also have sent exactly the file's size
+    on_finish = _burn_on_complete(
+        filename, lambda: bool(encoding) or transfer.bytes == entry.size
+    ) if burn else None
+    
+    start, length = representation.start, representation.length
+    try:
+        if representation.cached:
+            copy = encoded_cache.path(filename, entry.size, entry.modified, encoding)
+            
+            def copy_iterator():
+                with open(copy, "rb") as f:
+                    yield from _read_range(f, start, length)
+            
+            stream = copy_iterator()
+        elif buffered is not None:
+            def buffer_iterator():
+                try:
+                    view = memoryview(buffered)
+                    for offset in range(0, len(view), config.chunk_size):
+                        yield bytes(view[offset:offset + config.chunk_size])
+                finally:
+                    buffer_pool.release(reserved)
+            
+            if not burn:
+                await run_in_executor(encoded_cache.store, buffered, filename, entry.size, entry.modified, encoding)
+            stream = buffer_iterator()
+        elif encoding:
+            stream = generate_encoded_stream(storage.open(filename), encoding)
+            if not burn:
+                # Kept so an interrupted download can resume against the same bytes
+                stream = encoded_cache.tee(stream, filename, entry.size, entry.modified, encoding)
+        else:
+            def file_iterator():
+                with closing(storage.open(filename)) as f:
+                    yield from _read_range(f, start, length)
+            
+            stream = file_iterator()
+    except BaseException:
+        stats.finish_transfer(transfer, False)
+        if buffered is not None:
+            buffer_pool.release(reserved)
+        raise
+    
+    def settle_unstarted():
+        # A client gone before the body started never ran the stream's
+        # cleanup, which would leave a single-use file claimed for good
+        if not stats.is_active(transfer):
+            return
+        stats.finish_transfer(transfer, False)
+        if buffered is not None:
+            buffer_pool.release(reserved)
+        stream.close()
+        if on_finish:
+            on_finish(False)
+    
+    return StreamingResponse(
+        _relay_stream(stream, transfer, device, on_finish),
+        status_code=representation.status,
+        media_type=guess_mime_type(display_name),
+        headers=headers,
+        background=BackgroundTask(settle_unstarted),
+    )
+
+
+@router.api_route("/api/play/{filename:path}", methods=["GET", "HEAD"], dependencies=[Depends(require_storage)])
+async def play_file(request: Request, filename: str):
+    """
+    Stream the browser-playable version of a video, with Range support for seeking.
+    
+    The version is made after upload when transcoding is on; see
+    Transcoder. It is served inline so browsers play it instead of
+    saving it.
+    
+    Args:
+        filename: ID of the original video.
+        
+    Returns:
+        The MP4; 404 if there is no web version (yet).
+    """
+    await _stat_or_raise(filename)
+    meta = await run_in_executor(load_meta, filename)
+    display_name = get_display_name(filename, meta)
+    if _get_path_filter().is_excluded(display_name):
+        raise HTTPException(status_code=404, detail="File not found")
+    if _is_burn_after_download(meta):
+        raise HTTPException(status_code=403, detail="Single-use files can only be downloaded")
+    
+    path = web_path(filename)
+    try:
+        stat = path.stat()
+    except FileNotFoundError:
+        raise HTTPException(status_code=404, detail="No playable version of this file")
+    
+    # Remade only when the original changes, which also changes its mtime
+    etag = f'"web-{stat.st_mtime_ns:x}-{stat.st_size:x}"'
+    representation = select_representation(request.headers, stat.st_size, etag, compressible=False)
+    headers = {
+        **representation.headers,
+        "Content-Disposition": content_disposition(f"{Path(display_name).stem}.mp4", "inline"),
+        "X-Content-Type-Options": "nosniff",
+    }
+    if representation.status == 304 or request.method == "HEAD":
+        return Response(status_code=representation.status, headers=headers)
+    
+    try:
+        f = open(path, "rb")
+    except FileNotFoundError:
+        raise HTTPException(status_code=404, detail="No playable version of this file")
+    
+    device = get_device_id(request)
+    transfer = stats.start_transfer(
+        filename, "download", request.client.host, representation.length, device, get_transfer_token(request),
+    )
+    headers["X-Transfer-Id"] = transfer.token
+    start, length = representation.start, representation.length
+    
+    def file_iterator():
+        with f:
+            yield from _read_range(f, start, length)
+    
+    return StreamingResponse(
+        _relay_stream(file_iterator(), transfer, device),
+        status_code=representation.status,
+        media_type="video/mp4",
+        headers=headers,
+    )
+
+
+def _zip_entries(names: list[str]) -> tuple[list[tuple[Path, str]], list[str]]:
+    """
+    Expand requested files and folders into archive entries.
+    
+    Files inside folders are checked like files asked for directly:
+    excluded and single-use ones are skipped, and obfuscated ones get
+    their original names.
+    
+    Returns:
+        (path on disk, path in archive) pairs, and the names skipped
+        because they don't exist, are hidden or are single-use.
+    """
+    storage = get_storage()
+    path_filter = _get_path_filter()
+    entries, skipped = [], []
+    for name in dict.fromkeys(names):
+        if is_hidden_name(name):
+            skipped.append(name)
+            continue
+        path = storage.path(name)  # Raises PermissionError outside the share
+        if path.is_symlink():
+            skipped.append(name)
+        elif path.is_dir():
+            for file_path, arcname in walk_folder(path, path.name):
+                # Files in the folder get the same checks and names as files asked for one by one
+                stored = file_path.relative_to(storage.root).as_posix()
+                meta = load_meta(stored)
+                arcname = f"{PurePosixPath(arcname).parent}/{PurePosixPath(get_display_name(stored, meta)).name}"
+                if path_filter.is_excluded(arcname) or _is_burn_after_download(meta):
+                    skipped.append(stored)
+                else:
+                    entries.append((file_path, arcname))
+        elif path.is_file():
+            meta = load_meta(name)
+            display_name = get_display_name(name, meta)
+            if path_filter.is_excluded(display_name) or _is_burn_after_download(meta):
+                skipped.append(name)
+            else:
+                entries.append((path, display_name))
+        else:
+            skipped.append(name)
+    return entries, skipped
+
+
+def _zip_archive_name(files: list[str]) -> str:
+    """Name a zip after the folder when a single folder is requested, otherwise after the share."""
+    storage = get_storage()
+    folders = [name for name in files if storage.path(name).is_dir()]
+    return Path(folders[0]).name if len(files) == 1 and folders else get_branding()["title"]
+
+
+async def _resolve_zip_entries(files: list[str]) -> tuple[list[tuple[Path, str]], list[str]]:
+    """_zip_entries for a request, turning its failures into HTTP errors."""
+    if config.storage_backend != "local":
+        raise HTTPException(status_code=400, detail="Zip downloads need local storage")
+    if any(not name.strip("/") for name in files):
+        raise HTTPException(status_code=400, detail="Name files or folders; the whole share can't be downloaded at once")
+    
+    try:
+        entries, skipped = await run_in_executor(_zip_entries, files)
+    except PermissionError:
+        raise HTTPException(status_code=403, detail="Access denied")
+    except TooDeep as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    if not entries:
+        raise HTTPException(status_code=404, detail="None of the requested files exist")
+    return entries, skipped
+
+
+def _archive_format(request: Request, requested: Optional[str]) -> str:
+    """
+    Archive format for a request: ?format= if given, then a format named in
+    Accept, then zip for browsers and tar.zst for everything else (curl,
+    scripts), whose users can be expected to have zstd.
+    """
+    if requested:
+        if requested not in ARCHIVE_FORMATS:
+            raise HTTPException(
+                status_code=400, detail=f"Unknown archive format; use one of: {', '.join(ARCHIVE_FORMATS)}"
+            )
+        return requested
+    accept = request.headers.get("accept", "")
+    for archive_format, (media_type, _) in ARCHIVE_FORMATS.items():
+        if media_type in accept:
+            return archive_format
+    return "zip" if "Mozilla/" in request.headers.get("user-agent", "") else "tar.zst"
+
+
+@router.get("/api/download-zip", dependencies=[Depends(require_storage)])
+async def download_zip(
+    request: Request,
+    files: List[str] = Query(...),
+    archive_format: Optional[str] = Query(None, alias="format"),
+):
+    """
+    Download several files and folders as one streamed archive.
+    
+    Folders are expanded with their structure kept, minus hidden entries
+    and symlinks. Missing names are skipped and listed in X-Skipped-Files.
+    The archive is named after the folder when a single folder is
+    requested, otherwise after the share's title.
+    
+    Args:
+        files: IDs of files and/or folder paths (repeat the parameter).
+        format: zip, tar.gz or tar.zst; see _archive_format for the default.
+        
+    Returns:
+        StreamingResponse with the archive.
+    """
+    archive_format = _archive_format(request, archive_format)
+    media_type, extension = ARCHIVE_FORMATS[archive_format]
+    entries, skipped = await _resolve_zip_entries(files)
+    archive_name = f"{_zip_archive_name(files)}{extension}"
+    total = sum(path.stat().st_size for path, _ in entries)
+    transfer = stats.start_transfer(
+        archive_name, "download", request.client.host, total,
+        get_device_id(request), get_transfer_token(request),
+    )
+    headers = {
+        "Content-Disposition": content_disposition(archive_name),
+        "X-Transfer-Id": transfer.token,
+    }
+    if skipped:
+        headers["X-Skipped-Files"] = quote(",".join(skipped))
+    return StreamingResponse(
+        _relay_stream(stream_archive(entries, archive_format), transfer, get_device_id(request)),
+        media_type=media_type,
+        headers=headers,
+    )
+
+
+class DownloadPreviewRequest(BaseModel):
+    """Body of POST /api/download-zip/preview."""
+    files: List[str]
+    format: Optional[str] = None  # zip, tar.gz or tar.zst; defaults like /api/download-zip
+
+
+@router.post("/api/download-zip/preview", dependencies=[Depends(require_storage)])
+async def preview_download_zip(request: Request, body: DownloadPreviewRequest):
+    """
+    Size up a multi-file download before committing to it.
+    
+    File counts and bytes are exact. The archive size is an estimate
+    (see estimate_archive): exact plus headers for zip, extrapolated
+    from compressing the start of a few files per type for the tars.
+    throughput is how fast recent downloads reached this client, as
+    the server measured them, for the client to work out an ETA; null
+    until it has downloaded something sizeable.
+    
+    Args:
+        body: The same selection GET /api/download-zip takes.
+        
+    Returns:
+        Totals, a per-type breakdown, the estimate, the throughput and
+        the names that would be skipped.
+    """
+    archive_format = _archive_format(request, body.format)
+    entries, skipped = await _resolve_zip_entries(body.files)
+    estimate = await run_in_executor(estimate_archive, entries, archive_format, get_file_type)
+    throughput = stats.throughput(request.client.host)
+    return {
+        "name": f"{_zip_archive_name(body.files)}{ARCHIVE_FORMATS[archive_format][1]}",
+        "format": archive_format,
+        "files": estimate.files,
+        "bytes": estimate.bytes,
+        "by_type": sorted(
+            (group.to_dict() for group in estimate.by_type.values()),
+            key=lambda group: group["bytes"], reverse=True,
+        ),
+        "estimate": {
+            "estimated": True,
+            "archive_bytes": estimate.estimated_bytes,
+            "method": "stored" if archive_format == "zip" else "sampled",
+            "sampled_bytes": estimate.sampled_bytes,
+        },
+        "throughput": {"estimated": True, **throughput} if throughput else None,
+        "skipped": skipped,
+    }
+
+
+class ArchiveJobRequest(BaseModel):
+    """Body of POST /api/archive-jobs."""
+    files: List[str]
+    format: Optional[str] = None  # zip, tar.gz or tar.zst; defaults like /api/download-zip
+
+
+def _publish_archive_job(job: ZipJob):
+    hub.publish("archive_job", job.to_dict())
+
+
+@router.post("/api/archive-jobs", status_code=202, dependencies=[Depends(require_storage)])
+async def create_archive_job(request: Request, body: ArchiveJobRequest):
+    """
+    Prepare an archive on disk, for a download that can resume if interrupted.
+    
+    Unlike /api/download-zip, the archive is built before it is served,
+    so it has a size and ETag and supports Range requests. Build progress
+    is published as 'archive_job' events. The archive is kept for
+    config.zip_job_retention seconds after it was last downloaded.
+    
+    Args:
+        body: IDs of files and/or folder paths.
+        
+    Returns:
+        The job (poll /api/archive-jobs/{id} or follow the events), with
+        the names that were skipped.
+    """
+    archive_format = _archive_format(request, body.format)
+    entries, skipped = await _resolve_zip_entries(body.files)
+    name = f"{_zip_archive_name(body.files)}{ARCHIVE_FORMATS[archive_format][1]}"
+    try:
+        job = await run_in_executor(zip_jobs.start, entries, name, _publish_archive_job, archive_format)
+    except JobRejected as e:
+        raise HTTPException(status_code=e.status, detail=str(e))
+    return {**job.to_dict(), "skipped": skipped}
+
+
+def _get_archive_job(job_id: str) -> ZipJob:
+    job = zip_jobs.get(job_id)
+    if job is None:
+        raise HTTPException(status_code=404, detail="Archive not found or expired")
+    return job
+
+
+@router.get("/api/archive-jobs/{job_id}")
+async def get_archive_job(job_id: str):
+    """Status of an archive job: building (with bytes written), ready or failed."""
+    return _get_archive_job(job_id).to_dict()
+
+
+@router.api_route("/api/archive-jobs/{job_id}/download", methods=["GET", "HEAD"])
+async def download_archive_job(request: Request, job_id: str):
+    """
+    Download a prepared archive, with Range and If-Range support for resuming.
+    
+    Every download keeps the archive for another retention window.
+    
+    Returns:
+        The archive; 409 while it is still being built.
+    """
+    job = _get_archive_job(job_id)
+    if job.state == "building":
+        raise HTTPException(status_code=409, detail="The archive is still being prepared")
+    if job.state == "failed":
+        raise HTTPException(status_code=500, detail=f"The archive could not be prepared: {job.error}")
+    zip_jobs.touch(job)
+    
+    representation = select_representation(request.headers, job.total, job.etag, compressible=False)
+    headers = {**representation.headers, "Content-Disposition": content_disposition(job.name)}
+    if representation.status == 304 or request.method == "HEAD":
+        return Response(status_code=representation.status, headers=headers)
+    
+    try:
+        f = open(job.path, "rb")
+    except FileNotFoundError:
+        raise HTTPException(status_code=404, detail="Archive not found or expired")
+    
+    device = get_device_id(request)
+    transfer = stats.start_transfer(
+        job.name, "download", request.client.host, representation.length, device, get_transfer_token(request),
+    )
+    headers["X-Transfer-Id"] = transfer.token
+    start, length = representation.start, representation.length
+    
+    def file_iterator():
+        with f:
+            yield from _read_range(f, start, length)
+    
+    return StreamingResponse(
+        _relay_stream(file_iterator(), transfer, device),
+        status_code=representation.status,
+        media_type=job.media_type,
+        headers=headers,
+    )
+
+
+# Largest window GET /api/tail will read
+MAX_TAIL_BYTES = 4 * 1024 * 1024
+
+
+@router.get("/api/tail/{filename:path}", dependencies=[Depends(require_storage)])
+async def tail_file(filename: str, window: int = Query(65536, alias="bytes"), lines: Optional[int] = None):
+    """
+    Show the end of a text file (e.g. a log) without downloading it all.
+    
+    Args:
+        filename: ID of the file.
+        bytes: Size of the window read from the end (max 4 MB).
+        lines: Return only the last N complete lines of that window.
+        
+    Returns:
+        The tail as UTF-8 text; 415 if the file looks binary.
+    """
+    if window < 1 or (lines is not None and lines < 1):
+        raise HTTPException(status_code=400, detail="bytes and lines must be positive")
+    
+    entry = await _stat_or_raise(filename)
+    meta = await run_in_executor(load_meta, filename)
+    if _get_path_filter().is_excluded(get_display_name(filename, meta)):
+        raise HTTPException(status_code=404, detail="File not found")
+    if _is_burn_after_download(meta):
+        raise HTTPException(status_code=403, detail="Single-use files can't be previewed")
+    
+    length = min(window, MAX_TAIL_BYTES, entry.size)
+    start = entry.size - length
+    
+    def read_window() -> bytes:
+        with closing(get_storage().open(filename)) as f:
+            return b"".join(_read_range(f, start, length))
+    
+    data = await run_in_executor(read_window)
+    if b"\0" in data:
+        raise HTTPException(status_code=415, detail="File is not text")
+    
+    if lines is not None:
+        kept = data.splitlines(keepends=True)
+        if start > 0 and kept:
+            kept = kept[1:]  # The window began mid-line
+        data = b"".join(kept[-lines:])
+        start = entry.size - len(data)
+    
+    return Response(content=data.decode("utf-8", errors="replace"), media_type="text/plain; charset=utf-8", headers={
+        "X-File-Size": str(entry.size),
+        "X-Tail-Start": str(start),
+    })
+
+
+class FileInfoRequest(BaseModel):
+    """Body of POST /api/files/info."""
+    filenames: List[str]
+
+
+# Largest batch POST /api/files/info accepts
+MAX_INFO_BATCH = 1000
+
+
+@router.post("/api/files/info")
+async def get_files_info(request: FileInfoRequest):
+    """
+    Get info for several files in one call.
+    
+    Lets clients refresh the files they are showing without fetching
+    the whole listing. Lookups run in parallel.
+    
+    Args:
+        request: IDs of the files to look up.
+        
+    Returns:
+        Map of ID to file info, or to {"error": ...} for files that are
+        missing or hidden.
+    """
+    if len(request.filenames) > MAX_INFO_BATCH:
+        raise HTTPException(status_code=400, detail=f"At most {MAX_INFO_BATCH} files per request")
+    
+    path_filter = _get_path_filter()
+    
+    def info_single(filename: str) -> dict:
+        try:
+            info = _get_file_info(get_storage().stat(filename))
+        except FileNotFoundError:
+            return {"error": "File not found"}
+        except PermissionError:
+            return {"error": "Access denied"}
+        if filename.startswith(".") or path_filter.is_excluded(info["name"]):
+            return {"error": "File not found"}
+        return info
+    
+    filenames = list(dict.fromkeys(request.filenames))
+    results = await asyncio.gather(*(run_in_executor(info_single, fn) for fn in filenames))
+    return {"files": dict(zip(filenames, results))}
+
+
+@router.post("/api/files/{filename:path}/claim")
+async def create_claim(request: Request, filename: str, expires: int = 0):
+    """
+    Issue a short single-use code that downloads this file (host only).
+    
+    Args:
+        filename: ID of the file.
+        expires: Seconds the code stays valid (default: config.claim_ttl).
+        
+    Returns:
+        The code, its expiry and the URL recipients can open.
+    """
+    _require_host(request)
+    await _stat_or_raise(filename)
+    
+    try:
+        claim = claims.create(filename, expires or config.claim_ttl)
+    except RuntimeError as e:
+        raise HTTPException(status_code=503, detail=str(e))
+    
+    return {
+        "code": claim.code,
+        "filename": get_display_name(filename),
+        "expires": format_timestamp(claim.expires),
+        "url": f"{get_server_url(config.port)}/c/{claim.code}",
+    }
+
+
+class CollectionRequest(BaseModel):
+    """Body of POST /api/collections."""
+    name: str
+    expires: str = ""  # Duration, e.g. '7d'; empty = until revoked
+    quota: str = ""  # Size, e.g. '2GB'; empty = unlimited
+
+
+def _collection_info(collection: Collection) -> dict:
+    """Collection link as listed to the host."""
+    state = "revoked" if collection.revoked else "expired" if collection.expired else "active"
+    return {
+        "id": collection.id,
+        "name": collection.name,
+        "folder": collection.folder,
+        "url": f"{get_server_url(config.port)}/u/{collection.token}",
+        "state": state,
+        "created": format_timestamp(collection.created),
+        "expires": format_timestamp(collection.expires) if collection.expires else None,
+        "quota": collection.quota or None,
+        "used": collection.used,
+        "used_human": format_size(collection.used),
+        "remaining": collection.remaining,
+        "files": collection.files,
+    }
+
+
+def _open_collection(token: str) -> Collection:
+    """The collection behind a link's token, or 404/410 if it can't take uploads."""
+    collection, problem = collection_links.resolve(token)
+    if problem == "unknown":
+        raise HTTPException(status_code=404, detail="Unknown upload link")
+    if problem:
+        raise HTTPException(status_code=410, detail=f"This upload link has {problem}")
+    return collection
+
+
+@router.post("/api/collections", status_code=201)
+async def create_collection(request: Request, body: CollectionRequest):
+    """
+    Create a link guests can upload through without the share key (host only).
+    
+    Files sent to /u/<token> land in a folder named after the link and
+    record the link's id in their metadata. The token is the only
+    credential, so the link works even on shares needing the key or PIN.
+    Links are kept in the data directory, so they can be handed out
+    before the share is next started.
+    
+    Args:
+        body: Name, and optionally how long the link works and how many
+            bytes it may take in total.
+        
+    Returns:
+        The link, including the URL to hand out.
+    """
+    _require_host(request)
+    if config.storage_backend != "local":
+        raise HTTPException(status_code=400, detail="Collection links need local storage")
+    name = body.name.strip()
+    if not name:
+        raise HTTPException(status_code=400, detail="Give the collection a name")
+    try:
+        ttl = parse_duration(body.expires) if body.expires else None
+        quota = parse_size(body.quota) if body.quota else 0
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    
+    collection = await run_in_executor(collection_links.create, name, ttl, quota)
+    return _collection_info(collection)
+
+
+@router.get("/api/collections")
+async def list_collections(request: Request):
+    """
+    Every collection link with its usage (host only).
+    
+    Returns:
+        Newest first, including expired and revoked links.
+    """
+    _require_host(request)
+    return {"collections": [_collection_info(c) for c in collection_links.list()]}
+
+
+@router.delete("/api/collections/{collection_id}")
+async def revoke_collection(request: Request, collection_id: str):
+    """
+    Stop a collection link from taking uploads (host only).
+    
+    Files already uploaded through it are kept.
+    
+    Returns:
+        The revoked link.
+    """
+    _require_host(request)
+    collection = await run_in_executor(collection_links.revoke, collection_id)
+    if collection is None:
+        raise HTTPException(status_code=404, detail="Collection not found")
+    return _collection_info(collection)
+
+
+@router.post("/api/collect/{token}", dependencies=[Depends(require_storage), Depends(require_uploads_open)])
+async def upload_to_collection(request: Request, token: str):
+    """
+    Upload files through a collection link; the page at /u/<token> posts here.
+    
+    Args:
+        token: The link's token.
+        files: Files to upload (the form field, repeated); at most
+            config.max_multipart_parts.
+        
+    Returns:
+        Per-file results, as from POST /api/upload-multiple, and the
+        link's remaining quota.
+    """
+    collection = _open_collection(token)
+    device = get_device_id(request)
+    form = await _read_upload_form(request)
+    results = []
+    try:
+        for index, file in enumerate(_uploaded_files(form)):
+            result = await _save_uploaded_file(file, request.client.host, device, collection=collection)
+            results.append({**result, "index": index})
+    finally:
+        await form.close()
+    
+    return {
+        "success": all(r["success"] for r in results),
+        "files": results,
+        "remaining": collection.remaining,
+    }
+
+
+@router.get("/api/claim/{code}", dependencies=[Depends(require_storage)])
+async def redeem_claim(request: Request, code: str):
+    """
+    Download the file behind a claim code. Each code works once.
+    
+    The route works without the share key, so each client gets a few
+    wrong codes before it is refused with 429.
+    
+    Args:
+        code: The code, case-insensitive.
+        
+    Returns:
+        The file download.
+    """
+    client = request.client.host if request.client else ""
+    claim = claims.redeem(code, client)
+    if claim is None:
+        if claims.locked(client):
+            raise CodedHTTPException(429, "Too many wrong codes; ask for a new link", "claim_locked")
+        raise HTTPException(status_code=404, detail="Unknown, expired or already used code")
+    try:
+        return await download_file(request, claim.filename)
+    except BaseException:
+        # Nothing was sent (missing, filtered, already being downloaded), so the code stays usable
+        claims.release(claim)
+        raise
+
+
+@router.get("/api/files/{filename:path}/chunks", dependencies=[Depends(require_storage)])
+async def get_chunk_manifest(filename: str, size: str = "64MB"):
+    """
+    Get per-chunk SHA-256 hashes for verifying a download piecewise.
+    
+    Computed in one streaming pass and cached in the file's metadata
+    sidecar until the file changes.
+    
+    Args:
+        filename: Name of the file.
+        size: Chunk size, e.g. '64MB' (default) or a byte count.
+        
+    Returns:
+        Manifest with chunk_size, total and the list of chunk hashes.
+    """
+    try:
+        chunk_size = parse_size(size)
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    if chunk_size < config.chunk_size:
+        raise HTTPException(status_code=400, detail=f"Chunk size must be at least {format_size(config.chunk_size)}")
+    
+    # Hashes and sizes of what download_file would refuse stay hidden too
+    entry = await _stat_or_raise(filename)
+    meta = await run_in_executor(load_meta, filename)
+    if _get_path_filter().is_excluded(get_display_name(filename, meta)):
+        raise HTTPException(status_code=404, detail="File not found")
+    if _is_burn_after_download(meta):
+        raise HTTPException(status_code=403, detail="Single-use files can't be downloaded in parts")
+    
+    return await run_in_executor(chunk_manifest, get_storage(), entry, chunk_size)
+
+
+# After GET /api/files/{filename}/chunks, which would otherwise match here
+@router.get("/api/files/{filename:path}")
+async def get_file_details(filename: str):
+    """
+    Get details for a single file, including its claim code status.
+    
+    Codes themselves are never listed; only whether each was claimed.
+    
+    Args:
+        filename: ID of the file.
+        
+    Returns:
+        File info plus a 'claims' list.
+    """
+    entry = await _stat_or_raise(filename)
+    info = await run_in_executor(_get_file_info, entry)
+    info["claims"] = [
+        {"expires": format_timestamp(c.expires), "claimed": c.claimed}
+        for c in claims.for_file(filename)
+    ]
+    return info
+
+
+@router.get("/api/checksum/{filename:path}", dependencies=[Depends(require_storage)])
+async def get_checksum(filename: str, algo: str = "sha256"):
+    """
+    Get a whole-file checksum, computing it if needed.
+    
+    Large files take a while to hash, so progress is published as
+    'checksum' events ({id, algorithm, done, total}) until a final event
+    carrying the checksum. Results are cached in the file's metadata
+    sidecar until the file changes, so repeat requests return instantly.
+    
+    Args:
+        filename: ID of the file.
+        algo: sha256 (default), md5 (e.g. to compare with S3 ETags) or crc32.
+        
+    Returns:
+        The checksum and whether it came from the cache.
+    """
+    if algo not in ALGORITHMS:
+        raise HTTPException(status_code=400, detail=f"Unknown algorithm '{algo}' (use {', '.join(ALGORITHMS)})")
+    
+    entry = await _stat_or_raise(filename)
+    if _get_path_filter().is_excluded(get_display_name(filename)):
+        raise HTTPException(status_code=404, detail="File not found")
+    
+    def publish_progress(delta: int, done: int):
+        hub.publish("checksum", {"id": entry.name, "algorithm": algo, "done": done, "total": entry.size})
+    
+    checksum, cached = await run_in_executor(file_checksum, get_storage(), entry, algo, publish_progress)
+    if not cached:
+        hub.publish("checksum", {"id": entry.name, "algorithm": algo, "checksum": checksum})
+    return {
+        "id": entry.name,
+        "algorithm": algo,
+        "checksum": checksum,
+        "size": entry.size,
+        "cached": cached,
+    }
+
+
+@router.post("/api/upload", dependencies=[Depends(require_storage), Depends(require_uploads_open)])
+async def upload_file(request: Request):
+    """
+    Upload a single file from the phone to the laptop.
+    
+    Send 'X-Burn-After-Download: 1' to make the file single-use.
+    
+    Args:
+        file: The uploaded file (the form field).
+        
+    Returns:
+        Upload result information.
+    """
+    form = await _read_upload_form(request)
+    try:
+        file = form.get("file")
+        if file is None or isinstance(file, str):
+            raise HTTPException(status_code=400, detail="No file provided")
+        result = await _save_uploaded_file(
+            file, request.client.host, get_device_id(request), get_transfer_token(request), get_client_mtime(request)
+        )
+    finally:
+        await form.close()
+    
+    if not result["success"]:
+        body = {"detail": result.get("error", "Upload failed")}
+        if "remaining" in result:
+            body["remaining"] = result["remaining"]
+        return JSONResponse(body, status_code=result.get("status", 400))
+    
+    if request.headers.get("x-burn-after-download") == "1" and not result.get("zip_removed"):
+        await run_in_executor(functools.partial(update_meta, result["id"], burn_after_download=True))
+        result["burn_after_download"] = True
+    
+    return JSONResponse(result, headers={"X-Transfer-Id": result["transfer_id"]})
+
+
+# Most files one POST /api/upload/check may ask about
+MAX_UPLOAD_CHECKS = 1000
+
+
+class UploadDescriptor(BaseModel):
+    """A file a client is about to upload, as far as it knows it."""
+    name: str
+    size: Optional[int] = None
+    sha256: Optional[str] = None  # Hex, optionally prefixed with 'sha256:'
+
+
+def _check_upload(storage: Storage, item: UploadDescriptor, index: dict[str, str]) -> dict:
+    """
+    What uploading one file would do: 'exists', 'duplicate' or 'proceed'.
+    
+    A hash is matched against every stored file's recorded checksum:
+    the same content under the name the upload would get is 'exists',
+    under another name 'duplicate'. Without a hash (or with one nothing
+    matches), a stored file of the same name and size is 'exists', unless
+    its recorded checksum shows other content. Obfuscated shares store
+    files under random IDs, so there only hashes can match.
+    """
+    safe = sanitize_filename(item.name)
+    result = {"name": item.name, "status": "proceed", "match": None, "duplicate_of": None, "target": None}
+    # Sorted or renamed files land elsewhere, so there is no name to promise
+    if not config.obfuscate_names and not config.organize_uploads and not config.date_folders:
+        result["target"] = next(name for name in _candidate_names(safe) if not storage.exists(name))
+    
+    digest = (item.sha256 or "").lower().removeprefix("sha256:")
+    if digest and digest in index:
+        existing = index[digest]
+        same_name = safe in (existing, load_meta(existing).get("original_name"))
+        result.update(status="exists" if same_name else "duplicate", match="sha256", duplicate_of=existing)
+        return result
+    
+    if item.size is None or config.obfuscate_names:
+        return result
+    try:
+        entry = storage.stat(safe)
+    except (OSError, ValueError):
+        return result
+    if entry.size != item.size:
+        return result
+    recorded = load_meta(safe).get("sha256") or {}
+    if digest and recorded.get("mtime") == entry.modified and recorded.get("size") == entry.size:
+        return result  # Same name and size, but the recorded hash shows other content
+    result.update(status="exists", match="name_size", duplicate_of=entry.name)
+    return result
+
+
+def _check_uploads(items: List[UploadDescriptor]) -> list[dict]:
+    storage = get_storage()
+    # One pass over the sidecars answers every hash in the request
+    index = sha256_index() if any(item.sha256 for item in items) else {}
+    return [_check_upload(storage, item, index) for item in items]
+
+
+@router.post("/api/upload/check", dependencies=[Depends(require_storage)])
+async def check_uploads(files: List[UploadDescriptor]):
+    """
+    Ask which files are already stored before sending any bytes.
+    
+    Args:
+        files: JSON array of {name, size?, sha256?}, at most MAX_UPLOAD_CHECKS.
+        
+    Returns:
+        One entry per file, in order: 'status' is 'exists' (already
+        stored under this name), 'duplicate' (the same content is stored
+        as 'duplicate_of') or 'proceed'; 'match' says whether the hash or
+        only name and size matched; 'target' is the name the upload would
+        be stored under now (null when uploads are sorted into folders or
+        stored under random IDs).
+    """
+    if len(files) > MAX_UPLOAD_CHECKS:
+        raise HTTPException(status_code=413, detail=f"Check at most {MAX_UPLOAD_CHECKS} files at once")
+    for item in files:
+        digest = (item.sha256 or "").lower().removeprefix("sha256:")
+        if item.sha256 and not re.fullmatch(r"[0-9a-f]{64}", digest):
+            raise HTTPException(status_code=400, detail=f"sha256 of '{item.name}' is not a hex SHA-256")
+    
+    return {"files": await run_in_executor(_check_uploads, files)}
+
+
+async def _read_upload_form(request: Request) -> FormData:
+    """
+    Parse a multipart upload, refusing more than config.max_multipart_parts parts with 413.
+    
+    The parser stops as soon as the limit is passed, so a body of
+    thousands of tiny parts is never held in memory. Close the form when done.
+    """
+    limit = config.max_multipart_parts
+    try:
+        return await request.form(max_files=limit, max_fields=limit)
+    except StarletteHTTPException as e:
+        if str(e.detail).startswith("Too many"):
+            raise HTTPException(status_code=413, detail=f"Too many parts in one upload; send at most {limit}")
+        raise
+
+
+def _uploaded_files(form: FormData) -> List[UploadFile]:
+    """The files sent as 'files' in an upload form."""
+    files = [value for value in form.getlist("files") if not isinstance(value, str)]
+    if not files:
+        raise HTTPException(status_code=400, detail="No files provided")
+    return files
+
+
+@router.post("/api/upload-multiple", dependencies=[Depends(require_storage), Depends(require_uploads_open)])
+async def upload_multiple_files(request: Request):
+    """
+    Upload multiple files simultaneously with parallel processing.
+    
+    Uses asyncio.gather for concurrent file saving operations. Forms
+    with more than config.max_multipart_parts parts are refused with 413.
+    
+    Args:
+        files: Files to upload (the form field, repeated).
+        
+    Returns:
+        Batch upload results in submission order, each carrying its
+        index, with a summary.
+    """
+    form = await _read_upload_form(request)
+    try:
+        files = _uploaded_files(form)
+        
+        # Process all files in parallel
+        device = get_device_id(request)
+        tasks = [_save_uploaded_file(file, request.client.host, device) for file in files]
+        
+        # gather() keeps submission order; the index lets clients correlate
+        # each result with the file they selected even if results are streamed
+        results = [{**result, "index": index} for index, result in enumerate(await asyncio.gather(*tasks))]
+    finally:
+        await form.close()
+    
+    # Compute summary using filter lambdas
+    successful = list(filter(lambda r: r["success"], results))
+    failed = list(filter(lambda r: not r["success"], results))
+    
+    total_size = sum(map(lambda r: r.get("size", 0), successful))
+    
+    return {
+        "success": len(failed) == 0,
+        "files": results,
+        "summary": {
+            "total": len(results),
+            "successful": len(successful),
+            "failed": len(failed),
+            "failed_indices": [r["index"] for r in failed],
+            "total_size": total_size,
+            "total_size_human": format_size(total_size),
+        }
+    }
+
+
+@router.put("/api/files/{filename:path}", status_code=201, dependencies=[Depends(require_storage), Depends(require_uploads_open)])
+async def put_file(request: Request, filename: str):
+    """
+    Upload a file as the raw request body, e.g. curl -T file http://host/api/files/name.
+    
+    Goes through the same checks as multipart uploads: the name is
+    sanitized, Content-Length is checked against the size limit, quota
+    and free space before reading, and an existing file is never
+    overwritten (the upload gets a _1, _2, ... suffix instead).
+    
+    Args:
+        filename: Name to store the file under.
+        
+    Returns:
+        File info of the stored file.
+    """
+    if _get_path_filter().is_excluded(sanitize_filename(filename)):
+        pipeline.decide("upload", filename, "filter", "excluded")
+        raise HTTPException(status_code=403, detail="Files of this type can't be uploaded to this share")
+    
+    device = get_device_id(request)
+    declared = request.headers.get("content-length")
+    rejection = check_declared_upload_size(declared, device)
+    if rejection:
+        return JSONResponse(rejection.body(), status_code=rejection.status)
+    
+    size = int(declared) if declared and declared.isdigit() else None
+    result = await _save_uploaded_file(
+        _RawBodyUpload(request, filename, size), request.client.host, device,
+        get_transfer_token(request), get_client_mtime(request),
+    )
+    
+    if not result["success"]:
+        body = {"detail": result.get("error", "Upload failed")}
+        if "remaining" in result:
+            body["remaining"] = result["remaining"]
+        return JSONResponse(body, status_code=result.get("status", 400))
+    
+    # An auto-extracted zip may be gone already; the upload result describes the folder
+    if result.get("zip_removed"):
+        return JSONResponse(result, status_code=201)
+    
+    entry = await _stat_or_raise(result["id"])
+    info = await run_in_executor(_get_file_info, entry)
+    return JSONResponse(info, status_code=201, headers={"X-Transfer-Id": result["transfer_id"]})
+
+
+# ==================== tus Resumable Uploads ====================
+
+# Bodies of tus PATCH requests must be declared as this
+TUS_CONTENT_TYPE = "application/offset+octet-stream"
+
+
+def _tus_headers(upload: Optional[TusUpload] = None, **extra) -> dict:
+    """Headers every tus response carries, plus the upload's offset and expiry."""
+    headers = {"Tus-Resumable": TUS_VERSION, **extra}
+    if upload is not None:
+        headers["Upload-Offset"] = str(upload.offset)
+        headers["Upload-Expires"] = formatdate(upload.expires, usegmt=True)
+    return headers
+
+
+def _tus_error(status: int, detail: str) -> JSONResponse:
+    return JSONResponse({"detail": detail}, status_code=status, headers=_tus_headers())
+
+
+def _check_tus_request(request: Request) -> Optional[JSONResponse]:
+    """Refuse requests for another protocol version, and tus on remote storage."""
+    if request.headers.get("tus-resumable") != TUS_VERSION:
+        return JSONResponse(
+            {"detail": f"Only tus {TUS_VERSION} is supported"},
+            status_code=412,
+            headers={**_tus_headers(), "Tus-Version": TUS_VERSION},
+        )
+    if config.storage_backend != "local":
+        return _tus_error(400, "Resumable uploads need local storage")
+    return None
+
+
+class _StagedUpload:
+    """A finished tus upload's staged data, read like an UploadFile so it shares the save path."""
+    
+    def __init__(self, upload: TusUpload):
+        self.filename = upload.filename
+        self.size = upload.length
+        self._path = upload.path
+        self._file: Optional[BinaryIO] = None
+    
+    async def read(self, size: int) -> bytes:
+        if self._file is None:
+            self._file = await run_in_executor(open, self._path, "rb")
+        return await run_in_executor(self._file.read, size)
+    
+    async def close(self):
+        if self._file is not None:
+            await run_in_executor(self._file.close)
+
+
+async def _finish_tus_upload(upload: TusUpload) -> dict:
+    """
+    Store a fully received upload like any other and drop its staged copy.
+    
+    The file goes through the same save path as multipart uploads, so it
+    gets the same name handling (a _1, _2, ... suffix when taken), quota,
+    metadata and events.
+    """
+    staged = _StagedUpload(upload)
+    try:
+        modified = int(upload.metadata.get("lastModified", "")) / 1000
+    except ValueError:
+        modified = None
+    try:
+        return await _save_uploaded_file(staged, upload.client, upload.device, modified=modified)
+    finally:
+        await staged.close()
+        await run_in_executor(tus_uploads.delete, upload)
+
+
+@router.options("/api/tus")
+@router.options("/api/tus/{upload_id}")
+async def tus_options():
+    """Describe the tus protocol support (version, extensions, size limit)."""
+    headers = _tus_headers(**{"Tus-Version": TUS_VERSION, "Tus-Extension": ",".join(TUS_EXTENSIONS)})
+    if config.max_upload_size:
+        headers["Tus-Max-Size"] = str(config.max_upload_size)
+    return Response(status_code=204, headers=headers)
+
+
+@router.post("/api/tus", dependencies=[Depends(require_storage), Depends(require_uploads_open)])
+async def tus_create(request: Request):
+    """
+    Start a resumable upload (tus creation), e.g. from Uppy or tus-js-client.
+    
+    Upload-Length is required; Upload-Metadata may name the file
+    ('filename' or 'name') and give 'lastModified' in milliseconds. The
+    size is checked against the size limit, quota and free space up
+    front. Data is then sent with PATCH to the returned Location.
+    
+    Returns:
+        201 with Location; an empty file is stored right away.
+    """
+    if rejection := _check_tus_request(request):
+        return rejection
+    length = request.headers.get("upload-length", "")
+    if not length.isdigit():
+        return _tus_error(400, "Upload-Length is required (deferred lengths are not supported)")
+    try:
+        metadata = parse_metadata(request.headers.get("upload-metadata"))
+    except ValueError as e:
+        return _tus_error(400, str(e))
+    
+    device = get_device_id(request)
+    upload = TusUpload("", int(length), metadata)
+    if _get_path_filter().is_excluded(sanitize_filename(upload.filename)):
+        pipeline.decide("upload", upload.filename, "filter", "excluded")
+        return _tus_error(403, "Files of this type can't be uploaded to this share")
+    rejection = check_declared_upload_size(length, device)
+    if rejection:
+        return JSONResponse(rejection.body(), status_code=rejection.status, headers=_tus_headers())
+    
+    upload = await run_in_executor(tus_uploads.create, int(length), metadata, device, request.client.host)
+    headers = _tus_headers(upload, Location=f"/api/tus/{upload.id}")
+    if upload.length == 0:
+        result = await _finish_tus_upload(upload)
+        if not result["success"]:
+            return JSONResponse(
+                {"detail": result.get("error", "Upload failed")}, status_code=result.get("status", 400), headers=headers
+            )
+        headers["X-File-Id"] = quote(result["id"])
+    return Response(status_code=201, headers=headers)
+
+
+@router.head("/api/tus/{upload_id}")
+async def tus_offset(request: Request, upload_id: str):
+    """How much of an upload has arrived, so the client knows where to resume."""
+    if rejection := _check_tus_request(request):
+        return rejection
+    upload = await run_in_executor(tus_uploads.get, upload_id)
+    if upload is None:
+        return Response(status_code=404, headers=_tus_headers())
+    headers = _tus_headers(upload, **{"Upload-Length": str(upload.length), "Cache-Control": "no-store"})
+    if upload.metadata:
+        headers["Upload-Metadata"] = format_metadata(upload.metadata)
+    return Response(status_code=200, headers=headers)
+
+
+@router.patch("/api/tus/{upload_id}", dependencies=[Depends(require_storage), Depends(require_uploads_open)])
+async def tus_append(request: Request, upload_id: str):
+    """
+    Append data to an upload at Upload-Offset.
+    
+    Bytes received before a dropped connection are kept, so the client
+    can ask HEAD for the offset and carry on. Once every byte is in, the
+    file is stored in the uploads directory.
+    
+    Returns:
+        204 with the new Upload-Offset (and X-File-Id once stored); 409
+        if Upload-Offset isn't where the upload stands.
+    """
+    if rejection := _check_tus_request(request):
+        return rejection
+    if request.headers.get("content-type") != TUS_CONTENT_TYPE:
+        return _tus_error(415, f"Content-Type must be {TUS_CONTENT_TYPE}")
+    upload = await run_in_executor(tus_uploads.get, upload_id)
+    if upload is None:
+        return _tus_error(404, "Upload not found or expired")
+    offset = request.headers.get("upload-offset", "")
+    if not offset.isdigit():
+        return _tus_error(400, "Upload-Offset is required")
+    if int(offset) != upload.offset:
+        return JSONResponse(
+            {"detail": f"Upload-Offset is {offset}, but the upload is at {upload.offset}"},
+            status_code=409,
+            headers=_tus_headers(upload),
+        )
+    if not tus_uploads.claim(upload):
+        return _tus_error(423, "Another request is appending to this upload")
+    
+    received = int(offset)
+    try:
+        with await run_in_executor(open, upload.path, "ab") as f:
+            buffer = bytearray()
+            try:
+                async for chunk in request.stream():
+                    received += len(chunk)
+                    if received > upload.length:
+                        return _tus_error(413, "More data than Upload-Length was sent")
+                    buffer += chunk
+                    if len(buffer) >= config.chunk_size:
+                        await run_in_executor(f.write, bytes(buffer))
+                        buffer.clear()
+            except ClientDisconnect:
+                pass  # What arrived is kept for the client to resume from
+            await run_in_executor(f.write, bytes(buffer))
+    finally:
+        tus_uploads.release(upload)
+    
+    await run_in_executor(tus_uploads.touch, upload)
+    headers = _tus_headers(upload)
+    if upload.offset < upload.length:
+        return Response(status_code=204, headers=headers)
+    
+    result = await _finish_tus_upload(upload)
+    if not result["success"]:
+        body = {"detail": result.get("error", "Upload failed")}
+        if "remaining" in result:
+            body["remaining"] = result["remaining"]
+        return JSONResponse(body, status_code=result.get("status", 400), headers=headers)
+    headers["X-File-Id"] = quote(result["id"])
+    return Response(status_code=204, headers=headers)
+
+
+@router.delete("/api/tus/{upload_id}")
+async def tus_terminate(request: Request, upload_id: str):
+    """Abandon an upload, deleting what was received (tus termination)."""
+    if rejection := _check_tus_request(request):
+        return rejection
+    upload = await run_in_executor(tus_uploads.get, upload_id)
+    if upload is None:
+        return _tus_error(404, "Upload not found or expired")
+    if not tus_uploads.claim(upload):
+        return _tus_error(423, "Another request is appending to this upload")
+    try:
+        await run_in_executor(tus_uploads.delete, upload)
+    finally:
+        tus_uploads.release(upload)
+    return Response(status_code=204, headers=_tus_headers())
+
+
+class MergeRequest(BaseModel):
+    """Body of POST /api/merge."""
+    parts: List[str]
+    output: str
+    delete_parts: bool = False
+
+
+def _concatenate(storage: Storage, parts: List[str], target: BinaryIO):
+    """Stream each part into the target in order."""
+    for part in parts:
+        with closing(storage.open(part)) as source:
+            shutil.copyfileobj(source, target, config.chunk_size)
+
+
+@router.post("/api/merge", dependencies=[Depends(require_storage), Depends(require_uploads_open)])
+async def merge_files(
+    request: MergeRequest,
+    role: str = Depends(get_role),
+    device: str = Depends(get_device_id),
+):
+    """
+    Concatenate uploaded parts into a single file.
+    
+    Parts are streamed in the given order, so memory use stays flat. The
+    output name gets a _1, _2, ... suffix if it's taken. Parts hidden by
+    the path filter can't be merged, and the merged size counts against
+    the upload size limit and the device's quota like an upload.
+    
+    Args:
+        request: Ordered part names, output name and whether to delete the parts.
+        
+    Returns:
+        File info for the merged file.
+    """
+    if not request.parts:
+        raise HTTPException(status_code=400, detail="No parts provided")
+    if len(set(request.parts)) != len(request.parts):
+        raise HTTPException(status_code=400, detail="Each part may only be listed once")
+    if request.delete_parts and not has_role(role, "trusted"):
+        raise HTTPException(status_code=403, detail="Deleting the parts needs the 'trusted' role; ask the host")
+    
+    # Also rejects part names escaping the uploads directory
+    path_filter = _get_path_filter()
+    total = 0
+    for part in request.parts:
+        entry = await _stat_or_raise(part)
+        meta = await run_in_executor(load_meta, part)
+        if path_filter.is_excluded(get_display_name(part, meta)):
+            raise HTTPException(status_code=404, detail="File not found")
+        if _is_burn_after_download(meta):
+            raise HTTPException(status_code=403, detail="Single-use files can't be merged")
+        if request.delete_parts:
+            await _check_owner(part, role, device)
+        total += entry.size
+    
+    safe_filename = sanitize_filename(request.output)
+    if path_filter.is_excluded(safe_filename):
+        raise HTTPException(status_code=403, detail="Files of this type can't be uploaded to this share")
+    
+    # The merged copy is new data, so it counts against the same limits as an upload
+    rejection = check_declared_upload_size(str(total), device)
+    if rejection:
+        raise HTTPException(status_code=rejection.status, detail=rejection.message)
+    if device and not devices.reserve_upload(device, total):
+        rejection = _quota_exceeded(devices.remaining(device) or 0)
+        raise HTTPException(status_code=rejection.status, detail=rejection.message)
+    
+    storage = get_storage()
+    stored_name = secrets.token_hex(16) if config.obfuscate_names else safe_filename
+    try:
+        target_name, f = await _create_unique(storage, stored_name)
+    except BaseException:
+        if device:
+            devices.release_upload(device, total)
+        raise
+    
+    try:
+        await run_in_executor(_concatenate, storage, request.parts, f)
+    except BaseException as e:
+        if device:
+            devices.release_upload(device, total)
+        await run_in_executor(f.close)
+        await run_in_executor(storage.delete, target_name)
+        if isinstance(e, OSError):
+            raise HTTPException(status_code=500, detail=f"Merge failed: {e}")
+        raise
+    await run_in_executor(f.close)
+    
+    if config.obfuscate_names or config.owner_only_modify:
+        await run_in_executor(functools.partial(
+            update_meta, target_name,
+            original_name=safe_filename if config.obfuscate_names else None,
+            owner=device if config.owner_only_modify else None,
+        ))
+    
+    if request.delete_parts:
+        for part in request.parts:
+            await run_in_executor(remove_file, part)
+    
+    entry = await run_in_executor(storage.stat, target_name)
+    dir_sizes.add(target_name, entry.size)
+    hub.publish("files", {"added": target_name})
+    return await run_in_executor(_get_file_info, entry)
+
+
+def _qr_link(request: Request) -> str:
+    """Link for a QR code: a fresh one-time link for the host (config.one_time_qr), the share link for others."""
+    return qr_url(config.port) if is_host(request) else share_url(config.port)
+
+
+@router.get("/api/qr")
+async def get_qr(request: Request):
+    """
+    Get QR code data for connecting to the server.
+    
+    With one-time QR links, every request by the host issues a new link.
+    
+    Returns:
+        QR code information including URL and encodings.
+    """
+    return get_qr_data(config.port, _qr_link(request))
+
+
+@router.get("/api/qr.png")
+async def get_qr_image(request: Request):
+    """
+    Get QR code as PNG image.
+    
+    Runs PNG generation in executor to avoid blocking.
+    
+    Returns:
+        PNG image of the QR code.
+    """
+    png_bytes = await run_in_executor(generate_qr_png_bytes, _qr_link(request), config.port)
+    return Response(content=png_bytes, media_type="image/png", headers={"Cache-Control": "no-store"})
+
+
+@router.get("/api/branding/logo")
+async def get_logo():
+    """
+    Get the custom logo configured for this share.
+    
+    Returns:
+        The logo image, validated when it was first loaded.
+    """
+    if not config.brand_logo_path:
+        raise HTTPException(status_code=404, detail="No logo configured")
+    
+    try:
+        data, media_type = await run_in_executor(load_logo, Path(config.brand_logo_path))
+    except (OSError, ValueError) as e:
+        raise HTTPException(status_code=404, detail=str(e))
+    return Response(content=data, media_type=media_type, headers={"Cache-Control": "max-age=3600"})
+
+
+class AnnounceRequest(BaseModel):
+    """Body of POST /api/announce."""
+    filename: str
+    message: str = ""
+
+
+# Longest note a host can attach to an announcement
+MAX_ANNOUNCE_MESSAGE = 280
+
+
+def _announcement_info(announcement: Announcement) -> dict:
+    """Announcement as sent in events and GET /api/announcements."""
+    return {
+        "id": announcement.id,
+        "file": announcement.file,
+        "message": announcement.message,
+        "created": format_timestamp(announcement.created),
+    }
+
+
+@router.post("/api/announce")
+async def announce_file(request: Request, body: AnnounceRequest):
+    """
+    Prompt every open page to download a file (host only).
+    
+    Connected browsers get an 'announcement' event and show a banner;
+    devices connecting within config.announcement_window seconds pick
+    it up from GET /api/announcements.
+    
+    Args:
+        body: The file's ID and an optional message.
+        
+    Returns:
+        The announcement.
+    """
+    _require_host(request)
+    if len(body.message) > MAX_ANNOUNCE_MESSAGE:
+        raise HTTPException(status_code=400, detail=f"Message is longer than {MAX_ANNOUNCE_MESSAGE} characters")
+    
+    entry = await _stat_or_raise(body.filename)
+    info = await run_in_executor(_get_file_info, entry)
+    announcement = announcements.add(body.filename, info, body.message.strip(), config.announcement_window)
+    info = _announcement_info(announcement)
+    hub.publish("announcement", info)
+    return info
+
+
+@router.get("/api/announcements")
+async def get_announcements():
+    """
+    Announcements made within the last config.announcement_window seconds.
+    
+    Returns:
+        Oldest first, in the same shape as 'announcement' events.
+    """
+    recent = announcements.recent(config.announcement_window)
+    return {"announcements": [_announcement_info(a) for a in recent]}
+
+
+@router.get("/api/events")
+async def event_stream(request: Request):
+    """
+    Server-Sent Events stream of live updates (e.g. 'files' on changes).
+    
+    While this device has transfers running, 'progress' events carry
+    their bytes, rate and ETA under the X-Transfer-Id they were given,
+    followed by one event with "done": true when each ends. Other
+    devices' transfers are never included.
+    
+    A comment line is sent every config.heartbeat_interval seconds so
+    mobile browsers and proxies keep the connection open, and so a dead
+    connection fails a write and gets cleaned up instead of lingering in
+    the hub.
+    
+    Returns:
+        text/event-stream response.
     """
-    async def delete_single(filename: str) -> dict:
-        file_path = config.uploads_dir / filename
+    subscriber = hub.subscribe(request.client.host if request.client else "")
+    device = get_device_id(request)
+    
+    async def watch_progress():
+        watched = set()
+        while True:
+            await asyncio.sleep(PROGRESS_INTERVAL)
+            current = stats.progress_for(device)
+            for progress in current:
+                subscriber.offer(format_event("progress", progress))
+            ids = {progress["id"] for progress in current}
+            for finished in watched - ids:
+                subscriber.offer(format_event("progress", {"id": finished, "done": True}))
+            watched = ids
+    
+    async def stream():
+        watcher = asyncio.create_task(watch_progress())
+        try:
+            yield "retry: 5000\n\n"
+            while not subscriber.dropped:
+                try:
+                    message = await asyncio.wait_for(subscriber.queue.get(), config.heartbeat_interval)
+                except asyncio.TimeoutError:
+                    if await request.is_disconnected():
+                        break
+                    message = ": ping\n\n"
+                yield message
+        finally:
+            watcher.cancel()
+            hub.unsubscribe(subscriber)
+    
+    return StreamingResponse(stream(), media_type="text/event-stream", headers={
+        "Cache-Control": "no-cache",
+        "X-Accel-Buffering": "no",
+    })
+
+
+@router.get("/api/status")
+async def get_status(request: Request):
+    """
+    Get server status and information.
+    
+    Answers even while the uploads directory is unavailable, reporting
+    'degraded' without file counts, so clients can tell the server is up.
+    
+    Returns:
+        Server status information including file count and storage stats.
+    """
+    storage = get_storage()
+    try:
+        require_storage()
+        files = await run_in_executor(storage.list)
+    except CodedHTTPException:
+        files = None
+    total_size = sum(entry.size for entry in files or [])
+    
+    return {
+        "status": "online" if files is not None else "degraded",
+        # Whether the uploads directory is there, and why not
+        "storage": storage_health.status(),
+        "url": get_server_url(config.port),
+        # Short code from the relay, when sharing with --relay
+        "relay": relay.status(),
+        "uploads_dir": str(config.uploads_dir),
+        "storage_backend": config.storage_backend,
+        "staging": config.staging_mode,
+        # Uploads held only in memory; lost if the process crashes
+        "unflushed": getattr(storage, "unflushed", 0),
+        "subscribers": hub.count,
+        # Everything under the uploads directory, subfolders included
+        "tree_size": dir_sizes.size(),
+        "size_index": dir_sizes.status(),
+        "integrity": sweep.status(),
+        "uploads_paused": _uploads_paused_at is not None,
+        # How outside changes are noticed: 'notify', 'poll' or 'off'
+        "watcher": watcher.mode,
+        "encodings": available_encodings(),
+        "file_count": len(files) if files is not None else None,
+        "total_size": total_size,
+        "total_size_human": format_size(total_size),
+        # A fresh one-time QR link, for the host only
+        "connect_url": qr_url(config.port) if config.one_time_qr and config.require_key and is_host(request) else None,
+    }
+
+
+@router.get("/api/config")
+async def get_config(request: Request):
+    """
+    Describe what this server supports so clients can adapt.
+    
+    Returns:
+        Capability document (mode, features, limits, branding).
+    """
+    return get_capabilities(get_device_id(request), get_role(request))
+
+
+@router.get("/api/transfers")
+async def get_transfers():
+    """
+    List transfers currently in progress.
+    
+    Returns:
+        Active transfers with bytes moved so far.
+    """
+    return [{**t, "started": format_timestamp(t["started"])} for t in stats.active_transfers()]
+
+
+@router.get("/api/metrics")
+async def get_metrics():
+    """
+    Get cumulative transfer counters and recent client activity.
+    
+    Returns:
+        Counters since server start plus per-client activity.
+    """
+    clients = [
+        {**c, "first_seen": format_timestamp(c["first_seen"]), "last_seen": format_timestamp(c["last_seen"])}
+        for c in stats.client_activity()
+    ]
+    return {
+        **stats.metrics(),
+        "client_activity": clients,
+    }
+
+
+# Largest body GET and POST /api/speedtest move in one test
+MAX_SPEEDTEST_BYTES = 1024 ** 3
+
+_SPEEDTEST_BLOCK = bytes(1024 * 1024)
+
+
+@router.get("/api/speedtest")
+async def speedtest_download(size: int = Query(100 * 1024 ** 2, alias="bytes")):
+    """
+    Stream zeros for a client to time, measuring download throughput.
+    
+    Nothing is read from disk and the body is never compressed, so only
+    the network is measured.
+    
+    Args:
+        bytes: How many bytes to send (max 1 GB).
         
-        if not file_path.exists():
-            return {"filename": filename, "success": False, "error": "File not found"}
+    Returns:
+        The zeros, with an exact Content-Length.
+    """
+    if not 0 <= size <= MAX_SPEEDTEST_BYTES:
+        raise HTTPException(status_code=400, detail=f"bytes must be between 0 and {MAX_SPEEDTEST_BYTES}")
+    
+    def zeros() -> Iterator[bytes]:
+        remaining = size
+        while remaining > 0:
+            block = _SPEEDTEST_BLOCK if remaining >= len(_SPEEDTEST_BLOCK) else _SPEEDTEST_BLOCK[:remaining]
+            remaining -= len(block)
+            yield block
+    
+    return StreamingResponse(zeros(), media_type="application/octet-stream", headers={
+        "Content-Length": str(size),
+        "Content-Encoding": "identity",
+        "Cache-Control": "no-store",
+    })
+
+
+@router.post("/api/speedtest")
+async def speedtest_upload(request: Request):
+    """
+    Read and discard the request body, measuring upload throughput.
+    
+    The clock starts at the first byte received, so connection setup
+    isn't counted.
+    
+    Returns:
+        Bytes received, seconds taken and bytes per second; 413 past 1 GB.
+    """
+    received = 0
+    started = None
+    try:
+        async for chunk in request.stream():
+            if started is None and chunk:
+                started = time.perf_counter()
+            received += len(chunk)
+            if received > MAX_SPEEDTEST_BYTES:
+                raise HTTPException(status_code=413, detail=f"Speed tests send at most {MAX_SPEEDTEST_BYTES} bytes")
+    except ClientDisconnect:
+        raise HTTPException(status_code=400, detail="Client disconnected")
+    elapsed = time.perf_counter() - started if started is not None else 0.0
+    return {
+        "bytes": received,
+        "elapsed": elapsed,
+        "bytes_per_second": received / elapsed if elapsed > 0 else None,
+    }
+
+
+@router.post("/api/reindex", status_code=202)
+async def reindex(request: Request):
+    """
+    Rebuild the folder size index in the background (admin only).
+    
+    A rebuild already running is cancelled and started over. Progress
+    shows up as 'size_index' in /api/status.
+    
+    Returns:
+        The index state.
+    """
+    _require_admin(request)
+    if config.storage_backend != "local":
+        raise HTTPException(status_code=400, detail="Folder sizes are only indexed for local storage")
+    dir_sizes.rebuild(config.uploads_dir)
+    return dir_sizes.status()
+
+
+@router.delete("/api/reindex")
+async def cancel_reindex(request: Request):
+    """Stop a running rebuild, keeping the previous sizes (admin only)."""
+    _require_admin(request)
+    dir_sizes.cancel()
+    return dir_sizes.status()
+
+
+class VerifyRequest(BaseModel):
+    """Body of POST /api/verify."""
+    fix: Optional[str] = None  # 'delete' or 'quarantine' corrupt files; None only reports
+
+
+def _publish_verify(current: IntegritySweep):
+    hub.publish("verify", current.status())
+    if current.running or current.report is None:
+        return
+    report = current.report
+    for label in ("corrupt", "missing"):
+        for name in getattr(report, label):
+            log(f"Integrity: {name} is {label}", "error")
+    for problem in report.errors:
+        log(f"Integrity: could not verify {problem}", "error")
+    log(
+        f"Integrity sweep {current.state}: {report.checked} files ({format_size(report.bytes)}) verified, "
+        f"{len(report.corrupt)} corrupt, {len(report.missing)} missing, {len(report.orphaned)} orphaned sidecars, "
+        f"{len(report.fixed)} fixed",
+        "info" if report.ok else "warning",
+    )
+
+
+@router.post("/api/verify", status_code=202, dependencies=[Depends(require_storage)])
+async def start_verify(request: Request, body: Optional[VerifyRequest] = None):
+    """
+    Re-hash every stored file in the background and compare it with its recorded checksum (admin only).
+    
+    Files without a checksum get one recorded. Progress is published as
+    'verify' events and shows up as 'integrity' in /api/status; problems
+    found are also logged (see /api/logs).
+    
+    Args:
+        body: Optional fix action for corrupt files: 'delete' or 'quarantine'
+            (moved to uploads/.failed). Sidecars of missing files are removed too.
         
-        try:
-            file_path.resolve().relative_to(config.uploads_dir.resolve())
-        except ValueError:
-            return {"filename": filename, "success": False, "error": "Access denied"}
+    Returns:
+        The sweep's state.
+    """
+    _require_admin(request)
+    if config.storage_backend != "local":
+        raise HTTPException(status_code=400, detail="Integrity sweeps are only available for local storage")
+    fix = body.fix if body else None
+    if fix is not None and fix not in FIX_ACTIONS:
+        raise HTTPException(status_code=400, detail=f"fix must be one of: {', '.join(FIX_ACTIONS)}")
+    if not sweep.start(config.uploads_dir, fix, _publish_verify, forget_file):
+        raise HTTPException(status_code=409, detail="An integrity sweep is already running")
+    return sweep.status()
+
+
+@router.get("/api/verify")
+async def get_verify(request: Request):
+    """The sweep's state, with the full report (names of affected files) once it ended (admin only)."""
+    _require_admin(request)
+    report = sweep.report
+    return {**sweep.status(), "report": report.to_dict() if report else None}
+
+
+@router.delete("/api/verify")
+async def cancel_verify(request: Request):
+    """Stop a running sweep (admin only)."""
+    _require_admin(request)
+    sweep.cancel()
+    await run_in_executor(sweep.wait, 5.0)
+    return sweep.status()
+
+
+@router.get("/api/debug/bundle")
+async def get_debug_bundle(request: Request):
+    """
+    Download a redacted diagnostics zip for bug reports (admin only).
+    
+    Contains the effective config (secrets and home paths redacted),
+    status, metrics, active transfers, capabilities and doctor checks.
+    
+    Returns:
+        application/zip attachment.
+    """
+    _require_admin(request)
+    
+    status = await get_status(request)
+    status["connect_url"] = None  # A live one-time link has no place in a bug report
+    snapshots = {
+        "status": status,
+        "metrics": await get_metrics(),
+        "transfers": stats.active_transfers(),
+        "capabilities": get_capabilities(get_device_id(request), get_role(request)),
+    }
+    data = await run_in_executor(build_bundle, snapshots)
+    
+    filename = f"flashare-debug-{time.strftime('%Y%m%d-%H%M%S')}.zip"
+    return Response(content=data, media_type="application/zip", headers={
+        "Content-Disposition": content_disposition(filename),
+    })
+
+
+@router.post("/api/auth/rotate")
+async def rotate_share_key(request: Request):
+    """
+    Replace the share key, PINs and short link, cutting off everyone who has the old link (host only).
+    
+    Outstanding claim codes are revoked too. Transfers already running
+    finish; new requests with the old key get 401 with code
+    'credentials_rotated'. Open pages are told via a
+    'credentials_rotated' event, and the /qr page reloads with the new
+    code.
+    
+    Returns:
+        The new share link and short link, and the new PINs where PIN access or roles are on.
+    """
+    _require_host(request)
+    if not config.require_key and not config.show_pin and not config.device_roles:
+        raise HTTPException(
+            status_code=409,
+            detail="This share has no key or PIN to rotate (start it with --require-key, --pin or --roles)",
+        )
+    
+    share_access.rotate()
+    claims.invalidate_all()
+    hub.publish("credentials_rotated", {})
+    log(f"🔄 Share key rotated by {request.client.host if request.client else 'host'}")
+    return {
+        "url": share_url(config.port),
+        "short_url": short_url(config.port),
+        "pin": share_access.pin if config.show_pin else None,
+        "host_pin": share_access.host_pin if config.device_roles else None,
+        "rotated_at": format_timestamp(share_access.rotated_at),
+    }
+
+
+@router.post("/api/admin/pause-uploads")
+async def pause_uploads(request: Request):
+    """
+    Stop accepting new uploads, e.g. during heavy disk activity (admin only).
+    
+    Uploads already running finish, and downloads are unaffected. New
+    uploads get 503 with Retry-After until resumed. Open pages are told
+    via an 'uploads' event.
+    
+    Returns:
+        The pause state.
+    """
+    global _uploads_paused_at
+    _require_admin(request)
+    if _uploads_paused_at is None:
+        _uploads_paused_at = time.time()
+        hub.publish("uploads", {"paused": True})
+        log("⏸️  Uploads paused")
+    return {"paused": True, "since": format_timestamp(_uploads_paused_at)}
+
+
+@router.post("/api/admin/resume-uploads")
+async def resume_uploads(request: Request):
+    """Accept new uploads again after pause-uploads (admin only)."""
+    global _uploads_paused_at
+    _require_admin(request)
+    if _uploads_paused_at is not None:
+        _uploads_paused_at = None
+        hub.publish("uploads", {"paused": False})
+        log("▶️  Uploads resumed")
+    return {"paused": False, "since": None}
+
+
+@router.get("/api/security-events")
+async def get_security_events(request: Request):
+    """
+    Summarize refused requests this session (admin only).
+    
+    Covers every 401, 403, 413 and 429 response: bad tokens, paths
+    outside the share, oversized uploads and exhausted quotas.
+    
+    Returns:
+        Totals per status, the most frequent clients and recent events.
+    """
+    _require_admin(request)
+    summary = security.summary()
+    for event in summary["recent"]:
+        event["time"] = format_timestamp(event["time"])
+    return summary
+
+
+# Most log lines GET /api/logs returns at once
+MAX_LOG_LINES = 1000
+
+
+def _since_time(since: str) -> float:
+    """Unix time from a lookback like '15m', an RFC 3339 time, or Unix seconds."""
+    try:
+        return float(since)
+    except ValueError:
+        pass
+    try:
+        return time.time() - parse_duration(since)
+    except ValueError:
+        pass
+    try:
+        when = datetime.fromisoformat(since)
+    except ValueError:
+        raise HTTPException(status_code=400, detail="'since' must be a duration (15m), an RFC 3339 time or Unix seconds")
+    if when.tzinfo is None:
+        raise HTTPException(status_code=400, detail="'since' needs a timezone, e.g. 2026-01-01T02:00:00Z")
+    return when.timestamp()
+
+
+@router.get("/api/logs")
+async def get_logs(
+    request: Request,
+    since: Optional[str] = None,
+    level: str = "info",
+    limit: int = Query(100, ge=1, le=MAX_LOG_LINES),
+):
+    """
+    Recent log lines, newest first (admin only).
+    
+    Covers this session's log lines (the last couple of thousand),
+    refused requests included unless FLASHARE_LOG_REJECTIONS=0, so a
+    failure can be looked into after the fact. Lines carry the request
+    ID they belong to, if any.
+    
+    Args:
+        since: Only lines from then on: a lookback like '15m', an RFC 3339
+            time or Unix seconds.
+        level: Only lines at least this severe: debug, info, warning or error.
+        limit: At most this many lines.
+        
+    Returns:
+        The matching lines.
+    """
+    _require_admin(request)
+    if level not in LEVELS:
+        raise HTTPException(status_code=400, detail=f"Unknown level; use one of: {', '.join(LEVELS)}")
+    entries = logbook.query(_since_time(since) if since else None, level, limit)
+    for entry in entries:
+        entry["time"] = format_timestamp(entry["time"])
+    return {"entries": entries, "count": len(entries)}
+
+
+@router.get("/api/failed")
+async def get_failed_uploads(request: Request):
+    """
+    List partial files kept from failed uploads (admin only).
+    
+    Only populated when config.keep_failed_uploads is on. 'offset' is
+    the number of bytes preserved, where a resumed upload would continue.
+    
+    Returns:
+        Entries with name, bytes received/expected, error and client.
+    """
+    _require_admin(request)
+    failed = await run_in_executor(list_failed)
+    return {"enabled": config.keep_failed_uploads, "failed": failed}
+
+
+@router.get("/api/devices")
+async def get_devices(request: Request):
+    """
+    Get per-device usage for this session (host only).
+    
+    Devices are listed under a public ID, never their cookie, which
+    would let whoever reads it act as that device.
+    
+    Returns:
+        Devices with bytes uploaded/downloaded, their quota and remaining allowance.
+    """
+    _require_host(request)
+    return [
+        {**d, "first_seen": format_timestamp(d["first_seen"]), "last_seen": format_timestamp(d["last_seen"])}
+        for d in devices.snapshot()
+    ]
+
+
+def _known_device(public_id: str) -> str:
+    """The device behind an ID from /api/devices, or 404."""
+    device = devices.lookup(public_id)
+    if device is None:
+        raise HTTPException(status_code=404, detail="Unknown device")
+    return device
+
+
+@router.put("/api/devices/{device_id}/quota")
+async def set_device_quota(request: Request, device_id: str, limit: Optional[str] = None):
+    """
+    Change one device's upload quota on the fly (admin token required).
+    
+    Args:
+        device_id: Device ID from /api/devices.
+        limit: New quota such as '2GB', '0' for unlimited, or omitted to
+            restore the default.
+        
+    Returns:
+        The device's updated usage.
+    """
+    _require_admin(request)
+    device = _known_device(device_id)
+    
+    try:
+        quota = parse_size(limit) if limit is not None else None
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    
+    devices.set_quota(device, quota)
+    return next(d for d in devices.snapshot() if d["id"] == device_id)
+
+
+@router.put("/api/devices/{device_id}/role")
+async def set_device_role(request: Request, device_id: str, role: str):
+    """
+    Promote or demote a device (admin token required).
+    
+    The role is bound to the device's current address, which matters
+    with config.strict_device_binding.
+    
+    Args:
+        device_id: Device ID from /api/devices.
+        role: 'guest', 'trusted' or 'host'.
+        
+    Returns:
+        The device's updated entry.
+    """
+    _require_admin(request)
+    if not config.device_roles:
+        raise HTTPException(status_code=409, detail="Roles are off on this share (start it with --roles)")
+    device = _known_device(device_id)
+    
+    try:
+        await run_in_executor(devices.set_role, device, role)
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    log(f"🔑 Device {device_id[:8]} is now {role}")
+    return next(d for d in devices.snapshot() if d["id"] == device_id)
+
+
+def _deletion_time(after: Optional[str], at: Optional[str]) -> Optional[float]:
+    """Unix time for a deferred delete, or None to delete right away."""
+    try:
+        if after is not None:
+            return time.time() + parse_duration(after)
+        if at is not None:
+            when = datetime.fromisoformat(at)
+            if when.tzinfo is None:
+                raise ValueError("'at' needs a timezone, e.g. 2026-01-01T02:00:00Z")
+            return when.timestamp()
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    return None
+
+
+@router.delete("/api/files/{filename:path}", dependencies=[Depends(require_role("trusted"))])
+async def delete_file(
+    filename: str,
+    after: Optional[str] = None,
+    at: Optional[str] = None,
+    role: str = Depends(get_role),
+    device: str = Depends(get_device_id),
+):
+    """
+    Delete a file from the uploads directory, now or later.
+    
+    With 'after' (e.g. 2h) or 'at' (RFC 3339), the file is queued for
+    deletion instead; the queue survives restarts. Scheduling again
+    replaces the time, and a time in the past deletes immediately.
+    
+    Args:
+        filename: Name of the file to delete.
+        after: Delay before deletion, e.g. '30m', '2h', '1d'.
+        at: Deletion time, e.g. '2026-01-01T02:00:00Z'.
+        
+    With owner-only changes on, other devices' uploads are refused with
+    403 'not_owner'.
+    
+    Returns:
+        Deletion result, with 'deletes_at' when deferred.
+    """
+    await _stat_or_raise(filename)
+    await _check_owner(filename, role, device)
+    
+    when = _deletion_time(after, at)
+    if when is not None and when > time.time():
+        await run_in_executor(deletions.schedule, filename, when)
+        hub.publish("files", {"scheduled": filename})
+        return {"success": True, "scheduled": filename, "deletes_at": format_timestamp(when)}
+    
+    # Use executor for file deletion (blocking I/O)
+    await run_in_executor(remove_file, filename)
+    
+    return {"success": True, "deleted": filename}
+
+
+class BatchOperation(BaseModel):
+    """One step of POST /api/batch."""
+    op: str  # delete, rename, move or mkdir
+    path: str  # The file (or, for mkdir, the folder)
+    to: Optional[str] = None  # New name for rename; destination folder for move ('' is the top)
+
+
+class BatchRequest(BaseModel):
+    """Body of POST /api/batch."""
+    operations: List[BatchOperation]
+
+
+@router.post("/api/batch", dependencies=[Depends(require_storage), Depends(require_role("trusted"))])
+async def run_batch_operations(
+    request: BatchRequest,
+    role: str = Depends(get_role),
+    device: str = Depends(get_device_id),
+):
+    """
+    Delete, rename and move files and create folders, all or nothing.
+    
+    Every step is checked first against the tree as the earlier steps
+    will leave it (existence, name collisions, staying inside the
+    uploads directory, permissions, owner-only changes). Then the steps
+    run in order; if one fails, those already done are undone in
+    reverse. The files involved are locked meanwhile, so other requests
+    can't change them halfway through.
+    
+    Returns:
+        'committed' and a result per step. 409 if a step was invalid or
+        failed, with nothing changed.
+    """
+    if config.storage_backend != "local":
+        raise HTTPException(status_code=400, detail="Batches need local storage")
+    if not request.operations:
+        raise HTTPException(status_code=400, detail="No operations given")
+    if len(request.operations) > MAX_BATCH_OPERATIONS:
+        raise HTTPException(status_code=400, detail=f"At most {MAX_BATCH_OPERATIONS} operations per batch")
+    
+    steps = [BatchStep(op.op, op.path, op.to) for op in request.operations]
+    try:
+        committed = await run_in_executor(
+            run_batch, steps, get_storage(), lambda name: _owner_problem(name, role, device),
+        )
+    except BatchInvalid:
+        committed = False
+    
+    body = {"committed": committed, "results": [step.to_dict(index) for index, step in enumerate(steps)]}
+    if not committed:
+        return JSONResponse(status_code=409, content=body)
+    
+    for step in steps:
+        if step.op == "mkdir" and step.status == "done":
+            dir_sizes.add_tree(config.uploads_dir / step.path)
+            hub.publish("files", {"added": step.path})
+        elif step.op in ("delete", "rename", "move"):
+            await run_in_executor(forget_file, step.path, step.size)
+            if step.result:
+                dir_sizes.add(step.result, step.size)
+                hub.publish("files", {"added": step.result})
+    log(f"🗂️  Batch of {len(steps)} operations committed")
+    return body
+
+
+@router.post("/api/files/{filename:path}/keep", dependencies=[Depends(require_role("trusted"))])
+async def keep_file(filename: str, role: str = Depends(get_role), device: str = Depends(get_device_id)):
+    """
+    Cancel a file's scheduled deletion.
+    
+    Args:
+        filename: ID of the file.
+        
+    Returns:
+        Whether a deletion was actually pending.
+    """
+    await _stat_or_raise(filename)
+    await _check_owner(filename, role, device)
+    was_scheduled = await run_in_executor(deletions.cancel, filename)
+    if was_scheduled:
+        hub.publish("files", {"kept": filename})
+    return {"success": True, "filename": filename, "was_scheduled": was_scheduled}
+
+
+@router.post("/api/files/{filename:path}/adopt")
+async def adopt_file(request: Request, filename: str):
+    """
+    Clear a file's owner, so any trusted device may change it again (host only).
+    
+    Args:
+        filename: ID of the file.
+        
+    Returns:
+        The file's info.
+    """
+    _require_host(request)
+    entry = await _stat_or_raise(filename)
+    await run_in_executor(functools.partial(update_meta, filename, owner=None))
+    hub.publish("files", {"adopted": filename})
+    return await run_in_executor(_get_file_info, entry)
+
+
+@router.delete("/api/files", dependencies=[Depends(require_role("trusted"))])
+async def delete_multiple_files(
+    filenames: List[str],
+    role: str = Depends(get_role),
+    device: str = Depends(get_device_id),
+):
+    """
+    Delete multiple files from the uploads directory.
+    
+    Uses parallel processing for batch deletions.
+    
+    Args:
+        filenames: List of filenames to delete.
         
+    Returns:
+        Batch deletion results.
+    """
+    async def delete_single(filename: str) -> dict:
         try:
-            await run_in_executor(file_path.unlink)
+            problem = await run_in_executor(_owner_problem, filename, role, device)
+            if problem:
+                return {"filename": filename, "success": False, "error": problem, "code": "not_owner"}
+            await run_in_executor(remove_file, filename)
             return {"filename": filename, "success": True}
+        except FileNotFoundError:
+            return {"filename": filename, "success": False, "error": "File not found"}
+        except PermissionError:
+            return {"filename": filename, "success": False, "error": "Access denied"}
         except Exception as e:
             return {"filename": filename, "success": False, "error": str(e)}
     
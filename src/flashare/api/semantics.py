@@ -0,0 +1,171 @@
+"""Response semantics (encoding, ranges, validators) for serving file content."""
+
+from dataclasses import dataclass, field
+from typing import Callable, Mapping, Optional
+
+from fastapi import HTTPException
+
+from flashare.core.compression import negotiate_encoding
+
+
+@dataclass
+class Representation:
+    """What to send for one request."""
+    status: int  # 200, 206 or 304
+    encoding: Optional[str] = None  # Content-Encoding to apply; None for identity
+    start: int = 0  # First byte of the file to send
+    length: int = 0  # Bytes of the file to send (before encoding)
+    headers: dict[str, str] = field(default_factory=dict)
+    cached: bool = False  # Send the cached encoded copy; start and length count its bytes
+
+
+def parse_range(header: Optional[str], size: int) -> Optional[tuple[int, int]]:
+    """
+    Parse a single-range 'bytes=start-end' header.
+
+    Args:
+        header: Raw Range header value.
+        size: Size of the file in bytes.
+
+    Returns:
+        Inclusive (start, end) offsets, or None if no usable range was sent.
+
+    Raises:
+        HTTPException: 416 if the range lies outside the file.
+    """
+    if not header or not header.startswith("bytes=") or "," in header:
+        return None
+
+    start_text, _, end_text = header[len("bytes="):].strip().partition("-")
+    try:
+        if start_text:
+            start = int(start_text)
+            end = min(int(end_text), size - 1) if end_text else size - 1
+        else:
+            # Suffix range: the last N bytes
+            start, end = max(size - int(end_text), 0), size - 1
+    except ValueError:
+        return None
+
+    if start > end or start >= size:
+        raise HTTPException(
+            status_code=416,
+            detail="Range not satisfiable",
+            headers={"Content-Range": f"bytes */{size}"},
+        )
+    return start, end
+
+
+def _opaque(tag: str) -> str:
+    """An ETag without its weakness marker, for weak comparison."""
+    return tag.strip().removeprefix("W/")
+
+
+def _encoded_etag(etag: str, encoding: Optional[str]) -> str:
+    """ETag of an encoded body: a distinct representation, so a distinct tag."""
+    if not encoding:
+        return etag
+    return f'{etag[:-1]}-{encoding}"'
+
+
+def _none_match(header: Optional[str], etag: str) -> bool:
+    """Whether If-None-Match matches (weak comparison, as RFC 9110 requires)."""
+    if not header:
+        return False
+    if header.strip() == "*":
+        return True
+    return any(_opaque(tag) == _opaque(etag) for tag in header.split(","))
+
+
+def _if_range_allows(header: Optional[str], etag: Optional[str]) -> bool:
+    """
+    Whether a Range may be honoured under If-Range.
+
+    Only a strong ETag match counts; dates never match since no
+    Last-Modified is sent, so the client gets the full, current file.
+    """
+    if not header:
+        return True
+    return bool(etag) and not etag.startswith("W/") and header.strip() == etag
+
+
+def select_representation(
+    request_headers: Mapping[str, str],
+    size: int,
+    etag: Optional[str] = None,
+    compressible: bool = True,
+    ranges: bool = True,
+    encoded_size: Optional[Callable[[str], Optional[int]]] = None,
+) -> Representation:
+    """
+    Decide status, encoding, byte range and headers for serving a file.
+
+    Every handler serving file content goes through here, since these
+    interact: a range must be cut from the identity bytes, an encoded
+    body is a different representation with its own ETag, and caches
+    need Vary whenever the body could have been encoded.
+
+    Rules, in order:
+    - Vary: Accept-Encoding whenever the body could have been encoded,
+      including identity and 304 responses.
+    - The encoding is negotiated from Accept-Encoding. If a complete
+      encoded copy is cached, a satisfiable Range (passing If-Range
+      against the encoded ETag) is cut from that copy and sent encoded.
+    - Otherwise a satisfiable Range (passing If-Range) is served as 206
+      from the identity bytes, without encoding: offsets into a stream
+      being compressed don't map onto anything.
+    - The ETag names the chosen representation; a matching
+      If-None-Match yields 304.
+
+    Args:
+        request_headers: The request's headers (case-insensitive mapping).
+        size: File size in bytes.
+        etag: Validator of the identity content, quoted (optionally W/).
+        compressible: Whether an encoded body may be sent.
+        ranges: Whether byte ranges may be served.
+        encoded_size: Size of the cached encoded copy for an encoding, or
+            None if there is none; without it nothing counts as cached.
+
+    Returns:
+        The representation to send; headers exclude Content-Disposition.
+
+    Raises:
+        HTTPException: 416 for an unsatisfiable range.
+    """
+    headers = {}
+    if compressible:
+        headers["Vary"] = "Accept-Encoding"
+    if ranges:
+        headers["Accept-Ranges"] = "bytes"
+
+    encoding = negotiate_encoding(request_headers.get("accept-encoding")) if compressible else None
+    cached_size = encoded_size(encoding) if encoding and encoded_size else None
+    if_range = request_headers.get("if-range")
+    if cached_size is not None and if_range and _if_range_allows(if_range, etag):
+        cached_size = None  # Resuming an identity download: stay identity
+    if cached_size is not None:
+        # The copy is a file like any other: its own length, ranges and validator
+        size, etag = cached_size, etag and _encoded_etag(etag, encoding)
+
+    byte_range = None
+    if ranges and _if_range_allows(if_range, etag):
+        byte_range = parse_range(request_headers.get("range"), size)
+    if byte_range and cached_size is None:
+        encoding = None
+
+    if etag:
+        headers["ETag"] = etag if cached_size is not None else _encoded_etag(etag, encoding)
+        if _none_match(request_headers.get("if-none-match"), headers["ETag"]):
+            return Representation(304, headers=headers)
+
+    if encoding:
+        headers["Content-Encoding"] = encoding
+    if byte_range:
+        start, end = byte_range
+        headers["Content-Range"] = f"bytes {start}-{end}/{size}"
+        headers["Content-Length"] = str(end - start + 1)
+        return Representation(206, encoding, start, end - start + 1, headers, cached_size is not None)
+
+    if not encoding or cached_size is not None:
+        headers["Content-Length"] = str(size)
+    return Representation(200, encoding, 0, size, headers, cached_size is not None)
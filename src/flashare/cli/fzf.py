@@ -1,6 +1,7 @@
 """fzf file selection wrapper for Flashare."""
 
 import os
+import shlex
 import subprocess
 import shutil
 from pathlib import Path
@@ -12,6 +13,67 @@ def is_fzf_available() -> bool:
     return shutil.which("fzf") is not None
 
 
+def _abbreviate_home(path: Path) -> str:
+    """Show a path with the home directory collapsed to ~."""
+    try:
+        relative = path.resolve().relative_to(Path.home())
+    except ValueError:
+        return str(path)
+    return "~" if relative == Path(".") else f"~/{relative}"
+
+
+def _find_command(start_dir: Path) -> str:
+    """
+    Build the find command listing candidate files under a directory.
+    
+    Excludes hidden files and common unwanted directories.
+    """
+    return (
+        f'find {shlex.quote(str(start_dir))} -type f '
+        '-not -path "*/.*" '
+        '-not -path "*/__pycache__/*" '
+        '-not -path "*/node_modules/*" '
+        '-not -path "*/.git/*" '
+        '2>/dev/null'
+    )
+
+
+def _location_header(directory: Path) -> str:
+    """Header naming a directory (with ~ for home) and how many files sit directly in it."""
+    try:
+        file_count = sum(1 for f in directory.iterdir() if f.is_file() and not f.name.startswith('.'))
+    except OSError:
+        file_count = 0
+    
+    return (
+        f"📁 {_abbreviate_home(directory)} ({file_count} files here)\n"
+        "ctrl-h: home · ctrl-u: uploads"
+    )
+
+
+def _navigation_options(start_dir: Path) -> list[str]:
+    """
+    Build fzf options for the location header and jump keybindings.
+    
+    The header shows where the picker is rooted and follows it when
+    ctrl-h jumps to home or ctrl-u to the uploads directory.
+    """
+    from flashare.config import config
+    
+    # change-header takes the rest of the binding, so the header's
+    # parentheses can't end it early; it has to come last
+    jump = lambda key, target, label: (
+        f"{key}:reload({_find_command(target)})+change-prompt({label} > )"
+        f"+change-header:{_location_header(target)}"
+    )
+    
+    return [
+        "--header", _location_header(start_dir),
+        "--bind", jump("ctrl-h", Path.home(), "~"),
+        "--bind", jump("ctrl-u", config.uploads_dir, _abbreviate_home(config.uploads_dir)),
+    ]
+
+
 def select_file(
     start_dir: Optional[Path] = None,
     prompt: str = "Select file to share > ",
@@ -33,16 +95,7 @@ def select_file(
     
     start_dir = start_dir or Path.cwd()
     
-    # Build the find command
-    # Exclude hidden files and common unwanted directories
-    find_cmd = (
-        f'find "{start_dir}" -type f '
-        '-not -path "*/.*" '
-        '-not -path "*/__pycache__/*" '
-        '-not -path "*/node_modules/*" '
-        '-not -path "*/.git/*" '
-        '2>/dev/null'
-    )
+    find_cmd = _find_command(start_dir)
     
     # Build fzf command
     fzf_opts = [
@@ -51,6 +104,7 @@ def select_file(
         "--layout", "reverse",
         "--border", "rounded",
         "--info", "inline",
+        *_navigation_options(start_dir),
     ]
     
     if preview:
@@ -111,14 +165,7 @@ def select_multiple_files(
     
     start_dir = start_dir or Path.cwd()
     
-    find_cmd = (
-        f'find "{start_dir}" -type f '
-        '-not -path "*/.*" '
-        '-not -path "*/__pycache__/*" '
-        '-not -path "*/node_modules/*" '
-        '-not -path "*/.git/*" '
-        '2>/dev/null'
-    )
+    find_cmd = _find_command(start_dir)
     
     fzf_opts = [
         "--prompt", prompt,
@@ -127,6 +174,7 @@ def select_multiple_files(
         "--layout", "reverse",
         "--border", "rounded",
         "--info", "inline",
+        *_navigation_options(start_dir),
     ]
     
     if preview:
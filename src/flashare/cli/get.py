@@ -0,0 +1,153 @@
+"""Verified, resumable downloads from a Flashare server."""
+
+import hashlib
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+from dataclasses import dataclass
+from pathlib import Path
+
+from flashare.cli.ui import create_progress
+
+
+# Attempts per chunk before giving up on the download
+MAX_CHUNK_ATTEMPTS = 3
+
+_READ_SIZE = 1024 * 1024
+
+
+class ChunkVerificationError(Exception):
+    """A chunk kept failing its checksum after every retry."""
+
+
+@dataclass
+class GetResult:
+    """Summary of a verified download."""
+    size: int
+    fetched: int
+    reused: int
+    retried: int
+
+
+def fetch_capabilities(base_url: str) -> dict:
+    """
+    Fetch a server's capability document (GET /api/config).
+
+    Returns:
+        The document, or an empty dict for servers that predate it.
+    """
+    try:
+        with urllib.request.urlopen(f"{base_url}/api/config", timeout=10) as response:
+            return json.load(response)
+    except urllib.error.HTTPError as e:
+        if e.code == 404:
+            return {}
+        raise
+
+
+def fetch_manifest(base_url: str, filename: str, chunk_size: str) -> dict:
+    """
+    Fetch the chunk checksum manifest for a remote file.
+
+    Args:
+        base_url: Server URL, e.g. http://192.168.1.5:8000.
+        filename: Remote file name.
+        chunk_size: Chunk size to request, e.g. '64MB'.
+
+    Returns:
+        The manifest dict.
+    """
+    query = urllib.parse.urlencode({"size": chunk_size})
+    url = f"{base_url}/api/files/{urllib.parse.quote(filename)}/chunks?{query}"
+    with urllib.request.urlopen(url, timeout=600) as response:
+        return json.load(response)
+
+
+def _local_chunk_ok(f, offset: int, length: int, expected: str) -> bool:
+    """Check whether bytes already on disk match a chunk's hash."""
+    digest = hashlib.sha256()
+    f.seek(offset)
+    remaining = length
+    while remaining and (block := f.read(min(_READ_SIZE, remaining))):
+        digest.update(block)
+        remaining -= len(block)
+    return not remaining and digest.hexdigest() == expected
+
+
+def _fetch_chunk(url: str, f, offset: int, length: int, on_bytes) -> str:
+    """Download one chunk via a Range request, hashing it as it is written."""
+    request = urllib.request.Request(url, headers={
+        "Range": f"bytes={offset}-{offset + length - 1}",
+        "Accept-Encoding": "identity",
+    })
+    digest = hashlib.sha256()
+    f.seek(offset)
+    with urllib.request.urlopen(request, timeout=60) as response:
+        if response.status != 206:
+            raise OSError("Server does not support range requests")
+        while block := response.read(_READ_SIZE):
+            digest.update(block)
+            f.write(block)
+            on_bytes(len(block))
+    return digest.hexdigest()
+
+
+def get_file(base_url: str, filename: str, output: Path, chunk_size: str = "64MB") -> GetResult:
+    """
+    Download a file, verifying each chunk against the server's manifest.
+
+    An existing output file is treated as a partial download: chunks that
+    already match are kept and only the rest are fetched. Chunks failing
+    verification are re-fetched on their own.
+
+    Args:
+        base_url: Server URL.
+        filename: Remote file name.
+        output: Local destination path.
+        chunk_size: Manifest chunk size.
+
+    Returns:
+        Counts of fetched, reused and retried chunks.
+
+    Raises:
+        ChunkVerificationError: If a chunk never matches its checksum.
+    """
+    features = fetch_capabilities(base_url).get("features", {})
+    if not features.get("chunk_manifest", True) or not features.get("range_downloads", True):
+        raise OSError("Server does not support verified downloads")
+
+    manifest = fetch_manifest(base_url, filename, chunk_size)
+    size, step, hashes = manifest["total"], manifest["chunk_size"], manifest["chunks"]
+    url = f"{base_url}/api/download/{urllib.parse.quote(filename)}"
+
+    resuming = output.exists()
+    existing = output.stat().st_size if resuming else 0
+    result = GetResult(size=size, fetched=0, reused=0, retried=0)
+
+    with open(output, "r+b" if resuming else "w+b") as f, create_progress() as progress:
+        task = progress.add_task(f"Downloading {filename}...", total=size)
+
+        for index, expected in enumerate(hashes):
+            offset = index * step
+            length = min(step, size - offset)
+
+            # Only validate the parts of a partial file that are actually there
+            if offset + length <= existing and _local_chunk_ok(f, offset, length, expected):
+                result.reused += 1
+                progress.update(task, advance=length)
+                continue
+
+            for attempt in range(MAX_CHUNK_ATTEMPTS):
+                if attempt:
+                    result.retried += 1
+                    progress.update(task, advance=-length)
+                if _fetch_chunk(url, f, offset, length, lambda n: progress.update(task, advance=n)) == expected:
+                    break
+            else:
+                raise ChunkVerificationError(f"Chunk {index} failed verification {MAX_CHUNK_ATTEMPTS} times")
+            result.fetched += 1
+
+        f.truncate(size)
+
+    return result
@@ -1,17 +1,26 @@
 """Main CLI entry point for Flashare."""
 
 import argparse
+import errno
+import re
+import shlex
 import shutil
+import signal
 import sys
+import time
 from pathlib import Path
+from typing import Optional
 
 from flashare import __version__, __app_name__
 from flashare.config import config
 from flashare.cli.fzf import select_multiple_files, is_fzf_available
+from flashare.cli.sync import CONFLICT_POLICIES
 from flashare.cli.ui import (
     console,
     print_banner,
     print_qr_code,
+    print_pin,
+    HOST_PIN_TITLE,
     print_server_info,
     print_file_ready,
     print_optimization_result,
@@ -22,12 +31,40 @@ from flashare.cli.ui import (
     confirm,
     create_progress,
 )
+from flashare.core.access import share_access
+from flashare.core.branding import load_logo, validate_accent_color
+from flashare.core.excludes import PathFilter, DEFAULT_EXCLUDES
+from flashare.core.exposure import check_share_root
 from flashare.core.ffmpeg import is_video_file, optimize_video, is_ffmpeg_available
-from flashare.core.network import get_server_url
+from flashare.core.instance import instance_lock, AlreadyServed
+from flashare.core.mime import parse_mime_override
+from flashare.core.ndjson import ndjson
+from flashare.core.network import get_server_url, parse_origin
+from flashare.core.notify import CONNECT_NOTIFICATIONS
+from flashare.core.organize import parse_template, date_folder, InvalidTemplate, DEFAULT_DATE_FORMAT
+from flashare.core.paths import sanitize_filename
+from flashare.core.permissions import parse_file_mode, make_dirs, restrict
+from flashare.core.pipeline import pipeline, Decision
+from flashare.core.progress import CountingReader
+from flashare.core.qr import QR_STYLES
+from flashare.core.settings import load_settings, settings_exist
+from flashare.core.stats import stats
+from flashare.core.units import parse_size, parse_duration
+from flashare.core.verify import FIX_ACTIONS
+from flashare.core.session import (
+    SessionState,
+    SharedFile,
+    save_session,
+    load_session,
+    discard_session,
+)
 
 
 def main():
     """Main entry point for the flashare command."""
+    _first_run()
+    load_settings()
+    
     parser = argparse.ArgumentParser(
         prog="flashare",
         description=f"{__app_name__} - CLI-First Hybrid File Sharing Tool",
@@ -39,6 +76,18 @@ def main():
         version=f"{__app_name__} {__version__}",
     )
     
+    parser.add_argument(
+        "--check-updates",
+        action="store_true",
+        help="Check for a newer release (cached for 24h)",
+    )
+    
+    parser.add_argument(
+        "--no-onboarding",
+        action="store_true",
+        help="Skip first-run setup and keep the defaults",
+    )
+    
     subparsers = parser.add_subparsers(dest="command", help="Available commands")
     
     # Send command
@@ -70,6 +119,27 @@ def main():
         default=Path.cwd(),
         help="Starting directory for file selection",
     )
+    send_parser.add_argument(
+        "--copy-retries",
+        type=int,
+        default=config.copy_retries,
+        metavar="N",
+        help=f"Retry a failing copy up to N times with backoff (default: {config.copy_retries})",
+    )
+    send_parser.add_argument(
+        "--clipboard",
+        action="store_true",
+        help="Also share what's on the clipboard (image, copied files or text)",
+    )
+    send_parser.add_argument(
+        "--as",
+        dest="display_name",
+        metavar="NAME",
+        help="Share a single file under a different name (original is untouched)",
+    )
+    _add_filter_arguments(send_parser)
+    _add_server_arguments(send_parser)
+    _add_qr_arguments(send_parser)
     
     # Receive command
     receive_parser = subparsers.add_parser("receive", help="Receive files (starts server)")
@@ -84,15 +154,389 @@ def main():
         default=config.host,
         help=f"Server host (default: {config.host})",
     )
+    _add_filter_arguments(receive_parser)
+    _add_server_arguments(receive_parser)
+    _add_qr_arguments(receive_parser)
     
     # Version command
-    subparsers.add_parser("version", help="Show version information")
+    version_parser = subparsers.add_parser("version", help="Show version information")
+    version_parser.add_argument(
+        "--check",
+        action="store_true",
+        help="Check GitHub for a newer release",
+    )
+    
+    # QR command
+    qr_parser = subparsers.add_parser("qr", help="Print the QR code for a running server")
+    qr_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port of the running server (default: {config.port})",
+    )
+    qr_parser.add_argument(
+        "--url",
+        help="Encode this URL instead of asking the running server",
+    )
+    qr_parser.add_argument(
+        "--png",
+        type=Path,
+        metavar="FILE",
+        help="Also write the QR code to a PNG file",
+    )
+    qr_parser.add_argument(
+        "--size",
+        type=int,
+        default=512,
+        help="PNG width/height in pixels (default: 512)",
+    )
+    qr_parser.add_argument(
+        "--wifi",
+        action="store_true",
+        help="Also print a Wi-Fi hotspot QR (needs FLASHARE_WIFI_SSID)",
+    )
+    _add_qr_arguments(qr_parser)
+    
+    # Top command
+    top_parser = subparsers.add_parser("top", help="Live dashboard of a running server")
+    top_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port of the running server (default: {config.port})",
+    )
+    top_parser.add_argument(
+        "--url",
+        help="Server URL to monitor (default: local server on --port)",
+    )
+    top_parser.add_argument(
+        "-n", "--interval",
+        type=float,
+        default=1.0,
+        help="Refresh interval in seconds (default: 1)",
+    )
+    
+    # Devices command
+    devices_parser = subparsers.add_parser("devices", help="Live view of connected devices and their activity")
+    devices_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port of the running server (default: {config.port})",
+    )
+    devices_parser.add_argument(
+        "--url",
+        help="Server URL to monitor (default: local server on --port)",
+    )
+    devices_parser.add_argument(
+        "-n", "--interval",
+        type=float,
+        default=1.0,
+        help="Refresh interval in seconds (default: 1)",
+    )
+    
+    # Get command
+    get_parser = subparsers.add_parser("get", help="Download a file from a server, verifying it chunk by chunk")
+    get_parser.add_argument(
+        "filename",
+        help="Name of the file on the server",
+    )
+    get_parser.add_argument(
+        "-o", "--output",
+        type=Path,
+        help="Where to save the file (default: same name in the current directory)",
+    )
+    get_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port of the server (default: {config.port})",
+    )
+    get_parser.add_argument(
+        "--url",
+        help="Server URL to download from (default: local server on --port)",
+    )
+    get_parser.add_argument(
+        "--chunk-size",
+        default="64MB",
+        help="Verification chunk size (default: 64MB)",
+    )
+    
+    # Claim command
+    claim_parser = subparsers.add_parser("claim", help="Issue a short single-use code that downloads a file")
+    claim_parser.add_argument(
+        "filename",
+        help="Name of the file on the server",
+    )
+    claim_parser.add_argument(
+        "--expires",
+        type=int,
+        default=config.claim_ttl,
+        help=f"Seconds the code stays valid (default: {config.claim_ttl})",
+    )
+    claim_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port of the server (default: {config.port})",
+    )
+    claim_parser.add_argument(
+        "--url",
+        help="Server URL (default: local server on --port; remote servers need FLASHARE_ADMIN_TOKEN)",
+    )
+    
+    # Announce command
+    announce_parser = subparsers.add_parser("announce", help="Prompt every open browser page to download a file")
+    announce_parser.add_argument(
+        "filename",
+        help="Name of the file on the server",
+    )
+    announce_parser.add_argument(
+        "-m", "--message",
+        default="",
+        help="Note shown with the prompt",
+    )
+    announce_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port of the server (default: {config.port})",
+    )
+    announce_parser.add_argument(
+        "--url",
+        help="Server URL (default: local server on --port; remote servers need FLASHARE_ADMIN_TOKEN)",
+    )
+    
+    # Rotate command
+    rotate_parser = subparsers.add_parser("rotate", help="Replace the share key, cutting off everyone with the old link")
+    rotate_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port of the server (default: {config.port})",
+    )
+    rotate_parser.add_argument(
+        "--url",
+        help="Server URL (default: local server on --port; remote servers need FLASHARE_ADMIN_TOKEN)",
+    )
+    
+    # Doctor command
+    doctor_parser = subparsers.add_parser("doctor", help="Diagnose setup and connectivity problems")
+    doctor_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Port to test (default: {config.port})",
+    )
+    
+    # Setup command
+    setup_parser = subparsers.add_parser("setup", help="Choose the uploads folder, port and PIN again")
+    setup_parser.add_argument(
+        "--no-check",
+        action="store_true",
+        help="Save the answers without testing connectivity",
+    )
+    
+    # Resume command
+    resume_parser = subparsers.add_parser("resume", help="Restart the last share session after a reboot")
+    resume_parser.add_argument(
+        "--discard",
+        action="store_true",
+        help="Forget the saved session instead of resuming it",
+    )
+    _add_output_argument(resume_parser)
+    
+    # Export command
+    export_parser = subparsers.add_parser(
+        "export", help="Bundle the whole share, with metadata and a checksum manifest, into a .tar.zst file"
+    )
+    export_parser.add_argument(
+        "output",
+        type=Path,
+        help="Bundle to write (e.g. session.tar.zst)",
+    )
+    
+    # Import command
+    import_parser = subparsers.add_parser("import", help="Restore a share from a bundle, verifying every file")
+    import_parser.add_argument(
+        "archive",
+        type=Path,
+        help="Bundle created by 'flashare export'",
+    )
+    import_parser.add_argument(
+        "--into",
+        type=Path,
+        metavar="DIR",
+        help="Restore into this new, empty directory instead of a fresh one in the data directory",
+    )
+    import_parser.add_argument(
+        "--serve",
+        action="store_true",
+        help="Serve the restored share read-only straight away",
+    )
+    import_parser.add_argument(
+        "-p", "--port",
+        type=int,
+        default=config.port,
+        help=f"Server port for --serve (default: {config.port})",
+    )
+    
+    # Clean command
+    clean_parser = subparsers.add_parser("clean", help="Remove leftovers from the uploads directory")
+    clean_parser.add_argument(
+        "--failed",
+        action="store_true",
+        help="Delete partial files kept from failed uploads (uploads/.failed)",
+    )
+    
+    # Verify command
+    verify_parser = subparsers.add_parser(
+        "verify", help="Re-hash stored files and report any that no longer match their recorded checksums"
+    )
+    verify_parser.add_argument(
+        "--fix",
+        choices=FIX_ACTIONS,
+        help="Delete corrupt files, or quarantine them in uploads/.failed; also drops sidecars of missing files",
+    )
+    verify_parser.add_argument(
+        "--workers",
+        type=int,
+        default=config.verify_workers,
+        metavar="N",
+        help=f"Files hashed at once (default: {config.verify_workers})",
+    )
+    
+    # Sync command
+    sync_parser = subparsers.add_parser("sync", help="Mirror a local folder onto a running server")
+    sync_parser.add_argument(
+        "folder",
+        type=Path,
+        help="Local folder to mirror (its top-level files)",
+    )
+    sync_parser.add_argument(
+        "url",
+        help="Server URL, with ?key=... if the share needs one (deleting may need FLASHARE_ADMIN_TOKEN)",
+    )
+    sync_parser.add_argument(
+        "--delete",
+        action="store_true",
+        help="Delete files on the server that are no longer in the folder",
+    )
+    sync_parser.add_argument(
+        "--conflict",
+        choices=CONFLICT_POLICIES,
+        default="skip",
+        help="When a changed file is newer on the server: leave it, replace it, or upload next to it (default: skip)",
+    )
+    sync_parser.add_argument(
+        "--dry-run",
+        action="store_true",
+        help="Print what would be done without changing anything",
+    )
+    sync_parser.add_argument(
+        "--watch",
+        action="store_true",
+        help="Keep running and sync again whenever the folder changes",
+    )
+    
+    # Speedtest command
+    speedtest_parser = subparsers.add_parser("speedtest", help="Measure the network speed to a running server")
+    speedtest_parser.add_argument(
+        "url",
+        help="Server URL as another device sees it, with ?key=... if the share needs one",
+    )
+    speedtest_parser.add_argument(
+        "--size",
+        type=parse_size,
+        default=100 * 1024 * 1024,
+        metavar="SIZE",
+        help="Bytes to move each way, up to 1GB (default: 100MB)",
+    )
     
     args = parser.parse_args()
     
+    if hasattr(args, "qr_style"):
+        config.qr_style = args.qr_style
+        config.qr_quiet_zone = args.qr_quiet_zone
+    
     # Handle version command
     if args.command == "version":
-        print(f"{__app_name__} {__version__}")
+        _print_version(args.check)
+        return
+    
+    if args.command == "qr":
+        _print_qr(args)
+        return
+    
+    if args.command == "top":
+        from flashare.cli.top import run_top
+        try:
+            run_top(args.url or f"http://127.0.0.1:{args.port}", args.interval)
+        except KeyboardInterrupt:
+            pass
+        return
+    
+    if args.command == "devices":
+        from flashare.cli.top import run_devices
+        try:
+            run_devices(args.url or f"http://127.0.0.1:{args.port}", args.interval)
+        except KeyboardInterrupt:
+            pass
+        return
+    
+    if args.command == "get":
+        _get_file(args)
+        return
+    
+    if args.command == "claim":
+        _claim_file(args)
+        return
+    
+    if args.command == "announce":
+        _announce_file(args)
+        return
+    
+    if args.command == "rotate":
+        _rotate_key(args)
+        return
+    
+    if args.command == "resume":
+        _apply_output_argument(args)
+        _resume_session(args.discard)
+        return
+    
+    if args.command == "doctor":
+        _run_doctor(args.port)
+        return
+    
+    if args.command == "setup":
+        from flashare.cli.onboarding import run_onboarding
+        run_onboarding(check_connectivity=not args.no_check)
+        return
+    
+    if args.command == "clean":
+        _clean(args)
+        return
+    
+    if args.command == "verify":
+        _verify_files(args)
+        return
+    
+    if args.command == "sync":
+        _sync_folder(args)
+        return
+    
+    if args.command == "speedtest":
+        _run_speedtest(args)
+        return
+    
+    # Handle archive commands (no server involved)
+    if args.command == "export":
+        _export_share(args.output)
+        return
+    
+    if args.command == "import":
+        _import_share(args.archive, args.into, args.serve, args.port)
         return
     
     # Default to 'send' if no command provided
@@ -105,22 +549,52 @@ def main():
         host = config.host
         no_optimize = False
         directory = Path.cwd()
+        dry_run = False
+        display_name = None
+        use_clipboard = False
+        force = False
     else:
         command = args.command
         port = args.port
         host = args.host
+        dry_run = args.dry_run
+        force = args.force
+        config.exclude_patterns = args.exclude
+        config.include_patterns = args.include
+        config.use_default_excludes = not args.no_default_excludes
+        config.confirm_files_over = max(args.confirm_files_over, 0)
+        config.confirm_size_over = args.confirm_size_over
+        _apply_server_arguments(args)
         if command == "send":
             files_to_share = args.files
             no_optimize = args.no_optimize
             directory = args.directory
+            display_name = args.display_name
+            use_clipboard = args.clipboard
+            config.copy_retries = max(args.copy_retries, 0)
     
     # Update config with CLI arguments
     config.port = port
     config.host = host
+    path_filter = PathFilter(
+        config.exclude_patterns,
+        config.include_patterns,
+        config.use_default_excludes,
+    )
+    session = SessionState.from_config()
+    
+    # A dry run reports the same decisions a real send makes, as a table
+    decisions: list[Decision] = []
+    if dry_run:
+        pipeline.subscribe(decisions.append)
+    skipped = lambda rel: pipeline.decide("send", rel, "filter", "excluded")
     
     # Print banner
     print_banner()
     
+    if args.check_updates or config.check_updates:
+        _notify_update()
+    
     # Check for required tools
     if not is_fzf_available():
         print_warning("fzf not found. Install with: brew install fzf")
@@ -130,31 +604,67 @@ def main():
     
     # Receive mode (equivalent to server-only)
     if command == "receive":
+        if dry_run:
+            _print_dry_run(path_filter, [(p, rel) for p, rel in path_filter.walk(config.uploads_dir)])
+            return
+        _guard_share_root(config.uploads_dir, path_filter, args.yes, args.allow_dangerous_root)
+        _lock_uploads(port, force)
         _start_server(host, port)
         return
     
-    # Get files to share
+    # Get files to share as (source, destination relative to uploads dir)
     file_paths = []
     
+    if use_clipboard:
+        file_paths.extend(_files_from_clipboard(path_filter))
+    
     if files_to_share:
         for f in files_to_share:
             p = Path(f)
             if not p.exists():
                 print_error(f"File not found: {f}")
                 sys.exit(1)
-            file_paths.append(p)
-    else:
+            if p.is_dir():
+                # Keep the directory name so the tree lands as one folder
+                root = p.resolve()
+                if not dry_run:
+                    _guard_share_root(root, path_filter, args.yes, args.allow_dangerous_root)
+                file_paths.extend(
+                    (src, f"{root.name}/{rel}")
+                    for src, rel in path_filter.walk(root, lambda rel: skipped(f"{root.name}/{rel}"))
+                )
+            else:
+                file_paths.append((p, p.name))
+    elif not file_paths:
         # Use fzf to select files
         print_info("Select files to share (Press TAB to select multiple)...")
-        file_paths = select_multiple_files(start_dir=directory)
+        file_paths = [(p, p.name) for p in select_multiple_files(start_dir=directory)]
         
         if not file_paths:
             print_warning("No files selected. Starting server with existing files...")
+            _lock_uploads(port, force)
             _start_server(host, port)
             return
     
+    if display_name:
+        display_name = Path(display_name).name
+        if len(file_paths) != 1 or "/" in file_paths[0][1] or not display_name:
+            print_error("--as can only be used when sending a single file")
+            sys.exit(1)
+        file_paths = [(file_paths[0][0], display_name)]
+    
+    if dry_run:
+        taken: set[Path] = set()
+        planned = [(src, rel, _plan_destination(rel, display_name or src.name, taken)) for src, rel in file_paths]
+        _print_send_plan(path_filter, planned, decisions)
+        return
+    
+    # Claim the uploads folder before copying anything into it
+    _lock_uploads(port, force)
+    
     # Process each file
-    for file_path in file_paths:
+    copied, failed = 0, []
+    for file_path, dest_rel in file_paths:
         console.print()
         print_info(f"Processing: [cyan]{file_path.name}[/]")
         
@@ -178,34 +688,1342 @@ def main():
                         result.output_size or 0,
                     )
                     final_path = result.output_path
+                    pipeline.decide("send", dest_rel, "optimize", "transcoded", final_path.name)
                 else:
                     print_error(f"Optimization failed: {result.error}")
                     print_info("Using original file instead.")
         
-        # Copy to uploads directory
-        dest_path = config.uploads_dir / final_path.name
+        # Copy to uploads directory, keeping any subfolder from a directory send
+        dest_path = _plan_destination(dest_rel, display_name or final_path.name, set())
+        make_dirs(dest_path.parent, config.uploads_dir)
         
-        # Handle duplicates
-        counter = 1
-        original_stem = dest_path.stem
-        while dest_path.exists():
-            dest_path = config.uploads_dir / f"{original_stem}_{counter}{dest_path.suffix}"
-            counter += 1
-        
-        shutil.copy2(final_path, dest_path)
+        error = _copy_with_retry(final_path, dest_path)
+        if error:
+            pipeline.decide("send", dest_rel, "stored", "failed", error)
+            failed.append((file_path, error))
+            continue
+        pipeline.decide("send", dest_rel, "stored", "copied", dest_path.relative_to(config.uploads_dir).as_posix())
+        copied += 1
         print_file_ready(dest_path.name, dest_path.stat().st_size)
+        
+        # Persist after every file so a crash mid-send can still resume
+        session.files.append(SharedFile(
+            source=str(file_path.resolve()),
+            name=dest_path.relative_to(config.uploads_dir).as_posix(),
+        ))
+        save_session(session)
+    
+    if failed:
+        console.print()
+        print_warning(f"Copied {copied} of {copied + len(failed)} files; these could not be shared:")
+        for file_path, error in failed:
+            console.print(f"  [red]✗[/] {file_path}: {error}")
     
     # Start server
-    _start_server(host, port)
+    _start_server(host, port, session)
 
 
-def _start_server(host: str, port: int):
-    """Start the FastAPI server."""
-    from flashare.server import run_server
+def _files_from_clipboard(path_filter: PathFilter) -> list[tuple[Path, str]]:
+    """Turn the clipboard into files to share, saving images and text to temp files."""
+    import tempfile
+    from flashare.core.clipboard import ClipboardUnavailable, read_clipboard, clipboard_filename
+    
+    try:
+        content = read_clipboard()
+    except ClipboardUnavailable as e:
+        print_error(f"Clipboard not available: {e}")
+        sys.exit(1)
+    
+    if content.files:
+        print_info(f"Sharing {len(content.files)} copied item(s) from the clipboard")
+        file_paths = []
+        for path in content.files:
+            if path.is_dir():
+                root = path.resolve()
+                file_paths.extend((src, f"{root.name}/{rel}") for src, rel in path_filter.walk(root))
+            else:
+                file_paths.append((path, path.name))
+        return file_paths
+    
+    if content.image is not None:
+        name, data = clipboard_filename(".png"), content.image
+    else:
+        name, data = clipboard_filename(".txt"), content.text.encode()
+    
+    path = Path(tempfile.mkdtemp(prefix="flashare-clipboard-")) / name
+    path.write_bytes(data)
+    print_info(f"Sharing clipboard {content.kind} as {name}")
+    return [(path, name)]
+
+
+def _print_version(check: bool):
+    """Print version and platform details, optionally checking for updates."""
+    import platform
+    
+    print(f"{__app_name__} {__version__}")
+    print(f"Platform: {platform.system().lower()}/{platform.machine().lower()}")
+    print(f"Python: {platform.python_version()} ({platform.python_implementation()})")
+    
+    if not check:
+        return
+    
+    from flashare.core.updates import check_for_updates
+    
+    info = check_for_updates(use_cache=False)
+    if info.error:
+        print(f"Update check failed: {info.error}")
+        sys.exit(1)
+    
+    if info.update_available:
+        print(f"A newer version is available: {info.latest}")
+        print(f"Changelog: {info.url}")
+    else:
+        print("You are running the latest version.")
+
+
+def _notify_update():
+    """Show a one-line hint when a newer release exists (never fails)."""
+    from flashare.core.updates import check_for_updates
+    
+    info = check_for_updates(timeout=1.5)
+    if info.update_available:
+        print_info(f"Flashare {info.latest} is available: [link={info.url}]{info.url}[/link]")
+
+
+def _copy_with_quit_guard(src: Path, dest: Path):
+    """
+    Copy a file, requiring Ctrl+C twice to abort mid-copy.
+    
+    The first interrupt only warns, so a stray keypress during a big send
+    doesn't leave a partial file behind. A second interrupt removes the
+    partial copy and exits.
+    """
+    interrupted = False
+    
+    def on_interrupt(signum, frame):
+        nonlocal interrupted
+        if interrupted:
+            raise KeyboardInterrupt
+        interrupted = True
+        console.print()
+        print_warning("Transfer in progress — press Ctrl+C again to force quit")
+    
+    previous = signal.signal(signal.SIGINT, on_interrupt)
+    try:
+        with create_progress() as progress:
+            task = progress.add_task(f"Copying {src.name}...", total=src.stat().st_size)
+            on_progress = lambda delta, _: progress.update(task, advance=delta)
+            with CountingReader(open(src, 'rb'), on_progress) as f_in, open(dest, 'xb') as f_out:
+                shutil.copyfileobj(f_in, f_out, 1024 * 1024)
+        shutil.copystat(src, dest)
+        restrict(dest)
+    except KeyboardInterrupt:
+        dest.unlink(missing_ok=True)
+        print_error(f"Transfer aborted, removed partial file: {dest.name}")
+        sys.exit(130)
+    finally:
+        signal.signal(signal.SIGINT, previous)
+
+
+# Errors retrying can't fix; anything else may be a file mid-write or a brief lock
+PERMANENT_COPY_ERRNOS = {errno.ENOENT, errno.EISDIR, errno.ENOTDIR, errno.ENAMETOOLONG, errno.ENOSPC, errno.EROFS}
+
+
+def _copy_with_retry(src: Path, dest: Path) -> Optional[str]:
+    """
+    Copy a file, retrying transient failures with exponential backoff.
+    
+    Returns:
+        None on success, otherwise why the file couldn't be copied.
+    """
+    delay = config.copy_retry_delay
+    for attempt in range(config.copy_retries + 1):
+        try:
+            _copy_with_quit_guard(src, dest)
+            return None
+        except FileExistsError as e:
+            return e.strerror or str(e)  # Not ours to remove
+        except OSError as e:
+            dest.unlink(missing_ok=True)
+            if e.errno in PERMANENT_COPY_ERRNOS or attempt == config.copy_retries:
+                return e.strerror or str(e)
+            time.sleep(delay)
+            delay *= 2
+
+
+def _add_output_argument(parser: argparse.ArgumentParser):
+    """Add --output, for commands that run the server."""
+    parser.add_argument(
+        "--output",
+        dest="output_format",
+        choices=["text", "ndjson"],
+        default="text",
+        help="'ndjson' prints one JSON event per line on stdout instead of the banner and QR code, "
+             "for scripts and apps wrapping flashare (logs go to stderr)",
+    )
+
+
+def _add_server_arguments(parser: argparse.ArgumentParser):
+    """Add the server tuning options shared by send and receive."""
+    _add_output_argument(parser)
+    parser.add_argument(
+        "--max-upload-size",
+        type=parse_size,
+        default=config.max_upload_size,
+        metavar="SIZE",
+        help="Reject uploads larger than SIZE, e.g. 2GB (default: unlimited)",
+    )
+    parser.add_argument(
+        "--device-quota",
+        type=parse_size,
+        default=config.per_device_quota,
+        metavar="SIZE",
+        help="Limit each device's total uploads per session, e.g. 500MB (default: unlimited)",
+    )
+    parser.add_argument(
+        "--file-mode",
+        type=parse_file_mode,
+        default=config.file_mode,
+        metavar="MODE",
+        help="Octal permissions for received files, e.g. 600; folders get the matching 700",
+    )
+    parser.add_argument(
+        "--mime-type",
+        action="append",
+        default=[],
+        metavar="EXT=TYPE",
+        help="Serve files ending in EXT as TYPE, e.g. log=text/plain (repeatable)",
+    )
+    parser.add_argument(
+        "--recreate-uploads-dir",
+        action="store_true",
+        default=config.auto_create_dir,
+        help="Re-create the uploads directory if it is removed while running (default: answer 503)",
+    )
+    parser.add_argument(
+        "--require-key",
+        action="store_true",
+        default=config.require_key,
+        help="Only let in devices that opened the share link or QR code (rotate with 'flashare rotate')",
+    )
+    parser.add_argument(
+        "--one-time-qr",
+        action="store_true",
+        default=config.one_time_qr,
+        help="Put a single-use link that expires after 5 minutes in QR codes instead of the share key, "
+             "so it never stays in browser history (implies --require-key; 'flashare qr' shows a fresh one)",
+    )
+    parser.add_argument(
+        "--pin",
+        action="store_true",
+        default=config.show_pin,
+        help="Also print a 6-digit PIN that devices without a camera can type at /pin",
+    )
+    parser.add_argument(
+        "--roles",
+        action="store_true",
+        default=config.device_roles,
+        help="Give devices roles: guests can't delete; type the host PIN at /pin for full control",
+    )
+    parser.add_argument(
+        "--strict-device-binding",
+        action="store_true",
+        default=config.strict_device_binding,
+        help="With --roles, a device's role only holds at the IP address it was granted to",
+    )
+    parser.add_argument(
+        "--remember-roles",
+        action="store_true",
+        default=config.remember_roles,
+        help="With --roles, keep devices' roles across restarts",
+    )
+    parser.add_argument(
+        "--owner-only",
+        action="store_true",
+        default=config.owner_only_modify,
+        help="Only let the device that uploaded a file (or the host) delete it",
+    )
+    parser.add_argument(
+        "--no-qr",
+        action="store_true",
+        default=not config.show_qr,
+        help="Don't print the QR code at startup (e.g. with --pin on a small terminal)",
+    )
+    parser.add_argument(
+        "--dedupe",
+        action="store_true",
+        default=config.dedupe_uploads,
+        help="Store uploads identical to an existing file as links to it, saving disk space",
+    )
+    parser.add_argument(
+        "--organize",
+        default=config.organize_uploads,
+        metavar="TEMPLATE",
+        help="Sort uploads into folders, e.g. '{type}/{year}/{month}-{day}/' "
+             "(also {device}; dates from EXIF, else the file's time)",
+    )
+    parser.add_argument(
+        "--date-folders",
+        nargs="?",
+        const=DEFAULT_DATE_FORMAT,
+        default=config.date_folders,
+        metavar="FORMAT",
+        help="Put uploads in a folder for the day they arrive, e.g. 2024-06-01/ "
+             f"(strftime FORMAT, default '{DEFAULT_DATE_FORMAT.replace('%', '%%')}'; --organize applies inside it)",
+    )
+    parser.add_argument(
+        "--relay",
+        action="store_true",
+        help="Register with the relay (FLASHARE_RELAY_URL) and print a short code for the share",
+    )
+    parser.add_argument(
+        "--relay-url",
+        default=config.relay_url,
+        metavar="URL",
+        help="Relay server to register with (default: $FLASHARE_RELAY_URL)",
+    )
+    parser.add_argument(
+        "--read-only",
+        action="store_true",
+        default=config.read_only,
+        help="Serve files without accepting uploads, deletions or other changes",
+    )
+    parser.add_argument(
+        "--cors-origin",
+        action="append",
+        default=list(config.cors_origins),
+        metavar="ORIGIN",
+        help="Also let web pages from ORIGIN call the API, e.g. https://example.com (repeatable)",
+    )
+    parser.add_argument(
+        "--cors-any",
+        action="store_true",
+        default=config.cors_any,
+        help="Let any website call the API, without cookies (default: only the share's own URLs)",
+    )
+    parser.add_argument(
+        "--trace",
+        action="store_true",
+        help="Log every decision made for each file (filters, renames, policy, dedupe, final path)",
+    )
+    parser.add_argument(
+        "--trace-format",
+        choices=["text", "json"],
+        default="text",
+        help="Print trace decisions as text or as one JSON object per line (default: text)",
+    )
+    parser.add_argument(
+        "--request-id-header",
+        default=config.request_id_header,
+        metavar="NAME",
+        help=f"Header that carries request IDs; an incoming one is kept (default: {config.request_id_header})",
+    )
+    parser.add_argument(
+        "--strict-routes",
+        action="store_true",
+        default=not config.lenient_routes,
+        help="Match URLs exactly (default: ignore case and trailing slashes)",
+    )
+    parser.add_argument(
+        "--obfuscate-names",
+        action="store_true",
+        default=config.obfuscate_names,
+        help="Store uploads under random IDs so names never appear in URLs",
+    )
+    parser.add_argument(
+        "--title",
+        default=config.brand_title,
+        help="Title shown in the web UI and QR page (default: Flashare)",
+    )
+    parser.add_argument(
+        "--accent-color",
+        type=validate_accent_color,
+        default=None,  # Not the env default: argparse would run it through the validator even when empty
+        metavar="HEX",
+        help="Web UI accent color, e.g. '#ff6600' (default: FLASHARE_ACCENT_COLOR)",
+    )
+    parser.add_argument(
+        "--logo",
+        default=config.brand_logo_path,
+        metavar="IMAGE",
+        help="PNG/JPEG/GIF/WebP logo shown in the web UI (max 512 KB)",
+    )
+    parser.add_argument(
+        "--poster-message",
+        default=config.poster_message,
+        metavar="TEXT",
+        help="Line printed under the title on the printable sign at /api/poster",
+    )
+    parser.add_argument(
+        "--notify-on-connect",
+        choices=CONNECT_NOTIFICATIONS,
+        default=config.notify_on_connect,
+        help="When a new device connects, ring the terminal bell or show a desktop notification (default: none)",
+    )
+    parser.add_argument(
+        "--time-format",
+        choices=["unix", "rfc3339"],
+        default=config.time_format,
+        help="How API timestamps are serialized (default: unix)",
+    )
+    parser.add_argument(
+        "--burn-after-download",
+        action="store_true",
+        default=config.burn_after_download,
+        help="Delete each file after its first complete download",
+    )
+    parser.add_argument(
+        "--auto-extract",
+        choices=["off", "keep", "remove"],
+        default="keep" if config.auto_extract_zip else "off",
+        help="Unpack uploaded zip files into a folder, keeping or removing the zip (default: off)",
+    )
+    parser.add_argument(
+        "--strict-mime",
+        action="store_true",
+        default=config.strict_mime,
+        help="Refuse uploads whose content contradicts their extension, such as a program named .jpg",
+    )
+    parser.add_argument(
+        "--transcode",
+        action="store_true",
+        default=config.transcode_videos,
+        help="Make a browser-playable .mp4 of uploaded videos in the background, keeping the original (needs ffmpeg)",
+    )
+    parser.add_argument(
+        "--heartbeat",
+        type=float,
+        default=config.heartbeat_interval,
+        metavar="SECONDS",
+        help=f"Keep-alive interval for live-update connections (default: {config.heartbeat_interval:g})",
+    )
+    parser.add_argument(
+        "--staging",
+        choices=["disk", "memory"],
+        default=config.staging_mode,
+        help="Buffer small uploads in RAM and flush them in the background (default: disk)",
+    )
+    parser.add_argument(
+        "--bandwidth-limit",
+        type=parse_size,
+        default=config.bandwidth_limit,
+        metavar="SIZE",
+        help="Cap downloads at SIZE per second in total, shared fairly between devices (default: unlimited)",
+    )
+    parser.add_argument(
+        "--stream-threshold",
+        type=parse_size,
+        default=config.stream_threshold,
+        metavar="SIZE",
+        help="Read request bodies up to SIZE in one piece and stream larger ones (default: 1MB)",
+    )
+    parser.add_argument(
+        "--max-parts",
+        type=int,
+        default=config.max_multipart_parts,
+        metavar="N",
+        help=f"Refuse multipart uploads with more than N parts with 413 (default: {config.max_multipart_parts})",
+    )
+    parser.add_argument(
+        "--max-header-size",
+        type=parse_size,
+        default=config.max_header_size,
+        metavar="SIZE",
+        help="Refuse requests whose headers exceed SIZE, e.g. 16KB (default: the HTTP server's limit)",
+    )
+    parser.add_argument(
+        "--list-concurrency",
+        type=int,
+        default=config.list_concurrency,
+        metavar="N",
+        help=f"Threads reading file details for large listings, e.g. 8 on network drives (default: {config.list_concurrency})",
+    )
+    parser.add_argument(
+        "--watch-interval",
+        type=float,
+        default=config.watch_interval,
+        metavar="SECONDS",
+        help="How often to scan for files changed outside Flashare where change "
+             f"notifications don't work, e.g. on NFS/SMB; 0 disables (default: {config.watch_interval:g})",
+    )
+    parser.add_argument(
+        "--zip-retention",
+        type=parse_duration,
+        default=config.zip_job_retention,
+        metavar="DURATION",
+        help="Keep zips prepared for resumable downloads this long after their last download, e.g. 30m (default: 1h)",
+    )
+    parser.add_argument(
+        "--compressed-cache",
+        type=parse_size,
+        default=config.encoded_cache_size,
+        metavar="SIZE",
+        help="Disk kept for compressed copies of downloads, so compressed downloads can resume; "
+             "0 turns it off (default: 1GB)",
+    )
+    parser.add_argument(
+        "--shutdown-timeout",
+        type=parse_duration,
+        default=config.shutdown_timeout,
+        metavar="DURATION",
+        help="On Ctrl+C, wait this long for transfers to finish before cutting them off; "
+             "0 waits for all of them (default: 30s)",
+    )
+    parser.add_argument(
+        "--staging-limit",
+        type=parse_size,
+        default=config.staging_limit,
+        metavar="SIZE",
+        help="RAM budget for memory staging (default: 2GB)",
+    )
+    parser.add_argument(
+        "--force",
+        action="store_true",
+        help="Serve the uploads folder even if another Flashare instance says it is serving it",
+    )
+
+
+def _apply_output_argument(args: argparse.Namespace):
+    """Switch to machine-readable output if asked, before anything is printed."""
+    if args.output_format == "ndjson":
+        ndjson.start()
+        console.quiet = True  # Banner, QR code and other decoration
+
+
+def _apply_server_arguments(args: argparse.Namespace):
+    """Copy the server tuning options onto the global config."""
+    _apply_output_argument(args)
+    config.max_upload_size = args.max_upload_size
+    config.per_device_quota = args.device_quota
+    config.obfuscate_names = args.obfuscate_names
+    config.file_mode = args.file_mode
+    try:
+        config.mime_overrides = dict(parse_mime_override(value) for value in args.mime_type)
+    except ValueError as e:
+        print_error(str(e))
+        sys.exit(1)
+    config.auto_create_dir = args.recreate_uploads_dir
+    config.lenient_routes = not args.strict_routes
+    config.read_only = args.read_only
+    config.cors_any = args.cors_any
+    try:
+        config.cors_origins = [parse_origin(origin) for origin in args.cors_origin]
+    except ValueError as e:
+        print_error(f"Invalid --cors-origin: {e}")
+        sys.exit(1)
+    if not re.fullmatch(r"[A-Za-z0-9-]+", args.request_id_header):
+        print_error(f"Invalid --request-id-header: {args.request_id_header!r}")
+        sys.exit(1)
+    config.request_id_header = args.request_id_header
+    if args.trace:
+        pipeline.subscribe(
+            (lambda d: print(d.to_json(), flush=True)) if args.trace_format == "json" else (lambda d: print(d.to_text()))
+        )
+    config.dedupe_uploads = args.dedupe
+    config.organize_uploads = args.organize
+    if config.organize_uploads:
+        try:
+            parse_template(config.organize_uploads)
+        except InvalidTemplate as e:
+            print_error(f"Invalid --organize template: {e}")
+            sys.exit(1)
+    config.date_folders = args.date_folders
+    if config.date_folders:
+        try:
+            date_folder(config.date_folders)
+        except InvalidTemplate as e:
+            print_error(f"Invalid --date-folders format: {e}")
+            sys.exit(1)
+    config.one_time_qr = args.one_time_qr
+    config.require_key = args.require_key or args.one_time_qr
+    config.show_pin = args.pin
+    config.device_roles = args.roles
+    config.strict_device_binding = args.strict_device_binding
+    config.remember_roles = args.remember_roles
+    config.owner_only_modify = args.owner_only
+    config.show_qr = not args.no_qr
+    config.relay_url = args.relay_url
+    config.use_relay = args.relay
+    if config.use_relay and not config.relay_url:
+        print_error("--relay needs a relay server; set FLASHARE_RELAY_URL or pass --relay-url")
+        sys.exit(1)
+    if config.file_mode is not None:
+        restrict(config.uploads_dir)
+    config.burn_after_download = args.burn_after_download
+    config.auto_extract_zip = args.auto_extract != "off"
+    config.auto_extract_remove_zip = args.auto_extract == "remove"
+    config.strict_mime = args.strict_mime
+    config.transcode_videos = args.transcode
+    if config.transcode_videos and not is_ffmpeg_available():
+        print_warning("ffmpeg not found. Video transcoding disabled.")
+        config.transcode_videos = False
+    config.staging_mode = args.staging
+    config.heartbeat_interval = args.heartbeat
+    config.time_format = args.time_format
+    config.brand_title = args.title
+    if args.accent_color is not None:
+        config.brand_accent_color = args.accent_color
+    elif config.brand_accent_color:
+        try:
+            validate_accent_color(config.brand_accent_color)
+        except ValueError as e:
+            print_error(f"FLASHARE_ACCENT_COLOR: {e}")
+            sys.exit(1)
+    config.brand_logo_path = args.logo
+    config.poster_message = args.poster_message
+    if args.notify_on_connect not in CONNECT_NOTIFICATIONS:
+        print_error(f"FLASHARE_NOTIFY_ON_CONNECT must be one of: {', '.join(CONNECT_NOTIFICATIONS)}")
+        sys.exit(1)
+    config.notify_on_connect = args.notify_on_connect
+    
+    # Fail at startup rather than serving a broken logo later
+    if config.brand_logo_path:
+        try:
+            load_logo(Path(config.brand_logo_path))
+        except (OSError, ValueError) as e:
+            print_error(f"Invalid logo: {e}")
+            sys.exit(1)
+    config.staging_limit = args.staging_limit
+    config.stream_threshold = args.stream_threshold
+    if args.max_parts < 1:
+        print_error("--max-parts must be at least 1")
+        sys.exit(1)
+    config.max_multipart_parts = args.max_parts
+    config.max_header_size = args.max_header_size
+    if args.list_concurrency < 1:
+        print_error("--list-concurrency must be at least 1")
+        sys.exit(1)
+    config.list_concurrency = args.list_concurrency
+    config.bandwidth_limit = args.bandwidth_limit
+    config.watch_interval = args.watch_interval
+    config.zip_job_retention = args.zip_retention
+    config.encoded_cache_size = args.compressed_cache
+    config.shutdown_timeout = args.shutdown_timeout
+
+
+def _add_qr_arguments(parser: argparse.ArgumentParser):
+    """Add the terminal QR rendering options."""
+    parser.add_argument(
+        "--qr-style",
+        choices=QR_STYLES,
+        default=config.qr_style,
+        help="QR rendering: color (black/white backgrounds), block, ascii (default: auto)",
+    )
+    parser.add_argument(
+        "--qr-quiet-zone",
+        type=int,
+        default=config.qr_quiet_zone,
+        metavar="N",
+        help=f"Blank border around the QR code in modules (default: {config.qr_quiet_zone})",
+    )
+
+
+def _add_filter_arguments(parser: argparse.ArgumentParser):
+    """Add the exclude/include options shared by send and receive."""
+    parser.add_argument(
+        "--exclude",
+        action="append",
+        default=[],
+        metavar="GLOB",
+        help="Exclude paths matching GLOB (relative to the shared root, repeatable)",
+    )
+    parser.add_argument(
+        "--include",
+        action="append",
+        default=[],
+        metavar="GLOB",
+        help="Include paths matching GLOB even if excluded (repeatable)",
+    )
+    parser.add_argument(
+        "--no-default-excludes",
+        action="store_true",
+        help=f"Do not exclude {', '.join(DEFAULT_EXCLUDES)} by default",
+    )
+    parser.add_argument(
+        "--dry-run",
+        action="store_true",
+        help="Print what would be shared and exit",
+    )
+    parser.add_argument(
+        "--confirm-files-over",
+        type=int,
+        default=config.confirm_files_over,
+        metavar="N",
+        help=f"Ask before sharing a folder with more than N files; 0 never asks (default: {config.confirm_files_over})",
+    )
+    parser.add_argument(
+        "--confirm-size-over",
+        type=parse_size,
+        default=config.confirm_size_over,
+        metavar="SIZE",
+        help="Ask before sharing a folder larger than SIZE; 0 never asks (default: 50GB)",
+    )
+    parser.add_argument(
+        "-y", "--yes",
+        action="store_true",
+        help="Share large folders without asking",
+    )
+    parser.add_argument(
+        "--i-know-what-im-doing",
+        dest="allow_dangerous_root",
+        action="store_true",
+        help="Allow sharing the filesystem root, the home directory or a system folder",
+    )
+
+
+def _print_dry_run(path_filter: PathFilter, file_paths: list[tuple[Path, str]]):
+    """Print the effective rules and the files that would be shared."""
+    print_info("Effective rules ([red]-[/] exclude, [green]+[/] include):")
+    for rule in path_filter.describe():
+        console.print(f"    {rule}")
+    console.print()
+    
+    total_size = 0
+    for src, rel in file_paths:
+        size = src.stat().st_size
+        total_size += size
+        console.print(f"  {rel} [dim]({size:,} bytes)[/]")
+    
+    console.print()
+    print_success(f"{len(file_paths)} files would be shared ({total_size:,} bytes)")
+
+
+def _guard_share_root(root: Path, path_filter: PathFilter, assume_yes: bool, allow_dangerous: bool):
+    """
+    Stop before exposing a folder nobody meant to share, e.g. after typing ~ by mistake.
+    
+    The filesystem root, the home directory and system folders are
+    refused unless allow_dangerous. Folders above the size thresholds
+    are summarized and need a yes (or --yes); without a terminal to ask
+    on, they are refused.
+    """
+    check = check_share_root(root, path_filter, config.confirm_files_over, config.confirm_size_over)
+    if check.dangerous:
+        if allow_dangerous:
+            print_warning(f"Sharing {root}, {check.dangerous}, as asked")
+            return
+        print_error(f"Refusing to share {root}: it is {check.dangerous}")
+        print_info("Share a folder inside it instead, or pass --i-know-what-im-doing")
+        sys.exit(1)
+    
+    if not check.too_large:
+        return
+    from flashare.cli.ui import _format_size
+    exposure = check.exposure
+    print_warning(
+        f"{root} holds at least {exposure.files:,} files ({_format_size(exposure.size)}), "
+        "and all of it would be shared"
+    )
+    if assume_yes:
+        return
+    if not sys.stdin.isatty():
+        print_error("Refusing to share that much without confirmation; pass --yes")
+        sys.exit(1)
+    if not confirm(f"Share everything in {root}?", default=False):
+        sys.exit(1)
+
+
+def _plan_destination(dest_rel: str, name: str, taken: set[Path]) -> Path:
+    """
+    Decide where a sent file lands in the uploads directory, without writing.
+    
+    Subfolders from a directory send are kept. The name is sanitized, and
+    a clash with a file on disk (or one already in taken, for dry runs)
+    gets _1, _2, ... appended.
+    
+    Args:
+        dest_rel: Path relative to the uploads directory, as sent.
+        name: File name to store it under.
+        taken: Destinations claimed by earlier files; this one is added.
+        
+    Returns:
+        The destination path.
+    """
+    dest_dir = config.uploads_dir.joinpath(
+        *(sanitize_filename(part) for part in Path(dest_rel).parent.parts)
+    )
+    dest_path = dest_dir / pipeline.sanitize("send", name, dest_rel)
+    
+    counter = 1
+    original_stem = dest_path.stem
+    while dest_path.exists() or dest_path in taken:
+        dest_path = dest_dir / f"{original_stem}_{counter}{dest_path.suffix}"
+        counter += 1
+    if counter > 1:
+        pipeline.decide("send", dest_rel, "duplicate", "renamed", dest_path.name)
+    
+    taken.add(dest_path)
+    return dest_path
+
+
+def _print_send_plan(path_filter: PathFilter, planned: list[tuple[Path, str, Path]], decisions: list[Decision]):
+    """Print the outcome of a dry-run send: where each file would land, and what was decided."""
+    from rich.table import Table
+    
+    print_info("Effective rules ([red]-[/] exclude, [green]+[/] include):")
+    for rule in path_filter.describe():
+        console.print(f"    {rule}")
+    console.print()
+    
+    notes: dict[str, list[str]] = {}
+    for decision in decisions:
+        if decision.outcome != "unchanged":
+            notes.setdefault(decision.file, []).append(f"{decision.step}: {decision.outcome}")
+    
+    table = Table(title="Dry run: nothing was written")
+    table.add_column("File")
+    table.add_column("Shared as")
+    table.add_column("Decisions", style="dim")
+    for _, rel, dest in planned:
+        table.add_row(rel, dest.relative_to(config.uploads_dir).as_posix(), ", ".join(notes.get(rel, [])))
+    for decision in decisions:
+        if decision.step == "filter":
+            table.add_row(decision.file, "[red]not shared[/]", "filter: excluded")
+    console.print(table)
+    
+    total_size = sum(src.stat().st_size for src, _, _ in planned)
+    print_success(f"{len(planned)} files would be shared ({total_size:,} bytes)")
+
+
+def _fetch_running_url(port: int) -> str | None:
+    """Ask a local running instance for its advertised URL (a fresh one-time link, if it uses them)."""
+    import json
+    import urllib.request
+    
+    try:
+        with urllib.request.urlopen(f"http://127.0.0.1:{port}/api/status", timeout=2) as response:
+            status = json.load(response)
+            return status.get("connect_url") or status.get("url")
+    except (OSError, ValueError):
+        return None
+
+
+def _print_qr(args: argparse.Namespace):
+    """Print the connection QR code (and optionally write PNG / Wi-Fi QR)."""
+    from flashare.core.qr import generate_qr_png_bytes, generate_wifi_payload
+    
+    url = args.url or _fetch_running_url(args.port)
+    if not url:
+        print_error(
+            f"No Flashare server is running on port {args.port}. "
+            "Start one with 'flashare receive' or pass --url."
+        )
+        sys.exit(1)
+    
+    print_qr_code(url=url)
+    
+    if args.png:
+        args.png.write_bytes(generate_qr_png_bytes(url, size=args.size))
+        print_success(f"Wrote QR code to [cyan]{args.png}[/] ({args.size}x{args.size})")
+    
+    if args.wifi:
+        if not config.wifi_ssid:
+            print_warning("No hotspot configured. Set FLASHARE_WIFI_SSID and FLASHARE_WIFI_PASSWORD.")
+            return
+        print_qr_code(
+            url=generate_wifi_payload(config.wifi_ssid, config.wifi_password),
+            title="📶 Join Wi-Fi",
+            subtitle=config.wifi_ssid,
+        )
+
+
+def _get_file(args: argparse.Namespace):
+    """Download a file with per-chunk verification and resume support."""
+    from flashare.cli.get import get_file, ChunkVerificationError
+    from flashare.cli.ui import _format_size
+    
+    base_url = (args.url or f"http://127.0.0.1:{args.port}").rstrip("/")
+    output = args.output or Path.cwd() / sanitize_filename(args.filename)
+    
+    try:
+        result = get_file(base_url, args.filename, output, args.chunk_size)
+    except ChunkVerificationError as e:
+        print_error(f"{e}; partial file kept at {output}, run again to resume")
+        sys.exit(1)
+    except OSError as e:
+        print_error(f"Download failed: {e}")
+        sys.exit(1)
+    except KeyboardInterrupt:
+        print_warning(f"Interrupted; run again to resume into {output}")
+        sys.exit(130)
+    
+    print_success(f"Saved {output} ({_format_size(result.size)}, all chunks verified)")
+    if result.reused or result.retried:
+        print_info(f"{result.reused} chunk(s) reused from a previous run, {result.retried} re-fetched after a bad checksum")
+
+
+def _claim_file(args: argparse.Namespace):
+    """Ask the server for a claim code and show it in large type."""
+    import json
+    import urllib.error
+    import urllib.parse
+    import urllib.request
+    from flashare.cli.ui import print_claim_code
+    
+    base_url = (args.url or f"http://127.0.0.1:{args.port}").rstrip("/")
+    query = urllib.parse.urlencode({"expires": args.expires})
+    request = urllib.request.Request(
+        f"{base_url}/api/files/{urllib.parse.quote(args.filename)}/claim?{query}",
+        method="POST",
+    )
+    if config.admin_token:
+        request.add_header("Authorization", f"Bearer {config.admin_token}")
+    
+    try:
+        with urllib.request.urlopen(request, timeout=10) as response:
+            claim = json.load(response)
+    except urllib.error.HTTPError as e:
+        print_error(f"Could not create claim code: {json.load(e).get('detail', e.reason)}")
+        sys.exit(1)
+    except OSError as e:
+        print_error(f"Could not reach server at {base_url}: {e}")
+        sys.exit(1)
+    
+    print_claim_code(claim["code"], claim["filename"], claim["url"], str(claim["expires"]))
+
+
+def _announce_file(args: argparse.Namespace):
+    """Ask the server to push a download prompt to every open page."""
+    import json
+    import urllib.error
+    import urllib.request
+    
+    base_url = (args.url or f"http://127.0.0.1:{args.port}").rstrip("/")
+    request = urllib.request.Request(
+        f"{base_url}/api/announce",
+        data=json.dumps({"filename": args.filename, "message": args.message}).encode(),
+        headers={"Content-Type": "application/json"},
+        method="POST",
+    )
+    if config.admin_token:
+        request.add_header("Authorization", f"Bearer {config.admin_token}")
+    
+    try:
+        with urllib.request.urlopen(request, timeout=10) as response:
+            announcement = json.load(response)
+    except urllib.error.HTTPError as e:
+        print_error(f"Could not announce file: {json.load(e).get('detail', e.reason)}")
+        sys.exit(1)
+    except OSError as e:
+        print_error(f"Could not reach server at {base_url}: {e}")
+        sys.exit(1)
+    
+    print_success(f"Announced {announcement['file']['name']} to connected devices")
+
+
+def _rotate_key(args: argparse.Namespace):
+    """Ask the server for a new share key and show the new QR code."""
+    import json
+    import urllib.error
+    import urllib.request
+    
+    base_url = (args.url or f"http://127.0.0.1:{args.port}").rstrip("/")
+    request = urllib.request.Request(f"{base_url}/api/auth/rotate", method="POST")
+    if config.admin_token:
+        request.add_header("Authorization", f"Bearer {config.admin_token}")
+    
+    try:
+        with urllib.request.urlopen(request, timeout=10) as response:
+            rotated = json.load(response)
+    except urllib.error.HTTPError as e:
+        print_error(f"Could not rotate the share key: {json.load(e).get('detail', e.reason)}")
+        sys.exit(1)
+    except OSError as e:
+        print_error(f"Could not reach server at {base_url}: {e}")
+        sys.exit(1)
+    
+    print_success("Share key rotated; the old link, QR code and PIN no longer work")
+    print_qr_code(args.port, url=rotated["url"], title="📱 New share link")
+    if rotated.get("short_url"):
+        print_info(f"Or type {rotated['short_url'].removeprefix('http://')}")
+    if rotated.get("pin"):
+        print_pin(rotated["pin"], f"{base_url}/pin")
+    if rotated.get("host_pin"):
+        print_pin(rotated["host_pin"], f"{base_url}/pin", HOST_PIN_TITLE)
+
+
+def _first_run():
+    """
+    Offer setup the first time flashare shares anything on this machine.
+    
+    Only for interactive send/receive runs: other commands, pipes and
+    scripts start with the defaults, and so does --no-onboarding, which
+    also saves them so setup isn't offered again.
+    """
+    if settings_exist():
+        return
+    argv = sys.argv[1:]
+    if "--no-onboarding" in argv:
+        from flashare.cli.onboarding import write_defaults
+        write_defaults()
+        return
+    command = next((arg for arg in argv if not arg.startswith("-")), None)
+    if command not in (None, "send", "receive") or "-h" in argv or "--help" in argv:
+        return
+    if not sys.stdin.isatty() or not sys.stdout.isatty():
+        return
+    from flashare.cli.onboarding import run_onboarding
+    run_onboarding()
+
+
+def _run_doctor(port: int):
+    """Run the diagnostic checks and print the results."""
+    from flashare.core.doctor import run_checks
+    
+    print_banner()
+    results = run_checks(port)
+    
+    for result in results:
+        if result.ok:
+            print_success(f"[bold]{result.name}[/]: {result.detail}")
+        else:
+            print_warning(f"{result.name}: {result.detail}" + (f"\n{result.hint}" if result.hint else ""))
+    
+    if not all(result.ok for result in results):
+        sys.exit(1)
+
+
+def _clean(args: argparse.Namespace):
+    """Purge the requested kinds of leftovers."""
+    from flashare.core.quarantine import purge_failed
+    
+    if not args.failed:
+        print_error("Nothing to clean; pass --failed")
+        sys.exit(1)
+    
+    try:
+        count = purge_failed()
+    except OSError as e:
+        print_error(f"Could not clean failed uploads: {e}")
+        sys.exit(1)
+    print_success(f"Removed {count} failed upload(s)")
+
+
+def _verify_files(args: argparse.Namespace):
+    """
+    Re-hash the uploads directory in the foreground and list what doesn't match.
+    
+    Exits with 1 if any file is corrupt, missing or unreadable, so the
+    command can run from cron.
+    """
+    from flashare.core.verify import sweep
+    from flashare.cli.ui import _format_size
+    
+    if args.workers < 1:
+        print_error("--workers must be at least 1")
+        sys.exit(1)
+    if not config.uploads_dir.is_dir():
+        print_error(f"Uploads directory not found: {config.uploads_dir}")
+        sys.exit(1)
+    config.verify_workers = args.workers
+    
+    sweep.start(config.uploads_dir, args.fix)
+    try:
+        with create_progress() as progress:
+            task = progress.add_task("Verifying...", total=None)
+            while not sweep.wait(0.2):
+                status = sweep.status()
+                files, size = status["files"], status["bytes"]
+                progress.update(
+                    task,
+                    description=(
+                        f"Verifying {files['done']:,}/{files['total']:,} files "
+                        f"({_format_size(status['bytes_per_second'] or 0)}/s)"
+                    ),
+                    completed=size["done"],
+                    total=size["total"] or None,
+                )
+    except KeyboardInterrupt:
+        sweep.cancel()
+        sweep.wait()
+        print_warning("Cancelled; files verified so far keep their fresh checksums")
+        sys.exit(130)
+    
+    report = sweep.report
+    if sweep.state == "failed":
+        print_error(f"Verification failed: {sweep.error}")
+        sys.exit(1)
+    
+    for label in ("corrupt", "missing", "orphaned"):
+        for name in getattr(report, label):
+            mark = "[yellow]•[/]" if label == "orphaned" else "[red]✗[/]"
+            console.print(f"  {mark} {name} ({label}{', fixed' if name in report.fixed else ''})")
+    for problem in report.errors:
+        console.print(f"  [red]✗[/] {problem}")
+    
+    rate = report.bytes / max(report.elapsed, 1e-6)
+    summary = (
+        f"Verified {report.checked:,} files ({_format_size(report.bytes)}) in {report.elapsed:.1f}s "
+        f"({_format_size(rate)}/s); {len(report.recorded)} newly recorded, {len(report.changed)} changed since last hashed"
+    )
+    if report.ok:
+        print_success(summary)
+        return
+    print_error(summary)
+    if args.fix:
+        print_info(f"{len(report.fixed)} corrupt file(s) {args.fix}d; sidecars of missing files removed")
+    else:
+        print_info("Run again with --fix delete or --fix quarantine to clean up")
+    sys.exit(1)
+
+
+def _print_sync_result(result, dry_run: bool):
+    """Summary line of one sync pass."""
+    from flashare.cli.ui import _format_size
+    
+    for reason in result.skipped:
+        console.print(f"  [dim]- {reason}[/]")
+    counts = [
+        f"{result.count(kind)} {label}"
+        for kind, label in (
+            ("upload", "new"), ("replace", "updated"), ("keep-both", "kept both"),
+            ("delete", "deleted"), ("conflict", "conflicts skipped"), ("unchanged", "unchanged"),
+        )
+        if result.count(kind)
+    ]
+    summary = ", ".join(counts) or "nothing to sync"
+    if dry_run:
+        print_info(f"Dry run: {summary}")
+    elif result.failed:
+        print_error(f"{summary}; {len(result.failed)} failed")
+    else:
+        print_success(f"{summary} ({_format_size(result.sent)} sent in {result.elapsed:.1f}s)")
+
+
+def _sync_folder(args: argparse.Namespace):
+    """
+    Mirror a folder onto a server, once or (with --watch) on every change.
+    
+    Exits with 1 if anything failed, so the command can run from cron.
+    """
+    from flashare.cli.sync import sync, wait_for_changes, SyncError
+    from flashare.cli.ui import _format_size
+    
+    if not args.folder.is_dir():
+        print_error(f"Not a folder: {args.folder}")
+        sys.exit(1)
+    
+    marks = {
+        "upload": "[green]+[/]", "replace": "[cyan]~[/]", "keep-both": "[cyan]+[/]",
+        "delete": "[red]-[/]", "conflict": "[yellow]![/]",
+    }
+    
+    def show(action):
+        detail = ", ".join(part for part in (_format_size(action.size), action.note) if part)
+        line = f"  {marks[action.kind]} {action.name} ({action.kind}; {detail})"
+        if action.error:
+            line = f"  [red]✗[/] {action.name} ({action.kind} failed: {action.error})"
+        console.print(line)
+    
+    while True:
+        try:
+            result = sync(args.url, args.folder, args.delete, args.conflict, args.dry_run, show)
+        except SyncError as e:
+            print_error(f"Sync failed: {e}")
+            if not args.watch:
+                sys.exit(1)
+        except KeyboardInterrupt:
+            print_warning("Interrupted; run again to finish the sync")
+            sys.exit(130)
+        else:
+            _print_sync_result(result, args.dry_run)
+            if result.failed and not args.watch:
+                sys.exit(1)
+        if not args.watch or args.dry_run:
+            return
+        
+        print_info(f"Watching {args.folder} for changes (Ctrl+C to stop)")
+        try:
+            wait_for_changes(args.folder)
+        except KeyboardInterrupt:
+            return
+
+
+def _run_speedtest(args: argparse.Namespace):
+    """Measure latency, download and upload speed to a server and print a report."""
+    from flashare.cli.speedtest import speedtest, SpeedtestError
+    from flashare.cli.ui import _format_size, print_separator
+    
+    def rate(bytes_per_second: float) -> str:
+        return f"{bytes_per_second * 8 / 1e6:,.1f} Mbit/s ({_format_size(int(bytes_per_second))}/s)"
+    
+    try:
+        with create_progress() as progress:
+            tasks = {
+                "download": progress.add_task("Download", total=args.size),
+                "upload": progress.add_task("Upload", total=args.size),
+            }
+            result = speedtest(args.url, args.size, lambda phase, done: progress.update(tasks[phase], completed=done))
+    except SpeedtestError as e:
+        print_error(f"Speed test failed: {e}")
+        sys.exit(1)
+    except KeyboardInterrupt:
+        print_warning("Speed test interrupted")
+        sys.exit(130)
+    
+    print_separator("Speed Test")
+    print_info(f"Latency:  {result.latency * 1000:.1f} ms")
+    print_info(f"Download: {rate(result.download)}")
+    print_info(f"Upload:   {rate(result.upload)}")
+    console.print(f"[dim]{_format_size(result.size)} each way to {args.url.split('?')[0]}[/]")
+
+
+def _export_share(output: Path):
+    """Archive the uploads directory into a single .tar.zst file."""
+    from flashare.core.archive import export_archive
+    
+    try:
+        with create_progress() as progress:
+            task = progress.add_task(f"Exporting to {output.name}...", total=None)
+            count = export_archive(config.uploads_dir, output)
+            progress.update(task, completed=100)
+    except OSError as e:
+        print_error(f"Export failed: {e}")
+        sys.exit(1)
+    
+    print_success(f"Exported {count} files to [cyan]{output}[/] ({output.stat().st_size:,} bytes)")
+
+
+def _import_share(archive: Path, into: Optional[Path] = None, serve: bool = False, port: int = 8000):
+    """
+    Restore a bundle into a fresh folder in the data directory (or into), optionally serving it.
+    
+    Without into, the share lands in <data dir>/imports/<bundle name>/uploads,
+    so it can be served again with 'flashare receive' from that folder and
+    never mixes with the uploads of the share it was imported from.
+    
+    Every file is checked against the bundle's manifest; anything damaged,
+    missing or unexpected is listed and the command fails, leaving what
+    was restored in place for inspection.
+    """
+    from flashare.core.archive import import_archive
+    from flashare.core.extract import unique_folder
+    
+    if not archive.is_file():
+        print_error(f"Archive not found: {archive}")
+        sys.exit(1)
+    
+    if into:
+        dest = into
+    else:
+        name = sanitize_filename(archive.name.removesuffix(".tar.zst").removesuffix(".tar.gz"))
+        dest = unique_folder(config.data_dir / "imports", name) / "uploads"
+    if into and into.exists() and any(into.iterdir()):
+        print_error(f"{into} is not empty; pick a new directory to import into")
+        sys.exit(1)
+    
+    try:
+        with create_progress() as progress:
+            task = progress.add_task(f"Importing {archive.name}...", total=None)
+            report = import_archive(archive, dest)
+            progress.update(task, completed=100)
+    except Exception as e:
+        print_error(f"Import failed: {e}")
+        sys.exit(1)
+    
+    if report.manifest is None:
+        print_warning("This archive has no manifest (made by an older Flashare); files were not verified")
+    elif not report.ok:
+        print_error(f"Restored {report.restored} files into {dest}, but the bundle is damaged:")
+        for label, paths in (("corrupt", report.corrupt), ("missing", report.missing), ("unexpected", report.unlisted)):
+            for path in paths:
+                console.print(f"  [red]✗[/] {path} ({label})")
+        sys.exit(1)
+    
+    verified = " and verified" if report.manifest else ""
+    print_success(f"Restored{verified} {report.restored} files into [cyan]{dest}[/]")
+    if not into and not serve:
+        print_info(f"Serve it again with: cd {shlex.quote(str(dest.parent))} && flashare receive")
+    
+    if serve:
+        config.uploads_dir = dest
+        config.port = port
+        config.read_only = True
+        branding = (report.manifest or {}).get("branding", {})
+        config.brand_title = config.brand_title or branding.get("title", "")
+        config.brand_accent_color = config.brand_accent_color or branding.get("accent_color", "")
+        _start_server(config.host, port)
+
+
+def _resume_session(discard: bool):
+    """Reload the saved session, revalidate its files and restart the server."""
+    if discard:
+        if discard_session():
+            print_success("Discarded the saved session.")
+        else:
+            print_info("No saved session to discard.")
+        return
+    
+    session = load_session()
+    if not session:
+        print_error("No saved session found. Share something with 'flashare send' first.")
+        sys.exit(1)
+    
+    session.apply_to_config()
+    config.uploads_dir.mkdir(parents=True, exist_ok=True)
+    
+    print_banner()
+    
+    kept = []
+    for shared in session.files:
+        staged = config.uploads_dir / shared.name
+        source = Path(shared.source)
+        
+        if staged.exists():
+            kept.append(shared)
+        elif source.is_file():
+            # The staged copy is gone but the original is still around
+            make_dirs(staged.parent, config.uploads_dir)
+            shutil.copy2(source, staged)
+            restrict(staged)
+            kept.append(shared)
+        else:
+            print_warning(f"Dropping {shared.name}: the file no longer exists")
+    
+    session.files = kept
+    save_session(session)
+    
+    print_info(f"Resumed session with {len(kept)} files")
+    _start_server(session.host, session.port, session)
+
+
+def _lock_uploads(port: int, force: bool = False):
+    """Claim the uploads folder for this process, or exit if another live instance serves it."""
+    try:
+        instance_lock.acquire(config.uploads_dir, port, force)
+    except AlreadyServed as e:
+        print_error(str(e))
+        print_info(
+            "Stop that instance first, or pass --force to serve the folder anyway "
+            f"(if the process is gone, you can also delete {e.path})"
+        )
+        sys.exit(1)
+    except OSError as e:
+        print_warning(f"Could not lock {config.uploads_dir}: {e}")
+
+
+def _start_server(host: str, port: int, session: SessionState | None = None):
+    """Start the FastAPI server."""
+    from flashare.server import run_server
+    
+    _lock_uploads(port)
+    
+    # Only a share with files is worth resuming, so a plain receive
+    # doesn't replace the send session --resume would bring back
+    if session and session.files:
+        try:
+            save_session(session)
+        except OSError as e:
+            print_warning(f"Could not save session state: {e}")
     
     console.print()
     print_server_info(host, port)
-    print_qr_code(port)
+    if config.show_qr:
+        print_qr_code(port)
+    if config.show_pin:
+        print_pin(share_access.pin, f"{get_server_url(port)}/pin")
+    if config.device_roles:
+        print_pin(share_access.host_pin, f"{get_server_url(port)}/pin", HOST_PIN_TITLE)
     
     print_info("Starting server... Press [bold]Ctrl+C[/] to stop.")
     console.print()
@@ -215,6 +2033,9 @@ def _start_server(host: str, port: int):
     except KeyboardInterrupt:
         console.print()
         print_success("Server stopped. Goodbye!")
+    finally:
+        instance_lock.release()
+        ndjson.emit("shutdown", summary=stats.metrics())
 
 
 if __name__ == "__main__":
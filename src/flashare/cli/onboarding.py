@@ -0,0 +1,107 @@
+"""First-run setup: where files go, which port, whether to show a PIN, and a connectivity check."""
+
+import os
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+from flashare.cli.ui import (
+    console,
+    ask,
+    confirm,
+    print_info,
+    print_separator,
+    print_success,
+    print_warning,
+)
+from flashare.core.settings import save_settings, settings_path
+
+
+# What the uploads question accepts for "the default": an uploads folder wherever flashare runs
+DEFAULT_UPLOADS = "./uploads"
+
+
+def _validate_folder(answer: str) -> Optional[str]:
+    """A folder is fine if it exists and is writable, or could be created."""
+    if answer == DEFAULT_UPLOADS:
+        return None
+    path = Path(answer).expanduser()
+    if path.exists():
+        if not path.is_dir():
+            return f"{path} is a file, not a folder"
+        if not os.access(path, os.W_OK):
+            return f"{path} is not writable"
+        return None
+    parent = next((p for p in path.absolute().parents if p.exists()), None)
+    if parent is None or not os.access(parent, os.W_OK):
+        return f"{path} can't be created here"
+    return None
+
+
+def _validate_port(answer: str) -> Optional[str]:
+    if not answer.isdigit() or not 1 <= int(answer) <= 65535:
+        return "Enter a number from 1 to 65535"
+    if int(answer) < 1024:
+        return "Ports below 1024 need administrator rights; pick 1024 or above"
+    return None
+
+
+def write_defaults():
+    """Skip setup: save today's defaults, so it isn't offered again."""
+    save_settings(None, config.port, config.show_pin)
+
+
+def run_onboarding(check_connectivity: bool = True):
+    """
+    Walk through the settings that trip up new users, save them, and test the network.
+
+    Every question has a default, so Enter moves on; Ctrl+C (or Ctrl+D)
+    at any point skips the rest and saves the defaults. The results apply
+    straight away and to every later run; `flashare setup` asks again.
+
+    Args:
+        check_connectivity: Finish with the doctor's checks, including
+            whether other devices can reach this machine on the port.
+    """
+    from flashare.core.doctor import run_checks
+
+    print_separator("Welcome to Flashare")
+    console.print(
+        "A few questions before the first share; press Enter to keep a default, Ctrl+C to skip setup.\n"
+    )
+    try:
+        console.print("[bold]Received files[/] are saved to a folder on this computer.")
+        folder = ask("Where should they go?", DEFAULT_UPLOADS, _validate_folder)
+
+        console.print(
+            "\n[bold]Phones connect[/] to this computer on a network port. Your firewall may ask whether to "
+            "allow it the first time: allow it on private networks, or phones won't be able to reach you."
+        )
+        port = int(ask("Which port?", str(config.port), _validate_port))
+
+        console.print("\n[bold]A PIN[/] lets devices without a camera join by typing 6 digits instead of scanning.")
+        pin = confirm("Show a PIN with every share?", default=config.show_pin)
+    except KeyboardInterrupt:
+        console.print()
+        write_defaults()
+        print_info(f"Setup skipped; defaults saved to {settings_path()}. Run 'flashare setup' any time.")
+        return
+
+    uploads_dir = None if folder == DEFAULT_UPLOADS else Path(folder).expanduser().absolute()
+    save_settings(uploads_dir, port, pin)
+    if uploads_dir:
+        uploads_dir.mkdir(parents=True, exist_ok=True)
+        config.uploads_dir = uploads_dir
+    config.port = port
+    config.show_pin = pin
+    print_success(f"Saved to {settings_path()}")
+
+    if not check_connectivity:
+        return
+    print_separator("Checking your setup")
+    for result in run_checks(port):
+        if result.ok:
+            print_success(f"[bold]{result.name}[/]: {result.detail}")
+        else:
+            print_warning(f"{result.name}: {result.detail}" + (f"\n{result.hint}" if result.hint else ""))
+    console.print()
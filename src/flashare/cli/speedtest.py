@@ -0,0 +1,113 @@
+"""Measuring the network between this machine and a Flashare server."""
+
+import json
+import statistics
+import time
+import urllib.error
+import urllib.parse
+import urllib.request
+from dataclasses import dataclass
+from typing import Callable, Iterator, Optional
+
+
+# Round trips timed for the latency figure
+PINGS = 5
+
+_BLOCK = bytes(1024 * 1024)
+
+
+class SpeedtestError(OSError):
+    """The server refused the test or couldn't be reached."""
+
+
+@dataclass
+class SpeedtestResult:
+    """What one run measured; speeds in bytes per second."""
+    latency: float
+    download: float
+    upload: float
+    size: int
+
+
+def _request(url: str, method: str = "GET", body=None, headers: Optional[dict] = None, timeout: float = 60):
+    try:
+        return urllib.request.urlopen(
+            urllib.request.Request(url, data=body, headers=headers or {}, method=method), timeout=timeout,
+        )
+    except urllib.error.HTTPError as e:
+        if e.code == 404:
+            raise SpeedtestError("The server doesn't support speed tests; update Flashare there")
+        try:
+            detail = json.load(e).get("detail", e.reason)
+        except ValueError:
+            detail = e.reason
+        raise SpeedtestError(f"{detail} ({e.code})")
+    except urllib.error.URLError as e:
+        raise SpeedtestError(f"Could not reach the server: {e.reason}")
+
+
+def speedtest(url: str, size: int, on_progress: Optional[Callable[[str, int], None]] = None) -> SpeedtestResult:
+    """
+    Time round trips, then a download and an upload of size bytes, against /api/speedtest.
+
+    Download speed is timed here from the first byte received; upload
+    speed is what the server measured from the first byte it received,
+    so connection setup counts in neither.
+
+    Args:
+        url: Server URL, with ?key=... if the share needs one.
+        size: Bytes to move each way.
+        on_progress: Called with ('download' or 'upload', bytes so far).
+
+    Raises:
+        SpeedtestError: If the server refused the test or went away.
+    """
+    parts = urllib.parse.urlsplit(url)
+    query = urllib.parse.parse_qs(parts.query)
+    base = urllib.parse.urlunsplit((parts.scheme, parts.netloc, parts.path.rstrip("/") + "/api/speedtest", "", ""))
+
+    def endpoint(**params) -> str:
+        params.update({k: v[0] for k, v in query.items() if k == "key"})
+        return f"{base}?{urllib.parse.urlencode(params)}" if params else base
+
+    pings = []
+    for _ in range(PINGS):
+        started = time.perf_counter()
+        with _request(endpoint(bytes=0)) as response:
+            response.read()
+        pings.append(time.perf_counter() - started)
+
+    received = 0
+    started = None
+    with _request(endpoint(bytes=size), timeout=600) as response:
+        while block := response.read(len(_BLOCK)):
+            if started is None:
+                started = time.perf_counter()
+            received += len(block)
+            if on_progress:
+                on_progress("download", received)
+    download_time = time.perf_counter() - started if started is not None else 0.0
+    if received != size:
+        raise SpeedtestError(f"The download stopped after {received} of {size} bytes")
+
+    def zeros() -> Iterator[bytes]:
+        sent = 0
+        while sent < size:
+            block = _BLOCK[:size - sent]
+            sent += len(block)
+            if on_progress:
+                on_progress("upload", sent)
+            yield block
+
+    with _request(
+        endpoint(), "POST", zeros(),
+        {"Content-Length": str(size), "Content-Type": "application/octet-stream"}, timeout=600,
+    ) as response:
+        report = json.load(response)
+
+    return SpeedtestResult(
+        latency=statistics.median(pings),
+        download=received / download_time if download_time > 0 else 0.0,
+        upload=report.get("bytes_per_second") or 0.0,
+        size=size,
+    )
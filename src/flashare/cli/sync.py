@@ -0,0 +1,335 @@
+"""Mirroring a local folder onto a running Flashare server."""
+
+import hashlib
+import json
+import time
+import urllib.error
+import urllib.parse
+import urllib.request
+from dataclasses import dataclass, field
+from datetime import datetime
+from pathlib import Path
+from typing import Callable, Optional
+
+from flashare.config import config
+from flashare.core.paths import sanitize_filename
+
+
+# What to do with a local change when the server's copy is newer
+CONFLICT_POLICIES = ("skip", "overwrite", "rename")
+
+# Seconds local changes must settle before --watch syncs them
+DEBOUNCE = 1.0
+
+# Seconds between scans of the folder when change notifications aren't available
+POLL_INTERVAL = 2.0
+
+# Files per POST /api/upload/check (the server's MAX_UPLOAD_CHECKS)
+CHECK_BATCH = 1000
+
+_READ_SIZE = 1024 * 1024
+
+
+class SyncError(OSError):
+    """The server refused a request or couldn't be reached."""
+
+
+@dataclass
+class SyncAction:
+    """
+    One step of a sync.
+
+    kind is 'upload' (new on the server), 'replace' (changed locally),
+    'keep-both' (changed on both sides; uploaded next to the server's
+    copy), 'delete' (gone locally, with --delete), 'conflict' (changed on
+    both sides and skipped) or 'unchanged'.
+    """
+    kind: str
+    name: str
+    size: int = 0
+    remote_id: Optional[str] = None
+    note: str = ""
+    error: str = ""
+
+
+@dataclass
+class SyncResult:
+    """Everything one sync pass planned, and what failed."""
+    actions: list[SyncAction] = field(default_factory=list)
+    skipped: list[str] = field(default_factory=list)  # Local entries that can't be mirrored, with why
+    elapsed: float = 0.0
+
+    def count(self, kind: str) -> int:
+        return sum(1 for action in self.actions if action.kind == kind and not action.error)
+
+    @property
+    def failed(self) -> list[SyncAction]:
+        return [action for action in self.actions if action.error]
+
+    @property
+    def sent(self) -> int:
+        """Bytes uploaded."""
+        return sum(
+            action.size for action in self.actions
+            if action.kind in ("upload", "replace", "keep-both") and not action.error
+        )
+
+
+class SyncClient:
+    """
+    The few API calls a sync needs.
+
+    A share key in the URL (?key=...) is sent with every request; the
+    admin token, if set, is sent as a bearer token, since deleting and
+    replacing files may need more than a guest's role.
+    """
+
+    def __init__(self, url: str):
+        parts = urllib.parse.urlsplit(url)
+        self.key = urllib.parse.parse_qs(parts.query).get("key", [None])[0]
+        self.base_url = urllib.parse.urlunsplit((parts.scheme, parts.netloc, parts.path.rstrip("/"), "", ""))
+
+    def _url(self, path: str, **query) -> str:
+        if self.key:
+            query["key"] = self.key
+        return f"{self.base_url}{path}" + (f"?{urllib.parse.urlencode(query)}" if query else "")
+
+    def _request(
+        self, method: str, path: str, body=None, headers: Optional[dict] = None, timeout: float = 30, **query,
+    ):
+        request = urllib.request.Request(self._url(path, **query), data=body, headers=headers or {}, method=method)
+        if config.admin_token:
+            request.add_header("Authorization", f"Bearer {config.admin_token}")
+        try:
+            with urllib.request.urlopen(request, timeout=timeout) as response:
+                return json.load(response) if response.status != 204 else None
+        except urllib.error.HTTPError as e:
+            try:
+                detail = json.load(e).get("detail", e.reason)
+            except ValueError:
+                detail = e.reason
+            raise SyncError(f"{method} {path}: {detail} ({e.code})")
+        except urllib.error.URLError as e:
+            raise SyncError(f"Could not reach {self.base_url}: {e.reason}")
+
+    def files(self) -> dict[str, dict]:
+        """Files at the top of the share, by display name."""
+        return {info["name"]: info for info in self._request("GET", "/api/files", sort="name")}
+
+    def check(self, files: list[dict]) -> list[dict]:
+        """Pre-flight check ({name, size, sha256} each), in batches the server accepts."""
+        results = []
+        for start in range(0, len(files), CHECK_BATCH):
+            body = json.dumps(files[start:start + CHECK_BATCH]).encode()
+            results += self._request(
+                "POST", "/api/upload/check", body, {"Content-Type": "application/json"},
+            )["files"]
+        return results
+
+    def checksum(self, remote_id: str) -> str:
+        """SHA-256 of a stored file; the server hashes it if it hasn't yet."""
+        return self._request("GET", f"/api/checksum/{urllib.parse.quote(remote_id)}", timeout=600)["checksum"]
+
+    def upload(self, path: Path, name: str) -> dict:
+        """Send a file as the raw body of PUT /api/files/<name>, keeping its modification time."""
+        stat = path.stat()
+        with open(path, "rb") as f:
+            return self._request(
+                "PUT", f"/api/files/{urllib.parse.quote(name)}", f,
+                {
+                    "Content-Length": str(stat.st_size),
+                    "Content-Type": "application/octet-stream",
+                    "X-File-Modified": str(int(stat.st_mtime * 1000)),
+                },
+                timeout=600,
+            )
+
+    def delete(self, remote_id: str):
+        self._request("DELETE", f"/api/files/{urllib.parse.quote(remote_id)}")
+
+
+def _sha256(path: Path) -> str:
+    digest = hashlib.sha256()
+    with open(path, "rb") as f:
+        while block := f.read(_READ_SIZE):
+            digest.update(block)
+    return digest.hexdigest()
+
+
+def _timestamp(value) -> float:
+    """A 'modified' from the API, whichever time format the server uses."""
+    if isinstance(value, str):
+        return datetime.fromisoformat(value.replace("Z", "+00:00")).timestamp()
+    return float(value or 0)
+
+
+def local_files(folder: Path) -> tuple[dict[str, Path], list[str]]:
+    """
+    Files a share can hold, by name, and entries left out with why.
+
+    Shares keep files flat and hidden names out of listings, so
+    subfolders, hidden files and names the server would change aren't
+    mirrored.
+    """
+    files, skipped = {}, []
+    for path in sorted(folder.iterdir()):
+        if path.name.startswith("."):
+            continue
+        if path.is_dir():
+            skipped.append(f"{path.name}/: folders aren't mirrored")
+        elif not path.is_file():
+            continue
+        elif sanitize_filename(path.name) != path.name:
+            skipped.append(f"{path.name}: the server would store it as {sanitize_filename(path.name)}")
+        else:
+            files[path.name] = path
+    return files, skipped
+
+
+def plan(client: SyncClient, folder: Path, delete: bool = False, conflict: str = "skip") -> SyncResult:
+    """
+    Work out what mirroring folder onto the server takes.
+
+    Files are compared by name, size and SHA-256: the pre-flight check
+    answers for files whose hash the server has on record, and the
+    checksum endpoint for those it only matched by name and size. A
+    changed file whose server copy was modified later than the local one
+    is a conflict, handled by the conflict policy.
+
+    Args:
+        client: The server.
+        folder: Local folder to mirror.
+        delete: Also delete files on the server that are gone locally.
+        conflict: One of CONFLICT_POLICIES.
+
+    Returns:
+        The planned actions; nothing has been changed yet.
+    """
+    started = time.monotonic()
+    files, skipped = local_files(folder)
+    result = SyncResult(skipped=skipped)
+    remote = client.files()
+
+    hashes = {name: _sha256(path) for name, path in files.items()}
+    checks = client.check([
+        {"name": name, "size": path.stat().st_size, "sha256": hashes[name]} for name, path in files.items()
+    ])
+    for (name, path), check in zip(files.items(), checks):
+        stat = path.stat()
+        theirs = remote.get(name)
+        if check["status"] == "exists":
+            if check["match"] == "sha256" or client.checksum(check["duplicate_of"]) == hashes[name]:
+                result.actions.append(SyncAction("unchanged", name, stat.st_size, check["duplicate_of"]))
+                continue
+        if theirs is None:
+            note = f"same content as {check['duplicate_of']}" if check["status"] == "duplicate" else ""
+            result.actions.append(SyncAction("upload", name, stat.st_size, note=note))
+        elif _timestamp(theirs["modified"]) > stat.st_mtime and conflict != "overwrite":
+            kind = "keep-both" if conflict == "rename" else "conflict"
+            result.actions.append(SyncAction(kind, name, stat.st_size, theirs["id"], "newer on the server"))
+        else:
+            result.actions.append(SyncAction("replace", name, stat.st_size, theirs["id"]))
+
+    if delete:
+        for name, theirs in remote.items():
+            if name not in files:
+                result.actions.append(SyncAction("delete", name, theirs["size"], theirs["id"]))
+    result.elapsed = time.monotonic() - started
+    return result
+
+
+def apply(
+    client: SyncClient,
+    folder: Path,
+    result: SyncResult,
+    on_action: Optional[Callable[[SyncAction], None]] = None,
+) -> SyncResult:
+    """
+    Carry out a plan, one file at a time; a failure is recorded on its action and the rest go on.
+
+    A replaced file is deleted on the server before the new version is
+    sent, since the server never overwrites; if the upload then fails,
+    the local file is still there for the next run.
+    """
+    started = time.monotonic() - result.elapsed
+    for action in result.actions:
+        try:
+            if action.kind in ("upload", "keep-both"):
+                stored = client.upload(folder / action.name, action.name)
+                if stored.get("id") != action.name:
+                    action.note = f"stored as {stored.get('id')}"
+            elif action.kind == "replace":
+                client.delete(action.remote_id)
+                client.upload(folder / action.name, action.name)
+            elif action.kind == "delete":
+                client.delete(action.remote_id)
+        except OSError as e:
+            action.error = str(e)
+        if on_action and action.kind != "unchanged":
+            on_action(action)
+    result.elapsed = time.monotonic() - started
+    return result
+
+
+def sync(
+    url: str,
+    folder: Path,
+    delete: bool = False,
+    conflict: str = "skip",
+    dry_run: bool = False,
+    on_action: Optional[Callable[[SyncAction], None]] = None,
+) -> SyncResult:
+    """
+    Mirror folder onto the server at url once.
+
+    Raises:
+        SyncError: If the server can't be listed or checked.
+    """
+    client = SyncClient(url)
+    result = plan(client, folder, delete, conflict)
+    if dry_run:
+        for action in result.actions:
+            if on_action and action.kind != "unchanged":
+                on_action(action)
+        return result
+    return apply(client, folder, result, on_action)
+
+
+def _fingerprint(folder: Path) -> dict[str, tuple[int, int]]:
+    files, _ = local_files(folder)
+    state = {}
+    for name, path in files.items():
+        try:
+            stat = path.stat()
+        except OSError:
+            continue  # Vanished mid-scan
+        state[name] = (stat.st_size, stat.st_mtime_ns)
+    return state
+
+
+def wait_for_changes(folder: Path):
+    """
+    Block until files in folder changed and then stayed untouched for DEBOUNCE seconds.
+
+    Uses change notifications when watchfiles is installed, else polls
+    every POLL_INTERVAL seconds.
+    """
+    try:
+        from watchfiles import watch
+    except ImportError:
+        watch = None
+
+    if watch is not None:
+        for _ in watch(folder, recursive=False, debounce=int(DEBOUNCE * 1000), step=50):
+            return
+
+    before = _fingerprint(folder)
+    while (current := _fingerprint(folder)) == before:
+        time.sleep(POLL_INTERVAL)
+    # Wait for a file still being written to settle
+    while True:
+        time.sleep(DEBOUNCE)
+        settled = _fingerprint(folder)
+        if settled == current:
+            return
+        current = settled
@@ -0,0 +1,295 @@
+"""Live terminal dashboard for a running Flashare server."""
+
+import json
+import time
+import urllib.request
+from datetime import datetime
+
+from rich import box
+from rich.console import Group
+from rich.live import Live
+from rich.panel import Panel
+from rich.table import Table
+
+from flashare.cli.ui import (
+    console,
+    _format_size,
+    COLOR_PRIMARY,
+    COLOR_ACCENT,
+    COLOR_MUTED,
+    COLOR_SUCCESS,
+)
+
+
+# Seconds the dashboard stays highlighted after a new client connects
+FLASH_SECONDS = 3.0
+
+# Refreshes each page of a long list stays up before the next one is shown
+PAGE_REFRESHES = 5
+
+# Lines around the lists (panels, borders, headers) in each view
+TOP_CHROME = 20
+DEVICES_CHROME = 7
+
+# Rows shown per list even on a tiny terminal
+MIN_PAGE_ROWS = 3
+
+
+def _fetch(base_url: str, path: str):
+    """GET a JSON endpoint from the remote server."""
+    with urllib.request.urlopen(f"{base_url}{path}", timeout=3) as response:
+        return json.load(response)
+
+
+def _to_unix(value) -> float:
+    """Accept API timestamps in either unix or RFC 3339 form."""
+    if isinstance(value, str):
+        return datetime.fromisoformat(value).timestamp()
+    return value
+
+
+def _format_age(seconds: float) -> str:
+    """Format a duration as a compact '1h 2m' / '5s' string."""
+    seconds = int(seconds)
+    if seconds < 60:
+        return f"{seconds}s"
+    if seconds < 3600:
+        return f"{seconds // 60}m {seconds % 60}s"
+    return f"{seconds // 3600}h {seconds % 3600 // 60}m"
+
+
+def _page_size(chrome: int, lists: int = 1) -> int:
+    """Rows each of lists tables can show without pushing the view off the terminal."""
+    return max((console.size.height - chrome) // lists, MIN_PAGE_ROWS)
+
+
+def _page(rows: list, page_size: int, tick: int) -> tuple[list, str]:
+    """
+    The rows to show on this refresh, and where they sit in the list.
+
+    A list longer than page_size is shown a page at a time, moving on every
+    PAGE_REFRESHES refreshes and wrapping back to the start after the last.
+
+    Args:
+        rows: The whole list.
+        page_size: Rows that fit on screen.
+        tick: Number of refreshes so far.
+
+    Returns:
+        The page of rows, and a position like '11-20/340' ('' if it all fits).
+    """
+    if len(rows) <= page_size:
+        return rows, ""
+    pages = -(-len(rows) // page_size)
+    start = tick // PAGE_REFRESHES % pages * page_size
+    shown = rows[start:start + page_size]
+    return shown, f"{start + 1}-{start + len(shown)}/{len(rows)}"
+
+
+def _titled(title: str, position: str) -> str:
+    """A panel title with the page position after it, if the list is paged."""
+    return f"{title} [dim]{position}[/]" if position else title
+
+
+def _render(
+    status: dict,
+    transfers: list,
+    metrics: dict,
+    rates: tuple[float, float],
+    flash: bool = False,
+    page_size: int = 10,
+    tick: int = 0,
+) -> Group:
+    """
+    Build the dashboard renderable from one round of API data.
+
+    flash highlights a new connection; transfers and clients are shown
+    page_size rows at a time (see _page).
+    """
+    up_rate, down_rate = rates
+
+    summary = Table(show_header=False, box=box.SIMPLE, padding=(0, 2))
+    summary.add_column("Label", style=COLOR_MUTED)
+    summary.add_column("Value", style=f"bold {COLOR_PRIMARY}")
+    summary.add_column("Label", style=COLOR_MUTED)
+    summary.add_column("Value", style=f"bold {COLOR_PRIMARY}")
+    summary.add_row(
+        "🌐 URL", status.get("url", "-"),
+        "⏱️  Uptime", _format_age(metrics.get("uptime", 0)),
+    )
+    summary.add_row(
+        "📁 Files", str(status.get("file_count", 0)),
+        "📦 Size", status.get("total_size_human", "-"),
+    )
+    summary.add_row(
+        "⬆ Upload", f"{_format_size(up_rate)}/s",
+        "⬇ Download", f"{_format_size(down_rate)}/s",
+    )
+    summary.add_row(
+        "📱 Clients", str(metrics.get("clients", 0)),
+        "🔁 Transfers", f"{metrics.get('uploads', 0)} up / {metrics.get('downloads', 0)} down",
+    )
+
+    active = Table(box=box.ROUNDED, border_style=COLOR_ACCENT, expand=True)
+    active.add_column("File", style=COLOR_PRIMARY)
+    active.add_column("Direction")
+    active.add_column("Client", style=COLOR_MUTED)
+    active.add_column("Progress", justify="right", style=COLOR_SUCCESS)
+    shown, transfers_position = _page(transfers, page_size, tick)
+    for transfer in shown:
+        total = transfer.get("total")
+        progress = _format_size(transfer["bytes"])
+        if total:
+            progress += f" / {_format_size(total)} ({transfer['bytes'] * 100 // max(total, 1)}%)"
+        active.add_row(transfer["filename"], transfer["direction"], transfer["client"], progress)
+    if not transfers:
+        active.add_row("[dim]No active transfers[/]", "", "", "")
+
+    now = time.time()
+    clients = Table(box=box.ROUNDED, border_style=COLOR_ACCENT, expand=True)
+    clients.add_column("Client", style=COLOR_PRIMARY)
+    clients.add_column("Last activity")
+    clients.add_column("Seen", justify="right", style=COLOR_MUTED)
+    shown, clients_position = _page(metrics.get("client_activity", []), page_size, tick)
+    for client in shown:
+        clients.add_row(
+            client["ip"],
+            client.get("last_action") or "[dim]browsing[/]",
+            f"{_format_age(now - _to_unix(client['last_seen']))} ago",
+        )
+
+    clients_title = _titled(
+        f"[bold]Connected clients[/] [reverse] {len(metrics.get('client_activity', []))} [/]", clients_position
+    )
+    if flash:
+        clients_title += f" [bold {COLOR_SUCCESS}]✨ new connection[/]"
+    return Group(
+        Panel(
+            summary, title="[bold]⚡ Flashare top[/]", box=box.ROUNDED,
+            border_style=COLOR_SUCCESS if flash else COLOR_PRIMARY,
+        ),
+        Panel(active, title=_titled("[bold]Active transfers[/]", transfers_position), box=box.SIMPLE),
+        Panel(clients, title=clients_title, box=box.SIMPLE),
+    )
+
+
+def _render_devices(devices: list, transfers: list, metrics: dict, page_size: int = 20, tick: int = 0) -> Panel:
+    """Build the devices view: who is connected, what they did and when, page_size rows at a time."""
+    activity = {c["ip"]: c for c in metrics.get("client_activity", [])}
+    active = {}
+    for transfer in transfers:
+        active[transfer["client"]] = active.get(transfer["client"], 0) + 1
+
+    now = time.time()
+    table = Table(box=box.ROUNDED, border_style=COLOR_ACCENT, expand=True)
+    table.add_column("Device", style=COLOR_PRIMARY)
+    table.add_column("IP", style=COLOR_MUTED)
+    table.add_column("Last activity")
+    table.add_column("When", justify="right", style=COLOR_MUTED)
+    table.add_column("⬆ Up", justify="right", style=COLOR_SUCCESS)
+    table.add_column("⬇ Down", justify="right", style=COLOR_SUCCESS)
+    table.add_column("Quota left", justify="right")
+
+    shown, position = _page(devices, page_size, tick)
+    for device in shown:
+        client = activity.get(device["ip"], {})
+        busy = active.get(device["ip"])
+        last_action = client.get("last_action") or "[dim]browsing[/]"
+        if busy:
+            last_action = f"[bold {COLOR_SUCCESS}]{busy} transfer(s) in progress[/]"
+        remaining = device.get("remaining")
+        table.add_row(
+            device["id"][:8] if device.get("cookie") else "[dim]no cookie[/]",
+            device["ip"],
+            last_action,
+            f"{_format_age(now - _to_unix(device['last_seen']))} ago",
+            _format_size(device["uploaded"]),
+            _format_size(device["downloaded"]),
+            _format_size(remaining) if remaining is not None else "[dim]∞[/]",
+        )
+    if not devices:
+        table.add_row("[dim]No devices yet[/]", "", "", "", "", "", "")
+
+    return Panel(
+        table,
+        title=_titled(f"[bold]📱 Devices[/] [reverse] {len(devices)} [/]", position),
+        box=box.ROUNDED,
+        border_style=COLOR_PRIMARY,
+    )
+
+
+def run_top(base_url: str, interval: float = 1.0):
+    """
+    Show a live dashboard of a running server until Ctrl+C.
+
+    Lists too long for the terminal cycle through pages, with their
+    position shown in the title.
+
+    Args:
+        base_url: Server URL, e.g. http://127.0.0.1:8000.
+        interval: Refresh interval in seconds.
+    """
+    base_url = base_url.rstrip("/")
+    previous = None
+    clients, flash_until = None, 0.0
+    tick = 0
+
+    with Live(console=console, refresh_per_second=4, screen=False) as live:
+        while True:
+            try:
+                status = _fetch(base_url, "/api/status")
+                transfers = _fetch(base_url, "/api/transfers")
+                metrics = _fetch(base_url, "/api/metrics")
+            except (OSError, ValueError) as e:
+                live.update(Panel(f"[red]Cannot reach {base_url}: {e}[/]", box=box.ROUNDED))
+                time.sleep(interval)
+                continue
+
+            # Bandwidth is the counter delta between two refreshes
+            now = time.time()
+            rates = (0.0, 0.0)
+            if previous:
+                elapsed = max(now - previous[0], 1e-6)
+                rates = (
+                    (metrics["bytes_uploaded"] - previous[1]) / elapsed,
+                    (metrics["bytes_downloaded"] - previous[2]) / elapsed,
+                )
+            previous = (now, metrics["bytes_uploaded"], metrics["bytes_downloaded"])
+
+            # Flash for a moment when a client joins, for hosts not watching closely
+            if clients is not None and metrics.get("clients", 0) > clients:
+                flash_until = now + FLASH_SECONDS
+            clients = metrics.get("clients", 0)
+
+            live.update(_render(status, transfers, metrics, rates, now < flash_until, _page_size(TOP_CHROME, 2), tick))
+            tick += 1
+            time.sleep(interval)
+
+
+def run_devices(base_url: str, interval: float = 1.0):
+    """
+    Show connected devices and their activity until Ctrl+C.
+
+    When there are more devices than fit, the view pages through them.
+
+    Args:
+        base_url: Server URL, e.g. http://127.0.0.1:8000.
+        interval: Refresh interval in seconds.
+    """
+    base_url = base_url.rstrip("/")
+    tick = 0
+
+    with Live(console=console, refresh_per_second=4, screen=False) as live:
+        while True:
+            try:
+                devices = _fetch(base_url, "/api/devices")
+                transfers = _fetch(base_url, "/api/transfers")
+                metrics = _fetch(base_url, "/api/metrics")
+            except (OSError, ValueError) as e:
+                live.update(Panel(f"[red]Cannot reach {base_url}: {e}[/]", box=box.ROUNDED))
+                time.sleep(interval)
+                continue
+
+            live.update(_render_devices(devices, transfers, metrics, _page_size(DEVICES_CHROME), tick))
+            tick += 1
+            time.sleep(interval)
@@ -1,6 +1,6 @@
 """Rich terminal UI components for Flashare - Modern aesthetic terminal experience."""
 
-from rich.console import Console
+from rich.console import Console, Group
 from rich.panel import Panel
 from rich.progress import Progress, SpinnerColumn, TextColumn, BarColumn, TaskProgressColumn
 from rich.table import Table
@@ -10,12 +10,13 @@ from rich.align import Align
 from rich.rule import Rule
 from rich import box
 from pathlib import Path
-from typing import Optional
+from typing import Callable, Optional
 from datetime import datetime
 
 from flashare import __app_name__, __version__
-from flashare.core.qr import generate_qr_ascii
-from flashare.core.network import get_server_url
+from flashare.config import config
+from flashare.core.qr import render_qr_terminal
+from flashare.core.access import qr_url, short_url
 
 
 # Global console instance with better styling
@@ -69,22 +70,86 @@ def print_banner():
     console.print()
 
 
-def print_qr_code(port: int = 8000):
+def print_qr_code(
+    port: int = 8000,
+    url: Optional[str] = None,
+    title: str = "📱 Scan to Connect",
+    subtitle: Optional[str] = None,
+):
     """
     Display QR code in modern styled panel.
     
     Args:
         port: Server port number.
+        url: Data to encode. Defaults to the auto-detected server URL.
+        title: Panel title.
+        subtitle: Panel subtitle. Defaults to the encoded URL.
     """
-    url = get_server_url(port)
-    qr_ascii = generate_qr_ascii(port=port)
+    url = url or qr_url(port)
+    qr_text = Text.from_ansi(render_qr_terminal(url, config.qr_style, config.qr_quiet_zone))
     
     console.print()
     console.print(
         Panel(
-            Align.center(qr_ascii),
-            title="[bold bright_cyan]📱 Scan to Connect[/]",
-            subtitle=f"[italic dim]{url}[/]",
+            Align.center(qr_text),
+            title=f"[bold bright_cyan]{title}[/]",
+            subtitle=f"[italic dim]{subtitle or url}[/]",
+            box=box.DOUBLE,
+            border_style=f"{COLOR_SUCCESS} bold",
+            padding=(2, 3),
+        ),
+    )
+    console.print()
+
+
+# Panel title for the PIN that makes a device a host (--roles)
+HOST_PIN_TITLE = "🔑 Host PIN: type it on your own devices for full control"
+
+
+def print_pin(pin: str, url: str, title: str = "🔢 Can't scan? Type this PIN"):
+    """
+    Display a 6-digit PIN: the share's, for devices that can't scan the
+    QR code, or the host PIN.
+    
+    Args:
+        pin: The 6-digit PIN.
+        url: Page where the PIN is typed in.
+        title: Panel title saying what the PIN is for.
+    """
+    big = Text("  ".join(pin), style=f"bold {COLOR_ACCENT}")
+    
+    console.print(
+        Panel(
+            Align.center(big),
+            title=f"[bold bright_cyan]{title}[/]",
+            subtitle=f"[italic dim]at {url}[/]",
+            box=box.ROUNDED,
+            border_style=COLOR_ACCENT,
+            padding=(1, 3),
+        ),
+    )
+    console.print()
+
+
+def print_claim_code(code: str, filename: str, url: str, expires: str):
+    """
+    Display a claim code big enough to read out across a room.
+    
+    Args:
+        code: The claim code.
+        filename: File the code downloads.
+        url: Direct link for the code, also shown as a QR code.
+        expires: When the code stops working.
+    """
+    big = Text("   ".join(code), style=f"bold {COLOR_ACCENT}")
+    qr_text = Text.from_ansi(render_qr_terminal(url, config.qr_style, config.qr_quiet_zone))
+    
+    console.print()
+    console.print(
+        Panel(
+            Group(Align.center(big), Text(), Align.center(qr_text)),
+            title=f"[bold bright_cyan]🎟️  Claim code for {filename}[/]",
+            subtitle=f"[italic dim]{url} · single use · expires {expires}[/]",
             box=box.DOUBLE,
             border_style=f"{COLOR_SUCCESS} bold",
             padding=(2, 3),
@@ -101,7 +166,7 @@ def print_server_info(host: str, port: int):
         host: Server host.
         port: Server port.
     """
-    url = get_server_url(port)
+    url = qr_url(port)
     
     # Create styled info table
     table = Table(
@@ -114,8 +179,11 @@ def print_server_info(host: str, port: int):
     table.add_column("Value", style=f"bold {COLOR_PRIMARY}")
     
     table.add_row("🌐 Server URL", f"[link={url}]{url}[/link]")
+    table.add_row("⌨️  Or type", short_url(port).removeprefix("http://"))
     table.add_row("📡 Host", f"[{COLOR_ACCENT}]{host}[/]")
     table.add_row("🔌 Port", f"[{COLOR_ACCENT}]{port}[/]")
+    # Starts empty; each device that connects is logged below with the new count
+    table.add_row("📱 Clients", "[reverse] 0 [/] connected")
     
     # Wrap in a panel
     console.print()
@@ -293,6 +361,33 @@ def confirm(prompt: str, default: bool = True) -> bool:
         return False
 
 
+def ask(prompt: str, default: str, validate: Callable[[str], Optional[str]]) -> str:
+    """
+    Ask for a value until it passes validation.
+    
+    Args:
+        prompt: The question to ask.
+        default: Answer used if the user just presses Enter.
+        validate: Returns an error message for a bad answer, else None.
+        
+    Returns:
+        The accepted answer.
+        
+    Raises:
+        KeyboardInterrupt: On Ctrl+C or Ctrl+D, so callers can bail out.
+    """
+    styled_prompt = f"[bold {COLOR_ACCENT}]?[/] {prompt} [dim]({default})[/]"
+    while True:
+        try:
+            response = console.input(styled_prompt + " ").strip() or default
+        except EOFError:
+            raise KeyboardInterrupt
+        problem = validate(response)
+        if problem is None:
+            return response
+        console.print(f"  [{COLOR_ERROR}]✗[/] {problem}")
+
+
 def create_progress(description: str = "Processing...") -> Progress:
     """
     Create a modern Rich progress bar for file operations.
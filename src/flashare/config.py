@@ -3,6 +3,7 @@
 import os
 from pathlib import Path
 from dataclasses import dataclass, field
+from typing import Optional
 
 
 @dataclass
@@ -13,15 +14,215 @@ class Config:
     port: int = 8000
     uploads_dir: Path = field(default_factory=lambda: Path.cwd() / "uploads")
     static_dir: Path = field(default_factory=lambda: Path(__file__).parent / "static")
+    data_dir: Path = field(
+        default_factory=lambda: Path(os.environ.get("FLASHARE_DATA_DIR", Path.home() / ".flashare"))
+    )
+    
+    # Check GitHub for new releases on normal commands (cached for 24h)
+    check_updates: bool = field(default_factory=lambda: os.environ.get("FLASHARE_CHECK_UPDATES") == "1")
     
     # FFmpeg settings
     ffmpeg_preset: str = "ultrafast"
     ffmpeg_crf: int = 28
     video_extensions: tuple = (".mov", ".mkv", ".avi", ".mp4", ".webm")
+    # Make an H.264/AAC .mp4 of uploaded videos browsers can't play (needs ffmpeg)
+    transcode_videos: bool = field(default_factory=lambda: os.environ.get("FLASHARE_TRANSCODE") == "1")
     
     # Compression settings
     zstd_level: int = 3
     chunk_size: int = 1024 * 64  # 64KB chunks
+    # Downloads up to this size are compressed in memory before sending, so
+    # they carry a Content-Length; the pool caps the RAM all of them may use
+    compress_buffer_threshold: int = 32 * 1024**2
+    compress_buffer_pool: int = 256 * 1024**2
+    # Smallest original/compressed size ratio worth compressing for; a
+    # buffered file that compresses worse is sent as it is
+    compress_min_ratio: float = 1.05
+    
+    # Terminal QR rendering: "auto", "block", "ascii" or "color"
+    qr_style: str = "auto"
+    qr_quiet_zone: int = 2  # Blank modules around the code
+    
+    # Web UI branding; empty values fall back to the defaults
+    brand_title: str = field(default_factory=lambda: os.environ.get("FLASHARE_TITLE", ""))
+    brand_accent_color: str = field(default_factory=lambda: os.environ.get("FLASHARE_ACCENT_COLOR", ""))
+    brand_logo_path: str = field(default_factory=lambda: os.environ.get("FLASHARE_LOGO", ""))
+    # Line printed under the title on the /api/poster sign, e.g. "Drop your photos here"
+    poster_message: str = field(default_factory=lambda: os.environ.get("FLASHARE_POSTER_MESSAGE", ""))
+    
+    # Hotspot credentials for the Wi-Fi QR code
+    wifi_ssid: str = field(default_factory=lambda: os.environ.get("FLASHARE_WIFI_SSID", ""))
+    wifi_password: str = field(default_factory=lambda: os.environ.get("FLASHARE_WIFI_PASSWORD", ""))
+    
+    # Relay that maps a short code (e.g. relay.example/ABC123) to this server
+    relay_url: str = field(default_factory=lambda: os.environ.get("FLASHARE_RELAY_URL", ""))
+    use_relay: bool = False
+    
+    # Storage backend: "local" (uploads_dir) or "s3"
+    storage_backend: str = field(default_factory=lambda: os.environ.get("FLASHARE_STORAGE", "local"))
+    s3_bucket: str = field(default_factory=lambda: os.environ.get("FLASHARE_S3_BUCKET", ""))
+    s3_prefix: str = field(default_factory=lambda: os.environ.get("FLASHARE_S3_PREFIX", ""))
+    s3_endpoint_url: str = field(default_factory=lambda: os.environ.get("FLASHARE_S3_ENDPOINT_URL", ""))
+    
+    # Upload staging: "disk" writes directly, "memory" buffers small uploads in RAM
+    staging_mode: str = "disk"
+    staging_limit: int = 2 * 1024**3  # Total RAM for staged uploads
+    staging_threshold: int = 256 * 1024**2  # Larger uploads always go straight to disk
+    
+    # Request bodies up to this size are read in one piece; larger ones are
+    # streamed in chunk_size pieces (and multipart files spill to a temp file)
+    stream_threshold: int = 1024**2
+    # Most parts (files plus fields) one multipart upload may have
+    max_multipart_parts: int = 1000
+    # Largest request head (request line plus headers) in bytes; 0 keeps
+    # the HTTP server's own limit
+    max_header_size: int = 0
+    
+    # Threads reading file metadata for one listing. Only pays off where
+    # sidecar reads wait on the disk (network shares, cold caches), so the
+    # default reads file by file
+    list_concurrency: int = 1
+    
+    # Download bytes per second shared fairly between devices, 0 = unlimited
+    bandwidth_limit: int = 0
+    
+    # Seconds between keep-alive comments on live-update streams
+    heartbeat_interval: float = 15.0
+    
+    # Re-create the uploads directory if it disappears while running,
+    # instead of answering 503 (off by default: it may be an unmounted drive)
+    auto_create_dir: bool = False
+    # Seconds between checks that the uploads directory is still there and
+    # writable; while it isn't, file requests get 503 'storage_unavailable'
+    storage_probe_interval: float = 5.0
+    # Tell the host on their desktop when that happens (macOS, Linux)
+    desktop_notifications: bool = field(default_factory=lambda: os.environ.get("FLASHARE_DESKTOP_NOTIFY", "1") != "0")
+    # Let the host know when a new device connects: "bell" rings the
+    # terminal, "desktop" shows a notification, "none" stays quiet
+    notify_on_connect: str = field(default_factory=lambda: os.environ.get("FLASHARE_NOTIFY_ON_CONNECT", "none"))
+    
+    # Seconds between scans of the uploads directory for outside changes when
+    # the filesystem doesn't deliver change notifications (NFS, SMB), 0 = don't watch
+    watch_interval: float = 5.0
+    
+    # Seconds between runs of the background sweeper (scheduled deletions)
+    sweep_interval: float = 30.0
+    
+    # Print refused requests (401/403/413/429) with client, path and reason
+    log_rejections: bool = field(default_factory=lambda: os.environ.get("FLASHARE_LOG_REJECTIONS", "1") != "0")
+    
+    # Header carrying each request's ID (an incoming one is kept, e.g. from a proxy)
+    request_id_header: str = field(default_factory=lambda: os.environ.get("FLASHARE_REQUEST_ID_HEADER", "X-Request-ID"))
+    
+    # Resolve '/API/Files' and '/api/files/' to their canonical routes
+    lenient_routes: bool = True
+    
+    # Store uploads identical to an existing file as links to it (local storage only)
+    dedupe_uploads: bool = field(default_factory=lambda: os.environ.get("FLASHARE_DEDUPE") == "1")
+    
+    # Sort uploads into folders, e.g. '{type}/{year}/{month}-{day}/' (local storage only)
+    organize_uploads: str = field(default_factory=lambda: os.environ.get("FLASHARE_ORGANIZE_UPLOADS", ""))
+    
+    # Put uploads in a folder for the day they arrive, named by this strftime format, e.g. '%Y-%m-%d'
+    date_folders: str = field(default_factory=lambda: os.environ.get("FLASHARE_DATE_FOLDERS", ""))
+    
+    # Move partial files of failed uploads to uploads/.failed instead of deleting them
+    keep_failed_uploads: bool = field(default_factory=lambda: os.environ.get("FLASHARE_KEEP_FAILED_UPLOADS") == "1")
+    failed_uploads_limit: int = 4 * 1024**3  # Oldest partials are evicted beyond this
+    
+    # Retries for copies into the share that fail transiently (delay doubles each time)
+    copy_retries: int = 3
+    copy_retry_delay: float = 0.5
+    
+    # Serve files without accepting uploads, deletions or other changes
+    read_only: bool = False
+    
+    # Upload settings
+    reject_empty: bool = False  # Refuse zero-byte uploads
+    # Refuse uploads whose content contradicts their extension (an .exe named .jpg)
+    strict_mime: bool = field(default_factory=lambda: os.environ.get("FLASHARE_STRICT_MIME") == "1")
+    max_upload_size: int = 0  # Bytes per file, 0 = unlimited
+    per_device_quota: int = 0  # Upload bytes per device per session, 0 = unlimited
+    # Delete every file after its first complete download
+    burn_after_download: bool = field(default_factory=lambda: os.environ.get("FLASHARE_BURN_AFTER_DOWNLOAD") == "1")
+    
+    # Unpack uploaded zips into a folder of the same name, optionally dropping the zip
+    auto_extract_zip: bool = field(default_factory=lambda: os.environ.get("FLASHARE_AUTO_EXTRACT_ZIP") == "1")
+    auto_extract_remove_zip: bool = False
+    
+    # Content types by extension, e.g. {".log": "text/plain"}, over the built-in table
+    mime_overrides: dict = field(default_factory=dict)
+    
+    # Octal permissions for received files (e.g. 0o600), None to follow the umask
+    file_mode: Optional[int] = None
+    
+    # Store uploads under random IDs, keeping real names only in metadata
+    obfuscate_names: bool = field(default_factory=lambda: os.environ.get("FLASHARE_OBFUSCATE_NAMES") == "1")
+    
+    # Timestamps in API responses: "unix" (float seconds) or "rfc3339" strings
+    time_format: str = field(default_factory=lambda: os.environ.get("FLASHARE_TIME_FORMAT", "unix"))
+    
+    # Zips prepared on disk for resumable downloads: how many may be built
+    # at once, and seconds each is kept after its last download
+    zip_jobs_limit: int = 2
+    zip_job_retention: float = 60 * 60
+    # Bytes of compressed copies kept so compressed downloads can resume
+    # (least recently used go first), 0 = compress on the fly only
+    encoded_cache_size: int = 1024 * 1024 * 1024
+    # Seconds an unfinished tus upload is kept after its last data arrived
+    tus_expiry: float = 24 * 60 * 60
+    # Files hashed at once by an integrity sweep (flashare verify, /api/verify)
+    verify_workers: int = 2
+    
+    # Seconds shutdown waits for transfers in progress before cutting them
+    # off; 0 waits for them however long they take
+    shutdown_timeout: float = 30.0
+    
+    # Seconds a claim code stays valid unless the host picks another expiry
+    claim_ttl: int = 15 * 60
+    
+    # Seconds a host announcement is still shown to devices that connect late
+    announcement_window: int = 10 * 60
+    
+    # Guests need the key from the share link/QR code; the host can rotate it
+    require_key: bool = field(default_factory=lambda: os.environ.get("FLASHARE_REQUIRE_KEY") == "1")
+    
+    # QR codes carry a single-use /connect/<token> link instead of the share key (with require_key)
+    one_time_qr: bool = field(default_factory=lambda: os.environ.get("FLASHARE_ONE_TIME_QR") == "1")
+    
+    # Print a 6-digit PIN, typed at /pin, for devices that can't scan the QR code
+    show_pin: bool = field(default_factory=lambda: os.environ.get("FLASHARE_PIN") == "1")
+    show_qr: bool = True
+    
+    # Web pages allowed to call the API besides the share's own URLs (--cors-origin)
+    cors_origins: list = field(
+        default_factory=lambda: [o for o in os.environ.get("FLASHARE_CORS_ORIGINS", "").split(",") if o]
+    )
+    # Let those pages send cookies (the share key, the device ID)
+    cors_allow_credentials: bool = True
+    # Let every website call the API, as before; cookies are never allowed then
+    cors_any: bool = False
+    
+    # Per-device roles (guest, trusted, host): devices start as guests, which
+    # can't delete, and are promoted by the admin API or by typing the host PIN
+    device_roles: bool = field(default_factory=lambda: os.environ.get("FLASHARE_ROLES") == "1")
+    # A role only holds at the IP address it was granted to
+    strict_device_binding: bool = False
+    # Keep roles in the data directory across restarts
+    remember_roles: bool = False
+    # Only the device that uploaded a file (or the host) may delete or replace it
+    owner_only_modify: bool = field(default_factory=lambda: os.environ.get("FLASHARE_OWNER_ONLY") == "1")
+    
+    # Token for host-only endpoints (e.g. raising a device's quota)
+    admin_token: str = field(default_factory=lambda: os.environ.get("FLASHARE_ADMIN_TOKEN", ""))
+    
+    # Share filtering (glob patterns relative to the shared root)
+    exclude_patterns: list = field(default_factory=list)
+    include_patterns: list = field(default_factory=list)
+    use_default_excludes: bool = True
+    # Ask before sharing a folder holding more files or bytes than this (0 = never ask)
+    confirm_files_over: int = 10_000
+    confirm_size_over: int = 50 * 1024**3
     
     def __post_init__(self):
         """Ensure uploads directory exists."""
@@ -0,0 +1,167 @@
+"""Optional share key guarding the share, and its rotation."""
+
+import secrets
+import threading
+import time
+from collections import Counter
+from typing import Optional
+
+from flashare.config import config
+from flashare.core.network import get_server_url
+from flashare.core.slugs import new_slug
+
+
+# Cookie remembering the key once a device has opened the share link
+KEY_COOKIE = "flashare_key"
+
+# Wrong PINs a device may type before it is locked out until the next rotation
+MAX_PIN_ATTEMPTS = 10
+
+# Seconds a one-time QR link (/connect/<token>) stays valid if nobody scans it
+BOOTSTRAP_TTL = 300
+
+
+def _new_pin() -> str:
+    return f"{secrets.randbelow(10**6):06d}"
+
+
+class ShareAccess:
+    """
+    The key every guest request must carry when config.require_key is on,
+    the 6-digit PIN that stands in for the link when typed at /pin, the
+    host PIN that makes the device typing it a host (config.device_roles),
+    the two-word slug of the short link typed instead of the URL, and the
+    single-use tokens of one-time QR links (config.one_time_qr).
+
+    Rotating replaces all of them; old keys are remembered so their
+    holders can be told the key changed rather than that they never had one.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self.key = secrets.token_urlsafe(9)
+        self.pin = _new_pin()
+        self.host_pin = self._new_host_pin()
+        self.slug = new_slug()
+        self._retired: set[str] = set()
+        self._pin_failures: Counter = Counter()
+        self._bootstrap: dict[str, float] = {}  # Token -> expiry
+        self.rotated_at: Optional[float] = None
+
+    def _new_host_pin(self) -> str:
+        while (pin := _new_pin()) == self.pin:
+            pass
+        return pin
+
+    def check(self, supplied: Optional[str]) -> Optional[str]:
+        """
+        Validate a key from a request.
+
+        Returns:
+            None if it is current, otherwise the error code for the
+            client: 'credentials_rotated' or 'credentials_required'.
+        """
+        with self._lock:
+            if supplied and secrets.compare_digest(supplied, self.key):
+                return None
+            if supplied in self._retired:
+                return "credentials_rotated"
+            return "credentials_required"
+
+    def redeem_pin(self, pin: str, client: str) -> str:
+        """
+        Validate a PIN typed by a device.
+
+        Only PINs in use count: the share PIN with config.show_pin, the
+        host PIN with config.device_roles. A 6-digit PIN is guessable, so
+        each device gets MAX_PIN_ATTEMPTS tries at both together before
+        further attempts are refused.
+
+        Returns:
+            'share' or 'host' for the PIN that matched, otherwise the
+            error code for the client: 'pin_locked' or 'pin_invalid'.
+        """
+        pin = pin.strip()
+        with self._lock:
+            if self._pin_failures[client] >= MAX_PIN_ATTEMPTS:
+                return "pin_locked"
+            if config.show_pin and secrets.compare_digest(pin, self.pin):
+                return "share"
+            if config.device_roles and secrets.compare_digest(pin, self.host_pin):
+                return "host"
+            self._pin_failures[client] += 1
+            return "pin_invalid"
+
+    def redeem_slug(self, slug: str, client: str) -> Optional[str]:
+        """
+        Validate the slug of a typed short link.
+
+        A slug is far easier to guess than the key, so wrong ones count
+        towards the same MAX_PIN_ATTEMPTS as wrong PINs.
+
+        Returns:
+            None if it is current, otherwise 'pin_locked' or 'slug_unknown'.
+        """
+        with self._lock:
+            if self._pin_failures[client] >= MAX_PIN_ATTEMPTS:
+                return "pin_locked"
+            if secrets.compare_digest(slug.strip().lower().encode(), self.slug.encode()):
+                return None
+            self._pin_failures[client] += 1
+            return "slug_unknown"
+
+    def issue_bootstrap(self) -> str:
+        """A fresh single-use token standing in for the key, valid for BOOTSTRAP_TTL seconds."""
+        token = secrets.token_urlsafe(16)
+        now = time.time()
+        with self._lock:
+            self._bootstrap = {t: expiry for t, expiry in self._bootstrap.items() if expiry > now}
+            self._bootstrap[token] = now + BOOTSTRAP_TTL
+        return token
+
+    def redeem_bootstrap(self, token: str) -> bool:
+        """Use up a one-time token; whether it was issued, unused and unexpired."""
+        with self._lock:
+            expiry = self._bootstrap.pop(token, None)
+        return expiry is not None and expiry > time.time()
+
+    def rotate(self) -> str:
+        """Replace the key, PINs and slug, cutting off everyone holding the old ones."""
+        with self._lock:
+            self._retired.add(self.key)
+            self.key = secrets.token_urlsafe(9)
+            self.pin = _new_pin()
+            self.host_pin = self._new_host_pin()
+            self.slug = new_slug()
+            self._pin_failures.clear()
+            self._bootstrap.clear()
+            self.rotated_at = time.time()
+            return self.key
+
+
+def share_url(port: int = 8000) -> str:
+    """The link handed to guests: the server URL, plus the key if one is required."""
+    url = get_server_url(port)
+    return f"{url}/?key={share_access.key}" if config.require_key else url
+
+
+def qr_url(port: int = 8000) -> str:
+    """
+    The link to put in a QR code shown by the host.
+
+    With config.one_time_qr (and a key required), a /connect/<token>
+    link that lets one device in and then stops working, so the key
+    never sits in a browser's history; otherwise share_url().
+    """
+    if config.one_time_qr and config.require_key:
+        return f"{get_server_url(port)}/connect/{share_access.issue_bootstrap()}"
+    return share_url(port)
+
+
+def short_url(port: int = 8000) -> str:
+    """The short link to type when the QR code won't scan, e.g. http://192.168.1.5:8000/go/blue-tiger."""
+    return f"{get_server_url(port)}/go/{share_access.slug}"
+
+
+# Global share key
+share_access = ShareAccess()
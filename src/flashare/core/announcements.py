@@ -0,0 +1,68 @@
+"""Host announcements of newly shared files, pushed to open pages."""
+
+import itertools
+import threading
+import time
+from dataclasses import dataclass, field
+
+
+@dataclass
+class Announcement:
+    """One "New file" prompt sent to every connected device."""
+    id: int
+    filename: str
+    file: dict
+    message: str = ""
+    created: float = field(default_factory=time.time)
+
+
+class AnnouncementLog:
+    """
+    Thread-safe in-memory history of announcements for this session.
+
+    Devices that connect shortly after an announcement catch up from
+    here; older entries are forgotten.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._ids = itertools.count(1)
+        self.entries: list[Announcement] = []
+
+    def _prune(self, window: float):
+        cutoff = time.time() - window
+        self.entries = [a for a in self.entries if a.created >= cutoff]
+
+    def add(self, filename: str, file: dict, message: str, window: float) -> Announcement:
+        """
+        Record an announcement.
+
+        Args:
+            filename: Stored file being announced.
+            file: Its FileInfo, as sent to clients.
+            message: Optional note from the host.
+            window: Seconds announcements stay in the catch-up history.
+
+        Returns:
+            The new announcement.
+        """
+        with self._lock:
+            self._prune(window)
+            announcement = Announcement(next(self._ids), filename, file, message)
+            self.entries.append(announcement)
+            return announcement
+
+    def recent(self, window: float) -> list[Announcement]:
+        """Announcements made within the last window seconds, oldest first."""
+        with self._lock:
+            self._prune(window)
+            return list(self.entries)
+
+    def invalidate_file(self, filename: str):
+        """Drop announcements of a file that has been deleted."""
+        with self._lock:
+            self.entries = [a for a in self.entries if a.filename != filename]
+
+
+# Global announcement history
+announcements = AnnouncementLog()
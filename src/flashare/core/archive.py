@@ -0,0 +1,241 @@
+"""Session bundles for Flashare: the share's files and metadata in one tar + Zstandard file."""
+
+import hashlib
+import io
+import json
+import os
+import tarfile
+import time
+from dataclasses import dataclass, field
+from pathlib import Path, PurePosixPath
+from typing import Optional
+
+import zstandard as zstd
+
+from flashare import __version__
+from flashare.config import config
+from flashare.core.compression import create_compressor
+from flashare.core.permissions import make_dirs, restrict
+
+
+# Manifest describing a bundle; written last so export can stream
+MANIFEST_NAME = "flashare-session.json"
+BUNDLE_VERSION = 1
+
+# Settings worth carrying to a re-hosted share; secrets and host paths are left out
+EXPORTED_SETTINGS = (
+    "obfuscate_names",
+    "time_format",
+    "organize_uploads",
+    "date_folders",
+    "burn_after_download",
+    "auto_extract_zip",
+    "auto_extract_remove_zip",
+    "reject_empty",
+    "max_upload_size",
+)
+
+# Folders that only matter on the exporting machine, or can be made again
+_SKIPPED_DIRS = {".failed", ".web", ".tus", ".flashare"}
+
+
+class BundleError(Exception):
+    """A bundle that can't be read or was made by a newer Flashare."""
+
+
+class _HashingReader:
+    """File wrapper hashing what tarfile reads from it."""
+
+    def __init__(self, raw):
+        self.raw = raw
+        self.digest = hashlib.sha256()
+
+    def read(self, size: int = -1) -> bytes:
+        data = self.raw.read(size)
+        self.digest.update(data)
+        return data
+
+
+def _session_manifest(files: list[dict]) -> dict:
+    return {
+        "version": BUNDLE_VERSION,
+        "flashare": __version__,
+        "created": time.time(),
+        "branding": {"title": config.brand_title, "accent_color": config.brand_accent_color},
+        "settings": {name: getattr(config, name) for name in EXPORTED_SETTINGS},
+        "files": files,
+    }
+
+
+def export_archive(source_dir: Path | str, output_path: Path | str) -> int:
+    """
+    Bundle a directory into a single .tar.zst file.
+
+    Hidden files (metadata sidecars) are included so the share can be
+    reconstituted exactly. Modification times and permissions are kept
+    by the tar headers. Each file is hashed as it is archived, and a
+    flashare-session.json manifest listing every file with its SHA-256,
+    plus branding and non-secret settings, is appended last.
+
+    Args:
+        source_dir: Directory to archive (usually the uploads directory).
+        output_path: Path of the archive to write.
+
+    Returns:
+        Number of files written to the archive.
+    """
+    source_dir = Path(source_dir)
+    output_path = Path(output_path)
+
+    compressor = create_compressor()
+    files = []
+
+    with open(output_path, 'wb') as f_out:
+        with compressor.stream_writer(f_out) as writer:
+            with tarfile.open(fileobj=writer, mode='w|') as tar:
+                for path in sorted(source_dir.rglob("*")):
+                    # Never archive the archive itself
+                    if path.resolve() == output_path.resolve():
+                        continue
+                    if not (path.is_file() or path.is_dir()) or path.is_symlink():
+                        continue
+
+                    arcname = path.relative_to(source_dir).as_posix()
+                    if _SKIPPED_DIRS & set(PurePosixPath(arcname).parts) or arcname == MANIFEST_NAME:
+                        continue
+                    if path.is_dir():
+                        tar.add(path, arcname=arcname, recursive=False)
+                        continue
+
+                    info = tar.gettarinfo(path, arcname=arcname)
+                    with open(path, 'rb') as f:
+                        reader = _HashingReader(f)
+                        tar.addfile(info, reader)
+                    files.append({"path": arcname, "size": info.size, "sha256": reader.digest.hexdigest()})
+
+                manifest = json.dumps(_session_manifest(files), indent=2).encode()
+                info = tarfile.TarInfo(MANIFEST_NAME)
+                info.size = len(manifest)
+                info.mtime = int(time.time())
+                tar.addfile(info, io.BytesIO(manifest))
+
+    return len(files)
+
+
+def _is_safe_member(member: tarfile.TarInfo) -> bool:
+    """Check that an archive member stays inside the destination directory."""
+    if not (member.isfile() or member.isdir()):
+        return False
+
+    name = PurePosixPath(member.name)
+    return not name.is_absolute() and ".." not in name.parts
+
+
+def _check_manifest(manifest) -> dict:
+    """Refuse a manifest that isn't shaped like the ones export_archive writes."""
+    if not isinstance(manifest, dict):
+        raise BundleError("Bundle manifest is not a JSON object")
+    if not isinstance(manifest.get("version", 0), int) or not isinstance(manifest.get("branding", {}), dict):
+        raise BundleError("Bundle manifest is malformed")
+    files = manifest.get("files", [])
+    if not isinstance(files, list) or not all(
+        isinstance(entry, dict) and isinstance(entry.get("path"), str) and isinstance(entry.get("sha256"), str)
+        for entry in files
+    ):
+        raise BundleError("Bundle manifest has a malformed file list")
+    return manifest
+
+
+@dataclass
+class ImportReport:
+    """What an import restored, and what didn't match the bundle's manifest."""
+    restored: int = 0
+    manifest: Optional[dict] = None  # None for archives from before manifests existed
+    corrupt: list[str] = field(default_factory=list)  # Content differs from the manifest
+    missing: list[str] = field(default_factory=list)  # Listed in the manifest, not in the bundle
+    unlisted: list[str] = field(default_factory=list)  # In the bundle, not in the manifest
+
+    @property
+    def ok(self) -> bool:
+        return not (self.corrupt or self.missing or self.unlisted)
+
+
+def _extract_file(tar: tarfile.TarFile, member: tarfile.TarInfo, dest_dir: Path) -> str:
+    """Write one file member out, returning the SHA-256 of what was written."""
+    target = dest_dir / member.name
+    make_dirs(target.parent, dest_dir)
+    digest = hashlib.sha256()
+    source = tar.extractfile(member)
+    with open(target, 'wb') as f:
+        while block := source.read(1024 * 1024):
+            digest.update(block)
+            f.write(block)
+    os.chmod(target, member.mode & 0o777)
+    os.utime(target, (member.mtime, member.mtime))
+    restrict(target)
+    return digest.hexdigest()
+
+
+def import_archive(archive_path: Path | str, dest_dir: Path | str) -> ImportReport:
+    """
+    Restore a bundle created by export_archive, verifying every file.
+
+    Members escaping the destination (absolute paths, '..', links,
+    devices) are skipped. Each file is hashed as it is written and
+    checked against the manifest once it is read; bundles from before
+    manifests existed are restored unverified.
+
+    Args:
+        archive_path: Path to the bundle to restore.
+        dest_dir: Directory to restore into.
+
+    Returns:
+        The import report; files that fail verification are still
+        restored, so check report.ok.
+
+    Raises:
+        BundleError: If the bundle is unreadable part way (reporting how
+            far it got), or its manifest is malformed or from a newer version.
+    """
+    archive_path = Path(archive_path)
+    dest_dir = Path(dest_dir)
+    dest_dir.mkdir(parents=True, exist_ok=True)
+
+    decompressor = zstd.ZstdDecompressor()
+    report = ImportReport()
+    hashes: dict[str, str] = {}
+
+    try:
+        with open(archive_path, 'rb') as f_in:
+            with decompressor.stream_reader(f_in) as reader:
+                with tarfile.open(fileobj=reader, mode='r|') as tar:
+                    for member in tar:
+                        if not _is_safe_member(member):
+                            continue
+                        if member.name == MANIFEST_NAME:
+                            report.manifest = json.load(tar.extractfile(member))
+                            continue
+
+                        if member.isdir():
+                            (dest_dir / member.name).mkdir(parents=True, exist_ok=True)
+                            restrict(dest_dir / member.name)
+                            continue
+
+                        hashes[PurePosixPath(member.name).as_posix()] = _extract_file(tar, member, dest_dir)
+                        report.restored += 1
+    except (tarfile.TarError, zstd.ZstdError, EOFError, ValueError) as e:
+        raise BundleError(f"Bundle is damaged after {report.restored} file(s): {e}")
+
+    if report.manifest is None:
+        return report
+    _check_manifest(report.manifest)
+    if report.manifest.get("version", 0) > BUNDLE_VERSION:
+        raise BundleError(
+            f"Bundle format {report.manifest['version']} is newer than this Flashare supports ({BUNDLE_VERSION})"
+        )
+
+    listed = {entry["path"]: entry["sha256"] for entry in report.manifest.get("files", [])}
+    report.corrupt = sorted(path for path, digest in hashes.items() if path in listed and listed[path] != digest)
+    report.missing = sorted(path for path in listed if path not in hashes)
+    report.unlisted = sorted(path for path in hashes if path not in listed)
+    return report
@@ -0,0 +1,336 @@
+"""All-or-nothing batches of file operations (delete, rename, move, mkdir)."""
+
+import os
+import secrets
+import shutil
+from dataclasses import dataclass, field
+from pathlib import Path, PurePosixPath
+from typing import Callable, Optional
+
+from flashare.core.correlation import log
+from flashare.core.locks import file_locks
+from flashare.core.metadata import load_meta, save_meta, delete_meta, rename_meta
+from flashare.core.paths import sanitize_filename
+from flashare.core.permissions import make_dirs
+from flashare.core.storage import LocalStorage, _fold
+
+
+BATCH_OPERATIONS = ("delete", "rename", "move", "mkdir")
+
+# Largest batch POST /api/batch accepts
+MAX_BATCH_OPERATIONS = 1000
+
+# Hidden folder (under the uploads directory, so moves stay on one filesystem) holding deletes until commit
+TRASH_PREFIX = ".flashare-batch-"
+
+
+class BatchInvalid(Exception):
+    """A step would fail; nothing has been changed."""
+
+    def __init__(self, message: str, code: str = "invalid"):
+        super().__init__(message)
+        self.code = code
+
+
+@dataclass
+class BatchStep:
+    """
+    One operation of a batch and what became of it.
+
+    status is 'pending', then 'done' (or 'exists' for a folder already
+    there), 'rolled_back' once undone, or 'invalid' / 'failed' for the
+    step that stopped the batch; steps after it stay 'not_run'.
+    """
+    op: str
+    path: str
+    to: Optional[str] = None
+    status: str = "pending"
+    error: Optional[str] = None
+    code: Optional[str] = None
+    result: Optional[str] = None  # Where a renamed or moved file ended up
+    size: int = 0
+    _undo: list[Callable[[], None]] = field(default_factory=list, repr=False)
+
+    def to_dict(self, index: int) -> dict:
+        data = {"index": index, "op": self.op, "path": self.path, "to": self.to, "status": self.status}
+        if self.result:
+            data["id"] = self.result
+        if self.error:
+            data["error"] = self.error
+            data["code"] = self.code
+        return data
+
+
+def _clean(value: str, what: str, allow_root: bool = False) -> str:
+    """A path relative to the uploads directory, refusing anything the server wouldn't create itself."""
+    parts = [part for part in (value or "").strip("/").split("/") if part]
+    if not parts:
+        if allow_root:
+            return ""
+        raise BatchInvalid(f"{what} is empty")
+    for part in parts:
+        if part.startswith(".") or sanitize_filename(part) != part:
+            raise BatchInvalid(f"{what} '{value}' has a name the server wouldn't use ('{part}')")
+    return "/".join(parts)
+
+
+class _View:
+    """The uploads tree as it will be after the steps validated so far."""
+
+    def __init__(self, storage: LocalStorage):
+        self.storage = storage
+        self._files: dict[str, bool] = {}
+        self._folders: dict[str, bool] = {}
+
+    def _key(self, name: str) -> str:
+        return name if self.storage.case_sensitive else _fold(name)
+
+    def path(self, name: str) -> Path:
+        return self.storage.path(name) if name else self.storage.root
+
+    def is_file(self, name: str) -> bool:
+        known = self._files.get(self._key(name))
+        return self.path(name).is_file() if known is None else known
+
+    def is_dir(self, name: str) -> bool:
+        if not name:
+            return True
+        known = self._folders.get(self._key(name))
+        return self.path(name).is_dir() if known is None else known
+
+    def taken(self, name: str) -> bool:
+        known = self._files.get(self._key(name))
+        if known is not None:
+            return known or bool(self._folders.get(self._key(name)))
+        return self.is_dir(name) or self.path(name).exists()
+
+    def set_file(self, name: str, present: bool):
+        self._files[self._key(name)] = present
+
+    def add_folder(self, name: str):
+        self._folders[self._key(name)] = True
+
+    def writable(self, folder: str) -> bool:
+        # A folder this batch creates will be writable if its nearest existing ancestor is
+        path = self.path(folder)
+        while not path.exists() and path != self.storage.root:
+            path = path.parent
+        return os.access(path, os.W_OK)
+
+
+def _parent(name: str) -> str:
+    parent = PurePosixPath(name).parent.as_posix()
+    return "" if parent == "." else parent
+
+
+def validate(
+    steps: list[BatchStep],
+    storage: LocalStorage,
+    may_change: Callable[[str], Optional[str]],
+):
+    """
+    Check every step against the tree as the earlier steps will leave it.
+
+    Normalizes each step's path and target in place.
+
+    Args:
+        steps: The batch, in order.
+        storage: The uploads directory.
+        may_change: Why the caller may not delete, rename or move a file
+            (e.g. it belongs to another device), or None.
+
+    Raises:
+        BatchInvalid: On the first step that would fail; its status and
+            error are set.
+    """
+    view = _View(storage)
+    for step in steps:
+        try:
+            _validate_step(step, view, may_change)
+        except PermissionError as e:
+            step.status, step.error, step.code = "invalid", str(e), "forbidden"
+            raise BatchInvalid(str(e), "forbidden")
+        except BatchInvalid as e:
+            step.status, step.error, step.code = "invalid", str(e), e.code
+            raise
+
+
+def _validate_step(step: BatchStep, view: _View, may_change: Callable[[str], Optional[str]]):
+    if step.op not in BATCH_OPERATIONS:
+        raise BatchInvalid(f"Unknown operation '{step.op}'; use one of {', '.join(BATCH_OPERATIONS)}")
+
+    if step.op == "mkdir":
+        step.path = _clean(step.path, "Folder")
+        parts = step.path.split("/")
+        for depth in range(1, len(parts) + 1):
+            folder = "/".join(parts[:depth])
+            if view.is_file(folder):
+                raise BatchInvalid(f"'{folder}' is a file", "conflict")
+        if view.is_dir(step.path):
+            return
+        if not view.writable(step.path):
+            raise BatchInvalid(f"No permission to create '{step.path}'", "forbidden")
+        for depth in range(1, len(parts) + 1):
+            view.add_folder("/".join(parts[:depth]))
+        return
+
+    step.path = _clean(step.path, "Path")
+    if not view.is_file(step.path):
+        if view.is_dir(step.path):
+            raise BatchInvalid(f"'{step.path}' is a folder; only files can be {step.op}d", "not_a_file")
+        raise BatchInvalid(f"'{step.path}' not found", "not_found")
+    problem = may_change(step.path)
+    if problem:
+        raise BatchInvalid(problem, "not_owner")
+    if not view.writable(_parent(step.path)):
+        raise BatchInvalid(f"No permission to change '{step.path}'", "forbidden")
+
+    if step.op == "delete":
+        view.set_file(step.path, False)
+        return
+
+    if step.op == "rename":
+        name = _clean(step.to, "New name")
+        if "/" in name:
+            raise BatchInvalid("A rename keeps the file in its folder; use 'move' to change folders")
+        target = f"{_parent(step.path)}/{name}".lstrip("/")
+    else:
+        folder = _clean(step.to, "Destination folder", allow_root=True)
+        if not view.is_dir(folder):
+            raise BatchInvalid(f"Folder '{folder}' does not exist; create it with 'mkdir' first", "not_found")
+        if not view.writable(folder):
+            raise BatchInvalid(f"No permission to write to '{folder or '/'}'", "forbidden")
+        target = f"{folder}/{PurePosixPath(step.path).name}".lstrip("/")
+        step.to = folder
+    if view.taken(target):
+        raise BatchInvalid(f"'{target}' already exists", "conflict")
+    view.set_file(step.path, False)
+    view.set_file(target, True)
+    step.result = target
+
+
+def _claim(source: Path, target: Path):
+    """Move a file to a name nobody holds, never replacing one that appeared meanwhile."""
+    try:
+        os.link(source, target)
+    except FileExistsError:
+        raise
+    except OSError:  # No hard links on this filesystem
+        if target.exists():
+            raise FileExistsError(str(target))
+        source.rename(target)
+        return
+    source.unlink()
+
+
+def _run_step(step: BatchStep, storage: LocalStorage, trash: Path, index: int):
+    if step.op == "mkdir":
+        path = storage.path(step.path)
+        if path.is_dir():
+            step.status = "exists"
+            return
+        created = [p for p in (path, *path.parents) if not p.exists() and storage.root in p.parents]
+        make_dirs(path, storage.root)
+
+        def remove_created():
+            for folder in created:  # Innermost first
+                folder.rmdir()
+        step._undo.append(remove_created)
+        return
+
+    source = storage.path(step.path)
+    step.size = source.stat().st_size
+    if step.op == "delete":
+        trash.mkdir(exist_ok=True)
+        staged = trash / str(index)
+        meta = load_meta(step.path)
+        source.replace(staged)
+        delete_meta(step.path)
+
+        def restore():
+            _claim(staged, source)
+            if meta:
+                save_meta(step.path, meta)
+        step._undo.append(restore)
+        return
+
+    target = storage.path(step.result)
+    _claim(source, target)
+    rename_meta(step.path, step.result)
+
+    def undo_move():
+        _claim(target, source)
+        rename_meta(step.result, step.path)
+    step._undo.append(undo_move)
+
+
+def _touched(step: BatchStep) -> set[str]:
+    """Stored names a step reads or claims, as other requests lock them."""
+    path = (step.path or "").strip("/")
+    names = {path}
+    if step.op == "rename" and step.to:
+        names.add(f"{_parent(path)}/{step.to.strip('/')}".strip("/"))
+    elif step.op == "move" and step.to is not None:
+        names.add(f"{step.to.strip('/')}/{PurePosixPath(path).name}".strip("/"))
+    return names
+
+
+def run_batch(
+    steps: list[BatchStep],
+    storage: LocalStorage,
+    may_change: Callable[[str], Optional[str]],
+) -> bool:
+    """
+    Validate a batch, then carry it out, undoing every completed step if one fails.
+
+    The files named are locked for the whole batch, so other requests
+    changing them wait. Deleted files are held in a hidden folder until
+    every step has succeeded, so a delete can be undone too. Each step's
+    status records the outcome.
+
+    Returns:
+        Whether the batch was committed.
+
+    Raises:
+        BatchInvalid: If validation failed; nothing was changed.
+    """
+    names = set().union(*(_touched(step) for step in steps))
+    try:
+        with file_locks.hold(name for name in names if name):
+            validate(steps, storage, may_change)
+            committed = _execute(steps, storage)
+    finally:
+        for step in steps:
+            if step.status == "pending":
+                step.status = "not_run"
+    return committed
+
+
+def _execute(steps: list[BatchStep], storage: LocalStorage) -> bool:
+    trash = storage.root / f"{TRASH_PREFIX}{secrets.token_hex(6)}"
+    for index, step in enumerate(steps):
+        try:
+            _run_step(step, storage, trash, index)
+        except OSError as e:
+            step.status, step.error, step.code = "failed", e.strerror or str(e), "failed"
+            _roll_back(steps[:index])
+            if any(step.status == "rollback_failed" for step in steps):
+                log(f"⚠️  Files a failed batch could not put back are kept in {trash}", "warning")
+            else:
+                shutil.rmtree(trash, ignore_errors=True)
+            return False
+        if step.status == "pending":
+            step.status = "done"
+    shutil.rmtree(trash, ignore_errors=True)
+    return True
+
+
+def _roll_back(done: list[BatchStep]):
+    for step in reversed(done):
+        try:
+            for undo in reversed(step._undo):
+                undo()
+            step.status = "rolled_back"
+        except OSError as e:
+            step.status, step.error, step.code = "rollback_failed", str(e), "rollback_failed"
+            log(f"❌ Could not undo batch step {step.op} {step.path}: {e}", "error")
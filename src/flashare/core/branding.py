@@ -0,0 +1,88 @@
+"""Custom title, accent color and logo for the web UI."""
+
+import re
+from functools import lru_cache
+from pathlib import Path
+from typing import Optional
+
+from flashare import __app_name__
+from flashare.config import config
+
+
+# Logos are served to every visitor, so keep them small
+LOGO_MAX_SIZE = 512 * 1024
+
+# Magic bytes of the image formats accepted as a logo
+_IMAGE_SIGNATURES = (
+    (b"\x89PNG\r\n\x1a\n", "image/png"),
+    (b"\xff\xd8\xff", "image/jpeg"),
+    (b"GIF87a", "image/gif"),
+    (b"GIF89a", "image/gif"),
+)
+
+_COLOR_PATTERN = re.compile(r"#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})")
+
+
+def sniff_image_type(data: bytes) -> Optional[str]:
+    """
+    Identify an image from its content rather than its extension.
+
+    Args:
+        data: File contents.
+
+    Returns:
+        The image MIME type, or None if it isn't a supported image.
+    """
+    for signature, media_type in _IMAGE_SIGNATURES:
+        if data.startswith(signature):
+            return media_type
+    if data[:4] == b"RIFF" and data[8:12] == b"WEBP":
+        return "image/webp"
+    return None
+
+
+def validate_accent_color(value: str) -> str:
+    """Check an accent color is a plain hex color (it ends up in CSS)."""
+    if not _COLOR_PATTERN.fullmatch(value):
+        raise ValueError(f"Invalid accent color {value!r}, expected e.g. #ff6600")
+    return value
+
+
+@lru_cache(maxsize=1)
+def load_logo(path: Path) -> tuple[bytes, str]:
+    """
+    Read and validate the configured logo.
+
+    Args:
+        path: Logo file.
+
+    Returns:
+        (image bytes, MIME type).
+
+    Raises:
+        ValueError: If the file is too large or not an image.
+    """
+    if path.stat().st_size > LOGO_MAX_SIZE:
+        raise ValueError(f"Logo {path} is larger than {LOGO_MAX_SIZE // 1024} KB")
+
+    data = path.read_bytes()
+    media_type = sniff_image_type(data)
+    if media_type is None:
+        raise ValueError(f"Logo {path} is not a PNG, JPEG, GIF or WebP image")
+    return data, media_type
+
+
+def get_branding() -> dict:
+    """
+    Branding for the web UI, falling back to the defaults.
+
+    Returns:
+        Title, accent color (None for the theme default) and logo URL.
+    """
+    # Colors from the environment bypass CLI validation, so re-check here
+    accent = config.brand_accent_color
+    return {
+        "title": config.brand_title or __app_name__,
+        "accent_color": accent if _COLOR_PATTERN.fullmatch(accent) else None,
+        "logo_url": "/api/branding/logo" if config.brand_logo_path else None,
+    }
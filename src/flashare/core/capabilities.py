@@ -0,0 +1,70 @@
+"""Capability document describing what this server supports."""
+
+from flashare import __version__
+from flashare.config import config
+from flashare.core.branding import get_branding
+from flashare.core.devices import devices, has_role
+
+
+# Features compiled into this build; the web UI shows a control only if
+# its feature is on, so new flags never need frontend changes elsewhere
+FEATURES = {
+    "upload": True,
+    "delete": True,
+    "folders": True,
+    "thumbnails": False,
+    "resumable_uploads": True,
+    "range_downloads": True,
+    "chunk_manifest": True,
+    "checksums": True,
+    "conditional_upload": True,
+    "merge": True,
+    "burn_after_download": True,
+    "claim_codes": True,
+    "live_updates": True,
+    "announcements": True,
+    "zip_download": True,
+    "archive_jobs": True,
+    "download_preview": True,
+    "speedtest": True,
+    "batch": True,
+    "transcode": False,
+    "clipboard": False,
+    "trash": False,
+    "approval": False,
+}
+
+
+def get_capabilities(device_id: str, role: str = "trusted") -> dict:
+    """
+    Assemble the capability document for a client.
+
+    Args:
+        device_id: Requesting device, for its remaining quota.
+        role: The device's role, so controls it can't use are switched off.
+
+    Returns:
+        Mode, the device's role, auth requirement, feature flags, limits
+        and branding.
+    """
+    features = dict(FEATURES)
+    features["transcode"] = config.transcode_videos
+    features["folders"] = config.storage_backend == "local"
+    features["resumable_uploads"] = config.storage_backend == "local"
+    features["batch"] = config.storage_backend == "local"
+    if config.read_only:
+        features.update(upload=False, delete=False, resumable_uploads=False, batch=False)
+    if not has_role(role, "trusted"):
+        features.update(delete=False, batch=False)
+    return {
+        "version": __version__,
+        "mode": "read-only" if config.read_only else "read-write",
+        "role": role,
+        "auth_required": config.require_key,
+        "features": features,
+        "limits": {
+            "max_upload_size": config.max_upload_size or None,
+            "quota_remaining": devices.remaining(device_id),
+        },
+        "branding": get_branding(),
+    }
@@ -0,0 +1,149 @@
+"""Whole-file checksums and chunked SHA-256 manifests for verifying files."""
+
+import hashlib
+import zlib
+from contextlib import closing
+from typing import Callable, Optional
+
+from flashare.core.metadata import load_meta, update_meta
+from flashare.core.progress import ProgressCounter
+from flashare.core.storage import Storage, StorageEntry
+
+
+DEFAULT_MANIFEST_CHUNK = 64 * 1024 * 1024
+
+# Whole-file algorithms offered, for interop with tools expecting MD5 or CRC32
+ALGORITHMS = ("sha256", "md5", "crc32")
+
+# Seconds between progress reports while hashing
+PROGRESS_INTERVAL = 0.5
+
+# Hashing reads in smaller blocks so memory stays flat for huge chunks
+_READ_SIZE = 1024 * 1024
+
+
+class _Crc32:
+    """zlib.crc32 behind the hashlib interface."""
+
+    def __init__(self):
+        self.value = 0
+
+    def update(self, data: bytes):
+        self.value = zlib.crc32(data, self.value)
+
+    def hexdigest(self) -> str:
+        return f"{self.value:08x}"
+
+
+def _new_hash(algorithm: str):
+    if algorithm == "crc32":
+        return _Crc32()
+    # MD5 is for matching other tools' checksums, not for security
+    return hashlib.new(algorithm, usedforsecurity=algorithm != "md5")
+
+
+def _cached_checksum(meta: dict, algorithm: str) -> Optional[dict]:
+    # SHA-256 shares the record uploads write, so If-None-Match lookups see it
+    if algorithm == "sha256":
+        return meta.get("sha256")
+    return meta.get("checksums", {}).get(algorithm)
+
+
+def file_checksum(
+    storage: Storage,
+    entry: StorageEntry,
+    algorithm: str,
+    on_progress: Optional[Callable[[int, int], None]] = None,
+) -> tuple[str, bool]:
+    """
+    Hash a whole file, reusing the value cached in its metadata sidecar.
+
+    The cache holds while the file's mtime and size are unchanged.
+
+    Args:
+        storage: Backend holding the file.
+        entry: Current stat of the file.
+        algorithm: One of ALGORITHMS.
+        on_progress: Called with (delta, bytes hashed so far) while hashing.
+
+    Returns:
+        (hex digest, whether it came from the cache).
+    """
+    meta = load_meta(entry.name)
+    hit = _cached_checksum(meta, algorithm)
+    if hit and hit.get("mtime") == entry.modified and hit.get("size") == entry.size:
+        return hit["hex"], True
+
+    digest = _new_hash(algorithm)
+    counter = ProgressCounter(on_progress or (lambda delta, total: None), PROGRESS_INTERVAL)
+    with closing(storage.open(entry.name)) as f:
+        while block := f.read(_READ_SIZE):
+            digest.update(block)
+            counter.update(len(block))
+    counter.close()
+
+    record = {"hex": digest.hexdigest(), "mtime": entry.modified, "size": entry.size}
+    if algorithm == "sha256":
+        update_meta(entry.name, sha256=record)
+    else:
+        update_meta(entry.name, checksums={**load_meta(entry.name).get("checksums", {}), algorithm: record})
+    return record["hex"], False
+
+
+def _hash_chunks(storage: Storage, name: str, chunk_size: int) -> list[str]:
+    """Hash a stored file chunk by chunk in a single streaming pass."""
+    hashes = []
+    with closing(storage.open(name)) as f:
+        while True:
+            digest = hashlib.sha256()
+            remaining = chunk_size
+            while remaining:
+                block = f.read(min(_READ_SIZE, remaining))
+                if not block:
+                    break
+                digest.update(block)
+                remaining -= len(block)
+            if remaining == chunk_size:
+                break
+            hashes.append(digest.hexdigest())
+            if remaining:
+                break
+    return hashes
+
+
+def chunk_manifest(storage: Storage, entry: StorageEntry, chunk_size: int = DEFAULT_MANIFEST_CHUNK) -> dict:
+    """
+    Build (or reuse) the per-chunk checksum manifest for a file.
+
+    Manifests are cached in the file's metadata sidecar and reused while
+    the file's mtime and size are unchanged.
+
+    Args:
+        storage: Backend holding the file.
+        entry: Current stat of the file.
+        chunk_size: Bytes per chunk.
+
+    Returns:
+        Manifest with the chunk size, total size and one SHA-256 per chunk.
+    """
+    cache_key = str(chunk_size)
+    cached = load_meta(entry.name).get("chunks", {})
+    hit = cached.get(cache_key)
+
+    if hit and hit.get("mtime") == entry.modified and hit.get("size") == entry.size:
+        hashes = hit["sha256"]
+    else:
+        hashes = _hash_chunks(storage, entry.name, chunk_size)
+        # Drop manifests for older versions of the file
+        fresh = {k: v for k, v in cached.items() if v.get("mtime") == entry.modified and v.get("size") == entry.size}
+        fresh[cache_key] = {"mtime": entry.modified, "size": entry.size, "sha256": hashes}
+        update_meta(entry.name, chunks=fresh)
+
+    return {
+        "filename": entry.name,
+        "algorithm": "sha256",
+        "chunk_size": chunk_size,
+        "total": entry.size,
+        "count": len(hashes),
+        "chunks": hashes,
+    }
@@ -0,0 +1,127 @@
+"""Short single-use claim codes for handing a file to one person."""
+
+import secrets
+import threading
+import time
+from collections import defaultdict
+from dataclasses import dataclass, field
+from typing import Optional
+
+
+# No 0/O, 1/I/L so codes survive being read out loud
+CODE_ALPHABET = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+# Codes work without the share key, so they must not be guessable:
+# 31^6 is about 890 million codes
+CODE_LENGTH = 6
+
+# Wrong codes a client may try before it is refused for the rest of the session
+MAX_CLAIM_ATTEMPTS = 10
+
+
+@dataclass
+class Claim:
+    """A code that resolves to one file, once."""
+    code: str
+    filename: str
+    expires: float
+    created: float = field(default_factory=time.time)
+    claimed: bool = False
+
+    @property
+    def expired(self) -> bool:
+        return time.time() >= self.expires
+
+
+class ClaimRegistry:
+    """Thread-safe in-memory claim codes; they end with the session."""
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self.claims: dict[str, Claim] = {}
+        self._failures: defaultdict[str, int] = defaultdict(int)
+
+    def _prune(self):
+        """Forget expired codes so they can be reissued."""
+        for code in [c for c, claim in self.claims.items() if claim.expired]:
+            del self.claims[code]
+
+    def create(self, filename: str, ttl: float) -> Claim:
+        """
+        Issue a new code for a file.
+
+        Args:
+            filename: Stored file the code resolves to.
+            ttl: Seconds until the code expires.
+
+        Returns:
+            The new claim.
+        """
+        with self._lock:
+            self._prune()
+            if len(self.claims) >= len(CODE_ALPHABET) ** CODE_LENGTH // 2:
+                raise RuntimeError("Too many active claim codes")
+
+            # Retry on collision with a live code
+            while True:
+                code = "".join(secrets.choice(CODE_ALPHABET) for _ in range(CODE_LENGTH))
+                if code not in self.claims:
+                    break
+
+            claim = self.claims[code] = Claim(code, filename, time.time() + ttl)
+            return claim
+
+    def redeem(self, code: str, client: str) -> Optional[Claim]:
+        """
+        Use a code, marking it claimed.
+
+        Each client gets MAX_CLAIM_ATTEMPTS wrong codes; after that every
+        code is refused for it (see locked).
+
+        Args:
+            code: Code as typed by the recipient (case-insensitive).
+            client: Who is trying, e.g. the client's IP address.
+
+        Returns:
+            The claim, or None if unknown, expired, already used or the
+            client is locked out.
+        """
+        with self._lock:
+            if self._failures[client] >= MAX_CLAIM_ATTEMPTS:
+                return None
+            claim = self.claims.get(code.strip().upper())
+            if claim is None or claim.expired or claim.claimed:
+                self._failures[client] += 1
+                return None
+            claim.claimed = True
+            return claim
+
+    def release(self, claim: Claim):
+        """Make a redeemed code usable again, e.g. when its download failed before anything was sent."""
+        with self._lock:
+            claim.claimed = False
+
+    def locked(self, client: str) -> bool:
+        """Whether a client used up its wrong codes."""
+        with self._lock:
+            return self._failures.get(client, 0) >= MAX_CLAIM_ATTEMPTS
+
+    def invalidate_file(self, filename: str):
+        """Drop every code for a file, e.g. when it is deleted."""
+        with self._lock:
+            for code in [c for c, claim in self.claims.items() if claim.filename == filename]:
+                del self.claims[code]
+
+    def invalidate_all(self):
+        """Drop every code, e.g. when the share key is rotated."""
+        with self._lock:
+            self.claims.clear()
+
+    def for_file(self, filename: str) -> list[Claim]:
+        """Live and used codes for a file."""
+        with self._lock:
+            self._prune()
+            return [claim for claim in self.claims.values() if claim.filename == filename]
+
+
+# Global claim registry
+claims = ClaimRegistry()
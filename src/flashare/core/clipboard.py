@@ -0,0 +1,233 @@
+"""Reading the host clipboard: images, copied files or text."""
+
+import base64
+import os
+import platform
+import shutil
+import subprocess
+import time
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Optional
+from urllib.parse import unquote, urlparse
+
+
+# Give up on a clipboard tool that hangs (e.g. no display to talk to)
+TOOL_TIMEOUT = 5
+
+
+class ClipboardUnavailable(Exception):
+    """No clipboard could be read on this machine."""
+
+
+@dataclass
+class ClipboardContent:
+    """What was on the clipboard; exactly one of the fields is set."""
+    image: Optional[bytes] = None  # PNG data
+    files: list[Path] = field(default_factory=list)
+    text: Optional[str] = None
+
+    @property
+    def kind(self) -> str:
+        if self.image is not None:
+            return "image"
+        return "files" if self.files else "text"
+
+
+class ClipboardProvider:
+    """
+    One platform's clipboard back-end.
+
+    Each reader returns None when the clipboard doesn't hold that kind of
+    data, and raises ClipboardUnavailable when the clipboard can't be read
+    at all.
+    """
+
+    name = "clipboard"
+
+    def read_image(self) -> Optional[bytes]:
+        """PNG bytes of a copied image."""
+        return None
+
+    def read_files(self) -> Optional[list[Path]]:
+        """Paths of copied files (from a file manager)."""
+        return None
+
+    def read_text(self) -> Optional[str]:
+        """Copied plain text."""
+        return None
+
+
+def _run(args: list[str]) -> Optional[bytes]:
+    """Run a clipboard tool, returning its output or None if it has nothing."""
+    try:
+        result = subprocess.run(args, capture_output=True, timeout=TOOL_TIMEOUT)
+    except FileNotFoundError:
+        raise ClipboardUnavailable(f"{args[0]} is not installed")
+    except subprocess.TimeoutExpired:
+        raise ClipboardUnavailable(f"{args[0]} did not respond")
+    return result.stdout if result.returncode == 0 and result.stdout else None
+
+
+def _file_uris(data: Optional[bytes]) -> Optional[list[Path]]:
+    """Local paths from a text/uri-list (comments and remote URIs skipped)."""
+    if not data:
+        return None
+    paths = []
+    for line in data.decode(errors="replace").splitlines():
+        uri = urlparse(line.strip())
+        if uri.scheme == "file":
+            paths.append(Path(unquote(uri.path)))
+    return paths or None
+
+
+class _TargetsProvider(ClipboardProvider):
+    """Back-ends that list the clipboard's MIME types and read one of them."""
+
+    def _targets(self) -> list[str]:
+        raise NotImplementedError
+
+    def _read(self, target: Optional[str]) -> Optional[bytes]:
+        """Clipboard data as target, or as text when target is None."""
+        raise NotImplementedError
+
+    def read_image(self) -> Optional[bytes]:
+        return self._read("image/png") if "image/png" in self._targets() else None
+
+    def read_files(self) -> Optional[list[Path]]:
+        return _file_uris(self._read("text/uri-list")) if "text/uri-list" in self._targets() else None
+
+    def read_text(self) -> Optional[str]:
+        data = self._read(None)
+        return data.decode(errors="replace") if data else None
+
+
+class WaylandProvider(_TargetsProvider):
+    """wl-paste from wl-clipboard."""
+
+    name = "wl-paste"
+
+    def _targets(self) -> list[str]:
+        return (_run(["wl-paste", "--list-types"]) or b"").decode().split()
+
+    def _read(self, target: Optional[str]) -> Optional[bytes]:
+        return _run(["wl-paste", "--no-newline"] + (["--type", target] if target else []))
+
+
+class XclipProvider(_TargetsProvider):
+    """xclip on X11."""
+
+    name = "xclip"
+
+    def _targets(self) -> list[str]:
+        return (_run(["xclip", "-selection", "clipboard", "-t", "TARGETS", "-o"]) or b"").decode().split()
+
+    def _read(self, target: Optional[str]) -> Optional[bytes]:
+        return _run(["xclip", "-selection", "clipboard"] + (["-t", target] if target else []) + ["-o"])
+
+
+class MacProvider(ClipboardProvider):
+    """pbpaste for text; AppleScript for images and Finder files."""
+
+    name = "pbpaste"
+
+    def read_image(self) -> Optional[bytes]:
+        # Prints «data PNGf89504E47...» when the clipboard holds an image
+        data = _run(["osascript", "-e", "the clipboard as «class PNGf»"])
+        if not data:
+            return None
+        hex_data = data.decode(errors="replace").strip().removeprefix("«data PNGf").removesuffix("»")
+        try:
+            return bytes.fromhex(hex_data)
+        except ValueError:
+            return None
+
+    def read_files(self) -> Optional[list[Path]]:
+        data = _run(["osascript", "-e", "POSIX path of (the clipboard as «class furl»)"])
+        return [Path(data.decode().strip())] if data else None
+
+    def read_text(self) -> Optional[str]:
+        data = _run(["pbpaste"])
+        return data.decode(errors="replace") if data else None
+
+
+class PowerShellProvider(ClipboardProvider):
+    """Get-Clipboard through PowerShell on Windows."""
+
+    name = "powershell"
+
+    def _powershell(self, script: str) -> Optional[bytes]:
+        return _run(["powershell", "-NoProfile", "-NonInteractive", "-Command", script])
+
+    def read_image(self) -> Optional[bytes]:
+        data = self._powershell(
+            "$i = Get-Clipboard -Format Image; if ($i) { $m = New-Object IO.MemoryStream; "
+            "$i.Save($m, [Drawing.Imaging.ImageFormat]::Png); [Convert]::ToBase64String($m.ToArray()) }"
+        )
+        return base64.b64decode(data) if data else None
+
+    def read_files(self) -> Optional[list[Path]]:
+        data = self._powershell("Get-Clipboard -Format FileDropList | ForEach-Object { $_.FullName }")
+        return [Path(line) for line in data.decode(errors="replace").splitlines() if line.strip()] if data else None
+
+    def read_text(self) -> Optional[str]:
+        data = self._powershell("Get-Clipboard -Raw")
+        return data.decode(errors="replace") if data else None
+
+
+def get_provider() -> ClipboardProvider:
+    """
+    Pick the clipboard back-end for this platform.
+
+    Raises:
+        ClipboardUnavailable: If no supported clipboard tool is installed.
+    """
+    system = platform.system()
+    if system == "Darwin":
+        return MacProvider()
+    if system == "Windows":
+        return PowerShellProvider()
+    if os.environ.get("WAYLAND_DISPLAY") and shutil.which("wl-paste"):
+        return WaylandProvider()
+    if os.environ.get("DISPLAY") and shutil.which("xclip"):
+        return XclipProvider()
+    raise ClipboardUnavailable("No clipboard tool found (install wl-clipboard or xclip)")
+
+
+def read_clipboard(provider: Optional[ClipboardProvider] = None) -> ClipboardContent:
+    """
+    Read the clipboard, preferring an image, then copied files, then text.
+
+    A screenshot is usually also offered as text by some apps, so the
+    richer formats are checked first. Copied paths that no longer exist
+    are dropped.
+
+    Args:
+        provider: Back-end to use (default: get_provider()).
+
+    Returns:
+        The clipboard's content.
+
+    Raises:
+        ClipboardUnavailable: If the clipboard can't be read or is empty.
+    """
+    provider = provider or get_provider()
+
+    image = provider.read_image()
+    if image:
+        return ClipboardContent(image=image)
+
+    files = [path for path in provider.read_files() or [] if path.exists()]
+    if files:
+        return ClipboardContent(files=files)
+
+    text = provider.read_text()
+    if text and text.strip():
+        return ClipboardContent(text=text)
+
+    raise ClipboardUnavailable("The clipboard is empty")
+
+
+def clipboard_filename(suffix: str) -> str:
+    """Name for a file made from the clipboard, e.g. clipboard_20240101-120000.png."""
+    return f"clipboard_{time.strftime('%Y%m%d-%H%M%S')}{suffix}"
@@ -0,0 +1,157 @@
+"""Collection links: upload-only URLs that gather files into one folder until they expire."""
+
+import json
+import os
+import secrets
+import threading
+import time
+from dataclasses import dataclass, field, asdict
+from typing import Optional
+
+from flashare.config import config
+from flashare.core.paths import sanitize_filename
+
+
+# Links outlive the session (they are handed out days ahead), so they are kept here
+COLLECTIONS_FILE = "collections.json"
+
+
+@dataclass
+class Collection:
+    """
+    One upload link.
+
+    The token is the credential and only appears in the link; the id
+    names the collection everywhere else (listings, file metadata).
+    """
+    id: str
+    token: str
+    name: str
+    folder: str  # Under the uploads directory
+    expires: Optional[float] = None  # None = until revoked
+    quota: int = 0  # Bytes, 0 = unlimited
+    used: int = 0
+    files: int = 0
+    created: float = field(default_factory=time.time)
+    revoked: bool = False
+
+    @property
+    def expired(self) -> bool:
+        return self.expires is not None and time.time() >= self.expires
+
+    @property
+    def remaining(self) -> Optional[int]:
+        return max(self.quota - self.used, 0) if self.quota else None
+
+
+class CollectionRegistry:
+    """Thread-safe collection links, saved to config.data_dir on every change."""
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self.collections: dict[str, Collection] = {}  # By token
+
+    def create(self, name: str, ttl: Optional[float] = None, quota: int = 0) -> Collection:
+        """
+        Issue a new link.
+
+        Args:
+            name: Shown to uploaders; also names the folder files land in.
+            ttl: Seconds until the link stops working (None = until revoked).
+            quota: Bytes the link may take in total (0 = unlimited).
+        """
+        collection = Collection(
+            id=secrets.token_hex(4),
+            token=secrets.token_urlsafe(16),
+            name=name,
+            folder=sanitize_filename(name),
+            expires=time.time() + ttl if ttl else None,
+            quota=quota,
+        )
+        with self._lock:
+            self.collections[collection.token] = collection
+        self.save()
+        return collection
+
+    def resolve(self, token: str) -> tuple[Optional[Collection], str]:
+        """
+        Look up a link from its token.
+
+        Returns:
+            (collection, '') for a usable link, otherwise the collection
+            (if known) and why it can't be used: 'unknown', 'revoked' or
+            'expired'.
+        """
+        with self._lock:
+            collection = self.collections.get(token)
+        if collection is None:
+            return None, "unknown"
+        if collection.revoked:
+            return collection, "revoked"
+        if collection.expired:
+            return collection, "expired"
+        return collection, ""
+
+    def reserve(self, collection: Collection, count: int) -> bool:
+        """Account count uploaded bytes, refusing them if they'd exceed the quota."""
+        with self._lock:
+            if collection.quota and collection.used + count > collection.quota:
+                return False
+            collection.used += count
+            return True
+
+    def release(self, collection: Collection, count: int):
+        """Give back bytes of an upload that failed."""
+        with self._lock:
+            collection.used = max(collection.used - count, 0)
+
+    def record(self, collection: Collection):
+        """Count a finished upload."""
+        with self._lock:
+            collection.files += 1
+        self.save()
+
+    def revoke(self, collection_id: str) -> Optional[Collection]:
+        """Stop a link from working; files already uploaded stay."""
+        with self._lock:
+            collection = next((c for c in self.collections.values() if c.id == collection_id), None)
+            if collection is None:
+                return None
+            collection.revoked = True
+        self.save()
+        return collection
+
+    def list(self) -> list[Collection]:
+        """Every link, newest first."""
+        with self._lock:
+            return sorted(self.collections.values(), key=lambda c: c.created, reverse=True)
+
+    def save(self):
+        """Write every link to the data directory."""
+        with self._lock:
+            saved = [asdict(c) for c in self.collections.values()]
+        path = config.data_dir / COLLECTIONS_FILE
+        path.parent.mkdir(parents=True, exist_ok=True)
+        temp = path.with_suffix(".tmp")
+        temp.write_text(json.dumps(saved, indent=2))
+        os.replace(temp, path)
+
+    def load(self) -> int:
+        """
+        Restore links saved by an earlier session.
+
+        Returns:
+            Number of links still usable.
+        """
+        try:
+            saved = json.loads((config.data_dir / COLLECTIONS_FILE).read_text())
+            loaded = [Collection(**c) for c in saved]
+        except (OSError, ValueError, TypeError):
+            return 0
+        with self._lock:
+            self.collections = {c.token: c for c in loaded}
+        return sum(1 for c in loaded if not (c.revoked or c.expired))
+
+
+# Global collection links
+collection_links = CollectionRegistry()
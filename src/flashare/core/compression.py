@@ -1,11 +1,23 @@
 """Zstandard compression utilities for Flashare."""
 
+import threading
+import zlib
+from contextlib import closing
 from pathlib import Path
-from typing import Generator, BinaryIO
+from typing import Generator, BinaryIO, Optional
 import zstandard as zstd
 
 from flashare.config import config
 
+try:
+    import brotli
+except ImportError:  # Optional: br is simply not offered without it
+    brotli = None
+
+
+# Server preference when the client accepts several encodings
+ENCODING_PREFERENCE = ("zstd", "br", "gzip")
+
 
 def create_compressor(level: int | None = None) -> zstd.ZstdCompressor:
     """
@@ -21,7 +33,7 @@ def create_compressor(level: int | None = None) -> zstd.ZstdCompressor:
 
 
 def generate_compressed_stream(
-    file_path: Path | str,
+    file_path: Path | str | BinaryIO,
     chunk_size: int | None = None
 ) -> Generator[bytes, None, None]:
     """
@@ -31,7 +43,8 @@ def generate_compressed_stream(
     chunks that can be sent directly to HTTP clients.
     
     Args:
-        file_path: Path to the file to compress.
+        file_path: Path to the file to compress, or an open binary file
+            (closed once the stream is exhausted).
         chunk_size: Size of chunks to read. Defaults to config value.
         
     Yields:
@@ -40,7 +53,9 @@ def generate_compressed_stream(
     chunk_size = chunk_size or config.chunk_size
     compressor = create_compressor()
     
-    with open(file_path, 'rb') as f_in:
+    source = file_path if hasattr(file_path, "read") else open(file_path, 'rb')
+    
+    with closing(source) as f_in:
         for chunk in compressor.read_to_iter(f_in, size=chunk_size):
             yield chunk
 
@@ -87,3 +102,162 @@ def decompress_stream(
     
     for chunk in decompressor.read_to_iter(input_stream, size=chunk_size):
         yield chunk
+
+
+def available_encodings() -> list[str]:
+    """
+    List the content encodings this server can produce.
+    
+    Returns:
+        Encodings in preference order (zstd > br > gzip).
+    """
+    return [e for e in ENCODING_PREFERENCE if e != "br" or brotli is not None]
+
+
+def negotiate_encoding(accept_encoding: str | None) -> Optional[str]:
+    """
+    Pick the best encoding the client offers in its Accept-Encoding header.
+    
+    Server preference decides between acceptable encodings; q=0 excludes
+    an encoding and '*' stands for anything not listed explicitly.
+    
+    Args:
+        accept_encoding: Raw Accept-Encoding header value.
+        
+    Returns:
+        The chosen encoding, or None for identity.
+    """
+    offered = {}
+    for part in (accept_encoding or "").split(","):
+        name, _, params = part.strip().partition(";")
+        if not name:
+            continue
+        q = 1.0
+        for param in params.split(";"):
+            key, _, value = param.strip().partition("=")
+            if key == "q":
+                try:
+                    q = float(value)
+                except ValueError:
+                    q = 0.0
+        offered[name.strip().lower()] = q
+    
+    wildcard = offered.get("*", 0.0)
+    for encoding in available_encodings():
+        if offered.get(encoding, wildcard) > 0:
+            return encoding
+    return None
+
+
+def _brotli_quality() -> int:
+    """Map the configured zstd level (1-22) onto brotli's 0-11 quality scale."""
+    return max(0, min(11, round(config.zstd_level / 2) + 3))
+
+
+def generate_encoded_stream(
+    file_path: Path | str | BinaryIO,
+    encoding: str,
+    chunk_size: int | None = None,
+) -> Generator[bytes, None, None]:
+    """
+    Generate chunks of a file compressed with the given content encoding.
+    
+    Args:
+        file_path: Path or open binary file (closed when exhausted).
+        encoding: One of available_encodings().
+        chunk_size: Size of chunks to read. Defaults to config value.
+        
+    Yields:
+        Encoded byte chunks.
+    """
+    if encoding == "zstd":
+        yield from generate_compressed_stream(file_path, chunk_size)
+        return
+    
+    chunk_size = chunk_size or config.chunk_size
+    if encoding == "br":
+        compressor = brotli.Compressor(quality=_brotli_quality())
+        compress, flush = compressor.process, compressor.finish
+    else:
+        compressor = zlib.compressobj(6, zlib.DEFLATED, 31)  # wbits=31 -> gzip container
+        compress, flush = compressor.compress, compressor.flush
+    
+    source = file_path if hasattr(file_path, "read") else open(file_path, 'rb')
+    
+    with closing(source) as f_in:
+        while chunk := f_in.read(chunk_size):
+            if data := compress(chunk):
+                yield data
+    yield flush()
+
+
+def compress_bytes(data: bytes, encoding: str) -> bytes:
+    """
+    Compress an in-memory payload (JSON responses, static assets).
+    
+    Args:
+        data: Bytes to compress.
+        encoding: One of available_encodings().
+        
+    Returns:
+        Encoded bytes.
+    """
+    if encoding == "zstd":
+        return create_compressor().compress(data)
+    if encoding == "br":
+        return brotli.compress(data, quality=_brotli_quality())
+    return zlib.compress(data, 6, 31)
+
+
+class BufferPool:
+    """
+    RAM shared by downloads compressed in memory before they are sent.
+
+    Each buffered download reserves its file's size (what compressing
+    may take at worst) for as long as the buffer lives; when the pool is
+    full, further downloads are streamed instead of waiting.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._used = 0
+
+    def reserve(self, count: int) -> bool:
+        """Claim memory from the pool, failing if it would overflow."""
+        with self._lock:
+            if self._used + count > config.compress_buffer_pool:
+                return False
+            self._used += count
+            return True
+
+    def release(self, count: int):
+        """Return memory to the pool."""
+        with self._lock:
+            self._used -= count
+
+    @property
+    def used(self) -> int:
+        with self._lock:
+            return self._used
+
+
+def compress_whole(
+    file_path: Path | str | BinaryIO,
+    encoding: str,
+    chunk_size: int | None = None,
+) -> bytes:
+    """
+    Compress a whole file into memory with the given content encoding.
+
+    The bytes are those generate_encoded_stream() would yield, joined.
+    """
+    return b"".join(generate_encoded_stream(file_path, encoding, chunk_size))
+
+
+def worth_compressing(original: int, compressed: int) -> bool:
+    """Whether compression saved at least config.compress_min_ratio; already-compressed media rarely does."""
+    return compressed * config.compress_min_ratio <= original
+
+
+# Global pool of compression buffers
+buffer_pool = BufferPool()
@@ -0,0 +1,52 @@
+"""Request IDs tying log lines, audit entries, transfers and error responses to one request."""
+
+import re
+import secrets
+from contextvars import ContextVar
+from typing import Optional
+
+from flashare.core.logbook import logbook
+
+
+# Incoming IDs are echoed into logs and headers, so only plain tokens are honoured
+REQUEST_ID_PATTERN = re.compile(r"[A-Za-z0-9._:-]{1,128}")
+
+# ID of the request being handled; '' outside requests (startup, background tasks)
+_request_id: ContextVar[str] = ContextVar("request_id", default="")
+
+
+def new_request_id() -> str:
+    """Generate a short ID that is easy to read out and grep for."""
+    return secrets.token_hex(6)
+
+
+def accept_request_id(incoming: Optional[str]) -> str:
+    """The client's ID if it is well formed (e.g. set by a proxy), otherwise a new one."""
+    if incoming and REQUEST_ID_PATTERN.fullmatch(incoming):
+        return incoming
+    return new_request_id()
+
+
+def current_request_id() -> str:
+    """ID of the request the caller is handling, or ''."""
+    return _request_id.get()
+
+
+def bind_request_id(request_id: str):
+    """Make request_id current for the rest of this context; returns a token for reset_request_id()."""
+    return _request_id.set(request_id)
+
+
+def reset_request_id(token):
+    _request_id.reset(token)
+
+
+def log(message: str, level: str = "info"):
+    """
+    Print a log line, tagged with the current request's ID when there is one.
+
+    The line is also kept in the log history served by /api/logs.
+    """
+    request_id = current_request_id()
+    logbook.add(level, message, request_id)
+    print(f"{message} [{request_id}]" if request_id else message)
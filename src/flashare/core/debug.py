@@ -0,0 +1,60 @@
+"""Diagnostics bundle for attaching to bug reports."""
+
+import io
+import json
+import platform
+import time
+import zipfile
+from dataclasses import asdict
+
+from flashare import __app_name__, __version__
+from flashare.config import config
+from flashare.core.doctor import run_checks
+from flashare.core.redact import redact, redact_text
+
+
+def _format_checks() -> str:
+    """Doctor output as plain text, one check per line."""
+    lines = []
+    for result in run_checks(lan=False):
+        lines.append(f"[{'ok' if result.ok else 'FAIL'}] {result.name}: {result.detail}")
+        if result.hint:
+            lines.append(f"       {result.hint}")
+    return "\n".join(lines) + "\n"
+
+
+def build_bundle(snapshots: dict[str, object]) -> bytes:
+    """
+    Zip up everything useful for diagnosing a problem remotely.
+
+    Every file passes through the redaction helpers, so secrets and the
+    user's home directory never end up in the bundle.
+
+    Args:
+        snapshots: Name -> JSON-able data (status, metrics, ...), each
+            written as <name>.json.
+
+    Returns:
+        The zip archive bytes.
+    """
+    summary = (
+        f"{__app_name__} {__version__}\n"
+        f"Platform: {platform.platform()}\n"
+        f"Python: {platform.python_version()}\n"
+        f"Created: {time.strftime('%Y-%m-%d %H:%M:%S %z')}\n"
+        "File logging is not available; server output goes to the terminal only.\n"
+    )
+
+    files = {
+        "README.txt": summary,
+        "config.json": json.dumps(redact(asdict(config)), indent=2, default=str),
+        "doctor.txt": _format_checks(),
+    }
+    for name, data in snapshots.items():
+        files[f"{name}.json"] = json.dumps(redact(data), indent=2, default=str)
+
+    buffer = io.BytesIO()
+    with zipfile.ZipFile(buffer, "w", zipfile.ZIP_DEFLATED) as archive:
+        for name, content in files.items():
+            archive.writestr(f"flashare-debug/{name}", redact_text(content))
+    return buffer.getvalue()
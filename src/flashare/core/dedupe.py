@@ -0,0 +1,52 @@
+"""Storing identical uploads once by linking them to existing content."""
+
+import os
+from pathlib import Path
+
+try:
+    import fcntl
+except ImportError:  # Windows
+    fcntl = None
+
+
+# ioctl cloning one file's extents into another (Btrfs, XFS, ...)
+FICLONE = 0x40049409
+
+
+def _reflink(src: Path, dest: Path):
+    """Make dest a copy-on-write clone of src."""
+    if fcntl is None:
+        raise OSError("reflinks are not supported on this platform")
+    with open(src, "rb") as s, open(dest, "xb") as d:
+        try:
+            fcntl.ioctl(d.fileno(), FICLONE, s.fileno())
+        except OSError:
+            os.unlink(dest)
+            raise
+
+
+def link_duplicate(path: Path, existing: Path) -> str:
+    """
+    Replace a freshly stored file with a link to identical content.
+
+    A reflink is tried first: it shares the data but stays a separate
+    file. Otherwise the name becomes a hardlink to the existing inode.
+    Either way the data is only freed once every name is deleted.
+
+    Args:
+        path: The new file, whose content equals existing's.
+        existing: File already holding that content.
+
+    Returns:
+        'reflink', 'hardlink', or '' if neither worked (e.g. on FAT, or
+        across filesystems) and the copy was kept.
+    """
+    tmp = path.with_name(f".{path.name}.dedupe")
+    for method, make_link in (("reflink", _reflink), ("hardlink", os.link)):
+        try:
+            make_link(existing, tmp)
+        except OSError:
+            continue
+        os.replace(tmp, path)
+        return method
+    return ""
@@ -0,0 +1,250 @@
+"""Per-device identification, roles and usage quotas for Flashare."""
+
+import hashlib
+import json
+import os
+import re
+import threading
+import time
+import uuid
+from dataclasses import dataclass, asdict
+from typing import Optional
+
+from flashare.config import config
+
+
+# Cookie that identifies a browser across requests
+DEVICE_COOKIE = "flashare_device"
+
+_DEVICE_ID_PATTERN = re.compile(r"[0-9a-f]{32}")
+
+# Roles from least to most trusted: guests browse, download and upload;
+# trusted devices may also delete; hosts may do everything the host can
+ROLES = ("guest", "trusted", "host")
+
+# Where roles are kept across restarts with config.remember_roles
+ROLES_FILE = "roles.json"
+
+
+def new_device_id() -> str:
+    """Generate a fresh device identifier for the cookie."""
+    return uuid.uuid4().hex
+
+
+def public_device_id(device_id: str) -> str:
+    """
+    Identifier to show for a device.
+
+    The real ID is the device cookie, which acts with the device's role
+    when presented, so listings show a hash of it instead.
+    """
+    return hashlib.sha256(device_id.encode()).hexdigest()[:16]
+
+
+def resolve_device_id(cookie: Optional[str], ip: str) -> str:
+    """
+    Pick the identity to account a request to.
+
+    Args:
+        cookie: Value of the device cookie, if the client sent one.
+        ip: Client IP address, used when there's no valid cookie.
+
+    Returns:
+        The device identifier.
+    """
+    if cookie and _DEVICE_ID_PATTERN.fullmatch(cookie):
+        return cookie
+    return ip
+
+
+@dataclass
+class Device:
+    """Usage counters for one connected device."""
+    id: str
+    ip: str
+    first_seen: float
+    last_seen: float
+    uploaded: int = 0
+    downloaded: int = 0
+    quota: Optional[int] = None  # Per-device override of config.per_device_quota
+    role: str = "guest"
+    role_ip: str = ""  # Address the device had when it got its role
+
+
+def has_role(role: str, needed: str) -> bool:
+    """Whether role grants at least what needed does."""
+    return ROLES.index(role) >= ROLES.index(needed)
+
+
+class DeviceRegistry:
+    """
+    Thread-safe per-device roles and byte counters.
+
+    Counters live in memory, so they reset whenever the session restarts;
+    roles are saved to the data directory when config.remember_roles is on.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self.devices: dict[str, Device] = {}
+
+    def _get(self, device_id: str, ip: str = "") -> Device:
+        device = self.devices.get(device_id)
+        if device is None:
+            now = time.time()
+            device = self.devices[device_id] = Device(device_id, ip or device_id, now, now)
+        return device
+
+    def touch(self, device_id: str, ip: str) -> bool:
+        """
+        Record that a device made a request.
+
+        Returns:
+            True if this is the device's first request this session.
+        """
+        with self._lock:
+            new = device_id not in self.devices
+            device = self._get(device_id, ip)
+            device.ip = ip
+            device.last_seen = time.time()
+            return new
+
+    def _limit(self, device: Device) -> int:
+        return config.per_device_quota if device.quota is None else device.quota
+
+    def remaining(self, device_id: str) -> Optional[int]:
+        """
+        Upload bytes a device may still send.
+
+        Returns:
+            Remaining allowance, or None when the device is unlimited.
+        """
+        with self._lock:
+            device = self._get(device_id)
+            limit = self._limit(device)
+            return max(limit - device.uploaded, 0) if limit else None
+
+    def reserve_upload(self, device_id: str, count: int) -> bool:
+        """
+        Count upload bytes against a device's quota.
+
+        Returns:
+            False (and counts nothing) if the bytes would exceed the quota.
+        """
+        with self._lock:
+            device = self._get(device_id)
+            limit = self._limit(device)
+            if limit and device.uploaded + count > limit:
+                return False
+            device.uploaded += count
+            return True
+
+    def release_upload(self, device_id: str, count: int):
+        """Give back bytes from an upload that didn't complete."""
+        with self._lock:
+            device = self._get(device_id)
+            device.uploaded = max(device.uploaded - count, 0)
+
+    def add_download(self, device_id: str, count: int):
+        """Account downloaded bytes to a device."""
+        with self._lock:
+            self._get(device_id).downloaded += count
+
+    def set_quota(self, device_id: str, quota: Optional[int]):
+        """Override one device's quota (None restores the default)."""
+        with self._lock:
+            self._get(device_id).quota = quota
+
+    def role(self, device_id: str, ip: str) -> str:
+        """
+        The role a request from device_id at ip acts with.
+
+        With config.strict_device_binding, a role only holds at the address
+        it was granted to, so a device cookie copied to another machine
+        acts as a guest.
+        """
+        with self._lock:
+            device = self.devices.get(device_id)
+            if device is None or device.role == "guest":
+                return "guest"
+            if config.strict_device_binding and device.role_ip != ip:
+                return "guest"
+            return device.role
+
+    def set_role(self, device_id: str, role: str, ip: Optional[str] = None):
+        """
+        Give a device a role, bound to ip (default: its last address).
+
+        Raises:
+            ValueError: For a role that isn't one of ROLES.
+        """
+        if role not in ROLES:
+            raise ValueError(f"Unknown role {role!r} (use {', '.join(ROLES)})")
+        with self._lock:
+            device = self._get(device_id, ip or "")
+            device.role = role
+            device.role_ip = ip or device.ip
+        if config.remember_roles:
+            self.save_roles()
+
+    def save_roles(self):
+        """Write every non-guest role to the data directory."""
+        with self._lock:
+            roles = {
+                device.id: {"role": device.role, "ip": device.role_ip}
+                for device in self.devices.values()
+                if device.role != "guest" and _DEVICE_ID_PATTERN.fullmatch(device.id)
+            }
+        path = config.data_dir / ROLES_FILE
+        path.parent.mkdir(parents=True, exist_ok=True)
+        temp = path.with_suffix(".tmp")
+        temp.write_text(json.dumps(roles, indent=2))
+        os.replace(temp, path)
+
+    def load_roles(self) -> int:
+        """
+        Restore roles saved by an earlier session.
+
+        Returns:
+            Number of devices given back their role.
+        """
+        try:
+            roles = json.loads((config.data_dir / ROLES_FILE).read_text())
+        except (OSError, ValueError):
+            return 0
+        with self._lock:
+            for device_id, saved in roles.items():
+                if saved.get("role") in ROLES and _DEVICE_ID_PATTERN.fullmatch(device_id):
+                    device = self._get(device_id, saved.get("ip", ""))
+                    device.role = saved["role"]
+                    device.role_ip = saved.get("ip", "")
+        return len(roles)
+
+    def lookup(self, public_id: str) -> Optional[str]:
+        """The device ID behind a public_device_id, if that device is known."""
+        with self._lock:
+            return next((d for d in self.devices if public_device_id(d) == public_id), None)
+
+    def snapshot(self) -> list[dict]:
+        """
+        Per-device usage, most recently active first.
+
+        Devices are listed under their public_device_id; 'cookie' says
+        whether the device has a cookie or is known by its address only.
+        """
+        with self._lock:
+            result = []
+            for device in self.devices.values():
+                limit = self._limit(device)
+                result.append({
+                    **asdict(device),
+                    "id": public_device_id(device.id),
+                    "cookie": bool(_DEVICE_ID_PATTERN.fullmatch(device.id)),
+                    "limit": limit or None,
+                    "remaining": max(limit - device.uploaded, 0) if limit else None,
+                })
+        return sorted(result, key=lambda d: d["last_seen"], reverse=True)
+
+
+# Global device registry
+devices = DeviceRegistry()
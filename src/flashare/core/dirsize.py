@@ -0,0 +1,186 @@
+"""Incremental index of directory sizes for the uploads tree."""
+
+import os
+import threading
+from concurrent.futures import ThreadPoolExecutor
+from pathlib import Path, PurePosixPath
+from typing import Optional
+
+
+# Threads walking top-level folders in parallel during a rebuild
+WALK_WORKERS = 4
+
+
+class RebuildCancelled(Exception):
+    """A rebuild was superseded or stopped."""
+
+
+def _ancestors(rel_path: str) -> list[str]:
+    """Folders containing a path, innermost first, ending with the root ('')."""
+    parents = [p.as_posix() for p in PurePosixPath(rel_path).parents]
+    return [("" if p == "." else p) for p in parents]
+
+
+def _walk(folder: Path, rel: str, cancel: threading.Event) -> dict[str, int]:
+    """Total bytes of every folder under (and including) folder; symlinks are skipped."""
+    sizes = {rel: 0}
+    try:
+        entries = list(os.scandir(folder))
+    except OSError:
+        return sizes
+    for entry in entries:
+        if cancel.is_set():
+            raise RebuildCancelled()
+        if entry.name.startswith("."):
+            continue  # Hidden, like in listings (this includes .meta)
+        child = f"{rel}/{entry.name}" if rel else entry.name
+        try:
+            if entry.is_dir(follow_symlinks=False):
+                nested = _walk(Path(entry.path), child, cancel)
+                sizes.update(nested)
+                sizes[rel] += nested[child]
+            elif entry.is_file(follow_symlinks=False):
+                sizes[rel] += entry.stat(follow_symlinks=False).st_size
+        except OSError:
+            continue  # Vanished mid-walk
+    return sizes
+
+
+class DirSizeIndex:
+    """
+    Sizes of every folder in the share, kept current without re-walking.
+
+    A rebuild walks the tree in parallel (one task per top-level folder)
+    in the background and swaps the result in atomically. Changes made
+    through Flashare are applied incrementally by bubbling the size delta
+    up the parent chain. A change arriving while a rebuild is walking may
+    or may not have been seen by the walk, so the index is marked dirty
+    and rebuilt once more rather than risk double counting.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._sizes: dict[str, int] = {}
+        self._root: Optional[Path] = None
+        self._cancel = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+        self._dirty = False
+        self.state = "empty"  # empty, building, ready, failed
+        self.done = 0
+        self.total = 0
+
+    # ---- rebuilding ----
+
+    def rebuild(self, root: Path):
+        """Start a background rebuild, cancelling one already running."""
+        with self._lock:
+            self._cancel.set()
+            self._cancel = cancel = threading.Event()
+            self._root = Path(root)
+            self._dirty = False
+            self.state = "building"
+            self.done = self.total = 0
+            self._thread = threading.Thread(
+                target=self._build, args=(self._root, cancel), name="flashare-dirsize", daemon=True
+            )
+            self._thread.start()
+
+    def cancel(self):
+        """Stop a running rebuild, keeping the previous sizes."""
+        with self._lock:
+            self._cancel.set()
+            if self.state == "building":
+                self.state = "ready" if self._sizes else "empty"
+
+    def _build(self, root: Path, cancel: threading.Event):
+        try:
+            top = [e for e in os.scandir(root) if not e.name.startswith(".")] if root.is_dir() else []
+            folders = [e for e in top if e.is_dir(follow_symlinks=False)]
+            sizes = {"": sum(
+                e.stat(follow_symlinks=False).st_size for e in top if e.is_file(follow_symlinks=False)
+            )}
+            with self._lock:
+                self.total = len(folders)
+
+            def walk_one(entry) -> dict[str, int]:
+                result = _walk(Path(entry.path), entry.name, cancel)
+                with self._lock:
+                    self.done += 1
+                return result
+
+            with ThreadPoolExecutor(max_workers=WALK_WORKERS) as pool:
+                for entry, nested in zip(folders, pool.map(walk_one, folders)):
+                    sizes.update(nested)
+                    sizes[""] += nested[entry.name]
+        except RebuildCancelled:
+            return
+        except OSError:
+            with self._lock:
+                if not cancel.is_set():
+                    self.state = "failed"
+            return
+
+        with self._lock:
+            if cancel.is_set():
+                return
+            self._sizes = sizes
+            self.state = "ready"
+            rerun = self._dirty
+        if rerun:
+            self.rebuild(root)
+
+    # ---- incremental updates ----
+
+    def add(self, rel_path: str, delta: int):
+        """
+        Account a file of rel_path growing (or shrinking) by delta bytes.
+
+        Args:
+            rel_path: File path relative to the uploads directory.
+            delta: Size change; negative for deletions.
+        """
+        with self._lock:
+            if self.state == "building":
+                self._dirty = True
+            if not self._sizes:
+                return
+            for folder in _ancestors(rel_path):
+                if folder in self._sizes:
+                    self._sizes[folder] += delta
+
+    def add_tree(self, folder: Path):
+        """Account a whole folder that just appeared (e.g. an extracted zip)."""
+        root = self._root
+        if root is None:
+            return
+        rel = folder.relative_to(root).as_posix()
+        sizes = _walk(folder, rel, threading.Event())
+        with self._lock:
+            if self.state == "building":
+                self._dirty = True
+            if not self._sizes:
+                return
+            self._sizes.update(sizes)
+            for parent in _ancestors(rel):
+                if parent in self._sizes:
+                    self._sizes[parent] += sizes[rel]
+
+    # ---- queries ----
+
+    def size(self, rel_dir: str = "") -> Optional[int]:
+        """Total bytes under a folder ('' for the whole share), if indexed."""
+        with self._lock:
+            return self._sizes.get(rel_dir)
+
+    def status(self) -> dict:
+        """Index state and rebuild progress, for /api/status."""
+        with self._lock:
+            return {
+                "state": self.state,
+                "folders": max(len(self._sizes) - 1, 0),
+                "progress": {"done": self.done, "total": self.total} if self.state == "building" else None,
+            }
+
+
+# Global directory size index
+dir_sizes = DirSizeIndex()
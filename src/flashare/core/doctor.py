@@ -0,0 +1,120 @@
+"""Environment diagnostics for Flashare."""
+
+import os
+import platform
+import shutil
+import socket
+import threading
+from dataclasses import dataclass
+from typing import Optional
+
+from flashare.config import config
+from flashare.core.network import get_local_ip
+
+
+@dataclass
+class CheckResult:
+    """Outcome of a single diagnostic check."""
+    name: str
+    ok: bool
+    detail: str
+    hint: Optional[str] = None
+
+
+def _check_tool(name: str, purpose: str) -> CheckResult:
+    """Check that an optional external tool is on PATH."""
+    path = shutil.which(name)
+    if path:
+        return CheckResult(name, True, path)
+    return CheckResult(name, False, "not found", f"Install {name} to enable {purpose}.")
+
+
+def _check_uploads_dir() -> CheckResult:
+    """Check that the uploads directory exists and is writable."""
+    uploads_dir = config.uploads_dir
+    if not uploads_dir.is_dir():
+        return CheckResult("uploads dir", False, f"{uploads_dir} does not exist")
+    if not os.access(uploads_dir, os.W_OK):
+        return CheckResult("uploads dir", False, f"{uploads_dir} is not writable",
+                           "Fix the directory permissions or choose another location.")
+    return CheckResult("uploads dir", True, str(uploads_dir))
+
+
+def _firewall_hint(port: int) -> str:
+    """Platform-specific advice for allowing inbound connections."""
+    if platform.system() == "Windows":
+        return (
+            "Windows Defender Firewall is probably blocking inbound connections. Run as admin:\n"
+            f'  netsh advfirewall firewall add rule name="Flashare" dir=in action=allow protocol=TCP localport={port}\n'
+            "or open Settings > Privacy & security > Windows Security > Firewall & network protection > "
+            "Allow an app through firewall, and allow Python/Flashare on Private networks."
+        )
+    if platform.system() == "Darwin":
+        return "Allow incoming connections for Flashare in System Settings > Network > Firewall."
+    return f"Allow TCP port {port} in your firewall (e.g. sudo ufw allow {port}/tcp)."
+
+
+def _check_lan_connectivity(port: int) -> CheckResult:
+    """
+    Check that a listener on the port is reachable via the LAN address.
+
+    Connecting to our own LAN IP goes through the host firewall on
+    Windows, which catches the dismissed "allow access" prompt case.
+    """
+    ip = get_local_ip()
+    if ip == "127.0.0.1":
+        return CheckResult("lan", False, "no LAN address detected",
+                           "Connect to Wi-Fi or Ethernet so other devices can reach you.")
+
+    try:
+        server = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        server.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+        server.bind(("0.0.0.0", port))
+        server.listen(1)
+    except OSError:
+        return CheckResult("port", False, f"port {port} is already in use",
+                           "Stop the other program or pick another port with --port.")
+
+    accepter = threading.Thread(target=lambda: _accept_once(server), daemon=True)
+    accepter.start()
+    try:
+        with socket.create_connection((ip, port), timeout=2):
+            pass
+        return CheckResult("lan", True, f"reachable at {ip}:{port}")
+    except OSError as e:
+        return CheckResult("lan", False, f"cannot connect to {ip}:{port} ({e})", _firewall_hint(port))
+    finally:
+        server.close()
+
+
+def _accept_once(server: socket.socket):
+    """Accept and drop a single connection for the self-connection test."""
+    try:
+        server.settimeout(3)
+        conn, _ = server.accept()
+        conn.close()
+    except OSError:
+        pass
+
+
+def run_checks(port: Optional[int] = None, lan: bool = True) -> list[CheckResult]:
+    """
+    Run every diagnostic check.
+
+    Args:
+        port: Port to test (defaults to the configured port).
+        lan: Include the LAN self-connection test, which needs the port
+            free (so not from inside a running server).
+
+    Returns:
+        Check results in display order.
+    """
+    port = port or config.port
+    results = [
+        _check_uploads_dir(),
+        _check_tool("fzf", "the interactive file picker"),
+        _check_tool("ffmpeg", "video optimization"),
+    ]
+    if lan:
+        results.append(_check_lan_connectivity(port))
+    return results
@@ -0,0 +1,183 @@
+"""Compressed copies of downloaded files kept on disk, so compressed downloads can resume."""
+
+import hashlib
+import os
+import secrets
+import threading
+from pathlib import Path
+from typing import Iterator, Optional
+
+from flashare.config import config
+from flashare.core.correlation import log
+
+
+# Smaller files restart quickly enough that caching their compressed copy isn't worth the disk
+CACHE_MIN_SIZE = 1024 * 1024
+
+
+class EncodedCache:
+    """
+    Compressed copies of stored files, by content encoding.
+
+    A compressed stream has no byte offsets that map onto the file, so an
+    interrupted compressed download can only be resumed against the very
+    bytes sent the first time. Those bytes are written here while they
+    are streamed; once complete, later requests (Range included) are
+    served from the copy. Copies are keyed by name, size, modification
+    time, encoding and zstd level, so an edited file or a changed level
+    never gets a stale copy. The least recently used are deleted beyond
+    config.encoded_cache_size bytes.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._writing: set[str] = set()
+
+    @property
+    def folder(self) -> Path:
+        return config.data_dir / "encoded"
+
+    @property
+    def enabled(self) -> bool:
+        return config.encoded_cache_size > 0
+
+    def path(self, name: str, size: int, modified: float, encoding: str) -> Path:
+        key = hashlib.sha256(f"{name}\0{size}\0{modified}\0{config.zstd_level}".encode()).hexdigest()[:32]
+        return self.folder / f"{key}.{encoding}"
+
+    def size(self, name: str, size: int, modified: float, encoding: str) -> Optional[int]:
+        """Size of the complete copy, or None if there is none (yet)."""
+        path = self.path(name, size, modified, encoding)
+        try:
+            os.utime(path)  # Recently used: pruned last
+            return path.stat().st_size
+        except OSError:
+            return None
+
+    def tee(self, stream: Iterator[bytes], name: str, size: int, modified: float, encoding: str) -> Iterator[bytes]:
+        """
+        Pass an encoded stream through, writing it to the cache on the way.
+
+        If the client goes away first, the rest of the stream is written
+        in the background, so the copy is there when it comes back to
+        resume. Files below CACHE_MIN_SIZE, a disabled cache, or another
+        request already writing the same copy leave the stream untouched.
+        """
+        target = self.path(name, size, modified, encoding)
+        with self._lock:
+            claimed = self.enabled and size >= CACHE_MIN_SIZE and target.name not in self._writing
+            if claimed:
+                self._writing.add(target.name)
+        if not claimed:
+            yield from stream
+            return
+        try:
+            self.folder.mkdir(parents=True, exist_ok=True)
+            temp = target.with_name(f"{target.name}.{secrets.token_hex(4)}.tmp")
+            out = open(temp, "wb")
+        except OSError:
+            self._release(target)
+            yield from stream
+            return
+
+        try:
+            for chunk in stream:
+                out.write(chunk)
+                yield chunk
+        except GeneratorExit:
+            # The client left; finish the copy without it
+            threading.Thread(
+                target=self._finish, args=(stream, out, temp, target), name="flashare-encode", daemon=True,
+            ).start()
+            raise
+        except BaseException:
+            out.close()
+            temp.unlink(missing_ok=True)
+            self._release(target)
+            raise
+        self._store(out, temp, target)
+
+    def store(self, data: bytes, name: str, size: int, modified: float, encoding: str):
+        """Keep an encoded body compressed in one piece, under the same rules as tee()."""
+        target = self.path(name, size, modified, encoding)
+        with self._lock:
+            if not self.enabled or size < CACHE_MIN_SIZE or target.name in self._writing or target.exists():
+                return
+            self._writing.add(target.name)
+        temp = target.with_name(f"{target.name}.{secrets.token_hex(4)}.tmp")
+        try:
+            self.folder.mkdir(parents=True, exist_ok=True)
+            with open(temp, "wb") as out:
+                out.write(data)
+        except OSError:
+            temp.unlink(missing_ok=True)
+            self._release(target)
+            return
+        self._store(out, temp, target)
+
+    def _finish(self, stream: Iterator[bytes], out, temp: Path, target: Path):
+        try:
+            for chunk in stream:
+                out.write(chunk)
+        except Exception as e:  # Nobody else would hear of it in this thread
+            out.close()
+            temp.unlink(missing_ok=True)
+            self._release(target)
+            log(f"⚠️  Could not cache the compressed copy of a download: {e}", "warning")
+            return
+        finally:
+            close = getattr(stream, "close", None)
+            if close:
+                close()
+        self._store(out, temp, target)
+
+    def _store(self, out, temp: Path, target: Path):
+        try:
+            out.close()
+            temp.replace(target)
+        except OSError:
+            temp.unlink(missing_ok=True)
+        finally:
+            self._release(target)
+        self.prune()
+
+    def _release(self, target: Path):
+        with self._lock:
+            self._writing.discard(target.name)
+
+    def prune(self) -> int:
+        """
+        Delete the least recently used copies beyond config.encoded_cache_size,
+        and copies left half-written by a session that crashed.
+
+        Returns:
+            Number of copies removed.
+        """
+        if not self.folder.is_dir():
+            return 0
+        copies = []
+        for path in self.folder.iterdir():
+            if path.suffix == ".tmp":
+                with self._lock:
+                    abandoned = path.name.rsplit(".", 2)[0] not in self._writing
+                if abandoned:
+                    path.unlink(missing_ok=True)
+                continue
+            try:
+                stat = path.stat()
+            except OSError:
+                continue
+            copies.append((stat.st_mtime, stat.st_size, path))
+        total = sum(size for _, size, _ in copies)
+        removed = 0
+        for _, size, path in sorted(copies):
+            if total <= config.encoded_cache_size:
+                break
+            path.unlink(missing_ok=True)  # A download still running keeps its open file
+            total -= size
+            removed += 1
+        return removed
+
+
+# Global compressed copies
+encoded_cache = EncodedCache()
@@ -0,0 +1,155 @@
+"""Estimating how large an archive will be before it is built."""
+
+import zlib
+from dataclasses import dataclass, field
+from functools import lru_cache
+from pathlib import Path
+from typing import Callable
+
+from flashare.core.compression import create_compressor
+
+
+# Bytes read from the start of a sampled file
+SAMPLE_BYTES = 256 * 1024
+
+# Files sampled per type, largest first since they dominate the total,
+# so a preview reads at most SAMPLE_BYTES * SAMPLES_PER_TYPE per type
+SAMPLES_PER_TYPE = 3
+
+# Zip bytes besides the data: per member, plus its name twice (local and
+# central header), and once at the end of the archive
+_ZIP_MEMBER_OVERHEAD = 30 + 20 + 24 + 46  # Local header, zip64 extra, data descriptor, central entry
+_ZIP_END_OVERHEAD = 56 + 20 + 22  # Zip64 end record and locator, end of central directory
+# Tar headers are mostly zeros and shrink to almost nothing once compressed
+_COMPRESSED_TAR_MEMBER_OVERHEAD = 64
+
+
+@dataclass
+class TypeEstimate:
+    """Files of one type in a selection and what they'll take up in the archive."""
+    type: str
+    files: int = 0
+    bytes: int = 0
+    estimated_bytes: int = 0
+    sampled_files: int = 0
+    sampled_bytes: int = 0
+
+    def to_dict(self) -> dict:
+        return {
+            "type": self.type,
+            "files": self.files,
+            "bytes": self.bytes,
+            "estimated_bytes": self.estimated_bytes,
+            "sampled_files": self.sampled_files,
+            "sampled_bytes": self.sampled_bytes,
+        }
+
+
+@dataclass
+class ArchiveEstimate:
+    """Exact file totals of a selection and the estimated size of its archive."""
+    archive_format: str
+    files: int = 0
+    bytes: int = 0
+    estimated_bytes: int = 0
+    by_type: dict[str, TypeEstimate] = field(default_factory=dict)
+
+    @property
+    def sampled_bytes(self) -> int:
+        return sum(t.sampled_bytes for t in self.by_type.values())
+
+
+def _compressed_size(data: bytes, archive_format: str) -> int:
+    if archive_format == "tar.gz":
+        compressor = zlib.compressobj(6, zlib.DEFLATED, 16 + zlib.MAX_WBITS)
+        return len(compressor.compress(data) + compressor.flush())
+    return len(create_compressor().compress(data))
+
+
+@lru_cache(maxsize=4096)
+def _sample_ratio(path: str, size: int, mtime_ns: int, archive_format: str) -> tuple[float, int]:
+    """
+    Compressed to original size of a file's first SAMPLE_BYTES.
+
+    Size and mtime are part of the key, so a file that changed is
+    sampled again. Raises OSError (not cached) if it can't be read.
+
+    Returns:
+        The ratio and how many bytes were read.
+    """
+    with open(path, "rb") as f:
+        data = f.read(SAMPLE_BYTES)
+    if not data:
+        return 1.0, 0
+    return _compressed_size(data, archive_format) / len(data), len(data)
+
+
+def _member_overhead(arcname: str, archive_format: str) -> int:
+    if archive_format == "zip":
+        return _ZIP_MEMBER_OVERHEAD + 2 * len(arcname.encode())
+    return _COMPRESSED_TAR_MEMBER_OVERHEAD
+
+
+def estimate_archive(
+    entries: list[tuple[Path, str]],
+    archive_format: str,
+    type_of: Callable[[str], str],
+) -> ArchiveEstimate:
+    """
+    Add up a selection and estimate the size of its archive without building it.
+
+    File totals are exact. Zips store files as they are, so their
+    estimate only adds headers. For the compressed tars, the largest
+    SAMPLES_PER_TYPE files of each type have their first SAMPLE_BYTES
+    compressed with the archive's codec, and that ratio (weighted by
+    file size) is applied to the whole type. Samples are cached per
+    file, so previewing the same selection again reads nothing.
+
+    Args:
+        entries: (path on disk, path in archive) pairs, as for stream_archive.
+        archive_format: One of ARCHIVE_FORMATS.
+        type_of: Category of a file from its name, e.g. 'video'.
+
+    Returns:
+        The totals, per type and overall. Files that vanished are left out.
+    """
+    estimate = ArchiveEstimate(archive_format)
+    sized: dict[str, list[tuple[Path, int, int]]] = {}
+    overhead = 0
+    for path, arcname in entries:
+        try:
+            st = path.stat()
+        except OSError:
+            continue
+        kind = type_of(arcname)
+        group = estimate.by_type.setdefault(kind, TypeEstimate(kind))
+        group.files += 1
+        group.bytes += st.st_size
+        sized.setdefault(kind, []).append((path, st.st_size, st.st_mtime_ns))
+        overhead += _member_overhead(arcname, archive_format)
+        estimate.files += 1
+        estimate.bytes += st.st_size
+
+    for kind, group in estimate.by_type.items():
+        if archive_format == "zip":
+            group.estimated_bytes = group.bytes
+            continue
+        weighted, weight = 0.0, 0
+        for path, size, mtime_ns in sorted(sized[kind], key=lambda f: f[1], reverse=True)[:SAMPLES_PER_TYPE]:
+            try:
+                ratio, read = _sample_ratio(str(path), size, mtime_ns, archive_format)
+            except OSError:
+                continue
+            group.sampled_files += 1
+            group.sampled_bytes += read
+            weighted += ratio * size
+            weight += size
+        ratio = weighted / weight if weight else 1.0
+        group.estimated_bytes = round(group.bytes * ratio)
+
+    if archive_format == "zip":
+        overhead += _ZIP_END_OVERHEAD
+    else:
+        overhead += _COMPRESSED_TAR_MEMBER_OVERHEAD  # End marker and padding
+    estimate.estimated_bytes = sum(t.estimated_bytes for t in estimate.by_type.values()) + overhead
+    return estimate
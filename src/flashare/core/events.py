@@ -0,0 +1,102 @@
+"""Broadcast hub for live updates pushed to browsers over SSE."""
+
+import asyncio
+import itertools
+import json
+import threading
+import time
+from dataclasses import dataclass, field
+from typing import Callable, Optional
+
+
+# Events a subscriber may fall behind by before it is treated as dead
+QUEUE_SIZE = 64
+
+
+@dataclass
+class Subscriber:
+    """One open event stream."""
+    id: int
+    client: str
+    loop: asyncio.AbstractEventLoop
+    queue: asyncio.Queue = field(default_factory=lambda: asyncio.Queue(QUEUE_SIZE))
+    connected: float = field(default_factory=time.time)
+    # Set when the subscriber can't keep up; its stream then ends
+    dropped: bool = False
+
+    def offer(self, message: str):
+        """Queue a message, dropping the subscriber instead of blocking."""
+        try:
+            self.queue.put_nowait(message)
+        except asyncio.QueueFull:
+            self.dropped = True
+
+
+def format_event(event: str, data) -> str:
+    """Encode one Server-Sent Events message."""
+    return f"event: {event}\ndata: {json.dumps(data)}\n\n"
+
+
+class EventHub:
+    """
+    Thread-safe fan-out of events to every open stream.
+
+    publish() may be called from worker threads; delivery is handed to
+    each subscriber's event loop. Streams unsubscribe themselves when
+    they end, whether the client left cleanly or the connection died.
+    Listeners (e.g. --output ndjson) get every event too, synchronously.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._ids = itertools.count(1)
+        self._subscribers: dict[int, Subscriber] = {}
+        self._listeners: list[Callable[[str, dict], None]] = []
+
+    def subscribe(self, client: str) -> Subscriber:
+        """Register a stream. Must be called from its event loop."""
+        subscriber = Subscriber(next(self._ids), client, asyncio.get_running_loop())
+        with self._lock:
+            self._subscribers[subscriber.id] = subscriber
+        return subscriber
+
+    def unsubscribe(self, subscriber: Subscriber):
+        """Forget a stream; safe to call more than once."""
+        with self._lock:
+            self._subscribers.pop(subscriber.id, None)
+
+    def listen(self, listener: Callable[[str, dict], None]):
+        """Call listener(event, data) for every event published from now on."""
+        with self._lock:
+            self._listeners.append(listener)
+
+    def publish(self, event: str, data: Optional[dict] = None):
+        """
+        Send an event to every subscriber.
+
+        Args:
+            event: Event name, e.g. 'files'.
+            data: JSON-serializable payload.
+        """
+        message = format_event(event, data or {})
+        with self._lock:
+            subscribers = list(self._subscribers.values())
+            listeners = list(self._listeners)
+        for listener in listeners:
+            listener(event, data or {})
+        for subscriber in subscribers:
+            try:
+                subscriber.loop.call_soon_threadsafe(subscriber.offer, message)
+            except RuntimeError:
+                # Loop already closed (server shutting down)
+                self.unsubscribe(subscriber)
+
+    @property
+    def count(self) -> int:
+        """Number of open streams."""
+        with self._lock:
+            return len(self._subscribers)
+
+
+# Global event hub
+hub = EventHub()
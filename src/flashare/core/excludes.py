@@ -0,0 +1,146 @@
+"""Exclude/include pattern matching for directory shares."""
+
+import os
+import re
+from functools import lru_cache
+from pathlib import Path
+from typing import Callable, Iterable, Iterator, Optional
+
+
+# Junk that almost never belongs in a share
+DEFAULT_EXCLUDES = (".git/", "node_modules/", ".DS_Store", "Thumbs.db")
+
+
+@lru_cache(maxsize=256)
+def _compile_pattern(pattern: str) -> tuple[re.Pattern, bool]:
+    """
+    Translate a glob pattern into a regex over '/'-separated relative paths.
+
+    Rules (gitignore-style):
+        - A trailing '/' only matches directories.
+        - A pattern without '/' matches the name at any depth.
+        - '**' matches across path segments, '*' and '?' do not.
+
+    Returns:
+        Tuple of (compiled regex, directory-only flag).
+    """
+    dir_only = pattern.endswith("/")
+    pattern = pattern.strip("/") if dir_only else pattern.lstrip("/")
+    if "/" not in pattern:
+        pattern = f"**/{pattern}"
+
+    regex = ""
+    i = 0
+    while i < len(pattern):
+        if pattern.startswith("**/", i):
+            regex += "(?:.*/)?"
+            i += 3
+        elif pattern.startswith("**", i):
+            regex += ".*"
+            i += 2
+        elif pattern[i] == "*":
+            regex += "[^/]*"
+            i += 1
+        elif pattern[i] == "?":
+            regex += "[^/]"
+            i += 1
+        elif pattern[i] == "[":
+            end = pattern.find("]", i + 1)
+            if end == -1:
+                regex += re.escape(pattern[i])
+                i += 1
+            else:
+                body = pattern[i + 1:end].replace("\\", "\\\\")
+                if body.startswith("!"):
+                    body = "^" + body[1:]
+                regex += f"[{body}]"
+                i = end + 1
+        else:
+            regex += re.escape(pattern[i])
+            i += 1
+
+    return re.compile(f"{regex}$"), dir_only
+
+
+def _matches(patterns: Iterable[str], rel_path: str, is_dir: bool) -> bool:
+    """Check whether a relative path matches any of the patterns."""
+    for pattern in patterns:
+        regex, dir_only = _compile_pattern(pattern)
+        if dir_only and not is_dir:
+            continue
+        if regex.match(rel_path):
+            return True
+    return False
+
+
+class PathFilter:
+    """
+    Decide which paths under a shared root are exposed.
+
+    Include patterns override exclude patterns, so `--include .env.example`
+    keeps a file that a broader exclude would otherwise drop.
+    """
+
+    def __init__(
+        self,
+        excludes: Iterable[str] = (),
+        includes: Iterable[str] = (),
+        use_defaults: bool = True,
+    ):
+        self.excludes = (list(DEFAULT_EXCLUDES) if use_defaults else []) + list(excludes)
+        self.includes = list(includes)
+
+    def is_excluded(self, rel_path: str, is_dir: bool = False) -> bool:
+        """
+        Check a path relative to the shared root.
+
+        Args:
+            rel_path: Path relative to the root, using '/' separators.
+            is_dir: Whether the path is a directory.
+
+        Returns:
+            True if the path should not be shared.
+        """
+        if _matches(self.includes, rel_path, is_dir):
+            return False
+        return _matches(self.excludes, rel_path, is_dir)
+
+    def walk(self, root: Path, on_skip: Optional[Callable[[str], None]] = None) -> Iterator[tuple[Path, str]]:
+        """
+        Yield included files under root, pruning excluded directories.
+
+        Excluded directories are never descended into, which keeps large
+        trees like node_modules cheap to skip.
+
+        Args:
+            root: Directory to walk.
+            on_skip: Called with the relative path of each excluded file
+                or directory (directories end in '/').
+
+        Yields:
+            Tuples of (absolute path, relative posix path).
+        """
+        root = Path(root)
+        for dirpath, dirnames, filenames in os.walk(root):
+            base = Path(dirpath).relative_to(root).as_posix()
+            prefix = "" if base == "." else f"{base}/"
+
+            # Prune in place so os.walk skips excluded subtrees
+            kept = []
+            for d in sorted(dirnames):
+                if not self.is_excluded(f"{prefix}{d}", is_dir=True):
+                    kept.append(d)
+                elif on_skip:
+                    on_skip(f"{prefix}{d}/")
+            dirnames[:] = kept
+
+            for name in sorted(filenames):
+                rel = f"{prefix}{name}"
+                if not self.is_excluded(rel):
+                    yield Path(dirpath) / name, rel
+                elif on_skip:
+                    on_skip(rel)
+
+    def describe(self) -> list[str]:
+        """Return the effective rule list for display."""
+        return [f"- {p}" for p in self.excludes] + [f"+ {p}" for p in self.includes]
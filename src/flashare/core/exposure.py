@@ -0,0 +1,99 @@
+"""Pre-flight check of a folder about to be shared: how much it exposes, and whether anyone means to share it."""
+
+import sys
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Optional
+
+from flashare.core.excludes import PathFilter
+
+
+# Folders holding the operating system; sharing one is never what was meant
+_SYSTEM_DIRS = (
+    "/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/opt", "/proc", "/root",
+    "/sbin", "/sys", "/usr", "/var", "/home", "/Users", "/System", "/Library",
+    "/Applications", "/private",
+)
+_WINDOWS_SYSTEM_DIRS = ("Windows", "Program Files", "Program Files (x86)", "ProgramData", "Users")
+
+
+@dataclass
+class Exposure:
+    """What sharing a folder would expose, as far as the scan got."""
+    files: int = 0
+    size: int = 0
+    complete: bool = True  # False when the scan stopped at a threshold
+
+
+@dataclass
+class ShareCheck:
+    """Verdict on a folder about to be shared."""
+    root: Path
+    dangerous: str = ""  # Why the folder must never be shared, e.g. 'your home directory'
+    exposure: Optional[Exposure] = None
+    too_large: bool = False  # Above a threshold, so the user should confirm
+
+
+def dangerous_root(path: Path) -> str:
+    """
+    Why a folder is obviously not meant to be shared, or ''.
+
+    Covers the filesystem root (or a Windows drive), the home directory
+    itself, and system folders such as /etc or C:\\Windows. Folders inside
+    them (e.g. ~/Downloads) are fine.
+    """
+    path = Path(path).resolve()
+    if path.parent == path:
+        return "the root of the filesystem"
+    if path == Path.home().resolve():
+        return "your home directory"
+    if sys.platform == "win32":
+        system = {Path(path.anchor, name) for name in _WINDOWS_SYSTEM_DIRS}
+    else:
+        system = {Path(name) for name in _SYSTEM_DIRS}
+    if path in system or path in {p.resolve() for p in system}:
+        return "a system folder"
+    return ""
+
+
+def estimate_exposure(root: Path, path_filter: PathFilter, max_files: int, max_size: int) -> Exposure:
+    """
+    Count the files and bytes a share of root would expose.
+
+    Excluded paths are skipped like the share itself would. The scan
+    stops as soon as either limit is passed, so it stays quick on a tree
+    of millions of files.
+
+    Args:
+        root: Folder to scan.
+        path_filter: The share's include/exclude rules.
+        max_files: Stop after this many files (0 = no limit).
+        max_size: Stop after this many bytes (0 = no limit).
+    """
+    exposure = Exposure()
+    for path, _ in path_filter.walk(root):
+        try:
+            exposure.size += path.lstat().st_size
+        except OSError:
+            continue
+        exposure.files += 1
+        if (max_files and exposure.files > max_files) or (max_size and exposure.size > max_size):
+            exposure.complete = False
+            break
+    return exposure
+
+
+def check_share_root(root: Path, path_filter: PathFilter, max_files: int, max_size: int) -> ShareCheck:
+    """
+    Decide whether a folder can be shared without asking.
+
+    Dangerous folders are reported without being scanned. Otherwise the
+    folder is scanned up to the limits, and too_large is set when it
+    holds more than max_files files or max_size bytes.
+    """
+    check = ShareCheck(Path(root), dangerous=dangerous_root(root))
+    if check.dangerous:
+        return check
+    check.exposure = estimate_exposure(root, path_filter, max_files, max_size)
+    check.too_large = not check.exposure.complete
+    return check
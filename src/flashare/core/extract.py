@@ -0,0 +1,156 @@
+"""Safe extraction of uploaded zip archives into the share."""
+
+import shutil
+import stat
+import zipfile
+from pathlib import Path, PurePosixPath
+from typing import BinaryIO
+
+from flashare.core.paths import sanitize_filename
+from flashare.core.permissions import make_dirs, restrict
+
+
+# Local file header, and the end record an empty archive starts with
+ZIP_SIGNATURES = (b"PK\x03\x04", b"PK\x05\x06")
+
+# Archives with more entries than this are refused
+MAX_ENTRIES = 10_000
+
+# Refuse archives expanding to more than this many times their compressed
+# size; ordinary files rarely pass 20x, zip bombs reach thousands
+MAX_EXPANSION_RATIO = 100
+
+
+class UnsafeArchiveError(ValueError):
+    """An archive entry would land outside the extraction folder, or the archive expands too far."""
+
+
+def is_zip(header: bytes) -> bool:
+    """Detect a zip archive from its first bytes, whatever its extension."""
+    return header[:4] in ZIP_SIGNATURES
+
+
+def _member_parts(name: str) -> list[str]:
+    """
+    Split an archive entry name into safe path components.
+
+    Raises:
+        UnsafeArchiveError: For absolute paths, drive letters or '..'.
+    """
+    path = PurePosixPath(name.replace("\\", "/"))
+    if path.is_absolute() or (path.parts and path.parts[0].endswith(":")):
+        raise UnsafeArchiveError(f"Absolute path in archive: {name}")
+    if ".." in path.parts:
+        raise UnsafeArchiveError(f"Parent reference in archive: {name}")
+    return [sanitize_filename(part) for part in path.parts if part != "."]
+
+
+def unique_folder(parent: Path, name: str) -> Path:
+    """Pick a folder name under parent, appending _1, _2, ... if taken."""
+    candidate = parent / name
+    counter = 1
+    while candidate.exists():
+        candidate = parent / f"{name}_{counter}"
+        counter += 1
+    return candidate
+
+
+def _plan(archive: zipfile.ZipFile, max_size: int) -> tuple[list[tuple[zipfile.ZipInfo, list[str]]], int]:
+    """
+    Validate every entry of an archive before anything is written.
+
+    Returns:
+        The entries with their safe path components, and the total
+        expanded size.
+
+    Raises:
+        UnsafeArchiveError: If any entry would escape, is a symlink, or
+            the archive has too many entries or expands too far.
+    """
+    infos = archive.infolist()
+    if len(infos) > MAX_ENTRIES:
+        raise UnsafeArchiveError(f"Archive has {len(infos)} entries, over the {MAX_ENTRIES} entry limit")
+
+    members = []
+    total = 0
+    compressed = 0
+    for info in infos:
+        if stat.S_ISLNK(info.external_attr >> 16):
+            raise UnsafeArchiveError(f"Symlink in archive: {info.filename}")
+        parts = _member_parts(info.filename)
+        if not parts:
+            continue
+        total += info.file_size
+        compressed += info.compress_size
+        members.append((info, parts))
+
+    if max_size and total > max_size:
+        raise UnsafeArchiveError(f"Archive expands to {total} bytes, over the {max_size} byte limit")
+    if total > max(compressed, 1) * MAX_EXPANSION_RATIO:
+        raise UnsafeArchiveError(
+            f"Archive expands to {total} bytes from {compressed}, over {MAX_EXPANSION_RATIO} times its size"
+        )
+    return members, total
+
+
+def expanded_size(source: BinaryIO, max_size: int = 0) -> int:
+    """
+    Total size a zip archive would extract to, validating it as extract_zip does.
+
+    Lets callers charge the extracted bytes (e.g. to a device's quota)
+    before anything is written.
+
+    Raises:
+        UnsafeArchiveError: If extract_zip would refuse the archive.
+        zipfile.BadZipFile: If the archive is corrupt.
+    """
+    with zipfile.ZipFile(source) as archive:
+        return _plan(archive, max_size)[1]
+
+
+def extract_zip(source: BinaryIO, dest: Path, max_size: int = 0) -> int:
+    """
+    Extract a zip archive into a new folder, preserving its structure.
+
+    Every entry is validated before anything is written, so a malicious
+    archive (zip-slip, absolute paths, symlinks, zip bombs) leaves
+    nothing behind.
+
+    Args:
+        source: Seekable archive file.
+        dest: Folder to create; must not exist yet.
+        max_size: Refuse archives expanding beyond this many bytes (0 = no limit
+            besides MAX_EXPANSION_RATIO).
+
+    Returns:
+        Number of files extracted.
+
+    Raises:
+        UnsafeArchiveError: If any entry would escape dest or is a symlink,
+            or the archive has too many entries or expands too far.
+        zipfile.BadZipFile: If the archive is corrupt.
+    """
+    with zipfile.ZipFile(source) as archive:
+        members, _ = _plan(archive, max_size)
+
+        dest.mkdir(parents=True)
+        restrict(dest)
+        root = dest.resolve()
+        count = 0
+        try:
+            for info, parts in members:
+                target = dest.joinpath(*parts)
+                # Belt and braces: sanitized parts can't escape, but check anyway
+                target.resolve().relative_to(root)
+                if info.is_dir():
+                    make_dirs(target, dest)
+                    continue
+                make_dirs(target.parent, dest)
+                with archive.open(info) as src, open(target, "xb") as out:
+                    shutil.copyfileobj(src, out)
+                restrict(target)
+                count += 1
+        except BaseException:
+            shutil.rmtree(dest, ignore_errors=True)
+            raise
+        return count
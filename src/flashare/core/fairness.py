@@ -0,0 +1,127 @@
+"""Soft bandwidth fairness between devices downloading at the same time."""
+
+import threading
+import time
+from collections import deque
+from typing import Callable, Optional
+
+from flashare.config import config
+
+
+# Transfers up to this size are "small" and get a bigger share so they finish snappily
+SMALL_TRANSFER = 5 * 1024**2
+
+# Weight of a device with a small transfer running, relative to 1 for the rest
+SMALL_BOOST = 4.0
+
+# Without a configured limit, capacity is estimated from this many seconds of traffic
+ESTIMATE_WINDOW = 2.0
+
+# Paced lanes may exceed their share of the estimate by this much, so it can grow
+HEADROOM = 1.25
+
+# Never pace a lane below this rate, whatever the estimate says
+MIN_RATE = 64 * 1024
+
+
+class Lane:
+    """One transfer's place in the schedule; see FairShare.open()."""
+
+    def __init__(self, share: "FairShare", device: str, small: bool):
+        self._share = share
+        self.device = device
+        self.small = small
+        self.ready_at = 0.0  # Clock time before which the lane should not send again
+
+    def take(self, count: int) -> float:
+        """
+        Account count bytes about to be sent.
+
+        Returns:
+            Seconds to wait before sending them (0 when not paced).
+        """
+        return self._share._take(self, count)
+
+    def close(self):
+        self._share._close(self)
+
+
+class FairShare:
+    """
+    Divides download bandwidth between devices instead of between connections.
+
+    Every active device gets an equal weight, and a device with a small
+    transfer running gets SMALL_BOOST times as much. A device's rate is split
+    between its own transfers the same way. Rates are recalculated on every
+    chunk, so shares follow devices as they come and go.
+
+    The budget is config.bandwidth_limit. When that is unlimited, capacity is
+    estimated from recent traffic. Lanes are only paced while more than one
+    device is downloading, so a lone device always runs at full speed.
+    """
+
+    def __init__(self, clock: Callable[[], float] = time.monotonic):
+        self._clock = clock
+        self._lock = threading.Lock()
+        self._lanes: list[Lane] = []
+        self._recent: deque[tuple[float, int]] = deque()  # (time, bytes) for the estimate
+
+    def open(self, device: str, size: Optional[int]) -> Lane:
+        """
+        Register a transfer.
+
+        Args:
+            device: Device the bytes go to.
+            size: Bytes the transfer will send, if known; unknown counts as large.
+        """
+        lane = Lane(self, device, size is not None and size <= SMALL_TRANSFER)
+        with self._lock:
+            self._lanes.append(lane)
+        return lane
+
+    def _close(self, lane: Lane):
+        with self._lock:
+            if lane in self._lanes:
+                self._lanes.remove(lane)
+
+    def _budget(self, now: float) -> Optional[float]:
+        """Bytes per second to share out, or None if it can't be known yet."""
+        if config.bandwidth_limit:
+            return float(config.bandwidth_limit)
+        while self._recent and self._recent[0][0] < now - ESTIMATE_WINDOW:
+            self._recent.popleft()
+        if not self._recent:
+            return None
+        return sum(count for _, count in self._recent) / ESTIMATE_WINDOW * HEADROOM
+
+    def _rate(self, lane: Lane, budget: float) -> float:
+        """This lane's share of budget: its device's share, split among that device's lanes."""
+        weights: dict[str, float] = {}
+        for other in self._lanes:
+            weight = SMALL_BOOST if other.small else 1.0
+            weights[other.device] = max(weights.get(other.device, 0.0), weight)
+        device_rate = budget * weights[lane.device] / sum(weights.values())
+
+        own = [other for other in self._lanes if other.device == lane.device]
+        lane_weight = SMALL_BOOST if lane.small else 1.0
+        return device_rate * lane_weight / sum(SMALL_BOOST if o.small else 1.0 for o in own)
+
+    def _take(self, lane: Lane, count: int) -> float:
+        with self._lock:
+            now = self._clock()
+            budget = self._budget(now)
+            self._recent.append((now, count))
+
+            contended = len({other.device for other in self._lanes}) > 1
+            if lane not in self._lanes or budget is None or not (contended or config.bandwidth_limit):
+                lane.ready_at = now
+                return 0.0
+
+            rate = max(self._rate(lane, budget), MIN_RATE)
+            start = max(lane.ready_at, now)
+            lane.ready_at = start + count / rate
+            return start - now
+
+
+# Global download scheduler
+fair_share = FairShare()
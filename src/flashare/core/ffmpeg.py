@@ -203,3 +203,74 @@ def get_video_info(file_path: Path | str) -> Optional[dict]:
         pass
     
     return None
+
+
+# Containers and codecs every mainstream browser (including mobile Safari) plays
+WEB_CONTAINERS = {"mov", "mp4", "m4a", "3gp", "3g2", "mj2"}  # ffprobe's name for MP4 is 'mov,mp4,m4a,...'
+WEB_VIDEO_CODECS = {"h264"}
+WEB_AUDIO_CODECS = {"aac", "mp3"}
+
+
+def is_web_compatible(info: dict) -> bool:
+    """
+    Check if a video plays in browsers as it is.
+    
+    Args:
+        info: FFprobe output, as returned by get_video_info().
+        
+    Returns:
+        True for an MP4 with H.264 video and AAC/MP3 (or no) audio.
+    """
+    containers = set(info.get("format", {}).get("format_name", "").split(","))
+    if not containers & WEB_CONTAINERS:
+        return False
+    
+    streams = info.get("streams", [])
+    video = [s.get("codec_name") for s in streams if s.get("codec_type") == "video"]
+    audio = [s.get("codec_name") for s in streams if s.get("codec_type") == "audio"]
+    return bool(video) and all(c in WEB_VIDEO_CODECS for c in video) and all(c in WEB_AUDIO_CODECS for c in audio)
+
+
+def transcode_for_web(input_path: Path | str, output_path: Path | str) -> Optional[str]:
+    """
+    Write an H.264/AAC MP4 of a video that browsers can play and seek.
+    
+    The output is written next to output_path first and only moved into
+    place when complete, so a half-written version is never served.
+    
+    Args:
+        input_path: Video to transcode (left untouched).
+        output_path: Where the web version goes.
+        
+    Returns:
+        None on success, otherwise the error.
+    """
+    output_path = Path(output_path)
+    partial = output_path.with_suffix(".part.mp4")
+    
+    cmd = [
+        "ffmpeg",
+        "-i", str(input_path),
+        "-map", "0:v:0", "-map", "0:a:0?",  # First video and audio track; subtitles don't fit in MP4
+        "-vcodec", "libx264",
+        "-pix_fmt", "yuv420p",  # 10-bit and 4:4:4 H.264 won't play on phones
+        "-crf", "23",
+        "-preset", config.ffmpeg_preset,
+        "-acodec", "aac",
+        "-movflags", "+faststart",  # Index first, so playback starts before the download ends
+        "-y",
+        str(partial),
+    ]
+    
+    try:
+        result = subprocess.run(cmd, capture_output=True, text=True, timeout=3600)
+        if result.returncode != 0:
+            return f"FFmpeg error: {result.stderr[-500:]}"
+        partial.replace(output_path)
+        return None
+    except subprocess.TimeoutExpired:
+        return "FFmpeg operation timed out"
+    except Exception as e:
+        return str(e)
+    finally:
+        partial.unlink(missing_ok=True)
@@ -0,0 +1,110 @@
+"""Noticing the uploads directory go away (an unplugged drive, a dropped network mount) and come back."""
+
+import errno
+import os
+import threading
+import time
+from pathlib import Path
+from typing import Callable, Optional
+
+from flashare.core.correlation import log
+
+
+# Errors that mean the directory itself may be gone, not just one file in it
+ROOT_ERRNOS = {errno.ENOENT, errno.EIO, errno.ENOTCONN, errno.ESTALE, errno.ENODEV, errno.ENXIO}
+
+PROBE_NAME = ".flashare-probe"
+
+
+class StorageHealth:
+    """
+    Whether the uploads directory is usable, re-checked on every file
+    request, by a periodic probe, and after filesystem errors.
+
+    The directory counts as gone when it is missing, when it was a mount
+    point and no longer is (the drive was unmounted, leaving the empty
+    folder behind), or when a probe file can't be written for one of
+    ROOT_ERRNOS. Listeners are called with (available, reason) on every
+    change, from whichever thread noticed it.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._listeners: list[Callable[[bool, str], None]] = []
+        self._root: Optional[Path] = None
+        self._mount = False
+        self.available = True
+        self.reason = ""
+        self.since = time.time()
+
+    def listen(self, callback: Callable[[bool, str], None]):
+        self._listeners.append(callback)
+
+    def watch(self, root: Path):
+        """Start tracking root, remembering whether it is a mount point."""
+        with self._lock:
+            self._root = Path(root)
+            self._mount = os.path.ismount(root)
+
+    def _problem(self, root: Path, write: bool) -> str:
+        if not root.is_dir():
+            return "the uploads directory is missing"
+        if self._mount and root == self._root and not os.path.ismount(root):
+            return "the drive holding the uploads directory was unmounted"
+        if write:
+            probe = root / f"{PROBE_NAME}-{os.getpid()}"
+            try:
+                probe.write_bytes(b"")
+                probe.unlink()
+            except OSError as e:
+                if e.errno in ROOT_ERRNOS:
+                    return f"the uploads directory can't be written ({e.strerror})"
+        return ""
+
+    def probe(self, root: Path, write: bool = False) -> bool:
+        """
+        Check the directory, recording a change of state.
+
+        Args:
+            root: The uploads directory.
+            write: Also create and delete a probe file; read-only shares skip this.
+
+        Returns:
+            Whether the directory is usable.
+        """
+        problem = self._problem(Path(root), write)
+        self._set(not problem, problem)
+        return not problem
+
+    def check_error(self, root: Path, error: OSError) -> bool:
+        """
+        Whether a filesystem error was the directory going away (it is then marked unavailable).
+
+        Errors about single files, e.g. one deleted from outside, leave the state alone.
+        """
+        if error.errno not in ROOT_ERRNOS:
+            return False
+        return not self.probe(root)
+
+    def _set(self, available: bool, reason: str):
+        with self._lock:
+            if available == self.available:
+                return
+            self.available = available
+            self.reason = reason
+            self.since = time.time()
+        if available:
+            log(f"✅ Uploads directory is back: {self._root}")
+        else:
+            log(f"❌ Storage unavailable: {reason}", "error")
+        for callback in self._listeners:
+            callback(available, reason)
+
+    def status(self) -> dict:
+        """State for /api/status and the 'storage' event."""
+        with self._lock:
+            return {"available": self.available, "reason": self.reason or None, "since": self.since}
+
+
+# Global uploads directory health
+storage_health = StorageHealth()
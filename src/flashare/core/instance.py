@@ -0,0 +1,144 @@
+"""One server per uploads directory: a lock file naming the process that serves it."""
+
+import atexit
+import json
+import os
+import socket
+import time
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Optional
+
+from flashare.core.correlation import log
+
+
+# Hidden folder in the uploads directory, so listings and exports skip it
+LOCK_DIR = ".flashare"
+LOCK_NAME = "uploads.lock"
+
+
+@dataclass
+class LockHolder:
+    """Who the lock file says is serving the directory."""
+    pid: int
+    host: str
+    port: Optional[int] = None
+    started: float = 0.0
+
+    def describe(self) -> str:
+        where = f"PID {self.pid}" + (f" on port {self.port}" if self.port else "")
+        if self.host != socket.gethostname():
+            where += f" on {self.host}"
+        since = time.strftime("%Y-%m-%d %H:%M", time.localtime(self.started)) if self.started else "an unknown time"
+        return f"{where}, since {since}"
+
+
+class AlreadyServed(Exception):
+    """Another live Flashare instance holds the uploads directory."""
+
+    def __init__(self, path: Path, holder: LockHolder):
+        super().__init__(f"{path.parent.parent} is already being served by Flashare ({holder.describe()})")
+        self.path = path
+        self.holder = holder
+
+
+def _pid_alive(pid: int) -> bool:
+    if pid <= 0:
+        return False
+    if os.name == "nt":
+        # os.kill would terminate the process on Windows, so ask for its exit code instead
+        import ctypes
+        handle = ctypes.windll.kernel32.OpenProcess(0x1000, False, pid)  # PROCESS_QUERY_LIMITED_INFORMATION
+        if not handle:
+            return False
+        code = ctypes.c_ulong()
+        try:
+            ctypes.windll.kernel32.GetExitCodeProcess(handle, ctypes.byref(code))
+        finally:
+            ctypes.windll.kernel32.CloseHandle(handle)
+        return code.value == 259  # STILL_ACTIVE
+    try:
+        os.kill(pid, 0)
+    except ProcessLookupError:
+        return False
+    except PermissionError:
+        return True  # Someone else's process, but alive
+    return True
+
+
+def _read(path: Path) -> Optional[LockHolder]:
+    try:
+        data = json.loads(path.read_text())
+        return LockHolder(int(data["pid"]), str(data.get("host", "")), data.get("port"), float(data.get("started", 0)))
+    except (OSError, ValueError, KeyError, TypeError):
+        return None
+
+
+class InstanceLock:
+    """
+    The lock file in an uploads directory while this process serves it.
+
+    A lock whose process has died is stale and is taken over. A lock
+    written on another machine (an uploads directory on a network share)
+    can't be checked, so it counts as held.
+    """
+
+    def __init__(self):
+        self.path: Optional[Path] = None
+
+    def acquire(self, uploads_dir: Path, port: Optional[int] = None, force: bool = False):
+        """
+        Take the lock for uploads_dir, or fail if another live instance has it.
+
+        Args:
+            uploads_dir: Directory about to be served.
+            port: Port this instance will listen on, recorded for the error other instances show.
+            force: Take the lock even from a live instance.
+
+        Raises:
+            AlreadyServed: If another live instance holds it and force is off.
+        """
+        path = Path(uploads_dir) / LOCK_DIR / LOCK_NAME
+        path.parent.mkdir(parents=True, exist_ok=True)
+        record = json.dumps({"pid": os.getpid(), "host": socket.gethostname(), "port": port, "started": time.time()})
+        for _ in range(2):
+            try:
+                fd = os.open(path, os.O_WRONLY | os.O_CREAT | os.O_EXCL, 0o644)
+            except FileExistsError:
+                holder = _read(path)
+                if holder and holder.pid == os.getpid() and holder.host == socket.gethostname():
+                    break  # Already ours, e.g. resumed in the same process
+                if holder and not force and (holder.host != socket.gethostname() or _pid_alive(holder.pid)):
+                    raise AlreadyServed(path, holder)
+                if holder and force:
+                    log(f"⚠️  Taking over {uploads_dir} from another Flashare instance ({holder.describe()})", "warning")
+                elif holder:
+                    log(f"🧹 Removed a stale lock left by PID {holder.pid}")
+                path.unlink(missing_ok=True)
+                continue
+            with os.fdopen(fd, "w") as f:
+                f.write(record)
+            break
+        else:
+            raise AlreadyServed(path, _read(path) or LockHolder(0, socket.gethostname()))
+        self.path = path
+
+    def release(self):
+        """Remove the lock file (and its folder, if empty), if it is still ours."""
+        if self.path is None:
+            return
+        holder = _read(self.path)
+        if holder and holder.pid == os.getpid() and holder.host == socket.gethostname():
+            self.path.unlink(missing_ok=True)
+            try:
+                self.path.parent.rmdir()
+            except OSError:
+                pass  # Not empty
+        self.path = None
+
+
+# This process's lock on its uploads directory
+instance_lock = InstanceLock()
+
+# Also covers sys.exit() between taking the lock and starting the server
+atexit.register(instance_lock.release)
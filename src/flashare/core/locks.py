@@ -0,0 +1,47 @@
+"""Per-file locks, for changes that must not interleave with others on the same files."""
+
+import threading
+from contextlib import contextmanager
+from typing import Iterable, Iterator
+
+
+class FileLocks:
+    """
+    One re-entrant lock per stored name, created on first use.
+
+    Several names are always taken in sorted order, so two callers
+    locking overlapping sets can't deadlock. Locks are re-entrant, so a
+    batch holding a name may call helpers that lock it again.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._locks: dict[str, threading.RLock] = {}
+        self._holders: dict[str, int] = {}
+
+    @contextmanager
+    def hold(self, names: Iterable[str]) -> Iterator[None]:
+        names = sorted(set(names))
+        with self._lock:
+            locks = [self._locks.setdefault(name, threading.RLock()) for name in names]
+            for name in names:
+                self._holders[name] = self._holders.get(name, 0) + 1
+        taken = []
+        try:
+            for lock in locks:
+                lock.acquire()
+                taken.append(lock)
+            yield
+        finally:
+            for lock in reversed(taken):
+                lock.release()
+            with self._lock:
+                for name in names:
+                    self._holders[name] -= 1
+                    if not self._holders[name]:  # Nobody waiting: don't keep a lock per name ever seen
+                        del self._holders[name]
+                        del self._locks[name]
+
+
+# Global per-file locks
+file_locks = FileLocks()
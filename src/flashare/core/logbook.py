@@ -0,0 +1,61 @@
+"""Recent log lines kept in memory, for looking back at a failure after the fact."""
+
+import threading
+import time
+from collections import deque
+from dataclasses import dataclass, field, asdict
+from typing import Optional
+
+
+# Severities from least to most severe
+LEVELS = ("debug", "info", "warning", "error")
+
+# Log lines kept for /api/logs; the oldest are dropped first
+MAX_ENTRIES = 2000
+
+
+@dataclass
+class LogEntry:
+    """One log line."""
+    level: str
+    message: str
+    request_id: str = ""
+    time: float = field(default_factory=time.time)
+
+
+class LogBook:
+    """Thread-safe ring buffer of this session's log lines."""
+
+    def __init__(self, size: int = MAX_ENTRIES):
+        self._lock = threading.Lock()
+        self.entries: deque[LogEntry] = deque(maxlen=size)
+
+    def add(self, level: str, message: str, request_id: str = ""):
+        with self._lock:
+            self.entries.append(LogEntry(level, message, request_id))
+
+    def query(self, since: Optional[float] = None, level: str = "debug", limit: int = 100) -> list[dict]:
+        """
+        Matching lines, newest first.
+
+        Args:
+            since: Only lines logged at or after this Unix time.
+            level: Only lines at least this severe.
+            limit: At most this many lines.
+        """
+        floor = LEVELS.index(level)
+        with self._lock:
+            entries = list(self.entries)
+        matches = []
+        for entry in reversed(entries):
+            if since is not None and entry.time < since:
+                break  # Entries are in time order
+            if LEVELS.index(entry.level) >= floor:
+                matches.append(asdict(entry))
+                if len(matches) >= limit:
+                    break
+        return matches
+
+
+# Global log history
+logbook = LogBook()
@@ -0,0 +1,138 @@
+"""Per-file metadata sidecars for Flashare."""
+
+import json
+import threading
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+from flashare.core.permissions import make_dirs, restrict
+
+
+# Sidecars live in a hidden folder so listings never show them
+META_DIR_NAME = ".meta"
+
+_lock = threading.Lock()
+
+
+def meta_dir() -> Path:
+    """Directory holding the sidecar files."""
+    return config.uploads_dir / META_DIR_NAME
+
+
+def _sidecar_path(filename: str) -> Path:
+    return meta_dir() / f"{filename}.json"
+
+
+def load_meta(filename: str) -> dict:
+    """
+    Read the metadata sidecar for a stored file.
+
+    Args:
+        filename: Stored file name.
+
+    Returns:
+        The metadata dict (empty if there is no sidecar).
+    """
+    try:
+        return json.loads(_sidecar_path(filename).read_text())
+    except (OSError, ValueError):
+        return {}
+
+
+def save_meta(filename: str, meta: dict):
+    """Replace the metadata sidecar for a stored file atomically."""
+    path = _sidecar_path(filename)
+    make_dirs(path.parent, config.uploads_dir)
+
+    # Sidecars hold original names, so they get the same privacy as files
+    tmp_path = path.with_suffix(".tmp")
+    tmp_path.write_text(json.dumps(meta, indent=2))
+    restrict(tmp_path)
+    tmp_path.replace(path)
+
+
+def update_meta(filename: str, **fields) -> dict:
+    """
+    Merge fields into a file's metadata.
+
+    Fields set to None are removed.
+
+    Returns:
+        The updated metadata.
+    """
+    with _lock:
+        meta = load_meta(filename)
+        for key, value in fields.items():
+            if value is None:
+                meta.pop(key, None)
+            else:
+                meta[key] = value
+        save_meta(filename, meta)
+        return meta
+
+
+def delete_meta(filename: str):
+    """Remove a file's sidecar, if any."""
+    _sidecar_path(filename).unlink(missing_ok=True)
+
+
+def rename_meta(old_name: str, new_name: str):
+    """Move a sidecar along with its file."""
+    old_path = _sidecar_path(old_name)
+    if old_path.exists():
+        new_path = _sidecar_path(new_name)
+        make_dirs(new_path.parent, config.uploads_dir)
+        old_path.replace(new_path)
+
+
+def _sidecar_names() -> list[str]:
+    """Names of the stored files that have a sidecar."""
+    directory = meta_dir()
+    if not directory.is_dir():
+        return []
+    return [sidecar.relative_to(directory).as_posix()[:-len(".json")] for sidecar in directory.rglob("*.json")]
+
+
+def _is_current(filename: str, recorded: dict) -> bool:
+    """Whether a recorded checksum still describes the file (same mtime and size)."""
+    try:
+        stat = (config.uploads_dir / filename).stat()
+    except OSError:
+        return False
+    return stat.st_mtime == recorded.get("mtime") and stat.st_size == recorded.get("size")
+
+
+def find_by_sha256(digest: str) -> Optional[str]:
+    """
+    Look up a stored file by content hash using the recorded checksums.
+
+    Entries whose file has changed since it was hashed are ignored.
+
+    Args:
+        digest: Hex SHA-256 of the content.
+
+    Returns:
+        Name of a matching stored file, or None.
+    """
+    digest = digest.lower()
+    for filename in _sidecar_names():
+        recorded = load_meta(filename).get("sha256")
+        if recorded and recorded.get("hex") == digest and _is_current(filename, recorded):
+            return filename
+    return None
+
+
+def sha256_index() -> dict[str, str]:
+    """
+    Every current recorded checksum, for looking up many hashes at once.
+
+    Returns:
+        Hex SHA-256 -> name of a stored file with that content.
+    """
+    index = {}
+    for filename in sorted(_sidecar_names()):
+        recorded = load_meta(filename).get("sha256")
+        if recorded and recorded.get("hex") not in index and _is_current(filename, recorded):
+            index[recorded["hex"]] = filename
+    return index
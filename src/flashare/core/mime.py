@@ -0,0 +1,174 @@
+"""Content types for shared files, filling gaps in the platform's MIME table."""
+
+import mimetypes
+import re
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+
+
+# Types for the extensions the web UI groups by (see get_file_type) that
+# Python's table lacks, or that platforms register differently
+DEFAULT_MIME_TYPES = {
+    ".heic": "image/heic",
+    ".webp": "image/webp",
+    ".svg": "image/svg+xml",
+    ".bmp": "image/bmp",
+    ".mov": "video/quicktime",
+    ".mkv": "video/x-matroska",
+    ".webm": "video/webm",
+    ".m4v": "video/x-m4v",
+    ".flac": "audio/flac",
+    ".aac": "audio/aac",
+    ".ogg": "audio/ogg",
+    ".m4a": "audio/mp4",
+    ".md": "text/markdown",
+    ".csv": "text/csv",
+    ".rtf": "application/rtf",
+    ".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+    ".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+_MIME_PATTERN = re.compile(r"[\w.+-]+/[\w.+-]+")
+
+
+def parse_mime_override(value: str) -> tuple[str, str]:
+    """
+    Parse an 'EXT=TYPE' override such as 'heic=image/heic' or '.log=text/plain'.
+
+    Returns:
+        (extension with its leading dot, lowercased; content type).
+
+    Raises:
+        ValueError: If either half is missing or the type isn't 'type/subtype'.
+    """
+    extension, _, media_type = value.partition("=")
+    extension = "." + extension.strip().lower().lstrip(".")
+    media_type = media_type.strip()
+    if extension == "." or not _MIME_PATTERN.fullmatch(media_type):
+        raise ValueError(f"Invalid MIME override {value!r}, expected e.g. heic=image/heic")
+    return extension, media_type
+
+
+def mime_types() -> dict[str, str]:
+    """The built-in table with the configured overrides on top."""
+    return {**DEFAULT_MIME_TYPES, **config.mime_overrides}
+
+
+def register_mime_types():
+    """
+    Add the table to Python's, called once at startup.
+
+    Static assets, FileResponse and downloads then all agree on types.
+    """
+    for extension, media_type in mime_types().items():
+        mimetypes.add_type(media_type, extension)
+
+
+def guess_mime_type(filename: str) -> str:
+    """
+    Content type of a file from its name.
+
+    The table is consulted first, then the platform's, and anything
+    unknown is application/octet-stream.
+    """
+    return (
+        mime_types().get(Path(filename).suffix.lower())
+        or mimetypes.guess_type(filename)[0]
+        or "application/octet-stream"
+    )
+
+
+# Leading bytes of formats worth recognising, with the type they mean.
+# Checked in order; offset is where the signature starts
+SIGNATURES = (
+    (0, b"\x7fELF", "application/x-executable"),
+    (0, b"\xcf\xfa\xed\xfe", "application/x-mach-binary"),
+    (0, b"\xce\xfa\xed\xfe", "application/x-mach-binary"),
+    (0, b"\xca\xfe\xba\xbe", "application/x-mach-binary"),  # Also Java classes; both are programs
+    (0, b"#!/", "text/x-shellscript"),
+    (0, b"\xff\xd8\xff", "image/jpeg"),
+    (0, b"\x89PNG\r\n\x1a\n", "image/png"),
+    (0, b"GIF87a", "image/gif"),
+    (0, b"GIF89a", "image/gif"),
+    (0, b"BM", "image/bmp"),
+    (0, b"%PDF-", "application/pdf"),
+    (0, b"PK\x03\x04", "application/zip"),
+    (0, b"\x1f\x8b", "application/gzip"),
+    (0, b"7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"),
+    (0, b"Rar!\x1a\x07", "application/vnd.rar"),
+    (0, b"\x1a\x45\xdf\xa3", "video/x-matroska"),  # WebM too
+    (0, b"ID3", "audio/mpeg"),
+    (0, b"fLaC", "audio/flac"),
+    (0, b"OggS", "audio/ogg"),
+    (4, b"ftyp", "video/mp4"),  # ISO media: MP4, MOV, M4A, HEIC...
+)
+
+# Sniffed types that are programs the host might run by mistake
+EXECUTABLE_TYPES = {
+    "application/x-msdownload", "application/x-executable", "application/x-mach-binary", "text/x-shellscript",
+}
+
+# Extensions that honestly name programs, libraries and scripts
+EXECUTABLE_EXTENSIONS = {
+    ".exe", ".dll", ".msi", ".sys", ".scr", ".com", ".efi", ".bin", ".elf", ".so", ".o", ".ko", ".dylib",
+    ".bundle", ".class", ".sh", ".bash", ".zsh", ".command", ".py", ".pl", ".rb", ".run", ".out", ".appimage",
+}
+
+# Types whose content must be what the extension says; others (text,
+# documents, unknown extensions) hold too many formats to judge
+_STRICT_FAMILIES = ("image/", "video/", "audio/", "application/pdf")
+
+# Families ISO media files can legitimately be (.mp4, .mov, .m4a, .heic, .avif)
+_ISO_MEDIA = ("video/", "audio/", "image/")
+
+
+def sniff_mime_type(data: bytes) -> Optional[str]:
+    """Content type recognised from a file's first bytes, or None if unrecognised."""
+    if data[:2] == b"MZ" and len(data) >= 64:
+        # Windows programs; the PE header check keeps text starting with 'MZ' out
+        pe = int.from_bytes(data[60:64], "little")
+        if data[pe:pe + 4] == b"PE\0\0":
+            return "application/x-msdownload"
+    for offset, signature, media_type in SIGNATURES:
+        if data[offset:offset + len(signature)] == signature:
+            return media_type
+    if data[:4] == b"RIFF" and data[8:12] in (b"WEBP", b"WAVE", b"AVI "):
+        return {b"WEBP": "image/webp", b"WAVE": "audio/wav", b"AVI ": "video/x-msvideo"}[data[8:12]]
+    return None
+
+
+def mime_mismatch(filename: str, data: bytes) -> Optional[str]:
+    """
+    Why a file's content contradicts its name, or None if it doesn't.
+
+    Only clear contradictions count, so honest files are never refused:
+    a program under any name but a program's, or media and PDFs whose
+    content is something else entirely. A PNG named .jpg is still an
+    image and passes; content nothing recognises passes too.
+
+    Args:
+        filename: The name the file was uploaded as.
+        data: Its first bytes (a few hundred are plenty).
+    """
+    sniffed = sniff_mime_type(data)
+    if sniffed is None:
+        return None
+    extension = Path(filename).suffix.lower()
+    expected = guess_mime_type(filename)
+
+    if sniffed in EXECUTABLE_TYPES:
+        if extension in EXECUTABLE_EXTENSIONS:
+            return None
+        return f"'{filename}' is a program ({sniffed}), not {expected}"
+
+    if not expected.startswith(_STRICT_FAMILIES):
+        return None
+    if sniffed == "video/mp4" and expected.startswith(_ISO_MEDIA):
+        return None
+    if expected.split("/")[0] == sniffed.split("/")[0] and expected.startswith(("image/", "video/", "audio/")):
+        return None
+    if sniffed == expected:
+        return None
+    return f"'{filename}' contains {sniffed}, not {expected}"
@@ -0,0 +1,53 @@
+"""Machine-readable server events on stdout (--output ndjson), for wrapper scripts and apps."""
+
+import json
+import sys
+import threading
+import time
+from typing import Optional, TextIO
+
+from flashare.core.events import hub
+
+
+class NDJSONOutput:
+    """
+    One JSON object per line on stdout, flushed as each event happens.
+
+    Once started, stdout carries nothing else: sys.stdout is pointed at
+    stderr, so log lines and anything else printed land there instead.
+    Every live-update event is mirrored as {"event": <name>, ...data};
+    the server adds 'listening', 'upload', 'device_connected' and
+    'shutdown'. emit() is a no-op until start() is called.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._out: Optional[TextIO] = None
+
+    @property
+    def enabled(self) -> bool:
+        return self._out is not None
+
+    def start(self):
+        """Take over stdout for events."""
+        if self._out is not None:
+            return
+        self._out = sys.stdout
+        sys.stdout = sys.stderr
+        hub.listen(self.emit)
+
+    def emit(self, event: str, data: Optional[dict] = None, **fields):
+        """Write one event line."""
+        if self._out is None:
+            return
+        line = json.dumps({"event": event, "time": time.time(), **(data or {}), **fields}, default=str)
+        with self._lock:
+            try:
+                self._out.write(line + "\n")
+                self._out.flush()
+            except (OSError, ValueError):
+                pass  # The reading end went away; keep serving
+
+
+# Global event output
+ndjson = NDJSONOutput()
@@ -1,17 +1,30 @@
 """Network utilities for Flashare."""
 
+import ipaddress
+import platform
+import re
 import socket
+import subprocess
 from functools import lru_cache
 
 
-@lru_cache(maxsize=1)
-def get_local_ip() -> str:
-    """
-    Auto-detect the local IP address for QR codes and BLE advertising.
-    
-    Returns:
-        The local IP address as a string.
-    """
+# Adapter names that belong to VMs/containers rather than the real LAN
+VIRTUAL_ADAPTER_PATTERNS = (
+    "vethernet", "hyper-v", "wsl", "virtualbox", "vmware",
+    "docker", "veth", "br-", "virbr", "vboxnet", "utun", "tailscale",
+)
+
+# Address ranges that are virtual whatever the adapter is called: Docker's
+# default bridge and link-local. The rest of 172.16.0.0/12 is also used by
+# real LANs, so WSL and Hyper-V are recognised by adapter name instead
+VIRTUAL_NETWORKS = (
+    ipaddress.ip_network("172.17.0.0/16"),
+    ipaddress.ip_network("169.254.0.0/16"),
+)
+
+
+def _outbound_ip() -> str | None:
+    """Find the IP of the interface used for the default route."""
     try:
         # Create a socket connection to determine the local IP
         s = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
@@ -22,8 +35,65 @@ def get_local_ip() -> str:
         s.close()
         return ip
     except Exception:
-        # Fallback to localhost
-        return "127.0.0.1"
+        return None
+
+
+def _windows_adapter_ips() -> dict[str, str]:
+    """Map IPv4 addresses to adapter names by parsing ipconfig output."""
+    try:
+        output = subprocess.run(
+            ["ipconfig"], capture_output=True, text=True, timeout=3
+        ).stdout
+    except Exception:
+        return {}
+
+    adapters = {}
+    adapter = ""
+    for line in output.splitlines():
+        if line and not line[0].isspace() and line.rstrip().endswith(":"):
+            adapter = line.strip().rstrip(":")
+        elif match := re.search(r"IPv4[^:]*:\s*([\d.]+)", line):
+            adapters[match.group(1)] = adapter
+    return adapters
+
+
+def _is_virtual(ip: str, adapter: str = "") -> bool:
+    """Check whether an address likely belongs to a virtual adapter."""
+    if any(pattern in adapter.lower() for pattern in VIRTUAL_ADAPTER_PATTERNS):
+        return True
+    address = ipaddress.ip_address(ip)
+    return address.is_loopback or any(address in net for net in VIRTUAL_NETWORKS)
+
+
+@lru_cache(maxsize=1)
+def get_local_ip() -> str:
+    """
+    Auto-detect the local IP address for QR codes and BLE advertising.
+    
+    The default-route address wins unless it looks like a virtual adapter
+    (WSL, Hyper-V, Docker), in which case a real LAN address is preferred.
+    
+    Returns:
+        The local IP address as a string.
+    """
+    outbound = _outbound_ip()
+    adapters = _windows_adapter_ips() if platform.system() == "Windows" else {}
+
+    if outbound and not _is_virtual(outbound, adapters.get(outbound, "")):
+        return outbound
+
+    try:
+        candidates = {info[4][0] for info in socket.getaddrinfo(socket.gethostname(), None, socket.AF_INET)}
+    except OSError:
+        candidates = set()
+    candidates |= set(adapters)
+
+    for ip in sorted(candidates):
+        if not _is_virtual(ip, adapters.get(ip, "")):
+            return ip
+
+    # Fallback to the outbound address or localhost
+    return outbound or "127.0.0.1"
 
 
 def get_server_url(port: int = 8000) -> str:
@@ -37,3 +107,29 @@ def get_server_url(port: int = 8000) -> str:
         The complete server URL.
     """
     return f"http://{get_local_ip()}:{port}"
+
+
+def share_origins(port: int) -> set[str]:
+    """
+    Origins the share is reachable under, for CORS.
+    
+    Covers the advertised LAN URL plus localhost, so a page opened under
+    one of them may still call the API through another.
+    
+    Args:
+        port: The server port number.
+    """
+    return {get_server_url(port), f"http://localhost:{port}", f"http://127.0.0.1:{port}"}
+
+
+def parse_origin(value: str) -> str:
+    """
+    Check a browser origin such as 'https://example.com' or 'http://10.0.0.2:8080'.
+    
+    Raises:
+        ValueError: If the value has no http(s) scheme or carries a path.
+    """
+    origin = value.strip().rstrip("/")
+    if not re.fullmatch(r"https?://[^/\s?#]+", origin):
+        raise ValueError(f"Invalid origin {value!r}, expected e.g. https://example.com")
+    return origin.lower()
@@ -0,0 +1,56 @@
+"""Desktop notifications on the host machine, for things the host should know without watching the terminal."""
+
+import json
+import platform
+import shutil
+import subprocess
+import sys
+
+from flashare import __app_name__
+from flashare.config import config
+
+
+# How the host may be told about a device connecting (config.notify_on_connect)
+CONNECT_NOTIFICATIONS = ("bell", "desktop", "none")
+
+
+def desktop_notify(title: str, message: str) -> bool:
+    """
+    Show a notification on the host's desktop, if it has one.
+
+    Best effort: uses osascript on macOS and notify-send on Linux, and
+    does nothing elsewhere, on headless machines or with
+    config.desktop_notifications off. Never blocks or raises.
+
+    Returns:
+        Whether a notification was handed to the desktop.
+    """
+    if not config.desktop_notifications:
+        return False
+    system = platform.system()
+    if system == "Darwin":
+        # JSON strings are valid AppleScript string literals
+        command = ["osascript", "-e", f"display notification {json.dumps(message)} with title {json.dumps(title)}"]
+    elif system == "Linux" and shutil.which("notify-send"):
+        command = ["notify-send", f"--app-name={__app_name__}", title, message]
+    else:
+        return False
+    try:
+        subprocess.Popen(command, stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+    except OSError:
+        return False
+    return True
+
+
+def notify_connected(ip: str):
+    """
+    Tell the host a new device connected, as config.notify_on_connect says.
+
+    The bell goes to stderr, so it rings even when stdout carries
+    machine-readable output.
+    """
+    if config.notify_on_connect == "bell":
+        sys.stderr.write("\a")
+        sys.stderr.flush()
+    elif config.notify_on_connect == "desktop":
+        desktop_notify(__app_name__, f"A device connected from {ip}")
@@ -0,0 +1,187 @@
+"""Sorting uploads into folders from a path template, e.g. '{type}/{year}/{month}-{day}/'."""
+
+import os
+import re
+import string
+import time
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+from flashare.core.paths import sanitize_filename
+from flashare.core.permissions import make_dirs
+
+
+# Placeholders a template may use
+FIELDS = {"type", "year", "month", "day", "device"}
+
+# strftime format --date-folders uses when none is given
+DEFAULT_DATE_FORMAT = "%Y-%m-%d"
+
+# EXIF tags holding when a photo was taken, most specific first
+_EXIF_IFD = 0x8769
+_DATE_TIME_ORIGINAL = 0x9003
+_DATE_TIME = 0x0132
+
+
+class InvalidTemplate(ValueError):
+    """A folder template that can't be used."""
+
+
+def parse_template(template: str) -> list[list[tuple[str, Optional[str]]]]:
+    """
+    Split a template into folder segments of (literal text, field) pairs.
+
+    Templates are relative: absolute paths, drive letters, backslashes,
+    empty segments and '.' or '..' segments are refused, so no template
+    can place a file outside the uploads directory.
+
+    Args:
+        template: e.g. '{type}/{year}/{month}-{day}/'. A trailing slash is optional.
+
+    Returns:
+        One list of (literal, field or None) pairs per folder level.
+
+    Raises:
+        InvalidTemplate: If the template is malformed or could escape.
+    """
+    if not template.strip("/"):
+        raise InvalidTemplate("Template is empty")
+    if template.startswith("/") or "\\" in template or re.match(r"[A-Za-z]:", template):
+        raise InvalidTemplate("Template must be a relative path with '/' separators")
+
+    segments = []
+    for segment in template.rstrip("/").split("/"):
+        if segment in ("", ".", ".."):
+            raise InvalidTemplate(f"Invalid folder '{segment}' in template")
+        try:
+            parsed = list(string.Formatter().parse(segment))
+        except ValueError as e:
+            raise InvalidTemplate(f"Malformed template: {e}")
+        for _, field, spec, conversion in parsed:
+            if field is None:
+                continue
+            if spec or conversion:
+                raise InvalidTemplate(f"Placeholder '{{{field}}}' can't take a format or conversion")
+            if field not in FIELDS:
+                raise InvalidTemplate(
+                    f"Unknown placeholder '{{{field}}}' (use {', '.join(sorted(f'{{{f}}}' for f in FIELDS))})"
+                )
+        segments.append([(literal, field) for literal, field, _, _ in parsed])
+    return segments
+
+
+def render_folder(template: str, file_type: str, when: float, device: str = "") -> str:
+    """
+    Evaluate a template for one file.
+
+    Every rendered folder name is sanitized like a filename, so values
+    such as a device ID can't introduce separators or '..'.
+
+    Args:
+        template: Folder template (see parse_template).
+        file_type: Category of the file, e.g. 'image'.
+        when: Timestamp the date fields come from.
+        device: Uploading device's ID.
+
+    Returns:
+        Relative folder path, e.g. 'image/2024/05-17'.
+    """
+    moment = time.localtime(when)
+    values = {
+        "type": file_type,
+        "year": f"{moment.tm_year:04d}",
+        "month": f"{moment.tm_mon:02d}",
+        "day": f"{moment.tm_mday:02d}",
+        "device": device or "unknown",
+    }
+    folders = []
+    for segment in parse_template(template):
+        rendered = "".join(literal + (values[field] if field else "") for literal, field in segment)
+        folders.append(sanitize_filename(rendered, fallback="unknown"))
+    return "/".join(folders)
+
+
+def date_folder(date_format: str, when: Optional[float] = None) -> str:
+    """
+    Folder for files arriving at a given time, e.g. '2024-06-01' for '%Y-%m-%d'.
+
+    '/' in the format makes nested folders ('%Y/%m' gives '2024/06');
+    every level is sanitized like a filename.
+
+    Args:
+        date_format: strftime format.
+        when: Timestamp; now if None.
+
+    Raises:
+        InvalidTemplate: If the format has no date in it or could escape
+            the uploads directory.
+    """
+    if "%" not in date_format:
+        raise InvalidTemplate("Date folder format needs at least one %-field, e.g. '%Y-%m-%d'")
+    if date_format.startswith("/") or "\\" in date_format:
+        raise InvalidTemplate("Date folder format must be a relative path with '/' separators")
+    try:
+        rendered = time.strftime(date_format, time.localtime(when))
+    except ValueError as e:
+        raise InvalidTemplate(f"Invalid date folder format: {e}")
+    folders = [segment for segment in rendered.split("/") if segment]
+    if not folders or any(segment.startswith(".") for segment in folders):  # Hidden folders stay out of listings
+        raise InvalidTemplate(f"Date folder format renders to an unusable folder ('{rendered}')")
+    return "/".join(sanitize_filename(segment, fallback="unknown") for segment in folders)
+
+
+def capture_time(path: Path) -> Optional[float]:
+    """
+    When a photo was taken, from its EXIF data.
+
+    Returns:
+        The capture time, or None for files without a usable EXIF date
+        (or when Pillow isn't installed).
+    """
+    try:
+        from PIL import Image
+    except ImportError:
+        return None
+
+    try:
+        with Image.open(path) as image:
+            exif = image.getexif()
+            taken = exif.get_ifd(_EXIF_IFD).get(_DATE_TIME_ORIGINAL) or exif.get(_DATE_TIME)
+    except Exception:
+        return None  # Not an image, or corrupt metadata
+
+    try:
+        # Cameras that never had their clock set write '0000:00:00 00:00:00'
+        return time.mktime(time.strptime(str(taken).strip("\x00 "), "%Y:%m:%d %H:%M:%S"))
+    except (ValueError, OverflowError):
+        return None
+
+
+def move_into(path: Path, folder: Path) -> Path:
+    """
+    Move a file into a folder, appending _1, _2, ... until the name is free.
+
+    Like upload creation, claiming the name is exclusive (a hard link
+    fails if the target exists); filesystems without hard links fall
+    back to a checked rename.
+
+    Returns:
+        The file's new path.
+    """
+    make_dirs(folder, config.uploads_dir)
+    target = folder / path.name
+    counter = 1
+    while True:
+        try:
+            os.link(path, target)
+            path.unlink()
+            return target
+        except FileExistsError:
+            pass
+        except OSError:
+            if not target.exists():
+                path.rename(target)
+                return target
+        target = folder / f"{path.stem}_{counter}{path.suffix}"
+        counter += 1
@@ -0,0 +1,73 @@
+"""Cross-platform filename helpers for Flashare."""
+
+import re
+from pathlib import PurePosixPath, PureWindowsPath
+from urllib.parse import quote
+
+
+# Characters Windows Explorer refuses, plus ASCII control characters
+_INVALID_CHARS = re.compile(r'[<>:"/\\|?*\x00-\x1f]')
+
+# Device names Windows reserves regardless of extension (CON.txt is invalid too)
+_RESERVED_NAMES = {
+    "CON", "PRN", "AUX", "NUL",
+    *(f"COM{i}" for i in range(1, 10)),
+    *(f"LPT{i}" for i in range(1, 10)),
+}
+
+
+def sanitize_filename(name: str, fallback: str = "file") -> str:
+    """
+    Make a filename safe on Linux, macOS and Windows.
+
+    Strips any directory components (both separators), replaces characters
+    Windows forbids, trims trailing dots/spaces, and prefixes reserved
+    device names and names starting with '.', which would be hidden
+    (and refused by storage, see is_hidden_name).
+
+    Args:
+        name: Client- or filesystem-provided filename.
+        fallback: Name to use when nothing usable is left.
+
+    Returns:
+        A filename safe to create on every supported platform.
+    """
+    # Handle both separators regardless of the host OS
+    name = PureWindowsPath(PurePosixPath(name).name).name
+
+    name = _INVALID_CHARS.sub("_", name).rstrip(" .").lstrip(" ")
+
+    if name.split(".")[0].upper() in _RESERVED_NAMES or name.startswith("."):
+        name = f"_{name}"
+
+    return name[:255] or fallback
+
+
+def is_hidden_name(name: str) -> bool:
+    """
+    Whether a stored name has a part starting with '.', e.g. '.meta/a.txt.json'.
+
+    Flashare keeps its own state in such folders inside the uploads
+    directory (.meta, .tus, .failed, .web, .flashare), so no request may
+    name one, and '.' and '..' are caught too.
+    """
+    return any(part.startswith(".") for part in re.split(r"[/\\]", name))
+
+
+def content_disposition(filename: str, disposition: str = "attachment") -> str:
+    """
+    Build a Content-Disposition header with a client-safe suggested name.
+
+    Includes an ASCII fallback and an RFC 5987 UTF-8 name so non-ASCII
+    filenames survive on every browser.
+
+    Args:
+        filename: Stored filename.
+        disposition: "attachment" or "inline".
+
+    Returns:
+        Header value.
+    """
+    safe_name = sanitize_filename(filename)
+    ascii_name = safe_name.encode("ascii", "replace").decode("ascii").replace("?", "_")
+    return f"{disposition}; filename=\"{ascii_name}\"; filename*=UTF-8''{quote(safe_name)}"
@@ -0,0 +1,61 @@
+"""Permission bits for received files on shared (multi-user) hosts."""
+
+import os
+import stat
+from pathlib import Path
+
+from flashare.config import config
+
+
+def parse_file_mode(value: str) -> int:
+    """
+    Parse an octal permission string such as '600', '0640' or '0o600'.
+
+    The owner must keep read and write access, or the server could no
+    longer serve or replace its own files.
+
+    Raises:
+        ValueError: If the mode isn't valid octal permission bits.
+    """
+    text = value.strip().lower().removeprefix("0o")
+    try:
+        mode = int(text, 8)
+    except ValueError:
+        raise ValueError(f"Invalid file mode {value!r}, expected octal like 600")
+    if not 0 <= mode <= 0o777:
+        raise ValueError(f"Invalid file mode {value!r}, only permission bits (up to 777) are allowed")
+    if mode & 0o600 != 0o600:
+        raise ValueError(f"File mode {value!r} must give the owner read and write access")
+    return mode
+
+
+def dir_mode(file_mode: int) -> int:
+    """Directory bits matching a file mode: search wherever read is allowed (600 -> 700)."""
+    return file_mode | (file_mode & 0o444) >> 2
+
+
+def restrict(path: Path):
+    """
+    Apply config.file_mode to a file, or its directory form to a folder.
+
+    A no-op when no mode is configured or the platform ignores modes.
+    """
+    if config.file_mode is None or os.name == "nt":
+        return
+    mode = dir_mode(config.file_mode) if path.is_dir() else config.file_mode
+    if stat.S_IMODE(path.stat().st_mode) != mode:
+        path.chmod(mode)
+
+
+def make_dirs(path: Path, root: Path):
+    """
+    Create a folder under root, restricting every level that had to be made.
+
+    Args:
+        path: Folder to create.
+        root: Existing ancestor whose own mode is left alone.
+    """
+    missing = [p for p in (path, *path.parents) if not p.exists() and p != root and root in p.parents]
+    path.mkdir(parents=True, exist_ok=True)
+    for folder in missing:
+        restrict(folder)
@@ -0,0 +1,85 @@
+"""
+Decisions made for each file on its way into the share, as one event stream.
+
+Both ways files enter the share report here: staging by 'flashare send'
+and uploads to the server. Each decision is one event, covering filtering,
+name sanitizing, duplicate names, policy checks, dedupe hits and the final
+location. --trace output, JSON trace lines and --dry-run tables all read
+this same stream.
+"""
+
+import json
+import threading
+import time
+from dataclasses import dataclass, field, asdict
+from typing import Callable, Optional
+
+from flashare.core.correlation import current_request_id
+from flashare.core.paths import sanitize_filename
+
+
+@dataclass
+class Decision:
+    """One decision made for one file."""
+    origin: str  # 'send' (CLI staging) or 'upload' (server)
+    file: str  # The file as the user or client named it
+    step: str  # filter, sanitize, duplicate, optimize, policy, dedupe, organize, stored
+    outcome: str  # e.g. 'excluded', 'renamed', 'rejected', 'linked', 'saved'
+    detail: str = ""
+    time: float = field(default_factory=time.time)
+    request_id: str = field(default_factory=current_request_id)  # '' for 'send'
+
+    def to_text(self) -> str:
+        line = f"🔎 [{self.origin}] {self.file}: {self.step} → {self.outcome}"
+        if self.detail:
+            line += f" ({self.detail})"
+        return f"{line} [{self.request_id}]" if self.request_id else line
+
+    def to_json(self) -> str:
+        return json.dumps(asdict(self))
+
+
+class Pipeline:
+    """Fans decisions out to listeners; reporting costs nothing when nobody listens."""
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._listeners: list[Callable[[Decision], None]] = []
+
+    def subscribe(self, listener: Callable[[Decision], None]):
+        with self._lock:
+            self._listeners.append(listener)
+
+    def unsubscribe(self, listener: Callable[[Decision], None]):
+        with self._lock:
+            self._listeners.remove(listener)
+
+    def decide(self, origin: str, file: str, step: str, outcome: str, detail: str = ""):
+        """Report one decision to every listener."""
+        with self._lock:
+            listeners = list(self._listeners)
+        if not listeners:
+            return
+        decision = Decision(origin, file, step, outcome, detail)
+        for listener in listeners:
+            listener(decision)
+
+    def sanitize(self, origin: str, name: str, file: Optional[str] = None) -> str:
+        """
+        sanitize_filename, reporting whether the name had to change.
+
+        Args:
+            origin: 'send' or 'upload'.
+            name: Name to sanitize.
+            file: What the decision is reported under (default: name).
+        """
+        safe = sanitize_filename(name)
+        if safe != name:
+            self.decide(origin, file or name, "sanitize", "renamed", safe)
+        else:
+            self.decide(origin, file or name, "sanitize", "unchanged")
+        return safe
+
+
+# Global decision stream
+pipeline = Pipeline()
@@ -0,0 +1,89 @@
+"""Byte counting with throttled progress callbacks."""
+
+import time
+from typing import BinaryIO, Callable
+
+
+# Report at least this often while bytes are flowing
+DEFAULT_INTERVAL = 0.1
+
+
+class ProgressCounter:
+    """
+    Counts bytes and reports them to a callback, at most once per interval.
+
+    The callback receives (delta, total): bytes since the last report and
+    bytes so far. close() reports whatever is left, so the deltas always
+    add up to the final total.
+    """
+
+    def __init__(self, callback: Callable[[int, int], None], interval: float = DEFAULT_INTERVAL):
+        self.callback = callback
+        self.interval = interval
+        self.total = 0
+        self._reported = 0
+        self._last = time.monotonic()
+
+    def update(self, count: int):
+        """Count bytes, reporting if the interval has elapsed."""
+        self.total += count
+        now = time.monotonic()
+        if now - self._last >= self.interval:
+            self._last = now
+            self._report()
+
+    def _report(self):
+        delta = self.total - self._reported
+        if delta:
+            self._reported = self.total
+            self.callback(delta, self.total)
+
+    def close(self):
+        """Report any bytes not yet reported."""
+        self._report()
+
+
+class CountingReader:
+    """Wrap a binary file so reads are counted."""
+
+    def __init__(self, raw: BinaryIO, callback: Callable[[int, int], None], interval: float = DEFAULT_INTERVAL):
+        self.raw = raw
+        self.counter = ProgressCounter(callback, interval)
+
+    def read(self, size: int = -1) -> bytes:
+        data = self.raw.read(size)
+        self.counter.update(len(data))
+        return data
+
+    def close(self):
+        self.counter.close()
+        self.raw.close()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.close()
+
+
+class CountingWriter:
+    """Wrap a binary file so writes are counted."""
+
+    def __init__(self, raw: BinaryIO, callback: Callable[[int, int], None], interval: float = DEFAULT_INTERVAL):
+        self.raw = raw
+        self.counter = ProgressCounter(callback, interval)
+
+    def write(self, data) -> int:
+        written = self.raw.write(data)
+        self.counter.update(len(data) if written is None else written)
+        return written
+
+    def close(self):
+        self.counter.close()
+        self.raw.close()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.close()
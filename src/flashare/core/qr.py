@@ -1,12 +1,88 @@
 """QR code generation utilities for Flashare."""
 
 import io
+import os
+import sys
 from typing import Optional
 
 import qrcode
 from qrcode.constants import ERROR_CORRECT_M
 
-from flashare.core.network import get_server_url
+from flashare.core.access import share_url, short_url
+
+
+# Terminal rendering styles; "auto" picks "color" when the terminal supports it
+QR_STYLES = ("auto", "block", "ascii", "color")
+
+# Background escapes for dark/light modules, by color capability
+_QR_BACKGROUNDS = {
+    "truecolor": ("\x1b[48;2;0;0;0m", "\x1b[48;2;255;255;255m"),
+    "256": ("\x1b[48;5;16m", "\x1b[48;5;231m"),
+}
+_ANSI_RESET = "\x1b[0m"
+
+
+def _qr_matrix(data: str, quiet_zone: int = 2) -> list[list[bool]]:
+    """Encode data and return its module matrix, quiet zone included."""
+    qr = qrcode.QRCode(
+        version=1,
+        error_correction=ERROR_CORRECT_M,
+        box_size=1,
+        border=quiet_zone,
+    )
+    qr.add_data(data)
+    qr.make(fit=True)
+    return qr.get_matrix()
+
+
+def detect_color_support() -> Optional[str]:
+    """
+    Detect whether the terminal can draw explicit background colors.
+    
+    Returns:
+        "truecolor", "256", or None when colors are unavailable or disabled.
+    """
+    if os.environ.get("NO_COLOR") or not sys.stdout.isatty():
+        return None
+    
+    if os.environ.get("COLORTERM", "").lower() in ("truecolor", "24bit") or os.environ.get("WT_SESSION"):
+        return "truecolor"
+    if "256" in os.environ.get("TERM", ""):
+        return "256"
+    return None
+
+
+def render_qr_terminal(data: str, style: str = "auto", quiet_zone: int = 2) -> str:
+    """
+    Render a QR code for the terminal.
+    
+    "color" paints modules with pure black/white backgrounds, so contrast
+    doesn't depend on the terminal theme. "block" and "ascii" draw dark
+    modules with characters in the foreground color.
+    
+    Args:
+        data: Text to encode.
+        style: One of QR_STYLES.
+        quiet_zone: Blank border width in modules (scanners want 2-4).
+        
+    Returns:
+        The rendered QR code, possibly containing ANSI escapes.
+    """
+    # Without color support, fall back to the character-based rendering
+    color = detect_color_support() if style in ("auto", "color") else None
+    
+    modules = _qr_matrix(data, quiet_zone)
+    
+    if color:
+        dark, light = _QR_BACKGROUNDS[color]
+        return "\n".join(
+            "".join(f"{dark if cell else light}  " for cell in row) + _ANSI_RESET
+            for row in modules
+        )
+    
+    # Use block characters for better visibility, '#' where Unicode is unsafe
+    mark = "##" if style == "ascii" else "██"
+    return "\n".join("".join(mark if cell else "  " for cell in row) for row in modules)
 
 
 def generate_qr_ascii(url: Optional[str] = None, port: int = 8000) -> str:
@@ -20,29 +96,7 @@ def generate_qr_ascii(url: Optional[str] = None, port: int = 8000) -> str:
     Returns:
         ASCII art representation of the QR code.
     """
-    url = url or get_server_url(port)
-    
-    qr = qrcode.QRCode(
-        version=1,
-        error_correction=ERROR_CORRECT_M,
-        box_size=1,
-        border=2,
-    )
-    qr.add_data(url)
-    qr.make(fit=True)
-    
-    # Generate ASCII representation
-    modules = qr.get_matrix()
-    
-    lines = []
-    for row in modules:
-        line = ""
-        for cell in row:
-            # Use block characters for better visibility
-            line += "██" if cell else "  "
-        lines.append(line)
-    
-    return "\n".join(lines)
+    return render_qr_terminal(url or share_url(port), style="block")
 
 
 def generate_qr_svg(url: Optional[str] = None, port: int = 8000) -> str:
@@ -56,7 +110,7 @@ def generate_qr_svg(url: Optional[str] = None, port: int = 8000) -> str:
     Returns:
         SVG string of the QR code.
     """
-    url = url or get_server_url(port)
+    url = url or share_url(port)
     
     qr = qrcode.QRCode(
         version=1,
@@ -77,18 +131,23 @@ def generate_qr_svg(url: Optional[str] = None, port: int = 8000) -> str:
     return buffer.getvalue().decode('utf-8')
 
 
-def generate_qr_png_bytes(url: Optional[str] = None, port: int = 8000) -> bytes:
+def generate_qr_png_bytes(
+    url: Optional[str] = None,
+    port: int = 8000,
+    size: Optional[int] = None,
+) -> bytes:
     """
     Generate a PNG QR code as bytes.
     
     Args:
         url: The URL to encode. If None, uses the auto-detected server URL.
         port: Server port (used if url is None).
+        size: Optional output width/height in pixels.
         
     Returns:
         PNG image bytes.
     """
-    url = url or get_server_url(port)
+    url = url or share_url(port)
     
     qr = qrcode.QRCode(
         version=1,
@@ -101,25 +160,52 @@ def generate_qr_png_bytes(url: Optional[str] = None, port: int = 8000) -> bytes:
     
     img = qr.make_image(fill_color="black", back_color="white")
     
+    if size:
+        # Nearest-neighbour keeps module edges crisp for scanners
+        from PIL import Image
+        img = img.get_image().resize((size, size), Image.NEAREST)
+    
     buffer = io.BytesIO()
     img.save(buffer, format='PNG')
     return buffer.getvalue()
 
 
-def get_qr_data(port: int = 8000) -> dict:
+def generate_wifi_payload(ssid: str, password: str = "", security: str = "WPA") -> str:
+    """
+    Build the standard WIFI: payload understood by phone cameras.
+    
+    Args:
+        ssid: Network name.
+        password: Network password (empty for open networks).
+        security: WPA, WEP or nopass.
+        
+    Returns:
+        Payload string to encode as a QR code.
+    """
+    escape = lambda value: "".join(f"\\{c}" if c in '\\;,:"' else c for c in value)
+    
+    if not password:
+        security = "nopass"
+    
+    return f"WIFI:T:{security};S:{escape(ssid)};P:{escape(password)};;"
+
+
+def get_qr_data(port: int = 8000, url: Optional[str] = None) -> dict:
     """
     Get QR code data for API response.
     
     Args:
         port: Server port.
+        url: Link to encode; the share link by default.
         
     Returns:
-        Dictionary with URL and QR representations.
+        Dictionary with URL, short link and QR representations.
     """
-    url = get_server_url(port)
+    url = url or share_url(port)
     
     return {
         "url": url,
+        "short_url": short_url(port),
         "ascii": generate_qr_ascii(url),
         "svg": generate_qr_svg(url),
     }
@@ -0,0 +1,133 @@
+"""Keeping partial files of failed uploads for diagnosis."""
+
+import json
+import shutil
+import time
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+from flashare.core.permissions import make_dirs
+
+
+NOTE_SUFFIX = ".note.json"
+
+
+def failed_dir() -> Path:
+    """Hidden folder in the uploads directory holding failed uploads."""
+    return config.uploads_dir / ".failed"
+
+
+def _entries() -> list[tuple[Path, dict]]:
+    """Preserved partials with their notes, oldest first."""
+    folder = failed_dir()
+    if not folder.is_dir():
+        return []
+    entries = []
+    for note_path in folder.glob(f"*{NOTE_SUFFIX}"):
+        partial = note_path.with_name(note_path.name.removesuffix(NOTE_SUFFIX))
+        try:
+            note = json.loads(note_path.read_text())
+        except (OSError, ValueError):
+            note = {}
+        entries.append((partial, note))
+    return sorted(entries, key=lambda entry: entry[1].get("failed_at", 0))
+
+
+def _remove(partial: Path):
+    partial.unlink(missing_ok=True)
+    partial.with_name(partial.name + NOTE_SUFFIX).unlink(missing_ok=True)
+
+
+def _evict(limit: int):
+    """Remove the oldest partials until the folder fits in limit bytes."""
+    entries = _entries()
+    sizes = [partial.stat().st_size if partial.exists() else 0 for partial, _ in entries]
+    total = sum(sizes)
+    for (partial, _), size in zip(entries, sizes):
+        if total <= limit:
+            break
+        _remove(partial)
+        total -= size
+
+
+def quarantine(
+    path: Path,
+    name: str,
+    received: int,
+    expected: Optional[int],
+    error: str,
+    client: str,
+) -> Path:
+    """
+    Move a failed upload's partial file aside instead of deleting it.
+
+    A JSON note next to it records what was known when the upload failed.
+    The oldest partials are evicted once the folder exceeds
+    config.failed_uploads_limit.
+
+    Args:
+        path: The partial file.
+        name: Name the client uploaded it as.
+        received: Bytes received before the failure.
+        expected: Declared size, if the client sent one.
+        error: What went wrong.
+        client: Uploader's IP address.
+
+    Returns:
+        Where the partial now lives.
+    """
+    folder = failed_dir()
+    make_dirs(folder, config.uploads_dir)
+
+    stamp = time.strftime("%Y%m%d-%H%M%S")
+    target = folder / f"{stamp}_{name}"
+    counter = 1
+    while target.exists():
+        target = folder / f"{stamp}-{counter}_{name}"
+        counter += 1
+
+    shutil.move(path, target)
+    note = {
+        "name": name,
+        "received": received,
+        "expected": expected,
+        "error": error,
+        "client": client,
+        "failed_at": time.time(),
+    }
+    target.with_name(target.name + NOTE_SUFFIX).write_text(json.dumps(note, indent=2))
+
+    _evict(config.failed_uploads_limit)
+    return target
+
+
+def list_failed() -> list[dict]:
+    """
+    Preserved failed uploads, oldest first.
+
+    Each entry's 'offset' is how many bytes are on disk: where a resuming
+    client would continue from.
+    """
+    result = []
+    for partial, note in _entries():
+        if not partial.exists():
+            continue
+        result.append({**note, "id": partial.name, "offset": partial.stat().st_size})
+    return result
+
+
+def purge_failed() -> int:
+    """
+    Delete every preserved failed upload.
+
+    Returns:
+        How many were removed.
+    """
+    entries = _entries()
+    for partial, _ in entries:
+        _remove(partial)
+    folder = failed_dir()
+    if folder.is_dir() and not any(folder.iterdir()):
+        folder.rmdir()
+    return len(entries)
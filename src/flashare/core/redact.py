@@ -0,0 +1,95 @@
+"""Redaction of secrets and personal paths from diagnostic output."""
+
+import logging
+import re
+from dataclasses import asdict, is_dataclass
+from pathlib import Path, PurePath
+
+from flashare.config import config
+from flashare.core.access import share_access
+
+
+REDACTED = "***"
+
+# Words in a key name that mark its value as a credential
+_SECRET_WORDS = {"password", "passwd", "secret", "token", "pin", "key", "credential", "credentials"}
+
+
+# Credentials that travel in request paths: one-time QR tokens and the share key
+_REQUEST_SECRETS = re.compile(r"(/connect/)[^/?\s\"]+|([?&]key=)[^&\s\"]+")
+
+
+def is_secret_key(key) -> bool:
+    """Whether a key like 'admin_token' or 'webhookSecret' names a credential."""
+    words = re.findall(r"[a-z]+", re.sub(r"([a-z])([A-Z])", r"\1_\2", str(key)).lower())
+    return any(word in _SECRET_WORDS for word in words)
+
+
+def _secret_values() -> list[str]:
+    """Configured secrets and the share key, so they are scrubbed even where keys don't give them away."""
+    return [
+        value for key, value in vars(config).items()
+        if is_secret_key(key) and isinstance(value, str) and value
+    ] + [share_access.key]
+
+
+def redact_text(text: str) -> str:
+    """
+    Scrub a string of configured secrets and the user's home directory.
+
+    Args:
+        text: Free-form text (log lines, check output, JSON).
+
+    Returns:
+        The text with secrets replaced and home paths shortened to '~'.
+    """
+    for secret in _secret_values():
+        text = text.replace(secret, REDACTED)
+    home = str(Path.home())
+    if home not in ("", "/"):
+        text = text.replace(home, "~")
+    return text
+
+
+def redact(value):
+    """
+    Recursively redact a JSON-like structure.
+
+    Values under secret-looking keys are replaced outright; every other
+    string goes through redact_text. Paths and dataclasses are converted
+    to plain data first.
+
+    Args:
+        value: Dict, list, dataclass, path or scalar.
+
+    Returns:
+        A redacted copy, safe to share.
+    """
+    if is_dataclass(value) and not isinstance(value, type):
+        value = asdict(value)
+    if isinstance(value, dict):
+        return {
+            key: (REDACTED if value[key] and is_secret_key(key) else redact(value[key]))
+            for key in value
+        }
+    if isinstance(value, (list, tuple, set)):
+        return [redact(item) for item in value]
+    if isinstance(value, PurePath):
+        value = str(value)
+    if isinstance(value, str):
+        return redact_text(value)
+    return value
+
+
+def redact_request_line(text: str) -> str:
+    """Scrub one-time QR tokens and share keys from a request path or log line."""
+    return _REQUEST_SECRETS.sub(lambda match: (match.group(1) or match.group(2)) + REDACTED, text)
+
+
+class AccessLogFilter(logging.Filter):
+    """Scrub credentials from the web server's request log before it is written (uvicorn.access, info level)."""
+
+    def filter(self, record: logging.LogRecord) -> bool:
+        if isinstance(record.args, tuple):
+            record.args = tuple(redact_request_line(arg) if isinstance(arg, str) else arg for arg in record.args)
+        return True
@@ -0,0 +1,124 @@
+"""Registration with a relay server that hands out short share codes."""
+
+import json
+import threading
+import urllib.error
+import urllib.parse
+import urllib.request
+from dataclasses import dataclass
+from typing import Optional
+
+from flashare import __version__
+from flashare.config import config
+
+
+# Re-register this long before the relay would forget the code
+RENEW_MARGIN = 0.5
+
+
+@dataclass
+class RelayCode:
+    """A short code the relay maps to this server."""
+    code: str
+    url: str
+    ttl: int
+
+
+class RelayError(Exception):
+    """The relay could not be reached or refused the registration."""
+
+
+def _call(method: str, path: str, body: Optional[dict] = None, timeout: float = 10.0) -> dict:
+    """Send a JSON request to the configured relay."""
+    request = urllib.request.Request(
+        f"{config.relay_url.rstrip('/')}{path}",
+        data=json.dumps(body).encode() if body is not None else None,
+        headers={"Content-Type": "application/json", "User-Agent": f"flashare/{__version__}"},
+        method=method,
+    )
+    try:
+        with urllib.request.urlopen(request, timeout=timeout) as response:
+            return json.load(response) if response.status != 204 else {}
+    except urllib.error.HTTPError as e:
+        raise RelayError(f"Relay refused the request (HTTP {e.code})")
+    except (OSError, ValueError) as e:
+        raise RelayError(f"Could not reach relay ({e})")
+
+
+class RelayClient:
+    """
+    Keeps this server registered under one short code for the session.
+
+    The relay forgets codes that aren't renewed within their TTL, so a
+    crashed server never leaves a stale code pointing at a dead address.
+    Renewals ask for the same code again, so it stays stable while the
+    relay still holds it.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self.current: Optional[RelayCode] = None
+        self.error: Optional[str] = None
+
+    def register(self, target: str, pin: Optional[str] = None) -> RelayCode:
+        """
+        Register (or renew) the code for a server URL.
+
+        Args:
+            target: LAN URL devices on the same network should be sent to.
+            pin: The share's PIN, if PIN access is on, so the relay can
+                resolve it too. Renewals carry a rotated PIN.
+
+        Returns:
+            The code now mapped to target.
+
+        Raises:
+            RelayError: If the relay is unreachable or replies oddly.
+        """
+        with self._lock:
+            previous = self.current.code if self.current else None
+        try:
+            reply = _call("POST", "/api/register", {
+                "target": target, "code": previous, "pin": pin, "version": __version__,
+            })
+            try:
+                code = RelayCode(str(reply["code"]), str(reply["url"]), int(reply.get("ttl", 300)))
+            except (KeyError, TypeError, ValueError):
+                raise RelayError("Relay sent an unexpected reply")
+        except RelayError as e:
+            with self._lock:
+                self.error = str(e)
+            raise
+        with self._lock:
+            self.current, self.error = code, None
+        return code
+
+    def renew_after(self) -> float:
+        """Seconds until the current code should be renewed."""
+        with self._lock:
+            return self.current.ttl * RENEW_MARGIN if self.current else 30.0
+
+    def release(self):
+        """Give the code back; failures are ignored since it expires anyway."""
+        with self._lock:
+            code, self.current = self.current, None
+        if code:
+            try:
+                _call("DELETE", f"/api/register/{urllib.parse.quote(code.code)}", timeout=3.0)
+            except RelayError:
+                pass
+
+    def status(self) -> Optional[dict]:
+        """Code and link for /api/status, or None when not using a relay."""
+        if not config.use_relay:
+            return None
+        with self._lock:
+            return {
+                "code": self.current.code if self.current else None,
+                "url": self.current.url if self.current else None,
+                "error": self.error,
+            }
+
+
+# Global relay registration
+relay = RelayClient()
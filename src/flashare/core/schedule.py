@@ -0,0 +1,100 @@
+"""Persistent queue of files scheduled for deletion at a later time."""
+
+import json
+import threading
+import time
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+
+
+def schedule_path() -> Path:
+    """Location of the deletion queue in the data directory."""
+    return config.data_dir / "scheduled-deletions.json"
+
+
+class DeletionSchedule:
+    """
+    Deletion times per stored file, kept across restarts.
+
+    The data directory is shared by every share on the machine, so times
+    are recorded per uploads directory. Reads come from memory; writes
+    re-read the file first so other shares' entries are never clobbered.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._pending: Optional[dict[str, float]] = None
+
+    def _load(self) -> dict:
+        try:
+            return json.loads(schedule_path().read_text())
+        except (OSError, ValueError):
+            return {}
+
+    def _save(self, data: dict):
+        path = schedule_path()
+        path.parent.mkdir(parents=True, exist_ok=True)
+
+        tmp_path = path.with_suffix(".tmp")
+        tmp_path.write_text(json.dumps(data, indent=2))
+        tmp_path.replace(path)
+
+    def _key(self) -> str:
+        return str(config.uploads_dir.resolve())
+
+    def _current(self) -> dict[str, float]:
+        """This share's entries, loaded on first use."""
+        if self._pending is None:
+            self._pending = self._load().get(self._key(), {})
+        return self._pending
+
+    def _update(self, change) -> bool:
+        """Apply change(pending) to the on-disk entries and the cache."""
+        data = self._load()
+        pending = data.setdefault(self._key(), {})
+        changed = change(pending)
+        if changed:
+            if not pending:
+                del data[self._key()]
+            self._save(data)
+        self._pending = pending
+        return changed
+
+    def schedule(self, filename: str, when: float):
+        """Schedule (or reschedule) a file's deletion at a unix time."""
+        def set_time(pending: dict) -> bool:
+            pending[filename] = when
+            return True
+
+        with self._lock:
+            self._update(set_time)
+
+    def cancel(self, filename: str) -> bool:
+        """
+        Drop a file from the queue.
+
+        Returns:
+            True if it was scheduled.
+        """
+        with self._lock:
+            # Every deletion cancels, so skip the disk for unscheduled files
+            if filename not in self._current():
+                return False
+            return self._update(lambda pending: pending.pop(filename, None) is not None)
+
+    def deletes_at(self, filename: str) -> Optional[float]:
+        """When a file will be deleted, or None if it isn't scheduled."""
+        with self._lock:
+            return self._current().get(filename)
+
+    def due(self, now: Optional[float] = None) -> list[str]:
+        """Files whose deletion time has passed."""
+        now = time.time() if now is None else now
+        with self._lock:
+            return [filename for filename, when in self._current().items() if when <= now]
+
+
+# Global deletion schedule
+deletions = DeletionSchedule()
@@ -0,0 +1,64 @@
+"""Record of rejected requests, for spotting probing and abuse."""
+
+import threading
+import time
+from collections import Counter, deque
+from dataclasses import dataclass, field, asdict
+
+from flashare.config import config
+from flashare.core.correlation import current_request_id, log
+
+
+# Statuses that mean a request was refused rather than merely wrong
+AUDITED_STATUSES = {401, 403, 413, 415, 429}
+
+# Rejections kept in memory for /api/security-events
+MAX_EVENTS = 500
+
+
+@dataclass
+class SecurityEvent:
+    """One refused request."""
+    status: int
+    client: str
+    method: str
+    path: str
+    reason: str
+    time: float = field(default_factory=time.time)
+    request_id: str = field(default_factory=current_request_id)
+
+
+class SecurityLog:
+    """Thread-safe counts and recent history of refused requests this session."""
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self.events: deque[SecurityEvent] = deque(maxlen=MAX_EVENTS)
+        self.by_status: Counter = Counter()
+        self.by_client: Counter = Counter()
+
+    def record(self, status: int, client: str, method: str, path: str, reason: str):
+        """Count a refused request and, if enabled, log it."""
+        event = SecurityEvent(status, client, method, path, reason)
+        with self._lock:
+            self.events.append(event)
+            self.by_status[status] += 1
+            self.by_client[client] += 1
+        if config.log_rejections:
+            log(f"🚫 {status} {method} {path} from {client}: {reason}", "warning")
+
+    def summary(self, recent: int = 20) -> dict:
+        """Totals per status and per client, plus the latest events."""
+        with self._lock:
+            return {
+                "total": sum(self.by_status.values()),
+                "by_status": {str(status): count for status, count in sorted(self.by_status.items())},
+                "top_clients": [
+                    {"client": client, "count": count} for client, count in self.by_client.most_common(10)
+                ],
+                "recent": [asdict(event) for event in list(self.events)[-recent:]],
+            }
+
+
+# Global security event log
+security = SecurityLog()
@@ -0,0 +1,111 @@
+"""Persisted share session state for Flashare."""
+
+import json
+import time
+from dataclasses import dataclass, field, asdict
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+
+
+SESSION_VERSION = 1
+
+
+@dataclass
+class SharedFile:
+    """A file staged by send: where it came from and what it's called."""
+    source: str
+    name: str
+
+
+@dataclass
+class SessionState:
+    """Everything needed to bring a share back after a restart."""
+    host: str
+    port: int
+    uploads_dir: str
+    files: list[SharedFile] = field(default_factory=list)
+    exclude_patterns: list = field(default_factory=list)
+    include_patterns: list = field(default_factory=list)
+    use_default_excludes: bool = True
+    updated: float = field(default_factory=time.time)
+    version: int = SESSION_VERSION
+
+    @classmethod
+    def from_config(cls) -> "SessionState":
+        """Capture the current configuration as a new session."""
+        return cls(
+            host=config.host,
+            port=config.port,
+            uploads_dir=str(config.uploads_dir),
+            exclude_patterns=list(config.exclude_patterns),
+            include_patterns=list(config.include_patterns),
+            use_default_excludes=config.use_default_excludes,
+        )
+
+    def apply_to_config(self):
+        """Restore the saved settings onto the global config."""
+        config.host = self.host
+        config.port = self.port
+        config.uploads_dir = Path(self.uploads_dir)
+        config.exclude_patterns = list(self.exclude_patterns)
+        config.include_patterns = list(self.include_patterns)
+        config.use_default_excludes = self.use_default_excludes
+
+
+def session_path() -> Path:
+    """Location of the session file in the data directory."""
+    return config.data_dir / "session.json"
+
+
+def save_session(state: SessionState):
+    """
+    Write the session atomically so a crash never leaves a torn file.
+
+    Args:
+        state: Session to persist.
+    """
+    state.updated = time.time()
+    path = session_path()
+    path.parent.mkdir(parents=True, exist_ok=True)
+
+    tmp_path = path.with_suffix(".tmp")
+    tmp_path.write_text(json.dumps(asdict(state), indent=2))
+    tmp_path.replace(path)
+
+
+def load_session() -> Optional[SessionState]:
+    """
+    Load the saved session, if any.
+
+    Returns:
+        The session, or None when missing, unreadable or from another version.
+    """
+    try:
+        data = json.loads(session_path().read_text())
+    except (OSError, ValueError):
+        return None
+
+    if data.get("version") != SESSION_VERSION:
+        return None
+
+    try:
+        data["files"] = [SharedFile(**f) for f in data.get("files", [])]
+        return SessionState(**data)
+    except TypeError:
+        return None
+
+
+def discard_session() -> bool:
+    """
+    Delete the saved session.
+
+    Returns:
+        True if a session file was removed.
+    """
+    try:
+        session_path().unlink()
+        return True
+    except FileNotFoundError:
+        return False
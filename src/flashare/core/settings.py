@@ -0,0 +1,62 @@
+"""Settings chosen during setup, saved in the data directory and applied at every start."""
+
+import json
+import os
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+
+
+SETTINGS_FILE = "settings.json"
+
+
+def settings_path() -> Path:
+    return config.data_dir / SETTINGS_FILE
+
+
+def settings_exist() -> bool:
+    """Whether setup has run (or was skipped) on this machine."""
+    return settings_path().is_file()
+
+
+def load_settings() -> dict:
+    """
+    Apply the saved settings to config, before command-line flags are parsed.
+
+    Flags still win, since their defaults are read from config afterwards.
+    A missing or unreadable file changes nothing.
+
+    Returns:
+        The settings found.
+    """
+    try:
+        settings = json.loads(settings_path().read_text())
+    except (OSError, ValueError):
+        return {}
+    if settings.get("uploads_dir"):
+        config.uploads_dir = Path(settings["uploads_dir"]).expanduser()
+    if isinstance(settings.get("port"), int):
+        config.port = settings["port"]
+    if isinstance(settings.get("pin"), bool):
+        config.show_pin = settings["pin"]
+    return settings
+
+
+def save_settings(uploads_dir: Optional[Path], port: int, pin: bool):
+    """
+    Write the settings file atomically.
+
+    Args:
+        uploads_dir: Where received files go; None keeps the default
+            (an 'uploads' folder wherever flashare is started).
+        port: Default server port.
+        pin: Whether to print a PIN by default.
+    """
+    path = settings_path()
+    path.parent.mkdir(parents=True, exist_ok=True)
+    temp = path.with_suffix(".tmp")
+    temp.write_text(json.dumps(
+        {"uploads_dir": str(uploads_dir) if uploads_dir else None, "port": port, "pin": pin}, indent=2,
+    ))
+    os.replace(temp, path)
@@ -0,0 +1,31 @@
+"""Two-word short links (e.g. /go/blue-tiger) that are easy to read out and type."""
+
+import secrets
+
+
+# Short, common words without look-alike spellings, so a slug heard once can be typed
+ADJECTIVES = (
+    "amber", "bold", "brave", "brisk", "calm", "clever", "crisp", "curly", "daring",
+    "eager", "early", "fancy", "fast", "fuzzy", "gentle", "giant", "glad", "golden", "grand",
+    "green", "happy", "hasty", "humble", "icy", "jolly", "keen", "kind", "lazy", "lively",
+    "lucky", "mellow", "merry", "mighty", "misty", "modern", "noble", "odd", "orange", "plain",
+    "polite", "proud", "purple", "quick", "quiet", "rapid", "red", "rosy", "royal", "rusty",
+    "shy", "silent", "silver", "sleepy", "smart", "snowy", "solar", "sunny", "swift", "tidy",
+    "tiny", "violet", "warm", "witty", "yellow", "young", "zesty", "blue", "bright", "cheerful",
+)
+
+ANIMALS = (
+    "badger", "bat", "bear", "beaver", "bee", "bison", "camel", "cat", "cobra", "crab",
+    "crane", "crow", "deer", "dingo", "dog", "dolphin", "donkey", "dove", "duck", "eagle",
+    "eel", "elk", "falcon", "ferret", "finch", "fox", "frog", "gecko", "goat", "goose",
+    "gorilla", "hare", "hawk", "hedgehog", "heron", "horse", "ibis", "koala", "lemur", "lion",
+    "llama", "lynx", "mole", "moose", "moth", "mouse", "newt", "otter", "owl", "panda",
+    "parrot", "pelican", "penguin", "pony", "puffin", "rabbit", "raven", "robin", "salmon", "seal",
+    "shark", "sheep", "sloth", "snail", "swan", "tiger", "toad", "turtle", "walrus", "whale",
+    "wolf", "wombat", "yak", "zebra",
+)
+
+
+def new_slug() -> str:
+    """A random adjective-animal pair, e.g. 'blue-tiger'."""
+    return f"{secrets.choice(ADJECTIVES)}-{secrets.choice(ANIMALS)}"
@@ -0,0 +1,34 @@
+"""Natural, locale-independent ordering for file names."""
+
+import re
+import unicodedata
+
+
+_NUMBER_RUNS = re.compile(r"(\d+)")
+
+
+def collation_key(text: str) -> str:
+    """
+    Fold text for case- and accent-insensitive comparison.
+
+    'Ö' compares like 'o' and 'ß' like 'ss', so names sort where a person
+    expects them rather than by code point.
+    """
+    decomposed = unicodedata.normalize("NFKD", text.casefold())
+    return "".join(c for c in decomposed if not unicodedata.combining(c))
+
+
+def natural_key(name: str) -> tuple:
+    """
+    Sort key comparing digit runs numerically: 'File2' < 'File10'.
+
+    Names that collate equally ('a.txt' and 'A.txt', 'file01' and
+    'file1') fall back to the raw name, so the order is total.
+    """
+    parts = []
+    for index, run in enumerate(_NUMBER_RUNS.split(collation_key(name))):
+        if index % 2:
+            parts.append((0, int(run), ""))
+        elif run:
+            parts.append((1, 0, run))
+    return (tuple(parts), name)
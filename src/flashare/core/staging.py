@@ -0,0 +1,203 @@
+"""In-memory upload staging with asynchronous flushing to storage."""
+
+import io
+import os
+import queue
+import threading
+import time
+from dataclasses import dataclass
+from typing import BinaryIO, Optional
+
+from flashare.core.correlation import log
+from flashare.core.storage import Storage, StorageEntry, LocalStorage
+
+
+@dataclass
+class _StagedCopy:
+    """A completed upload held in memory until the writer flushes it."""
+    data: bytes
+    modified: float
+    target: BinaryIO
+
+
+class _StagedUpload(io.RawIOBase):
+    """
+    Upload handle that buffers in memory while the budget allows.
+
+    When the budget (or per-file threshold) runs out it spills what it has
+    to the real file and continues straight to disk.
+    """
+
+    def __init__(self, storage: "StagedStorage", name: str, target: BinaryIO):
+        self._storage = storage
+        self._name = name
+        self._target = target
+        self._buffer: Optional[bytearray] = bytearray()
+
+    def writable(self) -> bool:
+        return True
+
+    def write(self, data) -> int:
+        if self._buffer is not None:
+            fits = len(self._buffer) + len(data) <= self._storage.threshold
+            if fits and self._storage.reserve(len(data)):
+                self._buffer += data
+                return len(data)
+            self._spill()
+        return self._target.write(data)
+
+    def _spill(self):
+        """Switch to direct-to-disk, handing back the memory budget."""
+        self._target.write(self._buffer)
+        self._storage.release(len(self._buffer))
+        self._buffer = None
+
+    def close(self):
+        if self.closed:
+            return
+        if self._buffer is None:
+            self._target.close()
+        else:
+            self._storage.enqueue(self._name, bytes(self._buffer), self._target)
+        super().close()
+
+
+class StagedStorage(Storage):
+    """
+    Storage wrapper that stages small uploads in RAM.
+
+    Completed uploads are written to the wrapped backend by a background
+    thread, and are served from memory until then. Memory use never
+    exceeds the budget; uploads that don't fit go straight to disk. Files
+    still queued when the process dies are lost (they remain as empty
+    placeholders), which is why status reports the unflushed count.
+    """
+
+    def __init__(self, backend: Storage, limit: int, threshold: int):
+        self.backend = backend
+        self.limit = limit
+        self.threshold = min(threshold, limit)
+        self._lock = threading.Lock()
+        self._used = 0
+        self._staged: dict[str, _StagedCopy] = {}
+        self._queue: queue.Queue = queue.Queue()
+        self._writer = threading.Thread(target=self._flush_loop, name="flashare-staging", daemon=True)
+        self._writer.start()
+
+    # ---- memory budget ----
+
+    def reserve(self, count: int) -> bool:
+        """Claim memory from the budget, failing if it would overflow."""
+        with self._lock:
+            if self._used + count > self.limit:
+                return False
+            self._used += count
+            return True
+
+    def release(self, count: int):
+        """Return memory to the budget."""
+        with self._lock:
+            self._used -= count
+
+    @property
+    def unflushed(self) -> int:
+        """Number of completed uploads not yet written to disk."""
+        with self._lock:
+            return len(self._staged)
+
+    @property
+    def used(self) -> int:
+        """Bytes of the budget currently in use."""
+        with self._lock:
+            return self._used
+
+    # ---- background writer ----
+
+    def enqueue(self, name: str, data: bytes, target: BinaryIO):
+        """Hand a finished upload to the background writer."""
+        with self._lock:
+            self._staged[name] = _StagedCopy(data, time.time(), target)
+        self._queue.put(name)
+
+    def _flush_loop(self):
+        while True:
+            name = self._queue.get()
+            try:
+                self._flush(name)
+            except (OSError, ValueError) as e:
+                # Disk full or deleted mid-flush; the writer must keep going
+                log(f"⚠️  Failed to flush staged upload {name}: {e}", "error")
+            finally:
+                self._queue.task_done()
+
+    def _flush(self, name: str):
+        with self._lock:
+            copy = self._staged.get(name)
+        if copy is None:
+            return  # Deleted before it was flushed
+
+        try:
+            copy.target.write(copy.data)
+            copy.target.close()
+
+            # Keep the upload time so cached metadata stays valid
+            if isinstance(self.backend, LocalStorage):
+                os.utime(self.backend.path(name), (copy.modified, copy.modified))
+        finally:
+            copy.target.close()
+            with self._lock:
+                if self._staged.get(name) is copy:
+                    del self._staged[name]
+                    self._used -= len(copy.data)
+
+    def drain(self, timeout: Optional[float] = None) -> bool:
+        """
+        Wait for every queued upload to reach disk.
+
+        Returns:
+            True if the queue emptied within the timeout.
+        """
+        deadline = time.monotonic() + timeout if timeout is not None else None
+        while self.unflushed:
+            if deadline is not None and time.monotonic() > deadline:
+                return False
+            time.sleep(0.05)
+        return True
+
+    # ---- Storage interface ----
+
+    def _overlay(self, entry: StorageEntry) -> StorageEntry:
+        with self._lock:
+            copy = self._staged.get(entry.name)
+        if copy is None:
+            return entry
+        return StorageEntry(entry.name, len(copy.data), copy.modified)
+
+    def list(self) -> list[StorageEntry]:
+        return [self._overlay(entry) for entry in self.backend.list()]
+
+    def stat(self, name: str) -> StorageEntry:
+        return self._overlay(self.backend.stat(name))
+
+    def open(self, name: str) -> BinaryIO:
+        with self._lock:
+            copy = self._staged.get(name)
+        if copy is not None:
+            return io.BytesIO(copy.data)
+        return self.backend.open(name)
+
+    def create(self, name: str) -> BinaryIO:
+        # The backend file is created now so the name is reserved
+        return _StagedUpload(self, name, self.backend.create(name))
+
+    def exists(self, name: str) -> bool:
+        return self.backend.exists(name)
+
+    def delete(self, name: str) -> None:
+        with self._lock:
+            copy = self._staged.pop(name, None)
+            if copy is not None:
+                self._used -= len(copy.data)
+        if copy is not None:
+            copy.target.close()
+        self.backend.delete(name)
@@ -0,0 +1,224 @@
+"""In-memory transfer and client statistics for Flashare."""
+
+import itertools
+import secrets
+import threading
+import time
+from collections import deque
+from dataclasses import dataclass, field, asdict
+from typing import Optional
+
+from flashare.core.correlation import current_request_id
+
+
+# Downloads smaller than this say more about latency than about the link,
+# so they aren't counted towards a client's throughput
+THROUGHPUT_MIN_BYTES = 1024 * 1024
+
+# How far back, and how many downloads, a client's throughput is taken from
+THROUGHPUT_WINDOW = 10 * 60
+THROUGHPUT_SAMPLES = 5
+
+
+@dataclass
+class Transfer:
+    """A single upload or download in progress."""
+    id: int
+    filename: str
+    direction: str  # "upload" or "download"
+    client: str
+    total: Optional[int] = None
+    bytes: int = 0
+    started: float = field(default_factory=time.time)
+    # Unguessable ID given to the client, and the device allowed to watch it
+    token: str = field(default_factory=lambda: secrets.token_urlsafe(16))
+    device: str = ""
+    request_id: str = field(default_factory=current_request_id)  # Request that started it
+
+    def progress(self) -> dict:
+        """Bytes moved, average rate and ETA, keyed by the public token."""
+        elapsed = max(time.time() - self.started, 1e-6)
+        rate = self.bytes / elapsed
+        eta = None
+        if self.total and rate:
+            eta = max(self.total - self.bytes, 0) / rate
+        return {
+            "id": self.token,
+            "filename": self.filename,
+            "direction": self.direction,
+            "bytes": self.bytes,
+            "total": self.total,
+            "rate": rate,
+            "eta": eta,
+            "request_id": self.request_id,
+        }
+
+
+@dataclass
+class ClientActivity:
+    """What a connected client did last."""
+    ip: str
+    first_seen: float
+    last_seen: float
+    last_action: str = ""
+    uploads: int = 0
+    downloads: int = 0
+
+
+class ServerStats:
+    """
+    Thread-safe counters shared by the API handlers.
+
+    Streaming responses run in the thread pool, so every mutation is
+    guarded by a lock.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._ids = itertools.count(1)
+        self.started = time.time()
+        self.transfers: dict[int, Transfer] = {}
+        self.clients: dict[str, ClientActivity] = {}
+        self._throughput: dict[str, deque] = {}  # client -> (finished, bytes, seconds) of recent downloads
+        self.bytes_uploaded = 0
+        self.bytes_downloaded = 0
+        self.uploads = 0
+        self.downloads = 0
+        self.aborted = 0
+
+    def touch_client(self, ip: str, action: Optional[str] = None):
+        """Record that a client made a request."""
+        now = time.time()
+        with self._lock:
+            client = self.clients.get(ip)
+            if client is None:
+                client = self.clients[ip] = ClientActivity(ip, first_seen=now, last_seen=now)
+            client.last_seen = now
+            if action:
+                client.last_action = action
+
+    def start_transfer(
+        self,
+        filename: str,
+        direction: str,
+        client: str,
+        total: Optional[int] = None,
+        device: str = "",
+        token: Optional[str] = None,
+    ) -> Transfer:
+        """
+        Register a new active transfer.
+
+        token lets a client pick the ID it will watch (e.g. before an
+        upload starts); a random one is generated otherwise.
+        """
+        with self._lock:
+            transfer = Transfer(next(self._ids), filename, direction, client, total, device=device)
+            if token:
+                transfer.token = token
+            self.transfers[transfer.id] = transfer
+            return transfer
+
+    def add_bytes(self, transfer: Transfer, count: int):
+        """Account bytes moved by an active transfer."""
+        with self._lock:
+            transfer.bytes += count
+            if transfer.direction == "upload":
+                self.bytes_uploaded += count
+            else:
+                self.bytes_downloaded += count
+
+    def finish_transfer(self, transfer: Transfer, success: bool = True):
+        """Remove a transfer from the active set and update client activity."""
+        with self._lock:
+            self.transfers.pop(transfer.id, None)
+            # Even a download cut short measured the link while it ran
+            if transfer.direction == "download" and transfer.bytes >= THROUGHPUT_MIN_BYTES:
+                now = time.time()
+                samples = self._throughput.setdefault(transfer.client, deque(maxlen=THROUGHPUT_SAMPLES))
+                samples.append((now, transfer.bytes, max(now - transfer.started, 1e-6)))
+            if not success:
+                self.aborted += 1
+                return
+
+            verb = "uploaded" if transfer.direction == "upload" else "downloaded"
+            if transfer.direction == "upload":
+                self.uploads += 1
+            else:
+                self.downloads += 1
+
+            client = self.clients.get(transfer.client)
+            if client:
+                client.last_action = f"{verb} {transfer.filename}"
+                client.last_seen = time.time()
+                if transfer.direction == "upload":
+                    client.uploads += 1
+                else:
+                    client.downloads += 1
+
+    def is_active(self, transfer: Transfer) -> bool:
+        """Whether a transfer is still in progress (not yet finished)."""
+        with self._lock:
+            return transfer.id in self.transfers
+
+    def active_transfers(self) -> list[dict]:
+        """Snapshot of transfers in progress, without their private IDs."""
+        with self._lock:
+            transfers = [asdict(t) for t in self.transfers.values()]
+        for transfer in transfers:
+            del transfer["token"], transfer["device"]
+        return transfers
+
+    def progress_for(self, device: str) -> list[dict]:
+        """Progress of the transfers a device started, for that device only."""
+        with self._lock:
+            return [t.progress() for t in self.transfers.values() if device and t.device == device]
+
+    def throughput(self, client: str) -> Optional[dict]:
+        """
+        How fast recent downloads reached a client, as measured by the server.
+
+        Timed from the response starting until its last chunk was handed
+        to the network, so buffers in between make it read a little high
+        for short downloads.
+
+        Returns:
+            bytes_per_second (total bytes over total time of the last
+            THROUGHPUT_SAMPLES downloads within THROUGHPUT_WINDOW), how
+            many downloads that is and when the last one finished; None
+            if there is no recent download to go by.
+        """
+        cutoff = time.time() - THROUGHPUT_WINDOW
+        with self._lock:
+            samples = [s for s in self._throughput.get(client, ()) if s[0] >= cutoff]
+        if not samples:
+            return None
+        return {
+            "bytes_per_second": sum(s[1] for s in samples) / sum(s[2] for s in samples),
+            "downloads": len(samples),
+            "measured_at": samples[-1][0],
+        }
+
+    def client_activity(self) -> list[dict]:
+        """Snapshot of known clients, most recently active first."""
+        with self._lock:
+            clients = [asdict(c) for c in self.clients.values()]
+        return sorted(clients, key=lambda c: c["last_seen"], reverse=True)
+
+    def metrics(self) -> dict:
+        """Cumulative counters since server start."""
+        with self._lock:
+            return {
+                "uptime": time.time() - self.started,
+                "bytes_uploaded": self.bytes_uploaded,
+                "bytes_downloaded": self.bytes_downloaded,
+                "uploads": self.uploads,
+                "downloads": self.downloads,
+                "aborted": self.aborted,
+                "active_transfers": len(self.transfers),
+                "clients": len(self.clients),
+            }
+
+
+# Global stats instance
+stats = ServerStats()
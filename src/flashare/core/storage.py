@@ -0,0 +1,288 @@
+"""Storage backends for Flashare (local filesystem and S3)."""
+
+import os
+import secrets
+import tempfile
+import unicodedata
+from abc import ABC, abstractmethod
+from dataclasses import dataclass
+from functools import lru_cache
+from pathlib import Path
+from typing import BinaryIO
+
+from flashare.config import config
+from flashare.core.paths import is_hidden_name
+from flashare.core.permissions import restrict
+
+
+@dataclass
+class StorageEntry:
+    """Metadata about a stored file."""
+    name: str
+    size: int
+    modified: float
+
+
+class Storage(ABC):
+    """
+    Interface for where shared files live.
+
+    Names are flat file names relative to the storage root. Implementations
+    raise FileNotFoundError for missing files and PermissionError for names
+    escaping the root or naming anything hidden (see is_hidden_name).
+    """
+
+    @abstractmethod
+    def list(self) -> list[StorageEntry]:
+        """List all stored files."""
+
+    @abstractmethod
+    def stat(self, name: str) -> StorageEntry:
+        """Get metadata for a single file."""
+
+    @abstractmethod
+    def open(self, name: str) -> BinaryIO:
+        """Open a file for reading."""
+
+    @abstractmethod
+    def create(self, name: str) -> BinaryIO:
+        """Open a new file for writing. Data is committed on close()."""
+
+    @abstractmethod
+    def delete(self, name: str) -> None:
+        """Delete a file."""
+
+    def exists(self, name: str) -> bool:
+        """Check whether a file exists."""
+        try:
+            self.stat(name)
+            return True
+        except FileNotFoundError:
+            return False
+
+
+def _fold(name: str) -> str:
+    """Normalize a name the way case-insensitive filesystems compare it."""
+    return unicodedata.normalize("NFC", name).casefold()
+
+
+class LocalStorage(Storage):
+    """Files stored in a directory on the local filesystem."""
+
+    def __init__(self, root: Path):
+        self.root = Path(root)
+        self._case_sensitive: bool | None = None
+
+    @property
+    def case_sensitive(self) -> bool:
+        """
+        Whether the root's filesystem distinguishes 'a.txt' from 'A.TXT'.
+
+        Probed once with a throwaway file, since macOS and Windows volumes
+        are usually case-insensitive while Linux ones are not.
+        """
+        if self._case_sensitive is None:
+            self.root.mkdir(parents=True, exist_ok=True)
+            fd, probe = tempfile.mkstemp(prefix=".flashare-case-", dir=self.root)
+            os.close(fd)
+            try:
+                probe_path = Path(probe)
+                self._case_sensitive = not probe_path.with_name(probe_path.name.upper()).exists()
+            finally:
+                os.unlink(probe)
+        return self._case_sensitive
+
+    def path(self, name: str) -> Path:
+        """
+        Resolve a name to a path inside the root.
+
+        Raises:
+            PermissionError: If the name escapes the storage root or is
+                hidden, like Flashare's own state folders.
+        """
+        if is_hidden_name(name):
+            raise PermissionError(f"Access denied: {name}")
+        file_path = self.root / name
+        try:
+            file_path.resolve().relative_to(self.root.resolve())
+        except ValueError:
+            raise PermissionError(f"Access denied: {name}")
+        return file_path
+
+    def _entry(self, file_path: Path) -> StorageEntry:
+        stat = file_path.stat()
+        # Files in folders (e.g. organized uploads) are named by their path under the root
+        name = file_path.relative_to(self.root).as_posix()
+        return StorageEntry(name=name, size=stat.st_size, modified=stat.st_mtime)
+
+    def list(self) -> list[StorageEntry]:
+        if not self.root.exists():
+            return []
+        return [self._entry(f) for f in self.root.iterdir() if f.is_file() and not f.name.startswith(".")]
+
+    def stat(self, name: str) -> StorageEntry:
+        file_path = self.path(name)
+        if not file_path.is_file():
+            raise FileNotFoundError(name)
+        return self._entry(file_path)
+
+    def open(self, name: str) -> BinaryIO:
+        return open(self.path(name), 'rb')
+
+    def create(self, name: str) -> BinaryIO:
+        return self._create_at(self.path(name))
+
+    def create_temp(self) -> tuple[Path, BinaryIO]:
+        """
+        Create a hidden file in the root for an upload to be written into.
+
+        Listings and downloads skip hidden names, so the upload can't be
+        seen half-written; place() gives it its real name once complete.
+        """
+        self.root.mkdir(parents=True, exist_ok=True)
+        file_path = self.root / f".flashare-upload-{secrets.token_hex(8)}"
+        return file_path, self._create_at(file_path)
+
+    def place(self, temp: Path, name: str) -> None:
+        """
+        Move a file written through create_temp to its name.
+
+        Raises:
+            FileExistsError: If the name is already taken; temp is left as it is.
+        """
+        file_path = self.path(name)
+        try:
+            # A hard link fails rather than replacing a file that appeared meanwhile
+            os.link(temp, file_path)
+        except FileExistsError:
+            raise
+        except OSError:
+            # No hard links (e.g. FAT volumes), so fall back to renaming
+            if file_path.exists():
+                raise FileExistsError(name)
+            os.rename(temp, file_path)
+            return
+        os.unlink(temp)
+
+    def _create_at(self, file_path: Path) -> BinaryIO:
+        # Exclusive so a concurrent upload can never be silently replaced,
+        # and born with the configured mode so it's never briefly readable
+        mode = 0o666 if config.file_mode is None else config.file_mode
+        fd = os.open(file_path, os.O_WRONLY | os.O_CREAT | os.O_EXCL | getattr(os, "O_BINARY", 0), mode)
+        f = os.fdopen(fd, 'wb')
+        restrict(file_path)  # umask may have dropped bits that were asked for
+        return f
+
+    def exists(self, name: str) -> bool:
+        if self.case_sensitive:
+            return self.path(name).exists()
+
+        # Compare folded names so 'Photo.JPG' and 'photo.jpg' collide
+        # consistently, including NFC/NFD differences on macOS; every
+        # level is folded, so 'Foo/a.txt' and 'foo/A.TXT' collide too
+        self.path(name)
+        current = self.root
+        for part in filter(None, name.split("/")):
+            folded = _fold(part)
+            try:
+                current = next(f for f in current.iterdir() if _fold(f.name) == folded)
+            except (StopIteration, FileNotFoundError, NotADirectoryError):
+                return False
+        return True
+
+    def delete(self, name: str) -> None:
+        self.path(name).unlink()
+
+
+class _S3Upload(tempfile.SpooledTemporaryFile):
+    """Spooled buffer that uploads itself to S3 when closed."""
+
+    def __init__(self, storage: "S3Storage", key: str):
+        super().__init__(max_size=config.chunk_size * 16)
+        self._storage = storage
+        self._key = key
+
+    def close(self):
+        if not self.closed:
+            self.seek(0)
+            self._storage.client.upload_fileobj(self, self._storage.bucket, self._key)
+        super().close()
+
+
+class S3Storage(Storage):
+    """
+    Files stored in an S3 (or S3-compatible) bucket.
+
+    Requires boto3, which is imported lazily so the local backend keeps
+    working without it.
+    """
+
+    def __init__(self, bucket: str, prefix: str = "", endpoint_url: str | None = None):
+        import boto3
+
+        self.bucket = bucket
+        self.prefix = prefix.strip("/") + "/" if prefix.strip("/") else ""
+        self.client = boto3.client("s3", endpoint_url=endpoint_url or None)
+
+    def _key(self, name: str) -> str:
+        if "/" in name or not name or is_hidden_name(name):
+            raise PermissionError(f"Access denied: {name}")
+        return f"{self.prefix}{name}"
+
+    def list(self) -> list[StorageEntry]:
+        entries = []
+        paginator = self.client.get_paginator("list_objects_v2")
+        for page in paginator.paginate(Bucket=self.bucket, Prefix=self.prefix, Delimiter="/"):
+            for obj in page.get("Contents", []):
+                entries.append(StorageEntry(
+                    name=obj["Key"][len(self.prefix):],
+                    size=obj["Size"],
+                    modified=obj["LastModified"].timestamp(),
+                ))
+        return entries
+
+    def stat(self, name: str) -> StorageEntry:
+        from botocore.exceptions import ClientError
+
+        try:
+            head = self.client.head_object(Bucket=self.bucket, Key=self._key(name))
+        except ClientError:
+            raise FileNotFoundError(name)
+        return StorageEntry(
+            name=name,
+            size=head["ContentLength"],
+            modified=head["LastModified"].timestamp(),
+        )
+
+    def open(self, name: str) -> BinaryIO:
+        self.stat(name)
+        return self.client.get_object(Bucket=self.bucket, Key=self._key(name))["Body"]
+
+    def create(self, name: str) -> BinaryIO:
+        return _S3Upload(self, self._key(name))
+
+    def delete(self, name: str) -> None:
+        self.stat(name)
+        self.client.delete_object(Bucket=self.bucket, Key=self._key(name))
+
+
+@lru_cache(maxsize=1)
+def get_storage() -> Storage:
+    """
+    Get the storage backend selected by config.storage_backend.
+
+    Returns:
+        The configured Storage instance (local by default).
+    """
+    if config.storage_backend == "s3":
+        if not config.s3_bucket:
+            raise ValueError("storage_backend 's3' requires s3_bucket to be set")
+        backend = S3Storage(config.s3_bucket, config.s3_prefix, config.s3_endpoint_url)
+    else:
+        backend = LocalStorage(config.uploads_dir)
+
+    if config.staging_mode == "memory":
+        from flashare.core.staging import StagedStorage
+        return StagedStorage(backend, config.staging_limit, config.staging_threshold)
+
+    return backend
@@ -0,0 +1,113 @@
+"""Browser-playable versions of uploaded videos, made in the background."""
+
+import queue
+import threading
+from pathlib import Path
+from typing import Callable, Optional
+
+from flashare.config import config
+from flashare.core.events import hub
+from flashare.core.ffmpeg import get_video_info, is_web_compatible, transcode_for_web
+from flashare.core.permissions import make_dirs, restrict
+
+
+# Web versions live in a hidden folder so listings never show them
+WEB_DIR_NAME = ".web"
+
+
+def web_dir() -> Path:
+    """Directory holding the web versions."""
+    return config.uploads_dir / WEB_DIR_NAME
+
+
+def web_path(filename: str) -> Path:
+    """Where the web version of a stored file goes."""
+    return web_dir() / f"{filename}.mp4"
+
+
+class Transcoder:
+    """
+    Makes an H.264/AAC MP4 of each uploaded video that browsers can't play.
+
+    Videos are queued as they are uploaded and transcoded one at a time by
+    a single worker thread, as each transcode keeps every core busy. The
+    original is never touched. Every change of state is published as a
+    'transcode' event carrying the file's id and state: 'queued',
+    'running', 'ready', 'skipped' (already web-compatible) or 'failed'.
+    """
+
+    def __init__(self, publish: Callable[[str, Optional[dict]], None] = hub.publish):
+        self._publish = publish
+        self._lock = threading.Lock()
+        self._queue: queue.Queue[str] = queue.Queue()
+        self._states: dict[str, str] = {}
+        self._worker: Optional[threading.Thread] = None
+
+    def submit(self, filename: str):
+        """Queue a stored video to be transcoded."""
+        with self._lock:
+            if self._states.get(filename) in ("queued", "running"):
+                return
+            if self._worker is None:
+                self._worker = threading.Thread(target=self._run, daemon=True)
+                self._worker.start()
+        self._set(filename, "queued")
+        self._queue.put(filename)
+
+    def state(self, filename: str) -> Optional[str]:
+        """State of a file's web version, or None if none was ever asked for."""
+        with self._lock:
+            state = self._states.get(filename)
+        if state is None and web_path(filename).is_file():
+            return "ready"  # Made by an earlier session
+        return state
+
+    def forget(self, filename: str):
+        """Delete a file's web version along with the file."""
+        with self._lock:
+            self._states.pop(filename, None)
+        web_path(filename).unlink(missing_ok=True)
+
+    def _set(self, filename: str, state: str, error: str = ""):
+        with self._lock:
+            self._states[filename] = state
+        event = {"id": filename, "state": state}
+        if error:
+            event["error"] = error
+        self._publish("transcode", event)
+
+    def _run(self):
+        while True:
+            filename = self._queue.get()
+            with self._lock:
+                if filename not in self._states:
+                    continue  # Deleted while queued
+            self._transcode(filename)
+
+    def _transcode(self, filename: str):
+        source = config.uploads_dir / filename
+        info = get_video_info(source)
+        if info is None:
+            self._set(filename, "failed", "The video could not be read")
+            return
+        if is_web_compatible(info):
+            self._set(filename, "skipped")
+            return
+
+        self._set(filename, "running")
+        target = web_path(filename)
+        make_dirs(target.parent, config.uploads_dir)
+        error = transcode_for_web(source, target)
+        with self._lock:
+            deleted = filename not in self._states
+        if deleted:
+            target.unlink(missing_ok=True)
+        elif error:
+            self._set(filename, "failed", error)
+        else:
+            restrict(target)
+            self._set(filename, "ready")
+
+
+# Global video transcoder
+transcoder = Transcoder()
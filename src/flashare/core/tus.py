@@ -0,0 +1,178 @@
+"""Resumable uploads over the tus protocol (https://tus.io), staged until complete."""
+
+import base64
+import binascii
+import json
+import secrets
+import threading
+import time
+from dataclasses import dataclass, field, asdict
+from pathlib import Path
+from typing import Optional
+
+from flashare.config import config
+from flashare.core.permissions import make_dirs, restrict
+
+
+# The only protocol version spoken; clients send it with every request
+TUS_VERSION = "1.0.0"
+TUS_EXTENSIONS = ("creation", "termination", "expiration")
+
+# Unfinished uploads live in a hidden folder next to the files, so
+# finishing one never crosses filesystems and listings never show them
+TUS_DIR_NAME = ".tus"
+
+
+def parse_metadata(header: Optional[str]) -> dict[str, str]:
+    """
+    Decode an Upload-Metadata header: comma-separated 'key base64value' pairs.
+
+    Raises:
+        ValueError: If a value isn't valid base64 UTF-8.
+    """
+    metadata = {}
+    for pair in (header or "").split(","):
+        key, _, value = pair.strip().partition(" ")
+        if not key:
+            continue
+        try:
+            metadata[key] = base64.b64decode(value.strip(), validate=True).decode()
+        except (binascii.Error, UnicodeDecodeError):
+            raise ValueError(f"Upload-Metadata value for '{key}' is not base64 UTF-8")
+    return metadata
+
+
+def format_metadata(metadata: dict[str, str]) -> str:
+    """Encode metadata back into an Upload-Metadata header."""
+    return ",".join(
+        f"{key} {base64.b64encode(value.encode()).decode()}" if value else key for key, value in metadata.items()
+    )
+
+
+@dataclass
+class TusUpload:
+    """
+    One upload in progress.
+
+    The offset isn't stored: data is only ever appended, so it is the
+    size of the staged file, which stays right across crashes.
+    """
+    id: str
+    length: int
+    metadata: dict[str, str]
+    device: str = ""
+    client: str = ""
+    created: float = field(default_factory=time.time)
+    expires: float = 0.0
+
+    @property
+    def filename(self) -> str:
+        """Name the client gave the file (Uppy sends 'filename', others 'name')."""
+        return self.metadata.get("filename") or self.metadata.get("name") or self.id
+
+    @property
+    def path(self) -> Path:
+        return config.uploads_dir / TUS_DIR_NAME / f"{self.id}.part"
+
+    @property
+    def info_path(self) -> Path:
+        return config.uploads_dir / TUS_DIR_NAME / f"{self.id}.json"
+
+    @property
+    def offset(self) -> int:
+        try:
+            return self.path.stat().st_size
+        except FileNotFoundError:
+            return 0
+
+
+class TusUploads:
+    """
+    Unfinished tus uploads, kept on disk so they resume across restarts.
+
+    Each upload is a .part file of the bytes received so far plus a
+    .json file describing it. Uploads untouched for config.tus_expiry
+    seconds are deleted. Only one request may append to an upload at a
+    time.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._busy: set[str] = set()
+
+    @property
+    def folder(self) -> Path:
+        return config.uploads_dir / TUS_DIR_NAME
+
+    def create(self, length: int, metadata: dict[str, str], device: str = "", client: str = "") -> TusUpload:
+        """Start an upload of length bytes with an empty staged file."""
+        self.prune()
+        upload = TusUpload(secrets.token_hex(16), length, metadata, device, client)
+        upload.expires = upload.created + config.tus_expiry
+        make_dirs(self.folder, config.uploads_dir)
+        upload.path.touch()
+        restrict(upload.path)
+        self._save(upload)
+        return upload
+
+    def _save(self, upload: TusUpload):
+        temp = upload.info_path.with_suffix(".tmp")
+        temp.write_text(json.dumps(asdict(upload), indent=2))
+        restrict(temp)
+        temp.replace(upload.info_path)
+
+    def get(self, upload_id: str) -> Optional[TusUpload]:
+        """An unexpired upload by ID, or None."""
+        if not upload_id.isalnum():
+            return None  # Also keeps the ID from naming paths elsewhere
+        try:
+            upload = TusUpload(**json.loads((self.folder / f"{upload_id}.json").read_text()))
+        except (OSError, ValueError, TypeError):
+            return None
+        if upload.expires <= time.time():
+            self.delete(upload)
+            return None
+        return upload
+
+    def touch(self, upload: TusUpload):
+        """Give an upload that is making progress another expiry window."""
+        upload.expires = time.time() + config.tus_expiry
+        self._save(upload)
+
+    def claim(self, upload: TusUpload) -> bool:
+        """Reserve an upload for one appending request; False if another has it."""
+        with self._lock:
+            if upload.id in self._busy:
+                return False
+            self._busy.add(upload.id)
+            return True
+
+    def release(self, upload: TusUpload):
+        with self._lock:
+            self._busy.discard(upload.id)
+
+    def delete(self, upload: TusUpload):
+        """Drop an upload and its data (finished, terminated or expired)."""
+        upload.path.unlink(missing_ok=True)
+        upload.info_path.unlink(missing_ok=True)
+
+    def prune(self) -> int:
+        """
+        Delete expired uploads.
+
+        Returns:
+            Number of uploads removed.
+        """
+        if not self.folder.is_dir():
+            return 0
+        count = 0
+        for info in self.folder.glob("*.json"):
+            if self.get(info.stem) is None:
+                (self.folder / f"{info.stem}.part").unlink(missing_ok=True)
+                info.unlink(missing_ok=True)
+                count += 1
+        return count
+
+
+# Global tus uploads
+tus_uploads = TusUploads()
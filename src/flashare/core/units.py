@@ -0,0 +1,68 @@
+"""Human-friendly unit parsing for Flashare."""
+
+import re
+
+
+_SIZE_UNITS = {
+    "": 1,
+    "b": 1,
+    "k": 1024, "kb": 1024,
+    "m": 1024**2, "mb": 1024**2,
+    "g": 1024**3, "gb": 1024**3,
+    "t": 1024**4, "tb": 1024**4,
+}
+
+
+def parse_size(value: str | int) -> int:
+    """
+    Parse a size like '500MB', '2GB', '64k' or '1048576' into bytes.
+    
+    Args:
+        value: Size string (binary units, case-insensitive) or integer.
+        
+    Returns:
+        Size in bytes.
+        
+    Raises:
+        ValueError: If the value cannot be parsed.
+    """
+    if isinstance(value, int):
+        return value
+    
+    match = re.fullmatch(r"\s*(\d+(?:\.\d+)?)\s*([a-zA-Z]*)\s*", value)
+    if not match or match.group(2).lower() not in _SIZE_UNITS:
+        raise ValueError(f"Invalid size: {value!r}")
+    
+    return int(float(match.group(1)) * _SIZE_UNITS[match.group(2).lower()])
+
+
+_DURATION_UNITS = {"s": 1, "m": 60, "h": 3600, "d": 86400}
+
+
+def parse_duration(value: str | int | float) -> float:
+    """
+    Parse a duration like '90s', '15m', '2h', '1d' or '1h30m' into seconds.
+    
+    A bare number is taken as seconds.
+    
+    Args:
+        value: Duration string or number of seconds.
+        
+    Returns:
+        Duration in seconds.
+        
+    Raises:
+        ValueError: If the value cannot be parsed.
+    """
+    if isinstance(value, (int, float)):
+        return float(value)
+    
+    text = value.strip().lower()
+    if re.fullmatch(r"\d+(?:\.\d+)?", text):
+        return float(text)
+    
+    parts = re.findall(r"(\d+(?:\.\d+)?)([smhd])", text)
+    if not parts or "".join(n + u for n, u in parts) != text:
+        raise ValueError(f"Invalid duration: {value!r}")
+    
+    return sum(float(number) * _DURATION_UNITS[unit] for number, unit in parts)
@@ -0,0 +1,101 @@
+"""Release update checks for Flashare."""
+
+import json
+import re
+import time
+import urllib.request
+from dataclasses import dataclass
+from typing import Optional
+
+from flashare import __version__
+from flashare.config import config
+
+
+RELEASES_API = "https://api.github.com/repos/Abhijit-without-h/flashare/releases/latest"
+CACHE_TTL = 24 * 60 * 60  # Check GitHub at most once a day
+
+
+@dataclass
+class UpdateInfo:
+    """Result of an update check."""
+    current: str
+    latest: Optional[str]
+    url: Optional[str]
+    error: Optional[str] = None
+
+    @property
+    def update_available(self) -> bool:
+        """Whether the latest release is newer than the running version."""
+        return bool(self.latest) and _parse_version(self.latest) > _parse_version(self.current)
+
+
+def _parse_version(version: str) -> tuple:
+    """Turn 'v1.2.3' into (1, 2, 3) for comparison, ignoring suffixes."""
+    return tuple(
+        int(match.group()) if (match := re.match(r"\d+", part)) else 0
+        for part in version.lstrip("vV").split(".")
+    )
+
+
+def _read_cache() -> Optional[dict]:
+    """Read the cached release info if it is still fresh."""
+    cache_path = config.data_dir / "update-check.json"
+    try:
+        cached = json.loads(cache_path.read_text())
+    except (OSError, ValueError):
+        return None
+
+    if time.time() - cached.get("checked_at", 0) > CACHE_TTL:
+        return None
+    return cached
+
+
+def _write_cache(latest: str, url: str):
+    """Store release info so the API isn't queried on every run."""
+    try:
+        config.data_dir.mkdir(parents=True, exist_ok=True)
+        (config.data_dir / "update-check.json").write_text(json.dumps({
+            "checked_at": time.time(),
+            "latest": latest,
+            "url": url,
+        }))
+    except OSError:
+        pass
+
+
+def check_for_updates(timeout: float = 3.0, use_cache: bool = True) -> UpdateInfo:
+    """
+    Ask the GitHub releases API whether a newer version exists.
+
+    Proxy settings from the environment (HTTPS_PROXY etc.) are honored.
+    Network failures never raise; they are reported via UpdateInfo.error.
+
+    Args:
+        timeout: Request timeout in seconds.
+        use_cache: Reuse a result from the last 24 hours if available.
+
+    Returns:
+        UpdateInfo describing the latest release.
+    """
+    if use_cache and (cached := _read_cache()):
+        return UpdateInfo(__version__, cached.get("latest"), cached.get("url"))
+
+    request = urllib.request.Request(
+        RELEASES_API,
+        headers={
+            "Accept": "application/vnd.github+json",
+            "User-Agent": f"flashare/{__version__}",
+        },
+    )
+
+    try:
+        with urllib.request.urlopen(request, timeout=timeout) as response:
+            release = json.load(response)
+    except (OSError, ValueError) as e:
+        return UpdateInfo(__version__, None, None, error=f"Could not reach GitHub ({e})")
+
+    latest = release.get("tag_name", "")
+    url = release.get("html_url", "")
+    _write_cache(latest, url)
+
+    return UpdateInfo(__version__, latest, url)
@@ -0,0 +1,298 @@
+"""Integrity sweep: re-hash stored files and compare them with the checksums on record."""
+
+import hashlib
+import threading
+import time
+from concurrent.futures import ThreadPoolExecutor
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Callable, Optional
+
+from flashare.config import config
+from flashare.core.metadata import delete_meta, load_meta, meta_dir, update_meta
+from flashare.core.quarantine import quarantine
+from flashare.core.watcher import snapshot
+
+
+# What --fix may do with a corrupt file
+FIX_ACTIONS = ("delete", "quarantine")
+
+# Seconds between progress reports while files are verified
+PROGRESS_INTERVAL = 0.5
+
+# Hashing reads in blocks so memory stays flat, and cancelling is noticed between them
+_READ_SIZE = 1024 * 1024
+
+
+class VerifyCancelled(Exception):
+    """A sweep was stopped."""
+
+
+@dataclass
+class VerifyReport:
+    """
+    Outcome of one sweep.
+
+    Files modified since they were hashed (mtime or size differ) are not
+    corrupt: their fresh hash is recorded and they count as 'changed'.
+    """
+    checked: int = 0
+    bytes: int = 0
+    recorded: list[str] = field(default_factory=list)  # No hash on record; one was saved
+    changed: list[str] = field(default_factory=list)
+    corrupt: list[str] = field(default_factory=list)  # Unmodified, yet the content differs
+    missing: list[str] = field(default_factory=list)  # A checksum is on record but the file is gone
+    orphaned: list[str] = field(default_factory=list)  # Sidecars of files gone, without a checksum
+    fixed: list[str] = field(default_factory=list)  # Corrupt files deleted or quarantined
+    errors: list[str] = field(default_factory=list)  # 'name: reason' for files that couldn't be read
+    elapsed: float = 0.0
+
+    @property
+    def ok(self) -> bool:
+        return not (self.corrupt or self.missing or self.errors)
+
+    def to_dict(self) -> dict:
+        return {
+            "ok": self.ok,
+            "checked": self.checked,
+            "bytes": self.bytes,
+            "recorded": self.recorded,
+            "changed": self.changed,
+            "corrupt": self.corrupt,
+            "missing": self.missing,
+            "orphaned": self.orphaned,
+            "fixed": self.fixed,
+            "errors": self.errors,
+            "elapsed": self.elapsed,
+        }
+
+
+class IntegritySweep:
+    """
+    One integrity sweep of the uploads directory at a time.
+
+    Every visible file is re-hashed with SHA-256 by a pool of
+    config.verify_workers threads and compared with the checksum recorded
+    in its metadata sidecar; files without one get it recorded. Sidecars
+    whose file is gone are reported afterwards. With a fix action,
+    corrupt files are deleted or moved to uploads/.failed, and sidecars
+    of missing files are removed.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._cancel = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+        self.state = "idle"  # idle, running, done, cancelled, failed
+        self.fix: Optional[str] = None
+        self.error = ""
+        self.files_done = self.files_total = 0
+        self.bytes_done = self.bytes_total = 0
+        self.started = self.finished = 0.0
+        self._reported = 0.0
+        self.report: Optional[VerifyReport] = None
+
+    @property
+    def running(self) -> bool:
+        return self.state == "running"
+
+    def start(
+        self,
+        root: Path,
+        fix: Optional[str] = None,
+        on_progress: Optional[Callable[["IntegritySweep"], None]] = None,
+        on_removed: Optional[Callable[[str, int], None]] = None,
+    ) -> bool:
+        """
+        Start a sweep of root in the background.
+
+        Args:
+            root: The uploads directory.
+            fix: One of FIX_ACTIONS, or None to only report.
+            on_progress: Called from the sweep with itself as files are
+                verified, and once more when it ends.
+            on_removed: Called with (name, size) after a corrupt file was
+                deleted or quarantined.
+
+        Returns:
+            False if a sweep is already running.
+        """
+        with self._lock:
+            if self.running:
+                return False
+            self._cancel = cancel = threading.Event()
+            self.state = "running"
+            self.fix = fix
+            self.error = ""
+            self.files_done = self.files_total = self.bytes_done = self.bytes_total = 0
+            self.started = time.time()
+            self.finished = 0.0
+            self.report = None
+            self._thread = threading.Thread(
+                target=self._run, args=(Path(root), fix, cancel, on_progress, on_removed),
+                name="flashare-verify", daemon=True,
+            )
+            self._thread.start()
+        return True
+
+    def cancel(self):
+        """Stop a running sweep; files already verified keep their fresh hashes."""
+        self._cancel.set()
+
+    def wait(self, timeout: Optional[float] = None) -> bool:
+        """Wait for the sweep to end; False if it is still running after timeout."""
+        thread = self._thread
+        if thread is not None:
+            thread.join(timeout)
+        return not self.running
+
+    def _run(
+        self,
+        root: Path,
+        fix: Optional[str],
+        cancel: threading.Event,
+        on_progress: Optional[Callable[["IntegritySweep"], None]],
+        on_removed: Optional[Callable[[str, int], None]],
+    ):
+        report = VerifyReport()
+        notify = on_progress or (lambda sweep: None)
+        try:
+            files = snapshot(root)
+            with self._lock:
+                self.files_total = len(files)
+                self.bytes_total = sum(size for size, _ in files.values())
+
+            def verify_one(name: str) -> tuple[str, str, str]:
+                try:
+                    outcome, digest = self._verify_file(root / name, name, cancel)
+                except OSError as e:
+                    outcome, digest = "error", e.strerror or str(e)
+                with self._lock:
+                    self.files_done += 1
+                    report_now = time.monotonic() - self._reported >= PROGRESS_INTERVAL
+                    if report_now:
+                        self._reported = time.monotonic()
+                if report_now:
+                    notify(self)
+                return name, outcome, digest
+
+            found = {}  # Hashes of corrupt files, for the quarantine note
+            with ThreadPoolExecutor(max_workers=max(config.verify_workers, 1)) as pool:
+                for name, outcome, digest in pool.map(verify_one, sorted(files)):
+                    if outcome == "error":
+                        report.errors.append(f"{name}: {digest}")
+                        continue
+                    report.checked += 1
+                    report.bytes += files[name][0]
+                    if outcome == "corrupt":
+                        found[name] = digest
+                    if outcome != "ok":
+                        getattr(report, outcome).append(name)
+            if cancel.is_set():
+                raise VerifyCancelled()
+
+            self._find_orphans(root, report)
+            if fix:
+                self._fix(root, report, fix, found, on_removed)
+        except VerifyCancelled:
+            state = "cancelled"
+        except OSError as e:
+            self.error = str(e)
+            state = "failed"
+        else:
+            state = "done"
+
+        with self._lock:
+            self.finished = time.time()
+            report.elapsed = self.finished - self.started
+            self.report = report
+            self.state = state
+        notify(self)
+
+    def _verify_file(self, path: Path, name: str, cancel: threading.Event) -> tuple[str, str]:
+        """Hash one file; returns 'ok' or the report list it belongs in, and its hash."""
+        before = path.stat()
+        digest = hashlib.sha256()
+        with open(path, "rb") as f:
+            while block := f.read(_READ_SIZE):
+                if cancel.is_set():
+                    raise VerifyCancelled()
+                digest.update(block)
+                with self._lock:
+                    self.bytes_done += len(block)
+        after = path.stat()
+        if (after.st_mtime, after.st_size) != (before.st_mtime, before.st_size):
+            return "changed", ""  # Written to while being read; the next sweep hashes it
+
+        recorded = load_meta(name).get("sha256")
+        fresh = {"hex": digest.hexdigest(), "mtime": after.st_mtime, "size": after.st_size}
+        if recorded and recorded.get("mtime") == fresh["mtime"] and recorded.get("size") == fresh["size"]:
+            return "ok" if recorded.get("hex") == fresh["hex"] else "corrupt", fresh["hex"]
+        update_meta(name, sha256=fresh)
+        return "changed" if recorded else "recorded", fresh["hex"]
+
+    def _find_orphans(self, root: Path, report: VerifyReport):
+        """Sort sidecars without a file into missing (had a checksum) and orphaned."""
+        folder = meta_dir()
+        if not folder.is_dir():
+            return
+        for sidecar in sorted(folder.rglob("*.json")):
+            name = sidecar.relative_to(folder).as_posix()[:-len(".json")]
+            if (root / name).is_file():
+                continue
+            (report.missing if load_meta(name).get("sha256") else report.orphaned).append(name)
+
+    def _fix(
+        self,
+        root: Path,
+        report: VerifyReport,
+        fix: str,
+        found: dict[str, str],
+        on_removed: Optional[Callable[[str, int], None]],
+    ):
+        """Delete or quarantine corrupt files, and drop the sidecars of files that are gone."""
+        for name in report.corrupt:
+            path = root / name
+            try:
+                size = path.stat().st_size
+                if fix == "quarantine":
+                    recorded = load_meta(name).get("sha256", {})
+                    error = f"Checksum mismatch: recorded {recorded.get('hex')}, found {found.get(name)}"
+                    quarantine(path, name.replace("/", "_"), size, recorded.get("size"), error, "verify")
+                else:
+                    path.unlink()
+            except OSError as e:
+                report.errors.append(f"{name}: could not {fix}: {e.strerror or e}")
+                continue
+            delete_meta(name)
+            report.fixed.append(name)
+            if on_removed:
+                on_removed(name, size)
+        for name in report.missing + report.orphaned:
+            delete_meta(name)
+
+    def status(self) -> dict:
+        """State, progress and rates, plus the report's counts once it ended; for /api/status."""
+        with self._lock:
+            elapsed = max((self.finished or time.time()) - self.started, 1e-6)
+            status = {
+                "state": self.state,
+                "fix": self.fix,
+                "error": self.error or None,
+                "files": {"done": self.files_done, "total": self.files_total},
+                "bytes": {"done": self.bytes_done, "total": self.bytes_total},
+                "files_per_second": self.files_done / elapsed if self.started else None,
+                "bytes_per_second": self.bytes_done / elapsed if self.started else None,
+                "started": self.started or None,
+            }
+            report = self.report
+        if report is not None:
+            status["summary"] = {
+                key: len(value) if isinstance(value, list) else value
+                for key, value in report.to_dict().items()
+            }
+        return status
+
+
+# Global integrity sweep
+sweep = IntegritySweep()
@@ -0,0 +1,142 @@
+"""Noticing files changed in the uploads directory from outside Flashare."""
+
+import asyncio
+import os
+import uuid
+from pathlib import Path
+from typing import Callable, Optional
+
+from flashare.config import config
+from flashare.core.events import hub
+
+
+# Seconds to wait for the probe file to be reported before falling back to polling
+PROBE_TIMEOUT = 2.0
+
+# Relative path -> (size, mtime in ns) of every visible file
+Snapshot = dict[str, tuple[int, int]]
+
+
+def snapshot(root: Path) -> Snapshot:
+    """Size and modification time of every visible file under root; content is never read."""
+    files = {}
+    pending = [(root, "")]
+    while pending:
+        folder, rel = pending.pop()
+        try:
+            entries = list(os.scandir(folder))
+        except OSError:
+            continue
+        for entry in entries:
+            if entry.name.startswith("."):
+                continue  # Hidden, like in listings (this includes .meta and probe files)
+            child = f"{rel}/{entry.name}" if rel else entry.name
+            try:
+                if entry.is_dir(follow_symlinks=False):
+                    pending.append((Path(entry.path), child))
+                elif entry.is_file(follow_symlinks=False):
+                    stat = entry.stat(follow_symlinks=False)
+                    files[child] = (stat.st_size, stat.st_mtime_ns)
+            except OSError:
+                continue  # Vanished mid-walk
+    return files
+
+
+def diff(old: Snapshot, new: Snapshot) -> list[tuple[str, str]]:
+    """
+    Changes between two snapshots.
+
+    Returns:
+        (kind, path) pairs ordered by path, kind being 'added', 'removed' or 'changed'.
+    """
+    changes = [("removed", path) for path in old if path not in new]
+    changes += [
+        ("added" if path not in old else "changed", path)
+        for path, state in new.items()
+        if old.get(path) != state
+    ]
+    return sorted(changes, key=lambda change: change[1])
+
+
+async def notifications_work(root: Path) -> bool:
+    """
+    Whether change notifications are actually delivered for root.
+
+    Watches can often be set up on NFS/SMB mounts and in some containers
+    without any event ever arriving, so a probe file is written and the
+    watch must report it within PROBE_TIMEOUT.
+    """
+    try:
+        from watchfiles import awatch
+    except ImportError:
+        return False
+
+    stop = asyncio.Event()
+
+    async def first_change() -> bool:
+        async for _ in awatch(root, stop_event=stop, recursive=False, debounce=100):
+            return True
+        return False
+
+    watch = asyncio.create_task(first_change())
+    probe = root / f".flashare-probe-{uuid.uuid4().hex[:8]}"
+    try:
+        await asyncio.sleep(0.2)  # Let the watch start before writing
+        probe.write_bytes(b"")
+        return await asyncio.wait_for(watch, PROBE_TIMEOUT)
+    except (asyncio.TimeoutError, OSError):
+        return False
+    finally:
+        stop.set()
+        watch.cancel()
+        probe.unlink(missing_ok=True)
+
+
+class DirectoryWatcher:
+    """
+    Publishes 'files' events for changes made outside Flashare.
+
+    Files dropped in by a file manager, rsync or another app show up in
+    open browsers as if they had been uploaded.
+
+    Both modes find changes the same way, by diffing snapshots of sizes
+    and modification times, so they report exactly the same events; they
+    only differ in when they look. 'notify' rescans when the filesystem
+    reports a change, 'poll' every config.watch_interval seconds. Polling
+    is used whenever notifications_work() says events don't arrive.
+    """
+
+    def __init__(self, publish: Callable[[str, Optional[dict]], None] = hub.publish):
+        self.mode = "off"  # 'notify', 'poll' or 'off'
+        self._publish = publish
+        self._snapshot: Snapshot = {}
+
+    def scan(self, root: Path) -> list[tuple[str, str]]:
+        """Diff root against the last scan, publishing one event per change."""
+        if not root.is_dir():
+            return []  # Missing (e.g. unmounted); don't report everything as removed
+        current = snapshot(root)
+        changes = diff(self._snapshot, current)
+        self._snapshot = current
+        for kind, path in changes:
+            self._publish("files", {kind: path})
+        return changes
+
+    async def run(self, root: Path):
+        """Watch root until cancelled, choosing the mode first."""
+        self._snapshot = await asyncio.to_thread(snapshot, root)
+        self.mode = "notify" if await notifications_work(root) else "poll"
+        if self.mode == "notify":
+            print("👀 Watching the uploads directory for outside changes (notifications)")
+            from watchfiles import awatch
+            async for _ in awatch(root):
+                await asyncio.to_thread(self.scan, root)
+        else:
+            print(f"👀 Watching the uploads directory for outside changes (polling every {config.watch_interval:g}s)")
+            while True:
+                await asyncio.sleep(config.watch_interval)
+                await asyncio.to_thread(self.scan, root)
+
+
+# Global uploads directory watcher
+watcher = DirectoryWatcher()
@@ -0,0 +1,192 @@
+"""Zip archives prepared on disk before download, so interrupted downloads can resume."""
+
+import secrets
+import shutil
+import threading
+import time
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Callable, Optional
+
+from flashare.config import config
+from flashare.core.zipstream import stream_archive, ARCHIVE_FORMATS
+
+
+# Zip bytes per member besides its data (headers, data descriptor, zip64 extras)
+ENTRY_OVERHEAD = 200
+
+# Seconds between progress reports while an archive is built
+PROGRESS_INTERVAL = 0.5
+
+
+class JobRejected(Exception):
+    """An archive job that can't start now."""
+
+    def __init__(self, status: int, message: str):
+        super().__init__(message)
+        self.status = status  # HTTP status to answer with
+
+
+@dataclass
+class ZipJob:
+    """One archive, from building to deleted."""
+    id: str
+    name: str  # File name offered to the browser, e.g. 'Flashare.zip'
+    path: Path
+    total: int  # Estimated size while building, actual size once ready
+    format: str = "zip"  # One of ARCHIVE_FORMATS
+    written: int = 0
+    state: str = "building"  # building, ready or failed
+    error: str = ""
+    created: float = field(default_factory=time.time)
+    expires: Optional[float] = None  # Set when finished, pushed back by every download
+
+    @property
+    def etag(self) -> str:
+        # The archive is written once and never changes, so its ID names the content
+        return f'"{self.id}"'
+
+    @property
+    def media_type(self) -> str:
+        return ARCHIVE_FORMATS[self.format][0]
+
+    def to_dict(self) -> dict:
+        return {
+            "id": self.id,
+            "name": self.name,
+            "format": self.format,
+            "state": self.state,
+            "written": self.written,
+            "total": self.total,
+            "error": self.error or None,
+            "created": self.created,
+            "expires": self.expires,
+            "download_url": f"/api/archive-jobs/{self.id}/download",
+        }
+
+
+def estimate_size(entries: list[tuple[Path, str]]) -> int:
+    """Roughly how large a stored (uncompressed) zip of entries will be; compressed tars come out smaller."""
+    return sum(path.stat().st_size + ENTRY_OVERHEAD + 2 * len(arcname.encode()) for path, arcname in entries)
+
+
+class ZipJobs:
+    """
+    Archive jobs of this session.
+
+    Each archive is built by its own thread into config.data_dir, then
+    kept for config.zip_job_retention seconds after it was last
+    downloaded, so a phone whose download broke off can resume it with a
+    Range request. At most config.zip_jobs_limit archives are built at
+    once, and one is only started if it fits on disk next to those.
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self.jobs: dict[str, ZipJob] = {}
+
+    @property
+    def folder(self) -> Path:
+        return config.data_dir / "zip-jobs"
+
+    def start(
+        self,
+        entries: list[tuple[Path, str]],
+        name: str,
+        on_progress: Callable[[ZipJob], None],
+        archive_format: str = "zip",
+    ) -> ZipJob:
+        """
+        Start building an archive in the background.
+
+        Args:
+            entries: (path on disk, path in archive) pairs.
+            name: File name offered to the browser.
+            on_progress: Called from the building thread with the job as it
+                grows, and once more when it is ready or failed.
+            archive_format: One of ARCHIVE_FORMATS.
+
+        Raises:
+            JobRejected: 429 if too many archives are being built, 507 if
+                this one wouldn't fit on disk.
+        """
+        self.prune()
+        total = estimate_size(entries)
+        self.folder.mkdir(parents=True, exist_ok=True)
+
+        with self._lock:
+            building = [job for job in self.jobs.values() if job.state == "building"]
+            if len(building) >= config.zip_jobs_limit:
+                raise JobRejected(429, f"{len(building)} archive(s) are already being prepared; try again shortly")
+
+            # Space the other builds are still going to take is spoken for
+            free = shutil.disk_usage(self.folder).free - sum(max(job.total - job.written, 0) for job in building)
+            if total > free:
+                raise JobRejected(507, (
+                    f"Not enough disk space to prepare the archive "
+                    f"({total / 1024**2:.1f} MB needed, {max(free, 0) / 1024**2:.1f} MB free)"
+                ))
+
+            job_id = secrets.token_urlsafe(12)
+            extension = ARCHIVE_FORMATS[archive_format][1]
+            job = self.jobs[job_id] = ZipJob(job_id, name, self.folder / f"{job_id}{extension}", total, archive_format)
+
+        threading.Thread(target=self._build, args=(job, entries, on_progress), daemon=True).start()
+        return job
+
+    def _build(self, job: ZipJob, entries: list[tuple[Path, str]], on_progress: Callable[[ZipJob], None]):
+        partial = job.path.with_suffix(".part")
+        reported = 0.0
+        try:
+            with open(partial, "wb") as f:
+                for chunk in stream_archive(entries, job.format):
+                    f.write(chunk)
+                    job.written += len(chunk)
+                    if time.monotonic() - reported >= PROGRESS_INTERVAL:
+                        reported = time.monotonic()
+                        on_progress(job)
+            partial.rename(job.path)
+        except OSError as e:
+            partial.unlink(missing_ok=True)
+            job.error = str(e)
+            job.state = "failed"
+        else:
+            job.total = job.written
+            job.state = "ready"
+        job.expires = time.time() + config.zip_job_retention
+        on_progress(job)
+
+    def get(self, job_id: str) -> Optional[ZipJob]:
+        self.prune()
+        with self._lock:
+            return self.jobs.get(job_id)
+
+    def touch(self, job: ZipJob):
+        """Keep an archive for another retention window from now (it is being downloaded)."""
+        job.expires = time.time() + config.zip_job_retention
+
+    def prune(self) -> int:
+        """
+        Delete archives whose retention window has passed.
+
+        Returns:
+            Number of jobs removed.
+        """
+        now = time.time()
+        with self._lock:
+            expired = [job for job in self.jobs.values() if job.expires is not None and job.expires <= now]
+            for job in expired:
+                del self.jobs[job.id]
+        for job in expired:
+            job.path.unlink(missing_ok=True)  # A download still running keeps its open file
+        return len(expired)
+
+    def clear(self):
+        """Delete every archive, including leftovers of a session that crashed."""
+        with self._lock:
+            self.jobs.clear()
+        shutil.rmtree(self.folder, ignore_errors=True)
+
+
+# Global archive jobs
+zip_jobs = ZipJobs()
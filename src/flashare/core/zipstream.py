@@ -0,0 +1,187 @@
+"""Streaming zip and tar archives of stored files and folders."""
+
+import os
+import tarfile
+import zipfile
+import zlib
+from pathlib import Path
+from typing import Iterator
+
+from flashare.core.compression import create_compressor
+
+
+# Deepest folder nesting a zip download will walk into
+MAX_DEPTH = 32
+
+# Bytes read from each file per write into the archive
+READ_SIZE = 1024 * 1024
+
+# Archive formats offered for download: media type and file extension.
+# Zip opens everywhere without extra tools; the tars suit command lines
+ARCHIVE_FORMATS = {
+    "zip": ("application/zip", ".zip"),
+    "tar.gz": ("application/gzip", ".tar.gz"),
+    "tar.zst": ("application/zstd", ".tar.zst"),
+}
+
+
+class TooDeep(Exception):
+    """A folder nests deeper than MAX_DEPTH."""
+
+
+def walk_folder(folder: Path, prefix: str, depth: int = 0) -> list[tuple[Path, str]]:
+    """
+    Files under a folder with their paths inside the archive.
+
+    Hidden entries are skipped like in listings, and symlinks are never
+    followed, so a link can't pull in anything outside the share.
+
+    Args:
+        folder: Folder to walk.
+        prefix: Archive path of the folder itself, e.g. 'photos'.
+        depth: Current nesting level.
+
+    Returns:
+        (path on disk, path in archive) pairs, in name order.
+
+    Raises:
+        TooDeep: If nesting exceeds MAX_DEPTH.
+    """
+    if depth > MAX_DEPTH:
+        raise TooDeep(f"'{prefix}' is nested more than {MAX_DEPTH} folders deep")
+
+    entries = []
+    for entry in sorted(os.scandir(folder), key=lambda e: e.name):
+        if entry.name.startswith(".") or entry.is_symlink():
+            continue
+        arcname = f"{prefix}/{entry.name}"
+        if entry.is_dir():
+            entries.extend(walk_folder(Path(entry.path), arcname, depth + 1))
+        elif entry.is_file():
+            entries.append((Path(entry.path), arcname))
+    return entries
+
+
+class _Sink:
+    """Write-only buffer zipfile streams into; drained after every write."""
+
+    def __init__(self):
+        self._chunks: list[bytes] = []
+
+    def write(self, data) -> int:
+        self._chunks.append(bytes(data))
+        return len(data)
+
+    def flush(self):
+        pass
+
+    def drain(self) -> bytes:
+        data = b"".join(self._chunks)
+        self._chunks.clear()
+        return data
+
+
+def stream_zip(entries: list[tuple[Path, str]]) -> Iterator[bytes]:
+    """
+    Yield a zip archive of the given files without building it in memory.
+
+    Files are stored uncompressed (most shared media is compressed
+    already) and the sink isn't seekable, so zipfile writes data
+    descriptors after each member. Files that vanish mid-download are
+    left out.
+
+    Args:
+        entries: (path on disk, path in archive) pairs.
+
+    Yields:
+        Chunks of the archive.
+    """
+    sink = _Sink()
+    with zipfile.ZipFile(sink, "w", zipfile.ZIP_STORED) as archive:
+        for path, arcname in entries:
+            try:
+                info = zipfile.ZipInfo.from_file(path, arcname)
+                src = open(path, "rb")
+            except OSError:
+                continue
+            with src, archive.open(info, "w", force_zip64=True) as dest:
+                while chunk := src.read(READ_SIZE):
+                    dest.write(chunk)
+                    if data := sink.drain():
+                        yield data
+            if data := sink.drain():
+                yield data
+    if data := sink.drain():
+        yield data
+
+
+def _tar_blocks(entries: list[tuple[Path, str]]) -> Iterator[bytes]:
+    """
+    Yield an uncompressed tar of the given files, member by member.
+
+    Headers are written by tarfile; data is read in READ_SIZE pieces and
+    capped at the size in the header, so a file growing mid-download
+    can't corrupt the archive (one shrinking is padded with zeros).
+    Files that vanish first are left out.
+    """
+    written = 0
+    for path, arcname in entries:
+        try:
+            src = open(path, "rb")
+        except OSError:
+            continue
+        with src:
+            st = os.fstat(src.fileno())
+            info = tarfile.TarInfo(arcname)
+            info.size = st.st_size
+            info.mtime = int(st.st_mtime)
+            info.mode = st.st_mode & 0o777
+            header = info.tobuf(tarfile.PAX_FORMAT)
+            yield header
+            remaining = info.size
+            while remaining and (chunk := src.read(min(READ_SIZE, remaining))):
+                remaining -= len(chunk)
+                yield chunk
+            while remaining:
+                padding = min(READ_SIZE, remaining)
+                remaining -= padding
+                yield bytes(padding)
+            yield bytes(-info.size % tarfile.BLOCKSIZE)
+            written += len(header) + info.size + -info.size % tarfile.BLOCKSIZE
+
+    # End-of-archive marker, then padding to a whole record like tarfile writes
+    written += 2 * tarfile.BLOCKSIZE
+    yield bytes(2 * tarfile.BLOCKSIZE + -written % tarfile.RECORDSIZE)
+
+
+def stream_tar(entries: list[tuple[Path, str]], compression: str) -> Iterator[bytes]:
+    """
+    Yield a compressed tar archive of the given files without building it in memory.
+
+    Args:
+        entries: (path on disk, path in archive) pairs.
+        compression: 'gz' or 'zst'.
+
+    Yields:
+        Chunks of the archive.
+    """
+    if compression == "gz":
+        compressor = zlib.compressobj(6, zlib.DEFLATED, 16 + zlib.MAX_WBITS)  # gzip framing
+    else:
+        compressor = create_compressor().compressobj()
+    for block in _tar_blocks(entries):
+        if data := compressor.compress(block):
+            yield data
+    yield compressor.flush()
+
+
+def stream_archive(entries: list[tuple[Path, str]], archive_format: str = "zip") -> Iterator[bytes]:
+    """
+    Yield an archive of the given files in one of ARCHIVE_FORMATS.
+
+    Every format takes the same entries, so what goes in (and what is
+    left out) doesn't depend on the format.
+    """
+    if archive_format == "zip":
+        return stream_zip(entries)
+    return stream_tar(entries, archive_format.removeprefix("tar."))
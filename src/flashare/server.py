@@ -1,30 +1,716 @@
 """Main FastAPI server for Flashare."""
 
 import asyncio
+import base64
+import html
+import json
+import logging
+import mimetypes
+import time
 from contextlib import asynccontextmanager
+from functools import lru_cache
+from http import HTTPStatus
 from pathlib import Path
+from typing import Optional
+from urllib.parse import quote
 
-from fastapi import FastAPI
+from fastapi import FastAPI, HTTPException, Request
 from fastapi.staticfiles import StaticFiles
-from fastapi.responses import FileResponse
+from fastapi.responses import FileResponse, JSONResponse, Response, HTMLResponse, RedirectResponse
 from fastapi.middleware.cors import CORSMiddleware
+from starlette.datastructures import Headers, MutableHeaders
+from starlette.exceptions import HTTPException as StarletteHTTPException
+from starlette.formparsers import MultiPartParser
+from starlette.routing import Mount
 
 from flashare import __version__, __app_name__
 from flashare.config import config
-from flashare.api.routes import router as api_router
+from flashare.api.semantics import select_representation
+from flashare.api.routes import (
+    router as api_router,
+    check_declared_upload_size,
+    CodedHTTPException,
+    find_existing_upload,
+    format_size,
+    get_device_id,
+    is_host,
+    run_scheduled_deletions,
+    run_in_executor,
+)
+from flashare.core.access import share_access, share_url, short_url, KEY_COOKIE, BOOTSTRAP_TTL
+from flashare.core.branding import get_branding
+from flashare.core.collect import collection_links, Collection
+from flashare.core.compression import compress_bytes, negotiate_encoding
+from flashare.core.correlation import accept_request_id, bind_request_id, current_request_id, reset_request_id, log
+from flashare.core.logbook import logbook
+from flashare.core.mime import register_mime_types
+from flashare.core.ndjson import ndjson
+from flashare.core.network import get_server_url, share_origins
+from flashare.core.qr import generate_qr_png_bytes
+from flashare.core.redact import AccessLogFilter
+from flashare.core.relay import relay, RelayError
+from flashare.core.security import security, AUDITED_STATUSES
+from flashare.core.devices import devices, new_device_id, resolve_device_id, DEVICE_COOKIE
+from flashare.core.dirsize import dir_sizes
+from flashare.core.encodedcache import encoded_cache
+from flashare.core.events import hub
+from flashare.core.health import storage_health
+from flashare.core.notify import desktop_notify, notify_connected
+from flashare.core.staging import StagedStorage
+from flashare.core.storage import get_storage
+from flashare.core.stats import stats
+from flashare.core.tus import tus_uploads
+from flashare.core.watcher import watcher
+from flashare.core.zipjobs import zip_jobs
+
+
+# Text assets worth compressing; images/fonts are already compressed
+COMPRESSIBLE_SUFFIXES = {".html", ".css", ".js", ".svg", ".json", ".txt"}
+
+# JSON bodies smaller than this aren't worth the CPU
+MIN_COMPRESS_SIZE = 1024
+
+# Reachable without the share key: assets, claim codes and collection links (the code is the credential)
+KEYLESS_PREFIXES = ("/static/", "/c/", "/pin", "/go/", "/connect/", "/api/claim/", "/api/branding/logo", "/u/", "/api/collect/")
+
+# Headers tus clients on another origin (e.g. Uppy) must be able to read
+TUS_RESPONSE_HEADERS = (
+    "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+    "Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires", "X-File-Id",
+)
+
+# Methods an allowed cross-origin page may use (named, so preflights list them all)
+CORS_METHODS = ("GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+
+
+@lru_cache(maxsize=64)
+def _precompressed_asset(path: Path, mtime: float, encoding: str) -> bytes:
+    """Compress a static asset once per modification time and encoding."""
+    return compress_bytes(path.read_bytes(), encoding)
+
+
+def asset_response(path: Path, accept_encoding: str | None) -> Response:
+    """
+    Serve a static asset, pre-compressed when the client supports it.
+    
+    Args:
+        path: Asset path on disk.
+        accept_encoding: The request's Accept-Encoding header.
+        
+    Returns:
+        A compressed Response, or a plain FileResponse.
+    """
+    stat = path.stat()
+    representation = select_representation(
+        {"accept-encoding": accept_encoding or ""}, stat.st_size,
+        compressible=path.suffix in COMPRESSIBLE_SUFFIXES, ranges=False,
+    )
+    vary = {"Vary": representation.headers["Vary"]} if "Vary" in representation.headers else {}
+    if not representation.encoding:
+        return FileResponse(path, headers=vary)
+    
+    body = _precompressed_asset(path, stat.st_mtime, representation.encoding)
+    media_type = mimetypes.guess_type(path.name)[0] or "application/octet-stream"
+    return Response(
+        content=body,
+        media_type=media_type,
+        headers={"Content-Encoding": representation.encoding, **vary},
+    )
+
+
+class CompressedStaticFiles(StaticFiles):
+    """StaticFiles that negotiates zstd/br/gzip for text assets."""
+    
+    async def get_response(self, path: str, scope) -> Response:
+        response = await super().get_response(path, scope)
+        if isinstance(response, FileResponse) and response.status_code == 200:
+            headers = dict((k.decode(), v.decode()) for k, v in scope["headers"])
+            return asset_response(Path(response.path), headers.get("accept-encoding"))
+        return response
+
+
+class ShareCORSMiddleware(CORSMiddleware):
+    """
+    CORS for the share's own URLs, plus any given with --cors-origin.
+    
+    Built when the server starts, so the allowed origins follow the port
+    and LAN address actually in use. Browsers only apply CORS when reading
+    a response, so requests from other sites are refused here before they
+    run; otherwise a page could still upload or delete blind. --cors-any
+    allows every origin but never with cookies, so the share key can't be
+    used by whatever site a guest has open.
+    """
+    
+    def __init__(self, app):
+        super().__init__(
+            app,
+            allow_origins=["*"] if config.cors_any else sorted(share_origins(config.port) | set(config.cors_origins)),
+            allow_credentials=config.cors_allow_credentials and not config.cors_any,
+            allow_methods=CORS_METHODS,
+            allow_headers=["*"],
+            expose_headers=[config.request_id_header, *TUS_RESPONSE_HEADERS],
+        )
+    
+    async def __call__(self, scope, receive, send):
+        if scope["type"] == "http" and scope["method"] != "OPTIONS":
+            headers = Headers(scope=scope)
+            origin = headers.get("origin")
+            same_origin = origin == f"{scope['scheme']}://{headers.get('host')}"
+            if origin and not same_origin and not self.is_allowed_origin(origin):
+                response = JSONResponse({"detail": "Requests from other websites are not allowed"}, status_code=403)
+                await response(scope, receive, send)
+                return
+        await super().__call__(scope, receive, send)
+
+
+def _route_segments(routes) -> list[list[str]]:
+    """Path templates of the app's routes, split into segments ('{param}' kept)."""
+    return [
+        route.path.strip("/").split("/")
+        for route in routes
+        if getattr(route, "path", None) and not isinstance(route, Mount)
+    ]
+
+
+def normalize_route_path(path: str, routes) -> str:
+    """
+    Map a request path onto the route it was meant for.
+    
+    A trailing slash is dropped and the fixed segments of a route are
+    matched without regard to case, so '/API/Files/' resolves to
+    '/api/files'. Parameter segments (file names, codes) keep their case.
+    
+    Args:
+        path: The request path.
+        routes: The app's routes.
+        
+    Returns:
+        The canonical path, or the path unchanged if nothing matches.
+    """
+    stripped = path.rstrip("/") or "/"
+    segments = stripped.strip("/").split("/")
+    lowered = [segment.lower() for segment in segments]
+    candidates = [t for t in _route_segments(routes) if len(t) == len(segments)]
+    
+    def fixed(template, parts):
+        return all(t.startswith("{") or t == p for t, p in zip(template, parts))
+    
+    # An exact match wins, so a route never shadows a differently-cased one
+    if any(fixed(template, segments) for template in candidates):
+        return stripped
+    for template in candidates:
+        if fixed(template, lowered):
+            return "/" + "/".join(
+                segment if t.startswith("{") else t for t, segment in zip(template, segments)
+            )
+    return path
+
+
+class LenientRoutes:
+    """ASGI middleware rewriting request paths with normalize_route_path."""
+    
+    def __init__(self, app, routes):
+        self.app = app
+        self.routes = routes
+    
+    async def __call__(self, scope, receive, send):
+        if scope["type"] in ("http", "websocket") and config.lenient_routes:
+            path = normalize_route_path(scope["path"], self.routes)
+            if path != scope["path"]:
+                scope = dict(scope, path=path, raw_path=quote(path).encode())
+        await self.app(scope, receive, send)
+
+
+class RequestIDs:
+    """
+    ASGI middleware giving every request an ID, sent back in config.request_id_header.
+    
+    A well-formed incoming ID (e.g. from a reverse proxy) is kept. The ID
+    is current for the whole request, so log lines, security events,
+    transfers and error bodies carry it. An unhandled error is logged
+    with it and answered with a 500 naming it, so "request abc123 failed"
+    can be found in the logs.
+    """
+    
+    def __init__(self, app):
+        self.app = app
+    
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+        
+        headers = Headers(scope=scope)
+        request_id = accept_request_id(headers.get(config.request_id_header))
+        token = bind_request_id(request_id)
+        started = False
+        
+        async def send_with_id(message):
+            nonlocal started
+            if message["type"] == "http.response.start":
+                started = True
+                MutableHeaders(scope=message)[config.request_id_header] = request_id
+            await send(message)
+        
+        try:
+            await self.app(scope, receive, send_with_id)
+        except Exception as e:
+            log(f"💥 {scope['method']} {scope['path']} failed: {type(e).__name__}: {e}", "error")
+            if not started:
+                if "text/html" in headers.get("accept", ""):
+                    response = HTMLResponse(
+                        error_page(500, "Something went wrong on the share", scope["path"], request_id), status_code=500
+                    )
+                else:
+                    response = JSONResponse({"detail": "Internal server error", "request_id": request_id}, status_code=500)
+                await response(scope, receive, send_with_id)
+            raise  # Still logged with its traceback by the server
+        finally:
+            reset_request_id(token)
+
+
+class RecordRejections:
+    """ASGI middleware feeding refused requests to the security log."""
+    
+    def __init__(self, app):
+        self.app = app
+    
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+        
+        status = 0
+        body = bytearray()
+        
+        async def send_and_record(message):
+            nonlocal status
+            if message["type"] == "http.response.start":
+                status = message["status"]
+            elif message["type"] == "http.response.body" and status in AUDITED_STATUSES:
+                # Rejections carry a short JSON body with the reason
+                if len(body) < 4096:
+                    body.extend(message.get("body", b""))
+                if not message.get("more_body"):
+                    client = scope["client"][0] if scope.get("client") else ""
+                    security.record(status, client, scope["method"], scope["path"], _rejection_reason(status, bytes(body)))
+            await send(message)
+        
+        await self.app(scope, receive, send_and_record)
+
+
+def _rejection_reason(status: int, body: bytes) -> str:
+    """The 'detail' of an error body, or the status phrase."""
+    try:
+        detail = json.loads(body).get("detail")
+    except (ValueError, AttributeError):
+        detail = None
+    return str(detail) if detail else HTTPStatus(status).phrase
+
+
+def qr_page() -> str:
+    """Full-screen QR page for projecting at events, using the share's branding."""
+    branding = get_branding()
+    title = html.escape(branding["title"])
+    accent = branding["accent_color"] or "#6366f1"
+    logo = f'<img class="logo" src="{branding["logo_url"]}" alt="">' if branding["logo_url"] else ""
+    # One-time links are single-use, so the text can't show the one in the image
+    url = html.escape(get_server_url(config.port) if config.one_time_qr else share_url(config.port))
+    refresh = f"setInterval(refreshQr, {BOOTSTRAP_TTL * 1000 // 2})" if config.one_time_qr else ""
+    typed = html.escape(short_url(config.port).removeprefix("http://"))
+    return f"""<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{title}</title>
+<style>
+body {{ margin: 0; min-height: 100vh; display: flex; flex-direction: column; align-items: center;
+       justify-content: center; gap: 24px; background: #0a0a0f; color: #fff; font-family: sans-serif; }}
+h1 {{ margin: 0; color: {accent}; }}
+.logo {{ max-height: 96px; }}
+.qr {{ width: min(70vh, 80vw); background: #fff; padding: 16px; border-radius: 12px; }}
+</style>
+</head>
+<body>
+{logo}
+<h1>{title}</h1>
+<img class="qr" id="qr" src="/api/qr.png" alt="QR code">
+<p>{url}</p>
+<p>or type <strong>{typed}</strong></p>
+<script>
+// Show the new code as soon as the host rotates the share key
+const events = new EventSource("/api/events")
+events.addEventListener("credentials_rotated", () => location.reload())
+// One-time links: show a fresh one once this one is used, and before it expires
+const refreshQr = () => {{ document.getElementById("qr").src = `/api/qr.png?t=${{Date.now()}}` }}
+events.addEventListener("qr_used", refreshQr)
+{refresh}
+</script>
+</body>
+</html>
+"""
+
+
+# Printable page sizes (CSS @page names) for /api/poster
+POSTER_PAPERS = {"a4": "A4", "letter": "letter"}
+
+
+def poster_page(qr_png: bytes, paper: str = "a4", title: str = "", message: str = "") -> str:
+    """
+    One-page printable sign for events.
+    
+    The QR code prints about 14cm wide, so it scans from across a room;
+    the short link, the PIN (with --pin) and the full link are beneath.
+    
+    Args:
+        qr_png: QR code of the share link, embedded so printing needs no second request.
+        paper: One of POSTER_PAPERS.
+        title: Heading; the share's title by default.
+        message: Line under the heading; config.poster_message by default.
+    """
+    branding = get_branding()
+    heading = html.escape(title or branding["title"])
+    accent = branding["accent_color"] or "#6366f1"
+    logo = f'<img class="logo" src="{branding["logo_url"]}" alt="">' if branding["logo_url"] else ""
+    message = message or config.poster_message
+    note = f'<p class="message">{html.escape(message)}</p>' if message else ""
+    url = html.escape(share_url(config.port))
+    typed = html.escape(short_url(config.port).removeprefix("http://"))
+    pin = ""
+    if config.show_pin:
+        pin_url = html.escape(get_server_url(config.port).removeprefix("http://") + "/pin")
+        pin = f'<p>No camera? Open <strong>{pin_url}</strong> and enter PIN <strong>{share_access.pin}</strong></p>'
+    image = base64.b64encode(qr_png).decode()
+    return f"""<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{heading}</title>
+<style>
+@page {{ size: {POSTER_PAPERS[paper]} portrait; margin: 15mm; }}
+body {{ margin: 0; display: flex; flex-direction: column; align-items: center; gap: 6mm;
+       text-align: center; color: #000; background: #fff; font-family: sans-serif; }}
+h1 {{ margin: 0; font-size: 34pt; color: {accent}; }}
+p {{ margin: 0; font-size: 14pt; }}
+.logo {{ max-height: 25mm; }}
+.message {{ font-size: 20pt; }}
+.qr {{ width: 140mm; height: 140mm; image-rendering: pixelated; }}
+.typed {{ font-size: 24pt; }}
+.url {{ font-size: 9pt; color: #555; word-break: break-all; }}
+button {{ font-size: 14pt; padding: 8px 24px; border: 0; border-radius: 8px; background: {accent}; color: #fff; }}
+@media print {{ button {{ display: none; }} }}
+</style>
+</head>
+<body>
+{logo}
+<h1>{heading}</h1>
+{note}
+<img class="qr" src="data:image/png;base64,{image}" alt="QR code">
+<p>Scan with your phone's camera, or type</p>
+<p class="typed"><strong>{typed}</strong></p>
+{pin}
+<p class="url">{url}</p>
+<button onclick="print()">Print</button>
+</body>
+</html>
+"""
+
+
+def pin_page(error: str = "") -> str:
+    """Page where a device without a camera types the share's PIN."""
+    branding = get_branding()
+    title = html.escape(branding["title"])
+    accent = branding["accent_color"] or "#6366f1"
+    message = f'<p class="error">{html.escape(error)}</p>' if error else ""
+    return f"""<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{title}</title>
+<style>
+body {{ margin: 0; min-height: 100vh; display: flex; flex-direction: column; align-items: center;
+       justify-content: center; gap: 16px; background: #0a0a0f; color: #fff; font-family: sans-serif; }}
+h1 {{ margin: 0; color: {accent}; }}
+input {{ font-size: 2rem; width: 8ch; text-align: center; letter-spacing: 0.2em; padding: 8px; border-radius: 8px; }}
+button {{ font-size: 1.2rem; padding: 8px 24px; border: 0; border-radius: 8px; background: {accent}; color: #fff; }}
+.error {{ color: #f87171; }}
+</style>
+</head>
+<body>
+<h1>{title}</h1>
+<p>Enter the PIN shown on the host's screen</p>
+{message}
+<form action="/pin" method="get">
+<input name="code" inputmode="numeric" pattern="[0-9]{{6}}" maxlength="6" autocomplete="off" autofocus required>
+<button type="submit">Connect</button>
+</form>
+</body>
+</html>
+"""
+
+
+def error_page(status: int, detail: str, path: str, request_id: str = "", hint: str = "") -> str:
+    """Branded error page for browsers that land on a missing or refused URL, with an optional hint what to do."""
+    branding = get_branding()
+    title = html.escape(branding["title"])
+    accent = branding["accent_color"] or "#6366f1"
+    phrase = HTTPStatus(status).phrase
+    message = f"Nothing is shared at {path}" if status == 404 else detail
+    reference = f'<p class="reference">Request ID: {html.escape(request_id)}</p>' if request_id else ""
+    advice = f"<p>{html.escape(hint)}</p>" if hint else ""
+    return f"""<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{status} {html.escape(phrase)} - {title}</title>
+<style>
+body {{ margin: 0; min-height: 100vh; display: flex; flex-direction: column; align-items: center;
+       justify-content: center; gap: 16px; background: #0a0a0f; color: #fff; font-family: sans-serif;
+       text-align: center; padding: 0 24px; }}
+h1 {{ margin: 0; font-size: 4rem; color: {accent}; }}
+p {{ margin: 0; color: #a1a1aa; word-break: break-all; }}
+a {{ margin-top: 8px; padding: 8px 24px; border-radius: 8px; background: {accent}; color: #fff; text-decoration: none; }}
+.reference {{ font-size: 0.8rem; font-family: monospace; }}
+</style>
+</head>
+<body>
+<h1>{status}</h1>
+<h2>{html.escape(phrase)}</h2>
+<p>{html.escape(message)}</p>
+{advice}
+{reference}
+<a href="/">Back to {title}</a>
+</body>
+</html>
+"""
+
+
+# What guests opening a collection link that no longer works are told
+COLLECTION_PROBLEMS = {
+    "unknown": "This upload link doesn't exist. Check that it was copied completely.",
+    "revoked": "This upload link has been closed by its owner. Thank you anyway!",
+    "expired": "This upload link has expired. Ask whoever sent it for a new one.",
+}
+
+
+def collection_page(collection: Optional[Collection], problem: str = "") -> str:
+    """
+    Upload-only page behind a collection link, or why the link doesn't work.
+    
+    Files are posted one at a time to /api/collect/<token>, so each gets its
+    own progress and a failure doesn't lose the rest.
+    """
+    branding = get_branding()
+    accent = branding["accent_color"] or "#6366f1"
+    heading = html.escape(collection.name if collection else branding["title"])
+    if problem:
+        body = f'<p class="note">{html.escape(COLLECTION_PROBLEMS[problem])}</p>'
+    else:
+        expires = time.strftime("%d %b %Y %H:%M", time.localtime(collection.expires)) if collection.expires else ""
+        limits = f"<p class=\"note\">Open until {expires}</p>" if expires else ""
+        body = f"""{limits}
+<label class="pick">Choose photos or files<input id="files" type="file" multiple></label>
+<ul id="results"></ul>
+<script>
+const token = {json.dumps(collection.token)}
+const results = document.getElementById("results")
+document.getElementById("files").addEventListener("change", async (e) => {{
+  for (const file of e.target.files) {{
+    const row = document.createElement("li")
+    row.textContent = `${{file.name}}: waiting`
+    results.appendChild(row)
+    await new Promise((resolve) => {{
+      const xhr = new XMLHttpRequest()
+      const form = new FormData()
+      form.append("files", file)
+      xhr.upload.onprogress = (p) => {{ row.textContent = `${{file.name}}: ${{Math.round(p.loaded / p.total * 100)}}%` }}
+      xhr.onload = () => {{
+        let result = {{}}
+        try {{ result = JSON.parse(xhr.responseText) }} catch (error) {{}}
+        const saved = result.files && result.files[0]
+        row.textContent = saved && saved.success ? `${{file.name}}: ✓ uploaded`
+          : `${{file.name}}: ✗ ${{(saved && saved.error) || result.detail || "upload failed"}}`
+        resolve()
+      }}
+      xhr.onerror = () => {{ row.textContent = `${{file.name}}: ✗ connection lost`; resolve() }}
+      xhr.open("POST", `/api/collect/${{token}}`)
+      xhr.send(form)
+    }})
+  }}
+  e.target.value = ""
+}})
+</script>"""
+    return f"""<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{heading}</title>
+<style>
+body {{ margin: 0; min-height: 100vh; display: flex; flex-direction: column; align-items: center;
+       justify-content: center; gap: 16px; background: #0a0a0f; color: #fff; font-family: sans-serif;
+       text-align: center; padding: 0 24px; }}
+h1 {{ margin: 0; color: {accent}; }}
+.note {{ margin: 0; color: #a1a1aa; }}
+.pick {{ font-size: 1.2rem; padding: 12px 24px; border-radius: 8px; background: {accent}; cursor: pointer; }}
+.pick input {{ display: none; }}
+ul {{ list-style: none; padding: 0; margin: 0; text-align: left; word-break: break-all; }}
+</style>
+</head>
+<body>
+<h1>{heading}</h1>
+{body}
+</body>
+</html>
+"""
+
+
+def wants_html(request: Request) -> bool:
+    """Whether the request is a browser navigation rather than an API call."""
+    return "text/html" in request.headers.get("accept", "")
+
+
+def set_device_cookie(request: Request, response: Response, device_id: str):
+    """Hand a browser its device ID, once per request."""
+    response.set_cookie(DEVICE_COOKIE, device_id, max_age=30 * 24 * 3600, httponly=True, samesite="lax")
+    request.state.device_cookie_set = True
+
+
+def follow_pin(request: Request, code: Optional[str]) -> Response:
+    """
+    Send a device that typed the right PIN into the share, as the link would.
+    
+    The host PIN also makes the device a host; one arriving without a
+    device cookie is given one here, so the role sticks to the browser.
+    """
+    if not config.show_pin and not config.device_roles:
+        raise HTTPException(status_code=404, detail="PIN access is not enabled on this share")
+    if code is None:
+        return HTMLResponse(pin_page())
+    
+    client = request.client.host if request.client else ""
+    result = share_access.redeem_pin(code, client)
+    if result == "pin_locked":
+        return HTMLResponse(pin_page("Too many wrong PINs; ask the host for the link"), status_code=429)
+    if result == "pin_invalid":
+        return HTMLResponse(pin_page("That PIN is not right"), status_code=401)
+    
+    response = RedirectResponse(f"/?key={quote(share_access.key)}" if config.require_key else "/")
+    if result == "host":
+        device_id = request.cookies.get(DEVICE_COOKIE)
+        if resolve_device_id(device_id, client) != device_id:
+            device_id = new_device_id()
+            set_device_cookie(request, response, device_id)
+        devices.set_role(device_id, "host", client)
+        log(f"🔑 {client} entered the host PIN and is now a host device")
+    return response
+
+
+async def sweep():
+    """Background sweeper: carry out scheduled deletions as they fall due, and drop expired archives, uploads and copies."""
+    while True:
+        try:
+            deleted = await asyncio.to_thread(run_scheduled_deletions)
+            if deleted:
+                log(f"🗑️  Deleted {deleted} scheduled file(s)")
+            await asyncio.to_thread(zip_jobs.prune)
+            await asyncio.to_thread(tus_uploads.prune)
+            await asyncio.to_thread(encoded_cache.prune)
+        except OSError as e:
+            log(f"⚠️  Scheduled deletion failed: {e}", "warning")
+        await asyncio.sleep(config.sweep_interval)
+
+
+async def watch_storage():
+    """Probe the uploads directory every config.storage_probe_interval seconds, so losing it is noticed while idle."""
+    while True:
+        await asyncio.to_thread(storage_health.probe, config.uploads_dir, not config.read_only)
+        await asyncio.sleep(config.storage_probe_interval)
+
+
+def storage_changed(available: bool, reason: str):
+    """Tell clients and the host that the uploads directory went away or came back."""
+    hub.publish("storage", storage_health.status())
+    if available:
+        # Whatever happened while it was gone, sizes are re-counted from scratch
+        dir_sizes.rebuild(config.uploads_dir)
+        desktop_notify(__app_name__, f"Uploads directory is back: {config.uploads_dir}")
+    else:
+        desktop_notify(f"{__app_name__}: storage unavailable", f"{reason[:1].upper()}{reason[1:]}: {config.uploads_dir}")
+
+
+async def keep_relay_code():
+    """Register with the relay and keep the short code alive until shutdown."""
+    announced = None
+    while True:
+        try:
+            pin = share_access.pin if config.show_pin else None
+            code = await asyncio.to_thread(relay.register, get_server_url(config.port), pin)
+            if code.code != announced:
+                log(f"🔗 Relay code: {code.code}  →  {code.url}")
+                announced = code.code
+        except RelayError as e:
+            log(f"⚠️  {e}; retrying", "warning")
+        await asyncio.sleep(relay.renew_after())
+
+
 @asynccontextmanager
 async def lifespan(app: FastAPI):
     """
     Application lifespan handler.
     """
     # Startup
-    print(f"🚀 Starting {__app_name__} v{__version__}")
-    print(f"📁 Uploads directory: {config.uploads_dir}")
+    log(f"🚀 Starting {__app_name__} v{__version__}")
+    log(f"📁 Uploads directory: {config.uploads_dir}")
+    # Multipart files larger than this leave RAM for a temp file while parsing
+    MultiPartParser.spool_max_size = config.stream_threshold
+    register_mime_types()
+    # Archives from a previous run can't be resumed against (their jobs are gone)
+    await asyncio.to_thread(zip_jobs.clear)
+    sweeper = asyncio.create_task(sweep())
+    relay_task = asyncio.create_task(keep_relay_code()) if config.use_relay else None
+    watch_task = None
+    if config.storage_backend == "local" and config.watch_interval:
+        watch_task = asyncio.create_task(watcher.run(config.uploads_dir))
+    probe_task = None
+    if config.storage_backend == "local":
+        dir_sizes.rebuild(config.uploads_dir)
+        storage_health.watch(config.uploads_dir)
+        storage_health.listen(storage_changed)
+        if config.storage_probe_interval:
+            probe_task = asyncio.create_task(watch_storage())
+    open_links = await asyncio.to_thread(collection_links.load)
+    if open_links:
+        log(f"📥 {open_links} collection link(s) open for uploads")
+    if config.device_roles and config.remember_roles:
+        restored = devices.load_roles()
+        if restored:
+            log(f"🔑 Restored roles of {restored} device(s)")
     
     yield
     
-    # Shutdown
-    print(f"👋 {__app_name__} shutting down")
+    sweeper.cancel()
+    if watch_task:
+        watch_task.cancel()
+    if probe_task:
+        probe_task.cancel()
+    if relay_task:
+        relay_task.cancel()
+        await asyncio.to_thread(relay.release)
+    
+    # Shutdown: staged uploads must reach disk before we exit
+    storage = get_storage()
+    if isinstance(storage, StagedStorage) and storage.unflushed:
+        log(f"💾 Flushing {storage.unflushed} staged upload(s) to disk...")
+        await asyncio.to_thread(storage.drain)
+    
+    await asyncio.to_thread(zip_jobs.clear)
+    
+    log(f"👋 {__app_name__} shutting down")
 
 
 def create_app() -> FastAPI:
@@ -41,14 +727,141 @@ def create_app() -> FastAPI:
         lifespan=lifespan,
     )
     
-    # CORS middleware for browser access
-    app.add_middleware(
-        CORSMiddleware,
-        allow_origins=["*"],
-        allow_credentials=True,
-        allow_methods=["*"],
-        allow_headers=["*"],
-    )
+    # Read-only shares (e.g. a re-hosted bundle) refuse every change
+    @app.middleware("http")
+    async def refuse_writes(request: Request, call_next):
+        if config.read_only and request.method not in ("GET", "HEAD", "OPTIONS"):
+            return JSONResponse({"detail": "This share is read-only"}, status_code=403)
+        return await call_next(request)
+    
+    # Track connected clients for /api/metrics and /api/devices
+    @app.middleware("http")
+    async def track_clients(request: Request, call_next):
+        if request.client:
+            stats.touch_client(request.client.host)
+            device = get_device_id(request)
+            # Browsers count once they hold a device cookie; before that they're only an address
+            if devices.touch(device, request.client.host) and DEVICE_COOKIE in request.cookies:
+                ndjson.emit("device_connected", device=device[:8], ip=request.client.host)
+                notify_connected(request.client.host)
+                # Keeps the count badge of the server view current
+                log(f"📱 Device connected from {request.client.host} ({len(stats.clients)} connected)")
+        return await call_next(request)
+    
+    # Give each browser a device cookie so quotas and roles follow it across IP changes
+    @app.middleware("http")
+    async def assign_device_cookie(request: Request, call_next):
+        response = await call_next(request)
+        if DEVICE_COOKIE not in request.cookies and not getattr(request.state, "device_cookie_set", False):
+            set_device_cookie(request, response, new_device_id())
+        return response
+    
+    # Refuse uploads whose declared size can't fit before reading the body
+    @app.middleware("http")
+    async def reject_oversized_uploads(request: Request, call_next):
+        if request.method == "POST" and request.url.path.startswith("/api/upload"):
+            rejection = check_declared_upload_size(request.headers.get("x-file-size"), get_device_id(request))
+            if rejection:
+                return JSONResponse(rejection.body(), status_code=rejection.status)
+        return await call_next(request)
+    
+    # Skip uploads whose content is already stored (If-None-Match: "<sha256>")
+    @app.middleware("http")
+    async def skip_existing_uploads(request: Request, call_next):
+        if request.method == "POST" and request.url.path == "/api/upload":
+            existing = await run_in_executor(find_existing_upload, request.headers.get("if-none-match"))
+            if existing:
+                filename, digest = existing
+                return Response(status_code=304, headers={
+                    "ETag": f'"{digest}"',
+                    "X-Existing-File": quote(filename),
+                })
+        return await call_next(request)
+    
+    # Compress JSON API responses using the shared negotiation
+    @app.middleware("http")
+    async def compress_json(request: Request, call_next):
+        response = await call_next(request)
+        
+        content_type = response.headers.get("content-type", "")
+        if not content_type.startswith("application/json") or "content-encoding" in response.headers:
+            return response
+        
+        encoding = negotiate_encoding(request.headers.get("accept-encoding"))
+        if not encoding:
+            return response
+        
+        body = b"".join([chunk async for chunk in response.body_iterator])
+        headers = dict(response.headers)
+        headers.pop("content-length", None)
+        
+        if len(body) >= MIN_COMPRESS_SIZE:
+            body = compress_bytes(body, encoding)
+            headers["content-encoding"] = encoding
+            headers["vary"] = "Accept-Encoding"
+        
+        return Response(content=body, status_code=response.status_code, headers=headers)
+    
+    # Guests must present the share key (from the link, then a cookie). Registered
+    # last so it runs first: nothing above counts, sizes or answers a guest without it
+    @app.middleware("http")
+    async def require_share_key(request: Request, call_next):
+        if not config.require_key or is_host(request) or request.url.path.startswith(KEYLESS_PREFIXES):
+            return await call_next(request)
+        
+        from_link = request.query_params.get("key")
+        error = share_access.check(from_link or request.cookies.get(KEY_COOKIE))
+        if error:
+            detail = "The share key has changed; ask the host for the new link" if error == "credentials_rotated" \
+                else "This share needs the link or QR code from the host"
+            return JSONResponse({"detail": detail, "code": error}, status_code=401)
+        
+        response = await call_next(request)
+        if from_link:
+            response.set_cookie(KEY_COOKIE, from_link, httponly=True, samesite="lax")
+        return response
+    
+    # Outside the key check, so preflights are answered and refusals are readable
+    app.add_middleware(ShareCORSMiddleware)
+    
+    # Outermost, so every middleware above sees the canonical path
+    app.add_middleware(LenientRoutes, routes=app.router.routes)
+    
+    # Outside even that, so the path logged is the one the client sent
+    app.add_middleware(RecordRejections)
+    
+    # Outermost, so everything the request does (refusals included) carries its ID
+    app.add_middleware(RequestIDs)
+    
+    # Browsers get a page they can find their way back from; API clients keep JSON.
+    # Both name the request ID, for matching a report to the logs
+    @app.exception_handler(StarletteHTTPException)
+    async def http_error(request: Request, exc: StarletteHTTPException):
+        if not wants_html(request):
+            body = {"detail": exc.detail, "request_id": current_request_id()}
+            if getattr(exc, "code", None):
+                body["code"] = exc.code
+            return JSONResponse(
+                body,
+                status_code=exc.status_code,
+                headers=exc.headers,
+            )
+        return HTMLResponse(
+            error_page(exc.status_code, str(exc.detail), request.url.path, current_request_id()),
+            status_code=exc.status_code,
+            headers=exc.headers,
+        )
+    
+    # The uploads directory vanishing mid-request (an unplugged drive) is a
+    # 503 clients can recognise, not a 500; other filesystem errors stay 500s
+    @app.exception_handler(OSError)
+    async def storage_error(request: Request, exc: OSError):
+        if config.storage_backend == "local" and storage_health.check_error(config.uploads_dir, exc):
+            return await http_error(
+                request,
+                CodedHTTPException(503, f"Storage unavailable: {storage_health.reason}", "storage_unavailable"),
+            )
+        raise exc
     
     # Include API routes
     app.include_router(api_router)
@@ -56,15 +869,87 @@ def create_app() -> FastAPI:
     # Serve static files (mobile UI)
     static_dir = config.static_dir
     if static_dir.exists():
-        app.mount("/static", StaticFiles(directory=str(static_dir)), name="static")
+        app.mount("/static", CompressedStaticFiles(directory=str(static_dir)), name="static")
+    
+    @app.get("/qr", response_class=HTMLResponse)
+    async def serve_qr_page():
+        """Serve the presentation page with a large QR code."""
+        return qr_page()
+    
+    @app.get("/api/poster", response_class=HTMLResponse)
+    async def serve_poster(paper: str = "a4", title: str = "", message: str = ""):
+        """
+        Printable sign with a large QR code, for events (A4 or Letter).
+        
+        Args:
+            paper: 'a4' (default) or 'letter'.
+            title: Heading; the share's title by default.
+            message: Line under the heading; --poster-message by default.
+        """
+        if paper.lower() not in POSTER_PAPERS:
+            raise HTTPException(status_code=400, detail=f"paper must be one of: {', '.join(POSTER_PAPERS)}")
+        # Large enough to stay sharp at 14cm in print
+        png = await run_in_executor(generate_qr_png_bytes, None, config.port, 1200)
+        return poster_page(png, paper.lower(), title, message)
+    
+    @app.get("/pin", response_class=HTMLResponse)
+    async def serve_pin_page(request: Request, code: Optional[str] = None):
+        """Type the PIN printed next to the QR code (the form submits ?code=)."""
+        return follow_pin(request, code)
+    
+    @app.get("/pin/{code}")
+    async def follow_pin_link(request: Request, code: str):
+        """Short link form of the PIN, e.g. http://192.168.1.5:8000/pin/123456."""
+        return follow_pin(request, code)
+    
+    @app.get("/u/{token}", response_class=HTMLResponse)
+    async def serve_collection_page(token: str):
+        """Upload-only page of a collection link; a friendly note if it no longer works."""
+        collection, problem = collection_links.resolve(token)
+        status = {"": 200, "unknown": 404}.get(problem, 410)
+        return HTMLResponse(collection_page(collection, problem), status_code=status)
+    
+    @app.get("/go/{slug}")
+    async def follow_short_link(request: Request, slug: str):
+        """Short link typed by hand when the QR code won't scan, e.g. /go/blue-tiger."""
+        problem = share_access.redeem_slug(slug, request.client.host if request.client else "")
+        if problem == "pin_locked":
+            return HTMLResponse(
+                error_page(429, "Too many wrong links; ask the host for the link", request.url.path), status_code=429
+            )
+        if problem:
+            hint = "The short link may have changed; check the words on the host's screen, or scan the QR code"
+            return HTMLResponse(error_page(404, "", request.url.path, hint=hint), status_code=404)
+        return RedirectResponse(f"/?key={quote(share_access.key)}" if config.require_key else "/")
+    
+    @app.get("/connect/{token}")
+    async def follow_connect_link(request: Request, token: str):
+        """
+        One-time QR link: let the device in with a key cookie, then send it on with no credential in the URL.
+        
+        The token is used up here, so the link in the phone's history is dead.
+        """
+        if not share_access.redeem_bootstrap(token):
+            hint = "This QR code was already used or has expired; ask the host to show it again"
+            return HTMLResponse(error_page(410, "Link no longer valid", "/connect/", hint=hint), status_code=410)
+        response = RedirectResponse("/", status_code=303)
+        response.set_cookie(KEY_COOKIE, share_access.key, httponly=True, samesite="lax")
+        hub.publish("qr_used", {})
+        log(f"🔗 {request.client.host if request.client else 'A device'} joined through a one-time QR link")
+        return response
+    
+    @app.get("/c/{code}")
+    async def follow_claim_link(code: str):
+        """Short link form of a claim code, as printed next to it."""
+        return RedirectResponse(f"/api/claim/{quote(code)}")
     
     # Root route serves the mobile UI
     @app.get("/")
-    async def serve_ui():
+    async def serve_ui(request: Request):
         """Serve the main mobile UI."""
         index_path = static_dir / "index.html"
         if index_path.exists():
-            return FileResponse(index_path)
+            return asset_response(index_path, request.headers.get("accept-encoding"))
         return {
             "app": __app_name__,
             "version": __version__,
@@ -79,6 +964,19 @@ def create_app() -> FastAPI:
 app = create_app()
 
 
+def report_interrupted():
+    """Log the transfers the shutdown grace period is about to cut off."""
+    transfers = stats.active_transfers()
+    if not transfers:
+        return
+    log(f"⏱️  Shutdown timeout reached; interrupting {len(transfers)} transfer(s):", "warning")
+    for transfer in transfers:
+        total = f" of {format_size(transfer['total'])}" if transfer["total"] else ""
+        line = f"   ✂️  {transfer['direction']} of {transfer['filename']} ({format_size(transfer['bytes'])}{total})"
+        logbook.add("warning", line, transfer["request_id"])
+        print(f"{line} [{transfer['request_id']}]" if transfer["request_id"] else line)
+
+
 def run_server(host: str | None = None, port: int | None = None):
     """
     Run the Flashare server.
@@ -92,12 +990,40 @@ def run_server(host: str | None = None, port: int | None = None):
     host = host or config.host
     port = port or config.port
     
-    uvicorn.run(
+    class Server(uvicorn.Server):
+        async def startup(self, sockets=None):
+            await super().startup(sockets)
+            # Only now are connections accepted, so wrappers can open the URL right away
+            if self.started:
+                ndjson.emit("listening", url=share_url(port), host=host, port=port)
+        
+        async def shutdown(self, sockets=None):
+            # uvicorn cancels what is still running once the timeout passes; name
+            # those transfers first, while they are still registered. Cancelled
+            # uploads delete (or quarantine) their partial files on the way out
+            deadline = None
+            if config.shutdown_timeout:
+                deadline = asyncio.get_running_loop().call_later(config.shutdown_timeout, report_interrupted)
+            try:
+                await super().shutdown(sockets)
+            finally:
+                if deadline:
+                    deadline.cancel()
+    
+    # Only the h11 parser can cap the request line and headers
+    limits = {"http": "h11", "h11_max_incomplete_event_size": config.max_header_size} if config.max_header_size else {}
+    
+    server_config = uvicorn.Config(
         app,
         host=host,
         port=port,
         log_level="info",
+        timeout_graceful_shutdown=config.shutdown_timeout or None,
+        **limits,
     )
+    # After uvicorn set up its loggers: request lines must not carry one-time links or keys
+    logging.getLogger("uvicorn.access").addFilter(AccessLogFilter())
+    Server(server_config).run()
 
 
 if __name__ == "__main__":
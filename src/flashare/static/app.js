@@ -6,26 +6,42 @@
 // ==================== Constants ====================
 const API = {
   files: "/api/files",
+  fileTree: "/api/files?recursive=true",
   download: (name, compressed = true) => `/api/download/${encodeURIComponent(name)}?compressed=${compressed}`,
   upload: "/api/upload",
   uploadMultiple: "/api/upload-multiple",
+  uploadCheck: "/api/upload/check",
   delete: (name) => `/api/files/${encodeURIComponent(name)}`,
   status: "/api/status",
+  config: "/api/config",
   qr: "/api/qr",
+  announcements: "/api/announcements",
+  downloadZip: (names) => `/api/download-zip?${names.map(n => `files=${encodeURIComponent(n)}`).join("&")}`,
+  downloadPreview: "/api/download-zip/preview",
+  archiveJobs: "/api/archive-jobs",
+  archiveJob: (id) => `/api/archive-jobs/${encodeURIComponent(id)}`,
+  batch: "/api/batch",
 }
 
 const MAX_CONCURRENT_UPLOADS = 3
 const THUMBNAIL_SIZE = 80
 const CHUNK_SIZE = 1024 * 1024 // 1MB chunks for large file reading
+const DOWNLOAD_CONFIRM_SIZE = 100 * 1024 * 1024 // Ask before downloading more than this at once
 
 // ==================== State ====================
 let files = []
+let folderTree = null // Root folder of the last recursive listing; files holds the same files flattened
+let openFolders = new Set() // Folder paths expanded in the file list
 let uploadQueue = []
 let uploadProgress = new Map()
 let isUploading = false
 let selectedFiles = new Set()
 let isSelectMode = false
 let abortControllers = new Map()
+let transferProgress = new Map() // transfer id -> latest server progress event
+let downloadToasts = new Map() // transfer id -> sticky toast
+let archiveToasts = new Map() // archive job id -> sticky toast while the zip is prepared
+let shownAnnouncements = new Set() // announcement keys already on screen or dismissed
 let isDarkTheme = true
 
 // ==================== DOM Elements (Lazy Load Pattern) ====================
@@ -62,12 +78,16 @@ const getElements = (() => {
         selectAllBtn: document.getElementById("selectAllBtn"),
         downloadSelectedBtn: document.getElementById("downloadSelectedBtn"),
         deleteSelectedBtn: document.getElementById("deleteSelectedBtn"),
+        moveSelectedBtn: document.getElementById("moveSelectedBtn"),
         themeToggle: document.getElementById("themeToggle"),
         mobileCapture: document.getElementById("mobileCapture"),
         capturePhotoBtn: document.getElementById("capturePhotoBtn"),
         captureGalleryBtn: document.getElementById("captureGalleryBtn"),
         cameraInput: document.getElementById("cameraInput"),
         galleryInput: document.getElementById("galleryInput"),
+        claimForm: document.getElementById("claimForm"),
+        claimInput: document.getElementById("claimInput"),
+        announcements: document.getElementById("announcements"),
       }
     }
     return cached
@@ -108,6 +128,33 @@ const formatSize = (bytes) => {
   return `${bytes.toFixed(1)} ${units[i]}`
 }
 
+const formatDuration = (seconds) => {
+  if (seconds < 90) return `${Math.ceil(seconds)}s`
+  if (seconds < 90 * 60) return `${Math.round(seconds / 60)} min`
+  return `${(seconds / 3600).toFixed(1)} h`
+}
+
+const formatRate = (progress) => {
+  if (!progress?.rate) return ""
+  const eta = progress.eta != null ? ` · ${Math.ceil(progress.eta)}s left` : ""
+  return ` · ${formatSize(progress.rate)}/s${eta}`
+}
+
+// Random ID for watching a transfer's progress; only this device can see it
+const newTransferId = () => {
+  const bytes = crypto.getRandomValues(new Uint8Array(16))
+  return Array.from(bytes, b => b.toString(16).padStart(2, "0")).join("")
+}
+
+// API timestamps are unix seconds or RFC 3339, depending on server config
+const formatTime = (timestamp) => {
+  const date = new Date(typeof timestamp === "number" ? timestamp * 1000 : timestamp)
+  const sameDay = date.toDateString() === new Date().toDateString()
+  return sameDay
+    ? date.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" })
+    : date.toLocaleString([], { month: "short", day: "numeric", hour: "2-digit", minute: "2-digit" })
+}
+
 const escapeHtml = (text) => {
   const div = document.createElement("div")
   div.textContent = text
@@ -159,19 +206,40 @@ const parallelLimit = async (tasks, limit) => {
 }
 
 // ==================== API Functions ====================
+// 401 means the share key is missing or was rotated by the host
+const checkAccess = async (response) => {
+  if (response.status !== 401) return
+  const body = await response.json().catch(() => ({}))
+  const error = new Error(body.detail || "Access denied")
+  error.code = body.code
+  throw error
+}
+
+const flattenTree = (folder) => [...folder.files, ...folder.folders.flatMap(flattenTree)]
+
 const fetchFiles = async () => {
-  const response = await fetch(API.files)
+  const response = await fetch(hasFeature("folders") ? API.fileTree : API.files)
+  await checkAccess(response)
   if (!response.ok) throw new Error("Failed to fetch files")
-  return response.json()
+  const listing = await response.json()
+  folderTree = Array.isArray(listing) ? null : listing
+  return folderTree ? flattenTree(folderTree) : listing
 }
 
 const fetchStatus = async () => {
   const response = await fetch(API.status)
+  await checkAccess(response)
   if (!response.ok) throw new Error("Failed to fetch status")
   return response.json()
 }
 
-const uploadFile = (file, onProgress, abortSignal) => {
+const fetchAnnouncements = async () => {
+  const response = await fetch(API.announcements)
+  if (!response.ok) throw new Error("Failed to fetch announcements")
+  return (await response.json()).announcements
+}
+
+const uploadFile = (file, onProgress, abortSignal, transferId) => {
   return new Promise((resolve, reject) => {
     const formData = new FormData()
     formData.append("file", file)
@@ -195,6 +263,16 @@ const uploadFile = (file, onProgress, abortSignal) => {
     xhr.addEventListener("load", () => {
       if (xhr.status >= 200 && xhr.status < 300) {
         resolve(JSON.parse(xhr.responseText))
+      } else if (xhr.status === 413) {
+        reject(new Error("File too large"))
+      } else if (xhr.status === 429) {
+        reject(new Error("Upload quota for this device reached"))
+      } else if (xhr.status === 507) {
+        reject(new Error("Not enough space on the host"))
+      } else if (xhr.status === 503 && xhr.getResponseHeader("Retry-After")) {
+        reject(new Error("Uploads are paused by the host; try again shortly"))
+      } else if (xhr.status === 503) {
+        reject(new Error("Storage unavailable on the host"))
       } else {
         reject(new Error(`Upload failed: ${xhr.status}`))
       }
@@ -204,16 +282,49 @@ const uploadFile = (file, onProgress, abortSignal) => {
     xhr.addEventListener("abort", () => reject(new Error("Upload cancelled")))
 
     xhr.open("POST", API.upload)
+    // Size hint lets the server reject oversized files before we send them
+    xhr.setRequestHeader("X-File-Size", file.size)
+    xhr.setRequestHeader("X-File-Modified", file.lastModified)
+    if (transferId) xhr.setRequestHeader("X-Transfer-Id", transferId)
     xhr.send(formData)
   })
 }
 
+// Which queued files the host already has; an older server without the check gets everything
+const checkUploads = async (items) => {
+  const response = await fetch(API.uploadCheck, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(items.map(item => ({ name: item.file.name, size: item.file.size }))),
+  }).catch(() => null)
+  if (!response?.ok) return []
+  return (await response.json()).files
+}
+
 const deleteFile = async (filename) => {
   const response = await fetch(API.delete(filename), { method: "DELETE" })
-  if (!response.ok) throw new Error("Failed to delete file")
+  if (!response.ok) {
+    const body = await response.json().catch(() => ({}))
+    // Another device uploaded it and only it (or the host) may delete it
+    if (body.code === "not_owner") throw new Error("Only the device that uploaded it can delete it")
+    throw new Error("Failed to delete file")
+  }
   return response.json()
 }
 
+// Run operations all or nothing; on failure nothing was changed and the first problem is thrown
+const runBatch = async (operations) => {
+  const response = await fetch(API.batch, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ operations }),
+  })
+  const body = await response.json().catch(() => ({}))
+  if (body.committed) return body
+  const problem = body.results?.find(r => r.error)
+  throw new Error(problem ? `${problem.path}: ${problem.error}` : body.detail || "Failed to change files")
+}
+
 // ==================== Thumbnail Generation (Optimized) ====================
 const generateThumbnail = async (file) => {
   return new Promise((resolve) => {
@@ -304,6 +415,13 @@ const addToQueue = async (fileList) => {
   const thumbnails = await generateThumbnailsBatch(newFiles)
 
   newFiles.forEach((file, i) => {
+    // Skip files the server would refuse anyway
+    const maxSize = capabilities.limits?.max_upload_size
+    if (maxSize && file.size > maxSize) {
+      showToast(`${file.name} is too large (limit ${formatSize(maxSize)})`, "error")
+      return
+    }
+
     // Check for duplicates
     if (!uploadQueue.some(f => f.name === file.name && f.size === file.size)) {
       uploadQueue.push({
@@ -407,19 +525,33 @@ const uploadAllFiles = async () => {
 
   let completed = 0
   let failed = 0
+  let skipped = 0
   const total = uploadQueue.length
 
   // Render initial progress list
   renderProgressList()
   updateOverallProgress(0, total)
 
+  // Don't send files the host already has under the same name and size
+  const checks = await checkUploads(uploadQueue)
+  uploadQueue.forEach((item, index) => {
+    if (checks[index]?.status !== "exists") return
+    item.status = "skipped"
+    item.progress = 100
+    completed++
+    skipped++
+    renderProgressItem(item)
+  })
+  updateOverallProgress(completed, total)
+
   // Create upload tasks
-  const uploadTasks = uploadQueue.map((item, index) => async () => {
+  const uploadTasks = uploadQueue.filter(item => item.status !== "skipped").map((item) => async () => {
     const controller = new AbortController()
     abortControllers.set(item.id, controller)
 
     try {
       item.status = "uploading"
+      item.transferId = newTransferId()
       renderProgressItem(item)
 
       await uploadFile(
@@ -429,7 +561,8 @@ const uploadAllFiles = async () => {
           renderProgressItem(item)
           updateOverallProgress(completed, total)
         },
-        controller.signal
+        controller.signal,
+        item.transferId
       )
 
       item.status = "completed"
@@ -459,7 +592,8 @@ const uploadAllFiles = async () => {
 
   // Show results
   if (failed === 0) {
-    showToast(`Successfully uploaded ${completed} file${completed !== 1 ? "s" : ""}`, "success")
+    const already = skipped ? ` (${skipped} already on the host)` : ""
+    showToast(`Successfully uploaded ${completed} file${completed !== 1 ? "s" : ""}${already}`, "success")
     setTimeout(() => {
       closeUploadModal()
       handleRefresh()
@@ -510,8 +644,9 @@ const renderProgressItem = (item) => {
 const getStatusText = (item) => {
   switch (item.status) {
     case "pending": return "Waiting..."
-    case "uploading": return `${item.progress}%`
+    case "uploading": return `${item.progress}%${formatRate(transferProgress.get(item.transferId))}`
     case "completed": return "✓ Done"
+    case "skipped": return "✓ Already there"
     case "failed": return "✕ Failed"
     default: return ""
   }
@@ -540,48 +675,37 @@ const cancelAllUploads = () => {
 }
 
 // ==================== UI Functions ====================
-const renderFiles = () => {
-  const elements = getElements()
-
-  if (files.length === 0) {
-    elements.fileList.innerHTML = `
-      <div class="empty-state">
-        <svg width="64" height="64" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="1">
-          <path d="M22 19a2 2 0 01-2 2H4a2 2 0 01-2-2V5a2 2 0 012-2h5l2 3h9a2 2 0 012 2z"/>
-        </svg>
-        <p>No files available yet</p>
-        <p>Upload files to share them across devices</p>
-      </div>
-    `
-    elements.fileCount.textContent = "0"
-    return
-  }
-
-  elements.fileCount.textContent = files.length.toString()
-  elements.fileList.innerHTML = files.map((file, index) => `
-    <div class="file-card ${selectedFiles.has(file.name) ? 'selected' : ''}" 
-         data-filename="${escapeHtml(file.name)}" 
+const renderFileCard = (file, index) => `
+    <div class="file-card ${selectedFiles.has(file.id) ? 'selected' : ''}" 
+         data-id="${escapeHtml(file.id)}" 
          style="animation-delay: ${Math.min(index * 0.05, 0.25)}s">
       ${isSelectMode ? `
         <div class="file-checkbox">
-          <input type="checkbox" id="check-${index}" ${selectedFiles.has(file.name) ? 'checked' : ''}>
+          <input type="checkbox" id="check-${index}" ${selectedFiles.has(file.id) ? 'checked' : ''}>
           <label for="check-${index}"></label>
         </div>
       ` : ''}
       <div class="file-icon">${getFileIcon(file.name)}</div>
       <div class="file-info">
-        <div class="file-name">${escapeHtml(file.name)}</div>
-        <div class="file-meta">${file.size_human}</div>
+        <div class="file-name">${escapeHtml(file.name.split("/").pop())}</div>
+        <div class="file-meta">${file.size_human}${file.burn_after_download ? " · 🔥 single-use" : ""}${file.deletes_at ? ` · <span title="Scheduled for deletion">🕑 ${formatTime(file.deletes_at)}</span>` : ""}${["queued", "running"].includes(file.transcode) ? " · ⏳ converting for playback" : ""}</div>
       </div>
       <div class="file-actions">
-        <button class="download-btn" data-filename="${escapeHtml(file.name)}" title="Download">
+        ${file.play_url ? `
+        <a class="play-btn" href="${escapeHtml(file.play_url)}" target="_blank" rel="noopener" title="Play in browser">
+          <svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
+            <polygon points="6 4 20 12 6 20 6 4"/>
+          </svg>
+        </a>
+        ` : ""}
+        <button class="download-btn" data-id="${escapeHtml(file.id)}" data-filename="${escapeHtml(file.name)}" title="Download">
           <svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
             <path d="M21 15v4a2 2 0 01-2 2H5a2 2 0 01-2-2v-4"/>
             <polyline points="7 10 12 15 17 10"/>
             <line x1="12" y1="15" x2="12" y2="3"/>
           </svg>
         </button>
-        <button class="delete-btn" data-filename="${escapeHtml(file.name)}" title="Delete">
+        <button class="delete-btn" ${hasFeature("delete") ? "" : "hidden"} data-id="${escapeHtml(file.id)}" data-filename="${escapeHtml(file.name)}" title="Delete">
           <svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
             <polyline points="3 6 5 6 21 6"/>
             <path d="M19 6v14a2 2 0 01-2 2H7a2 2 0 01-2-2V6m3 0V4a2 2 0 012-2h4a2 2 0 012 2v2"/>
@@ -589,20 +713,57 @@ const renderFiles = () => {
         </button>
       </div>
     </div>
-  `).join("")
+  `
+
+const renderFiles = () => {
+  const elements = getElements()
+
+  if (files.length === 0) {
+    elements.fileList.innerHTML = `
+      <div class="empty-state">
+        <svg width="64" height="64" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="1">
+          <path d="M22 19a2 2 0 01-2 2H4a2 2 0 01-2-2V5a2 2 0 012-2h5l2 3h9a2 2 0 012 2z"/>
+        </svg>
+        <p>No files available yet</p>
+        <p>Upload files to share them across devices</p>
+      </div>
+    `
+    elements.fileCount.textContent = "0"
+    return
+  }
+
+  elements.fileCount.textContent = files.length.toString()
+  let index = 0
+  const cards = (list) => list.map(file => renderFileCard(file, index++)).join("")
+  const folderGroup = (folder) => `
+    <details class="folder-group" data-path="${escapeHtml(folder.path)}" ${openFolders.has(folder.path) ? "open" : ""}>
+      <summary>📁 ${escapeHtml(folder.name)} <span class="folder-count">${flattenTree(folder).length}</span>${folder.truncated ? " · too deeply nested to show" : ""}</summary>
+      ${folder.folders.map(folderGroup).join("")}${cards(folder.files)}
+    </details>
+  `
+  elements.fileList.innerHTML = folderTree
+    ? folderTree.folders.map(folderGroup).join("") + cards(folderTree.files)
+    : cards(files)
+
+  elements.fileList.querySelectorAll(".folder-group").forEach(group => {
+    group.addEventListener("toggle", () => {
+      if (group.open) openFolders.add(group.dataset.path)
+      else openFolders.delete(group.dataset.path)
+    })
+  })
 
   // Add event listeners with delegation
   elements.fileList.querySelectorAll(".download-btn").forEach(btn => {
     btn.addEventListener("click", (e) => {
       e.stopPropagation()
-      downloadFile(btn.dataset.filename)
+      downloadFile(btn.dataset.id, btn.dataset.filename)
     })
   })
 
   elements.fileList.querySelectorAll(".delete-btn").forEach(btn => {
     btn.addEventListener("click", async (e) => {
       e.stopPropagation()
-      await handleDeleteFile(btn.dataset.filename)
+      await handleDeleteFile(btn.dataset.id, btn.dataset.filename)
     })
   })
 
@@ -610,12 +771,12 @@ const renderFiles = () => {
   if (isSelectMode) {
     elements.fileList.querySelectorAll(".file-card").forEach(card => {
       card.addEventListener("click", () => {
-        const filename = card.dataset.filename
-        if (selectedFiles.has(filename)) {
-          selectedFiles.delete(filename)
+        const id = card.dataset.id
+        if (selectedFiles.has(id)) {
+          selectedFiles.delete(id)
           card.classList.remove("selected")
         } else {
-          selectedFiles.add(filename)
+          selectedFiles.add(id)
           card.classList.add("selected")
         }
         updateBatchActionsUI()
@@ -624,25 +785,61 @@ const renderFiles = () => {
   }
 }
 
-const downloadFile = (filename) => {
+// Files are addressed by id (the stored name); filename is only for display
+const downloadFile = (id, filename = id) => {
+  const transferId = newTransferId()
   const link = document.createElement("a")
-  link.href = API.download(filename, false)
+  link.href = `${API.download(id, false)}&transfer=${transferId}`
   link.download = filename
   document.body.appendChild(link)
   link.click()
   document.body.removeChild(link)
-  showToast(`Downloading ${filename}`, "success")
+
+  // Without live updates there is no progress to show, so don't stick around
+  const sticky = hasFeature("live_updates") && window.EventSource
+  const toast = showToast(`Downloading ${filename}`, "success", sticky ? 0 : 3000)
+  if (!sticky) return
+  downloadToasts.set(transferId, { toast, filename })
+
+  // Small files finish before the first progress event ever arrives
+  setTimeout(() => {
+    if (!transferProgress.has(transferId)) handleTransferProgress({ id: transferId, done: true })
+  }, 3000)
 }
 
-const handleDeleteFile = async (filename) => {
+// Apply a 'progress' event from the live-update stream
+const handleTransferProgress = (progress) => {
+  const download = downloadToasts.get(progress.id)
+
+  if (progress.done) {
+    transferProgress.delete(progress.id)
+    if (download) {
+      downloadToasts.delete(progress.id)
+      dismissToast(download.toast)
+    }
+    return
+  }
+
+  transferProgress.set(progress.id, progress)
+  if (download) {
+    const percent = progress.total ? ` ${Math.round(progress.bytes / progress.total * 100)}%` : ""
+    download.toast.querySelector(".toast-message").textContent =
+      `Downloading ${download.filename}${percent}${formatRate(progress)}`
+  }
+
+  const item = uploadQueue.find(item => item.transferId === progress.id)
+  if (item) renderProgressItem(item)
+}
+
+const handleDeleteFile = async (id, filename = id) => {
   if (!confirm(`Delete "${filename}"?`)) return
 
   try {
-    await deleteFile(filename)
+    await deleteFile(id)
     showToast(`Deleted ${filename}`, "success")
     await handleRefresh()
   } catch (error) {
-    showToast(`Failed to delete ${filename}`, "error")
+    showToast(`Failed to delete ${filename}: ${error.message}`, "error")
   }
 }
 
@@ -655,7 +852,14 @@ const showLoading = () => {
   `
 }
 
-const showToast = (message, type = "info") => {
+const dismissToast = (toast) => {
+  toast.style.opacity = "0"
+  toast.style.transform = "translateY(10px)"
+  setTimeout(() => toast.remove(), 300)
+}
+
+// duration 0 keeps the toast until dismissToast() is called
+const showToast = (message, type = "info", duration = 3000) => {
   const icons = { success: "✓", error: "✕", warning: "⚠", info: "ℹ" }
   const elements = getElements()
 
@@ -668,11 +872,75 @@ const showToast = (message, type = "info") => {
 
   elements.toastContainer.appendChild(toast)
 
-  setTimeout(() => {
-    toast.style.opacity = "0"
-    toast.style.transform = "translateY(10px)"
-    setTimeout(() => toast.remove(), 300)
-  }, 3000)
+  if (duration) setTimeout(() => dismissToast(toast), duration)
+  return toast
+}
+
+// Sticky notice while the host has paused uploads
+let uploadsPausedToast = null
+
+const setUploadsPaused = (paused) => {
+  if (paused && !uploadsPausedToast) {
+    uploadsPausedToast = showToast("Uploads paused by the host", "warning", 0)
+  } else if (!paused && uploadsPausedToast) {
+    dismissToast(uploadsPausedToast)
+    uploadsPausedToast = null
+    showToast("Uploads resumed", "info")
+  }
+}
+
+// Sticky notice while the host's uploads directory is gone (e.g. a drive was unplugged)
+let storageLostToast = null
+
+const setStorageAvailable = async (storage) => {
+  if (!storage.available && !storageLostToast) {
+    storageLostToast = showToast(`Storage unavailable: ${storage.reason}`, "error", 0)
+  } else if (storage.available && storageLostToast) {
+    dismissToast(storageLostToast)
+    storageLostToast = null
+    showToast("Storage is back", "success")
+    files = await fetchFiles().catch(() => files)
+    renderFiles()
+  }
+}
+
+// Banner for a file the host pushed to everyone; ids restart with the server, hence the timestamp
+const showAnnouncement = (announcement) => {
+  const key = `${announcement.id}@${announcement.created}`
+  if (shownAnnouncements.has(key) || sessionStorage.getItem(`flashare-announcement-${key}`)) return
+  shownAnnouncements.add(key)
+
+  const { file, message } = announcement
+  const banner = document.createElement("div")
+  banner.className = "announcement glass"
+  banner.innerHTML = `
+    <div class="announcement-text">
+      <span class="announcement-title">New file: ${escapeHtml(file.name)}</span>
+      ${message ? `<span class="announcement-message">${escapeHtml(message)}</span>` : ""}
+    </div>
+    <button class="btn btn-primary btn-sm announcement-download">Download</button>
+    <button class="btn-icon announcement-dismiss" title="Dismiss">✕</button>
+  `
+
+  const dismiss = () => {
+    sessionStorage.setItem(`flashare-announcement-${key}`, "1")
+    banner.remove()
+  }
+  banner.querySelector(".announcement-download").addEventListener("click", () => {
+    downloadFile(file.id, file.name)
+    dismiss()
+  })
+  banner.querySelector(".announcement-dismiss").addEventListener("click", dismiss)
+  getElements().announcements.appendChild(banner)
+}
+
+const showAccessLost = (message) => {
+  getElements().fileList.innerHTML = `
+    <div class="empty-state">
+      <p>No access</p>
+      <p>${escapeHtml(message)}</p>
+    </div>
+  `
 }
 
 // ==================== Modal Functions ====================
@@ -724,6 +992,7 @@ const updateBatchActionsUI = () => {
   const count = selectedFiles.size
   elements.downloadSelectedBtn.disabled = count === 0
   elements.deleteSelectedBtn.disabled = count === 0
+  elements.moveSelectedBtn.disabled = count === 0
   elements.selectAllBtn.textContent = count === files.length ? "Deselect All" : "Select All"
 }
 
@@ -731,24 +1000,146 @@ const selectAll = () => {
   if (selectedFiles.size === files.length) {
     selectedFiles.clear()
   } else {
-    files.forEach(f => selectedFiles.add(f.name))
+    files.forEach(f => selectedFiles.add(f.id))
   }
   renderFiles()
   updateBatchActionsUI()
 }
 
 const downloadSelected = async () => {
-  for (const filename of selectedFiles) {
-    downloadFile(filename)
+  // Folders can only come down as an archive, so take the whole selection as one zip
+  const selected = files.filter(f => selectedFiles.has(f.id))
+  if (!(await confirmDownloadSize(selected.map(f => f.id)))) return
+  if (hasFeature("zip_download") && selected.some(f => f.type === "folder")) {
+    if (hasFeature("archive_jobs") && window.EventSource) {
+      await prepareArchive(selected.map(f => f.id))
+      return
+    }
+    const link = document.createElement("a")
+    link.href = `${API.downloadZip(selected.map(f => f.id))}&transfer=${newTransferId()}`
+    document.body.appendChild(link)
+    link.click()
+    document.body.removeChild(link)
+    showToast(`Downloading ${selected.length} items as zip`, "success")
+    return
+  }
+
+  for (const id of selectedFiles) {
+    downloadFile(id, files.find(f => f.id === id)?.name)
     await new Promise(r => setTimeout(r, 300)) // Stagger downloads
   }
 }
 
+// Before a large download, show its size and a rough time at this device's recent speed
+const confirmDownloadSize = async (ids) => {
+  if (!hasFeature("download_preview")) return true
+  let preview
+  try {
+    const response = await fetch(API.downloadPreview, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ files: ids }),
+    })
+    if (!response.ok) return true // The download itself reports what is wrong
+    preview = await response.json()
+  } catch (error) {
+    return true
+  }
+
+  const size = preview.estimate.archive_bytes
+  if (size < DOWNLOAD_CONFIRM_SIZE) return true
+  const rate = preview.throughput?.bytes_per_second
+  const eta = rate ? `, roughly ${formatDuration(size / rate)} at your recent speed` : ""
+  return confirm(`Download ${preview.files} files, about ${formatSize(size)}${eta}?`)
+}
+
+// Have the server build the zip first, so an interrupted download can resume
+const prepareArchive = async (ids) => {
+  try {
+    const response = await fetch(API.archiveJobs, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ files: ids }),
+    })
+    const job = await response.json()
+    if (!response.ok) throw new Error(job.detail || "Failed to prepare the zip")
+
+    archiveToasts.set(job.id, showToast(`Preparing ${job.name}`, "info", 0))
+    // Small archives may be ready before the toast existed to hear about it
+    handleArchiveJob(await (await fetch(API.archiveJob(job.id))).json())
+  } catch (error) {
+    showToast(error.message, "error")
+  }
+}
+
+// Apply an 'archive_job' event: show build progress, then start the download
+const handleArchiveJob = (job) => {
+  const toast = archiveToasts.get(job.id)
+  if (!toast) return
+
+  if (job.state === "building") {
+    const percent = job.total ? ` ${Math.min(99, Math.round(job.written / job.total * 100))}%` : ""
+    toast.querySelector(".toast-message").textContent = `Preparing ${job.name}${percent}`
+    return
+  }
+
+  archiveToasts.delete(job.id)
+  dismissToast(toast)
+  if (job.state === "failed") {
+    showToast(`Failed to prepare ${job.name}`, "error")
+    return
+  }
+
+  const link = document.createElement("a")
+  link.href = `${job.download_url}?transfer=${newTransferId()}`
+  document.body.appendChild(link)
+  link.click()
+  document.body.removeChild(link)
+  showToast(`Downloading ${job.name}`, "success")
+}
+
+// Apply a 'transcode' event: refresh the list once a playable version is ready or failed
+const handleTranscode = async (event) => {
+  if (!["ready", "failed", "running"].includes(event.state)) return
+  const file = files.find(f => f.id === event.id)
+  if (event.state === "ready" && file) {
+    showToast(`${file.name} can now be played in the browser`, "success")
+  } else if (event.state === "failed" && file) {
+    showToast(`Could not convert ${file.name} for playback`, "warning")
+  }
+  try {
+    files = await fetchFiles()
+    renderFiles()
+  } catch (error) {
+    // Next event or the periodic refresh will catch up
+  }
+}
+
+const leaveSelectMode = async () => {
+  selectedFiles.clear()
+  isSelectMode = false
+  getElements().selectModeBtn.classList.remove("active")
+  getElements().batchActions.hidden = true
+  await handleRefresh()
+}
+
 const deleteSelected = async () => {
   if (!confirm(`Delete ${selectedFiles.size} selected files?`)) return
 
-  const deletePromises = Array.from(selectedFiles).map(filename =>
-    deleteFile(filename).catch(e => ({ error: e, filename }))
+  // One request that deletes all of them or none
+  if (hasFeature("batch")) {
+    try {
+      await runBatch(Array.from(selectedFiles).map(id => ({ op: "delete", path: id })))
+      showToast(`Deleted ${selectedFiles.size} files`, "success")
+    } catch (error) {
+      showToast(`Nothing deleted. ${error.message}`, "error")
+    }
+    await leaveSelectMode()
+    return
+  }
+
+  const deletePromises = Array.from(selectedFiles).map(id =>
+    deleteFile(id).catch(e => ({ error: e, id }))
   )
 
   const results = await Promise.all(deletePromises)
@@ -760,11 +1151,23 @@ const deleteSelected = async () => {
     showToast(`Deleted ${selectedFiles.size} files`, "success")
   }
 
-  selectedFiles.clear()
-  isSelectMode = false
-  getElements().selectModeBtn.classList.remove("active")
-  getElements().batchActions.hidden = true
-  await handleRefresh()
+  await leaveSelectMode()
+}
+
+const moveSelected = async () => {
+  const folder = prompt(`Move ${selectedFiles.size} selected files to folder (empty for the top):`, "")
+  if (folder === null) return
+  const target = folder.trim().replace(/^\/+|\/+$/g, "")
+
+  const operations = Array.from(selectedFiles).map(id => ({ op: "move", path: id, to: target }))
+  if (target) operations.unshift({ op: "mkdir", path: target })
+  try {
+    await runBatch(operations)
+    showToast(`Moved ${selectedFiles.size} files to ${target || "the top"}`, "success")
+  } catch (error) {
+    showToast(`Nothing moved. ${error.message}`, "error")
+  }
+  await leaveSelectMode()
 }
 
 // ==================== Theme Toggle ====================
@@ -810,6 +1213,49 @@ const handleFileSelect = async (fileList) => {
 }
 
 // ==================== Initialization ====================
+// ==================== Capabilities ====================
+// Controls are shown or hidden from the server's capability document,
+// so turning a feature on or off never needs a frontend change
+let capabilities = { features: {}, limits: {}, branding: {} }
+
+const hasFeature = (name) => capabilities.features[name] !== false
+
+const fetchCapabilities = async () => {
+  const response = await fetch(API.config)
+  if (!response.ok) throw new Error("Failed to fetch capabilities")
+  return response.json()
+}
+
+const applyCapabilities = () => {
+  document.querySelectorAll("[data-feature]").forEach(el => {
+    el.hidden = !hasFeature(el.dataset.feature)
+  })
+
+  const { mobileCapture } = getElements()
+  if (mobileCapture && !hasFeature("upload")) {
+    mobileCapture.style.display = "none"
+  }
+
+  const { title, accent_color: accent, logo_url: logoUrl } = capabilities.branding || {}
+  if (title) {
+    document.title = `${title} - File Sharing`
+    document.querySelector(".logo-text").textContent = title
+  }
+  if (accent) {
+    const root = document.documentElement.style
+    root.setProperty("--accent-primary", accent)
+    root.setProperty("--accent-secondary", accent)
+    root.setProperty("--accent-gradient", accent)
+  }
+  if (logoUrl) {
+    const logo = document.createElement("img")
+    logo.src = logoUrl
+    logo.alt = ""
+    logo.className = "logo-image"
+    document.querySelector(".logo-icon").replaceChildren(logo)
+  }
+}
+
 const init = async () => {
   loadTheme()
 
@@ -824,18 +1270,28 @@ const init = async () => {
   // Load initial data
   try {
     const [filesData, status] = await Promise.all([fetchFiles(), fetchStatus()])
+    capabilities = await fetchCapabilities().catch(() => capabilities)
+    applyCapabilities()
     files = filesData
     elements.serverUrl.textContent = status.url
+    setUploadsPaused(status.uploads_paused)
     renderFiles()
+    if (hasFeature("announcements")) {
+      fetchAnnouncements().then(list => list.forEach(showAnnouncement)).catch(() => {})
+    }
   } catch (error) {
     console.error("Initialization error:", error)
     elements.serverUrl.textContent = window.location.origin
-    elements.fileList.innerHTML = `
-      <div class="empty-state">
-        <p>Failed to connect</p>
-        <p>Please refresh the page</p>
-      </div>
-    `
+    if (error.code) {
+      showAccessLost(error.message)
+    } else {
+      elements.fileList.innerHTML = `
+        <div class="empty-state">
+          <p>Failed to connect</p>
+          <p>Please refresh the page</p>
+        </div>
+      `
+    }
   }
 
   // Event Listeners
@@ -849,7 +1305,13 @@ const init = async () => {
   elements.selectAllBtn.addEventListener("click", selectAll)
   elements.downloadSelectedBtn.addEventListener("click", downloadSelected)
   elements.deleteSelectedBtn.addEventListener("click", deleteSelected)
+  elements.moveSelectedBtn.addEventListener("click", moveSelected)
   elements.themeToggle.addEventListener("click", toggleTheme)
+  elements.claimForm.addEventListener("submit", (e) => {
+    e.preventDefault()
+    const code = elements.claimInput.value.trim()
+    if (code) window.location.href = `/c/${encodeURIComponent(code)}`
+  })
 
   // Modal backdrop click
   elements.uploadModal.querySelector(".modal-backdrop").addEventListener("click", closeUploadModal)
@@ -910,6 +1372,34 @@ const init = async () => {
       document.body.addEventListener(event, preventDefaults, false)
     })
 
+  // Live updates; EventSource reconnects by itself after drops
+  if (hasFeature("live_updates") && window.EventSource) {
+    const events = new EventSource("/api/events")
+    events.addEventListener("files", debounce(async () => {
+      try {
+        files = await fetchFiles()
+        renderFiles()
+      } catch (error) {
+        // Next event or the periodic refresh will catch up
+      }
+    }, 300))
+    events.addEventListener("progress", (e) => handleTransferProgress(JSON.parse(e.data)))
+    events.addEventListener("announcement", (e) => showAnnouncement(JSON.parse(e.data)))
+    events.addEventListener("uploads", (e) => setUploadsPaused(JSON.parse(e.data).paused))
+    events.addEventListener("storage", (e) => setStorageAvailable(JSON.parse(e.data)))
+    events.addEventListener("archive_job", (e) => handleArchiveJob(JSON.parse(e.data)))
+    events.addEventListener("transcode", (e) => handleTranscode(JSON.parse(e.data)))
+    events.addEventListener("credentials_rotated", async () => {
+      try {
+        await checkAccess(await fetch(API.status))
+      } catch (error) {
+        events.close()
+        files = []
+        showAccessLost(error.message)
+      }
+    })
+  }
+
   // Auto-refresh every 30 seconds
   setInterval(async () => {
     try {
@@ -0,0 +1,58 @@
+"""Fixtures shared by the tests: a fresh, empty share for every test."""
+
+import pytest
+from fastapi.testclient import TestClient
+
+from flashare.config import config
+from flashare.core.devices import devices
+from flashare.core.storage import get_storage
+
+
+ADMIN_TOKEN = "test-admin-token"
+
+
+@pytest.fixture(autouse=True)
+def share(tmp_path, monkeypatch):
+    """Point the app at empty uploads and data folders, with no devices known yet."""
+    monkeypatch.setattr(config, "uploads_dir", tmp_path / "uploads")
+    monkeypatch.setattr(config, "data_dir", tmp_path / "data")
+    monkeypatch.setattr(config, "admin_token", ADMIN_TOKEN)
+    monkeypatch.setattr(config, "require_key", False)
+    monkeypatch.setattr(devices, "devices", {})
+    config.uploads_dir.mkdir()
+    get_storage.cache_clear()
+    yield config.uploads_dir
+    get_storage.cache_clear()
+
+
+@pytest.fixture
+def app():
+    from flashare.server import create_app
+
+    return create_app()
+
+
+@pytest.fixture
+def guest(app):
+    """
+    A device other than the host machine.
+
+    TestClient requests come from the address 'testclient', never
+    loopback, and keep the device cookie the server hands out.
+    """
+    return TestClient(app)
+
+
+@pytest.fixture
+def host(app):
+    """The host, acting through the admin token."""
+    return TestClient(app, headers={"Authorization": f"Bearer {ADMIN_TOKEN}"})
+
+
+def upload(client: TestClient, name: str, data: bytes) -> str:
+    """Upload a file and return the ID its URLs use."""
+    response = client.post("/api/upload", files={"file": (name, data)})
+    response.raise_for_status()
+    body = response.json()
+    assert body["success"], body
+    return body["id"]
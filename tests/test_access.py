@@ -0,0 +1,46 @@
+"""The share key, checked before anything else looks at a guest's request."""
+
+import hashlib
+
+import pytest
+
+from flashare.config import config
+from flashare.core.access import share_access
+from flashare.core.devices import devices
+
+from conftest import upload
+
+
+@pytest.fixture(autouse=True)
+def key_required(monkeypatch):
+    monkeypatch.setattr(config, "require_key", True)
+
+
+def test_guest_without_key_is_refused(guest):
+    response = guest.get("/api/files")
+    assert response.status_code == 401
+    assert response.json()["code"] == "credentials_required"
+
+
+def test_guest_with_key_gets_in(guest):
+    assert guest.get("/api/files", params={"key": share_access.key}).status_code == 200
+    # The key from the link is kept in a cookie
+    assert guest.get("/api/files").status_code == 200
+
+
+def test_keyless_upload_cannot_probe_stored_content(guest, host):
+    data = b"something only the host has"
+    upload(host, "secret.txt", data)
+    response = guest.post(
+        "/api/upload",
+        headers={"If-None-Match": f'"{hashlib.sha256(data).hexdigest()}"'},
+        files={"file": ("probe.txt", b"")},
+    )
+    assert response.status_code == 401
+    assert "X-Existing-File" not in response.headers
+
+
+def test_keyless_requests_are_not_counted_as_devices(guest):
+    guest.get("/api/files")
+    guest.get("/api/files")
+    assert devices.devices == {}
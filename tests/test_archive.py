@@ -0,0 +1,162 @@
+"""Session bundles: exported with a manifest, verified on import, served read-only."""
+
+import hashlib
+import io
+import json
+import os
+import tarfile
+
+import pytest
+import zstandard as zstd
+
+from flashare.cli import main
+from flashare.config import config
+from flashare.core.archive import MANIFEST_NAME, BundleError, export_archive, import_archive
+
+from conftest import upload
+
+
+def _bundle(path, members: list[tuple[tarfile.TarInfo, bytes]]):
+    """Write a .tar.zst holding exactly these members, as a hand-made (or hostile) bundle would."""
+    with open(path, "wb") as f, zstd.ZstdCompressor().stream_writer(f) as writer:
+        with tarfile.open(fileobj=writer, mode="w|") as tar:
+            for info, data in members:
+                info.size = len(data)
+                tar.addfile(info, io.BytesIO(data))
+    return path
+
+
+def _file(name: str, data: bytes) -> tuple[tarfile.TarInfo, bytes]:
+    info = tarfile.TarInfo(name)
+    info.mode = 0o644
+    return info, data
+
+
+def _manifest(manifest) -> tuple[tarfile.TarInfo, bytes]:
+    return _file(MANIFEST_NAME, json.dumps(manifest).encode())
+
+
+def _listing(*files: tuple[str, bytes]) -> dict:
+    return {"version": 1, "files": [{"path": p, "size": len(d), "sha256": hashlib.sha256(d).hexdigest()} for p, d in files]}
+
+
+def test_round_trip(share, tmp_path, monkeypatch):
+    monkeypatch.setattr(config, "brand_title", "Team drop")
+    (share / "photos").mkdir()
+    (share / "photos" / "a.jpg").write_bytes(b"jpeg" * 1000)
+    (share / "notes.txt").write_text("hello")
+    (share / ".meta").mkdir()
+    (share / ".meta" / "notes.txt.json").write_text("{}")
+    (share / ".failed").mkdir()
+    (share / ".failed" / "half.bin").write_bytes(b"x")
+    os.utime(share / "notes.txt", (1_600_000_000, 1_600_000_000))
+
+    bundle = tmp_path / "share.tar.zst"
+    assert export_archive(share, bundle) == 3
+    report = import_archive(bundle, tmp_path / "restored")
+
+    restored = tmp_path / "restored"
+    assert report.ok and report.restored == 3
+    assert (restored / "photos" / "a.jpg").read_bytes() == b"jpeg" * 1000
+    assert (restored / ".meta" / "notes.txt.json").read_text() == "{}"
+    assert (restored / "notes.txt").stat().st_mtime == 1_600_000_000
+    assert not (restored / ".failed").exists()
+    assert not (restored / MANIFEST_NAME).exists()
+    assert report.manifest["branding"]["title"] == "Team drop"
+    assert {entry["path"] for entry in report.manifest["files"]} == {"photos/a.jpg", "notes.txt", ".meta/notes.txt.json"}
+
+
+def test_manifest_mismatches_are_reported(tmp_path):
+    manifest = _listing(("good.txt", b"good"), ("changed.txt", b"original"), ("lost.txt", b"lost"))
+    bundle = _bundle(tmp_path / "b.tar.zst", [
+        _file("good.txt", b"good"),
+        _file("changed.txt", b"tampered"),
+        _file("extra.txt", b"extra"),
+        _manifest(manifest),
+    ])
+    report = import_archive(bundle, tmp_path / "out")
+    assert not report.ok
+    assert report.restored == 3
+    assert report.corrupt == ["changed.txt"]
+    assert report.missing == ["lost.txt"]
+    assert report.unlisted == ["extra.txt"]
+
+
+def test_unsafe_members_are_skipped(tmp_path):
+    link = tarfile.TarInfo("link")
+    link.type = tarfile.SYMTYPE
+    link.linkname = "/etc/passwd"
+    fifo = tarfile.TarInfo("pipe")
+    fifo.type = tarfile.FIFOTYPE
+    bundle = _bundle(tmp_path / "b.tar.zst", [
+        _file("../escaped.txt", b"x"),
+        _file("/tmp/absolute.txt", b"x"),
+        _file("ok/../../escaped2.txt", b"x"),
+        (link, b""),
+        (fifo, b""),
+        _file("kept.txt", b"kept"),
+    ])
+    report = import_archive(bundle, tmp_path / "out")
+    assert report.restored == 1
+    assert sorted(p.name for p in (tmp_path / "out").iterdir()) == ["kept.txt"]
+    assert not (tmp_path / "escaped.txt").exists()
+    assert not (tmp_path / "escaped2.txt").exists()
+
+
+def test_bundle_without_manifest_is_restored_unverified(tmp_path):
+    bundle = _bundle(tmp_path / "b.tar.zst", [_file("a.txt", b"a")])
+    report = import_archive(bundle, tmp_path / "out")
+    assert report.manifest is None
+    assert report.restored == 1
+
+
+@pytest.mark.parametrize("manifest", [
+    [],
+    "flashare",
+    {"version": "1", "files": []},
+    {"version": 1, "files": {"a.txt": "abc"}},
+    {"version": 1, "files": ["a.txt"]},
+    {"version": 1, "files": [{"path": "a.txt"}]},
+    {"version": 1, "files": [], "branding": "Team drop"},
+])
+def test_malformed_manifest_is_refused(tmp_path, manifest):
+    bundle = _bundle(tmp_path / "b.tar.zst", [_file("a.txt", b"a"), _manifest(manifest)])
+    with pytest.raises(BundleError, match="manifest"):
+        import_archive(bundle, tmp_path / "out")
+
+
+def test_newer_bundle_is_refused(tmp_path):
+    bundle = _bundle(tmp_path / "b.tar.zst", [_manifest({"version": 99, "files": []})])
+    with pytest.raises(BundleError, match="newer"):
+        import_archive(bundle, tmp_path / "out")
+
+
+def test_truncated_bundle_is_refused(share, tmp_path):
+    (share / "a.bin").write_bytes(os.urandom(200_000))
+    bundle = tmp_path / "share.tar.zst"
+    export_archive(share, bundle)
+    bundle.write_bytes(bundle.read_bytes()[:50_000])
+    with pytest.raises(BundleError, match="damaged"):
+        import_archive(bundle, tmp_path / "out")
+
+
+def test_imported_share_is_served_read_only(share, tmp_path, monkeypatch):
+    for name in ("read_only", "port", "brand_title", "brand_accent_color"):
+        monkeypatch.setattr(config, name, getattr(config, name))
+    (share / "notes.txt").write_text("hello")
+    bundle = tmp_path / "share.tar.zst"
+    export_archive(share, bundle)
+
+    served = []
+    monkeypatch.setattr(main, "_start_server", lambda host, port: served.append((config.uploads_dir, port)))
+    main._import_share(bundle, tmp_path / "restored", serve=True, port=9123)
+    assert served == [(tmp_path / "restored", 9123)]
+    assert config.read_only
+
+
+def test_read_only_share_refuses_changes(guest, monkeypatch):
+    file_id = upload(guest, "notes.txt", b"hello")
+    monkeypatch.setattr(config, "read_only", True)
+    assert guest.get(f"/api/files/{file_id}").status_code == 200
+    assert guest.post("/api/upload", files={"file": ("more.txt", b"x")}).status_code == 403
+    assert guest.delete(f"/api/files/{file_id}").status_code == 403
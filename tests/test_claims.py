@@ -0,0 +1,71 @@
+"""Single-use claim codes are used up by a download, not by a failed attempt."""
+
+import pytest
+
+from flashare.api import routes
+from flashare.config import config
+from flashare.core.claims import ClaimRegistry, claims
+
+from conftest import upload
+
+
+@pytest.fixture(autouse=True)
+def no_claims(monkeypatch):
+    monkeypatch.setattr(claims, "claims", {})
+
+
+def _claim(host, file_id: str) -> str:
+    return host.post(f"/api/files/{file_id}/claim").json()["code"]
+
+
+def test_code_works_once(host, guest):
+    code = _claim(host, upload(host, "gift.txt", b"for you"))
+    assert guest.get(f"/api/claim/{code}").content == b"for you"
+    assert guest.get(f"/api/claim/{code}").status_code == 404
+
+
+def test_missing_file_keeps_the_code(host, guest, share):
+    code = _claim(host, upload(host, "gift.txt", b"for you"))
+    (share / "gift.txt").rename(share.parent / "gift.txt")
+    assert guest.get(f"/api/claim/{code}").status_code == 404
+
+    (share.parent / "gift.txt").rename(share / "gift.txt")
+    assert guest.get(f"/api/claim/{code}").content == b"for you"
+
+
+def test_filtered_file_keeps_the_code(host, guest, monkeypatch):
+    code = _claim(host, upload(host, "gift.txt", b"for you"))
+    monkeypatch.setattr(config, "exclude_patterns", ["*.txt"])
+    assert guest.get(f"/api/claim/{code}").status_code == 404
+
+    monkeypatch.setattr(config, "exclude_patterns", [])
+    assert guest.get(f"/api/claim/{code}").status_code == 200
+
+
+def test_burn_in_progress_keeps_the_code(host, guest, monkeypatch):
+    file_id = upload(host, "gift.txt", b"for you")
+    code = _claim(host, file_id)
+    monkeypatch.setattr(config, "burn_after_download", True)
+    monkeypatch.setattr(routes, "_burn_claims", {file_id})
+    assert guest.get(f"/api/claim/{code}").status_code == 410
+
+    routes._burn_claims.clear()
+    assert guest.get(f"/api/claim/{code}").content == b"for you"
+
+
+def test_unavailable_storage_keeps_the_code(host, guest, share):
+    code = _claim(host, upload(host, "gift.txt", b"for you"))
+    share.rename(share.with_name("unplugged"))
+    assert guest.get(f"/api/claim/{code}").status_code == 503
+
+    share.with_name("unplugged").rename(share)
+    assert guest.get(f"/api/claim/{code}").status_code == 200
+
+
+def test_released_code_can_be_redeemed_again():
+    registry = ClaimRegistry()
+    claim = registry.create("gift.txt", 60)
+    assert registry.redeem(claim.code.lower(), "192.168.1.20") is claim
+    assert registry.redeem(claim.code, "192.168.1.20") is None
+    registry.release(claim)
+    assert registry.redeem(claim.code, "192.168.1.20") is claim
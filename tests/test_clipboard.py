@@ -0,0 +1,137 @@
+"""Reading the clipboard through a stubbed back-end."""
+
+import re
+import subprocess
+
+import pytest
+
+from flashare.core import clipboard
+from flashare.core.clipboard import (
+    ClipboardProvider,
+    ClipboardUnavailable,
+    XclipProvider,
+    clipboard_filename,
+    read_clipboard,
+)
+
+
+PNG = b"\x89PNG\r\n\x1a\n" + b"\x00" * 16
+
+
+class StubProvider(ClipboardProvider):
+    """A clipboard holding whatever each test puts on it."""
+
+    def __init__(self, image=None, files=None, text=None):
+        self.image, self.files, self.text = image, files, text
+
+    def read_image(self):
+        return self.image
+
+    def read_files(self):
+        return self.files
+
+    def read_text(self):
+        return self.text
+
+
+def test_image_wins(tmp_path):
+    copied = tmp_path / "a.txt"
+    copied.write_text("a")
+    content = read_clipboard(StubProvider(image=PNG, files=[copied], text="screenshot"))
+    assert content.kind == "image"
+    assert content.image == PNG
+
+
+def test_files_come_before_text(tmp_path):
+    copied = [tmp_path / "a.txt", tmp_path / "b.txt"]
+    for path in copied:
+        path.write_text("x")
+    content = read_clipboard(StubProvider(files=copied, text="/tmp/a.txt"))
+    assert content.kind == "files"
+    assert content.files == copied
+
+
+def test_vanished_files_are_dropped(tmp_path):
+    kept = tmp_path / "kept.txt"
+    kept.write_text("x")
+    content = read_clipboard(StubProvider(files=[tmp_path / "gone.txt", kept]))
+    assert content.files == [kept]
+
+
+def test_only_vanished_files_fall_back_to_text(tmp_path):
+    content = read_clipboard(StubProvider(files=[tmp_path / "gone.txt"], text="hello"))
+    assert content.kind == "text"
+    assert content.text == "hello"
+
+
+@pytest.mark.parametrize("text", [None, "", "  \n\t"])
+def test_empty_clipboard_is_an_error(text):
+    with pytest.raises(ClipboardUnavailable, match="empty"):
+        read_clipboard(StubProvider(text=text))
+
+
+def test_unreadable_clipboard_is_an_error():
+    class Broken(ClipboardProvider):
+        def read_image(self):
+            raise ClipboardUnavailable("xclip did not respond")
+
+    with pytest.raises(ClipboardUnavailable, match="respond"):
+        read_clipboard(Broken())
+
+
+class FakeXclip:
+    """subprocess.run for xclip, answering from a table of target -> bytes."""
+
+    def __init__(self, targets: dict):
+        self.targets = targets
+
+    def __call__(self, args, **kwargs):
+        if "TARGETS" in args:
+            out = "\n".join(self.targets).encode()
+        elif "-t" in args:
+            out = self.targets.get(args[args.index("-t") + 1], b"")
+        else:
+            out = self.targets.get("UTF8_STRING", b"")
+        return subprocess.CompletedProcess(args, 0 if out else 1, out, b"")
+
+
+def test_xclip_reads_copied_files(tmp_path, monkeypatch):
+    copied = tmp_path / "my photo.jpg"
+    copied.write_bytes(b"jpg")
+    uris = f"# copied from a file manager\nfile://{tmp_path}/my%20photo.jpg\nhttps://example.com/x\n".encode()
+    monkeypatch.setattr(clipboard.subprocess, "run", FakeXclip({"text/uri-list": uris, "UTF8_STRING": b"x"}))
+    assert read_clipboard(XclipProvider()).files == [copied]
+
+
+def test_xclip_reads_images_and_text(monkeypatch):
+    monkeypatch.setattr(clipboard.subprocess, "run", FakeXclip({"image/png": PNG, "UTF8_STRING": b"caption"}))
+    assert read_clipboard(XclipProvider()).image == PNG
+    monkeypatch.setattr(clipboard.subprocess, "run", FakeXclip({"UTF8_STRING": "naïve".encode()}))
+    assert read_clipboard(XclipProvider()).text == "naïve"
+
+
+def test_missing_tool_is_unavailable(monkeypatch):
+    def missing(args, **kwargs):
+        raise FileNotFoundError(args[0])
+
+    monkeypatch.setattr(clipboard.subprocess, "run", missing)
+    with pytest.raises(ClipboardUnavailable, match="not installed"):
+        read_clipboard(XclipProvider())
+
+
+def test_no_tool_on_a_headless_linux(monkeypatch):
+    monkeypatch.setattr(clipboard.platform, "system", lambda: "Linux")
+    monkeypatch.delenv("WAYLAND_DISPLAY", raising=False)
+    monkeypatch.delenv("DISPLAY", raising=False)
+    with pytest.raises(ClipboardUnavailable):
+        clipboard.get_provider()
+
+
+def test_clipboard_filename():
+    assert re.fullmatch(r"clipboard_\d{8}-\d{6}\.png", clipboard_filename(".png"))
+
+
+def test_mac_image_is_decoded_from_applescript(monkeypatch):
+    reply = f"«data PNGf{PNG.hex().upper()}»\n".encode()
+    monkeypatch.setattr(clipboard.subprocess, "run", lambda args, **k: subprocess.CompletedProcess(args, 0, reply, b""))
+    assert clipboard.MacProvider().read_image() == PNG
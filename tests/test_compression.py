@@ -0,0 +1,74 @@
+"""Small compressed downloads are buffered to send an exact Content-Length."""
+
+import gzip
+import os
+
+import pytest
+
+from flashare.config import config
+from flashare.core.compression import BufferPool, buffer_pool, worth_compressing
+
+from conftest import upload
+
+
+TEXT = b"flashare shares files between a phone and a laptop\n" * 2000
+GZIP = {"Accept-Encoding": "gzip"}
+
+
+@pytest.fixture(autouse=True)
+def uncached(monkeypatch):
+    """No compressed copies are kept, so every download compresses afresh."""
+    monkeypatch.setattr(config, "encoded_cache_size", 0)
+
+
+def _download(client, file_id: str, headers: dict):
+    with client.stream("GET", f"/api/download/{file_id}", headers=headers) as response:
+        raw = b"".join(response.iter_raw())
+    assert response.status_code == 200
+    return response, raw
+
+
+def test_compressed_download_has_exact_length(guest):
+    file_id = upload(guest, "notes.txt", TEXT)
+    response, raw = _download(guest, file_id, GZIP)
+    assert response.headers["Content-Encoding"] == "gzip"
+    assert response.headers["Content-Length"] == str(len(raw))
+    assert gzip.decompress(raw) == TEXT
+    assert buffer_pool.used == 0
+
+
+def test_incompressible_file_is_sent_as_is(guest):
+    data = os.urandom(64 * 1024)
+    file_id = upload(guest, "photo.jpg", data)
+    identity_etag = guest.head(f"/api/download/{file_id}", headers={"Accept-Encoding": "identity"}).headers["ETag"]
+    response, raw = _download(guest, file_id, GZIP)
+    assert "Content-Encoding" not in response.headers
+    assert response.headers["Content-Length"] == str(len(data))
+    assert response.headers["ETag"] == identity_etag
+    assert raw == data
+    assert buffer_pool.used == 0
+
+
+@pytest.mark.parametrize("setting", ["compress_buffer_threshold", "compress_buffer_pool"])
+def test_large_files_or_a_full_pool_stream(guest, monkeypatch, setting):
+    monkeypatch.setattr(config, setting, len(TEXT) - 1)
+    file_id = upload(guest, "notes.txt", TEXT)
+    response, raw = _download(guest, file_id, GZIP)
+    assert response.headers["Content-Encoding"] == "gzip"
+    assert "Content-Length" not in response.headers
+    assert gzip.decompress(raw) == TEXT
+
+
+def test_pool_refuses_what_would_overflow(monkeypatch):
+    monkeypatch.setattr(config, "compress_buffer_pool", 100)
+    pool = BufferPool()
+    assert pool.reserve(60)
+    assert not pool.reserve(50)
+    pool.release(60)
+    assert pool.reserve(100)
+    assert pool.used == 100
+
+
+@pytest.mark.parametrize("compressed, worth", [(50, True), (95, True), (96, False), (100_000, False)])
+def test_worth_compressing(compressed, worth):
+    assert worth_compressing(100, compressed) == worth
@@ -0,0 +1,89 @@
+"""Which web pages may call the API: the share's own, --cors-origin, or any with --cors-any."""
+
+import pytest
+
+from flashare.config import config
+from flashare.core.network import parse_origin
+
+
+OTHER_SITE = "https://evil.example"
+
+
+@pytest.fixture(autouse=True)
+def origins(monkeypatch):
+    monkeypatch.setattr(config, "port", 8000)
+    monkeypatch.setattr(config, "cors_origins", [])
+    monkeypatch.setattr(config, "cors_any", False)
+    monkeypatch.setattr(config, "cors_allow_credentials", True)
+
+
+def _post(client, origin: str):
+    return client.post("/api/upload", files={"file": ("a.txt", b"a")}, headers={"Origin": origin})
+
+
+def test_other_sites_are_refused_before_anything_runs(guest, share):
+    response = _post(guest, OTHER_SITE)
+    assert response.status_code == 403
+    assert "access-control-allow-origin" not in response.headers
+    assert not list(share.rglob("a*.txt"))
+    assert guest.get("/api/files", headers={"Origin": OTHER_SITE}).status_code == 403
+
+
+def test_other_sites_fail_preflight(guest):
+    response = guest.options(
+        "/api/upload", headers={"Origin": OTHER_SITE, "Access-Control-Request-Method": "POST"}
+    )
+    assert response.status_code == 400
+    assert "access-control-allow-origin" not in response.headers
+
+
+def test_same_origin_and_no_origin_are_allowed(guest):
+    assert _post(guest, "http://testserver").status_code == 200
+    assert guest.get("/api/files").status_code == 200
+
+
+@pytest.mark.parametrize("origin", ["http://localhost:8000", "http://127.0.0.1:8000"])
+def test_share_urls_are_allowed_with_cookies(guest, origin):
+    response = _post(guest, origin)
+    assert response.status_code == 200
+    assert response.headers["access-control-allow-origin"] == origin
+    assert response.headers["access-control-allow-credentials"] == "true"
+
+
+def test_another_port_is_another_site(guest):
+    assert _post(guest, "http://localhost:9000").status_code == 403
+
+
+def test_configured_origin_is_allowed(guest, monkeypatch):
+    monkeypatch.setattr(config, "cors_origins", ["https://intranet.example"])
+    response = _post(guest, "https://intranet.example")
+    assert response.status_code == 200
+    assert response.headers["access-control-allow-origin"] == "https://intranet.example"
+
+
+def test_cors_any_allows_every_site_without_cookies(guest, monkeypatch):
+    monkeypatch.setattr(config, "cors_any", True)
+    response = guest.get("/api/files", headers={"Origin": OTHER_SITE})
+    assert response.status_code == 200
+    assert response.headers["access-control-allow-origin"] == "*"
+    assert "access-control-allow-credentials" not in response.headers
+
+    preflight = guest.options(
+        "/api/upload", headers={"Origin": OTHER_SITE, "Access-Control-Request-Method": "POST"}
+    )
+    assert preflight.status_code == 200
+    assert "access-control-allow-credentials" not in preflight.headers
+
+
+@pytest.mark.parametrize("value, expected", [
+    ("https://Example.com/", "https://example.com"),
+    ("  http://10.0.0.2:8080 ", "http://10.0.0.2:8080"),
+])
+def test_parse_origin(value, expected):
+    assert parse_origin(value) == expected
+
+
+@pytest.mark.parametrize("value", ["example.com", "ftp://example.com", "https://example.com/app", "https://a.com?x=1"])
+def test_parse_origin_rejects(value):
+    with pytest.raises(ValueError):
+        parse_origin(value)
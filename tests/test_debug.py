@@ -0,0 +1,67 @@
+"""The diagnostics bundle: admin only, and free of secrets."""
+
+import io
+import json
+import zipfile
+
+from flashare.config import config
+from flashare.core.access import share_access
+from flashare.core.debug import build_bundle
+from flashare.core.redact import REDACTED
+
+from conftest import ADMIN_TOKEN
+
+
+def _contents(data: bytes) -> dict[str, str]:
+    with zipfile.ZipFile(io.BytesIO(data)) as archive:
+        return {name: archive.read(name).decode() for name in archive.namelist()}
+
+
+def test_guests_cannot_download_the_bundle(guest):
+    assert guest.get("/api/debug/bundle").status_code == 401
+
+
+def test_bundle_is_disabled_without_admin_token(guest, monkeypatch):
+    monkeypatch.setattr(config, "admin_token", "")
+    assert guest.get("/api/debug/bundle").status_code == 403
+
+
+def test_bundle_has_no_secrets(host, monkeypatch):
+    monkeypatch.setattr(config, "require_key", True)
+    monkeypatch.setattr(config, "wifi_password", "correct horse battery")
+    response = host.get("/api/debug/bundle")
+    assert response.status_code == 200
+    assert response.headers["content-type"] == "application/zip"
+
+    files = _contents(response.content)
+    assert {"flashare-debug/config.json", "flashare-debug/status.json", "flashare-debug/doctor.txt"} <= set(files)
+    for name, text in files.items():
+        for secret in (ADMIN_TOKEN, share_access.key, "correct horse battery"):
+            assert secret not in text, name
+    saved = json.loads(files["flashare-debug/config.json"])
+    assert saved["admin_token"] == REDACTED
+    assert saved["wifi_password"] == REDACTED
+
+
+def test_secrets_are_scrubbed_wherever_they_appear(monkeypatch):
+    monkeypatch.setattr(config, "admin_token", ADMIN_TOKEN)
+    snapshots = {
+        "links": {
+            "share": f"http://192.168.1.42:8000/?key={share_access.key}",
+            "note": f"token was {ADMIN_TOKEN}",
+            "apiToken": "anything",
+        },
+    }
+    links = json.loads(_contents(build_bundle(snapshots))["flashare-debug/links.json"])
+    assert links == {
+        "share": f"http://192.168.1.42:8000/?key={REDACTED}",
+        "note": f"token was {REDACTED}",
+        "apiToken": REDACTED,
+    }
+
+
+def test_home_directory_is_shortened(monkeypatch, tmp_path):
+    monkeypatch.setenv("HOME", str(tmp_path))
+    monkeypatch.setattr(config, "uploads_dir", tmp_path / "Shared")
+    saved = json.loads(_contents(build_bundle({}))["flashare-debug/config.json"])
+    assert saved["uploads_dir"] == "~/Shared"
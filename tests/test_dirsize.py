@@ -0,0 +1,141 @@
+"""The folder size index: rebuilt in the background, updated incrementally."""
+
+import os
+import threading
+
+import pytest
+
+from flashare.core import dirsize
+from flashare.core.dirsize import DirSizeIndex
+
+
+def _write(root, rel: str, size: int):
+    path = root / rel
+    path.parent.mkdir(parents=True, exist_ok=True)
+    path.write_bytes(b"x" * size)
+
+
+def _settle(index: DirSizeIndex) -> DirSizeIndex:
+    """Wait for rebuilds to finish, including one re-run because the index went dirty."""
+    while True:
+        thread = index._thread
+        thread.join(10)
+        if index._thread is thread:
+            return index
+
+
+@pytest.fixture
+def tree(tmp_path):
+    root = tmp_path / "share"
+    _write(root, "top.txt", 10)
+    _write(root, "photos/a.jpg", 100)
+    _write(root, "photos/2024/b.jpg", 1000)
+    _write(root, "docs/c.pdf", 5)
+    # Hidden, like in listings: Flashare's own state isn't counted
+    _write(root, ".meta/top.txt.json", 50000)
+    _write(root, "photos/.thumbs/a.jpg", 50000)
+    return root
+
+
+@pytest.fixture
+def index(tree):
+    index = DirSizeIndex()
+    index.rebuild(tree)
+    return _settle(index)
+
+
+def test_rebuild_sizes_every_folder(index):
+    assert index.state == "ready"
+    assert index.size() == 1115
+    assert index.size("photos") == 1100
+    assert index.size("photos/2024") == 1000
+    assert index.size("docs") == 5
+    assert index.size("missing") is None
+    assert index.status() == {"state": "ready", "folders": 3, "progress": None}
+
+
+def test_symlinks_are_not_followed(tree):
+    os.symlink(tree / "photos", tree / "link")
+    index = DirSizeIndex()
+    index.rebuild(tree)
+    assert _settle(index).size() == 1115
+
+
+def test_adding_bubbles_up_every_parent(index):
+    index.add("photos/2024/c.jpg", 300)
+    assert index.size("photos/2024") == 1300
+    assert index.size("photos") == 1400
+    assert index.size() == 1415
+    assert index.size("docs") == 5
+
+
+def test_removing_bubbles_up_every_parent(index):
+    index.add("photos/2024/b.jpg", -1000)
+    assert index.size("photos/2024") == 0
+    assert index.size("photos") == 100
+    assert index.size() == 115
+
+
+def test_changes_before_the_first_rebuild_are_ignored():
+    index = DirSizeIndex()
+    index.add("a.txt", 10)
+    assert index.size() is None
+
+
+def test_add_tree_accounts_a_new_folder(index, tree):
+    _write(tree, "photos/trip/d.jpg", 20)
+    _write(tree, "photos/trip/e/f.jpg", 30)
+    index.add_tree(tree / "photos/trip")
+    assert index.size("photos/trip") == 50
+    assert index.size("photos/trip/e") == 30
+    assert index.size("photos") == 1150
+    assert index.size() == 1165
+
+
+def test_rebuild_matches_incremental_updates(index, tree):
+    _write(tree, "docs/new.txt", 7)
+    index.add("docs/new.txt", 7)
+    (tree / "photos/a.jpg").unlink()
+    index.add("photos/a.jpg", -100)
+    incremental = {folder: index.size(folder) for folder in ("", "photos", "photos/2024", "docs")}
+
+    index.rebuild(tree)
+    _settle(index)
+    assert {folder: index.size(folder) for folder in incremental} == incremental
+
+
+def test_change_during_rebuild_triggers_another(tree, monkeypatch):
+    walking = threading.Event()
+    proceed = threading.Event()
+    walk = dirsize._walk
+
+    def slow_walk(folder, rel, cancel):
+        walking.set()
+        proceed.wait(10)
+        return walk(folder, rel, cancel)
+
+    monkeypatch.setattr(dirsize, "_walk", slow_walk)
+    index = DirSizeIndex()
+    index.rebuild(tree)
+    assert walking.wait(10)
+    # Whether the walk sees this file is a race, so the index must walk again
+    _write(tree, "docs/late.txt", 3)
+    index.add("docs/late.txt", 3)
+    proceed.set()
+
+    assert _settle(index).size("docs") == 8
+    assert index.size() == 1118
+
+
+def test_cancel_keeps_previous_sizes(index, tree, monkeypatch):
+    proceed = threading.Event()
+    walk = dirsize._walk
+    monkeypatch.setattr(dirsize, "_walk", lambda *args: proceed.wait(10) and walk(*args))
+    _write(tree, "docs/more.txt", 1)
+    index.rebuild(tree)
+    assert index.state == "building"
+    index.cancel()
+    proceed.set()
+    index._thread.join(10)
+    assert index.state == "ready"
+    assert index.size() == 1115
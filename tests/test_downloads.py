@@ -0,0 +1,101 @@
+"""Download streams: finished, or cut short by the client leaving."""
+
+import asyncio
+import threading
+
+from flashare.api.routes import _relay_stream
+from flashare.core.stats import stats
+
+
+class Source:
+    """A chunk stream that records being closed, optionally holding its second read."""
+
+    def __init__(self, chunks: int, hold: bool = False):
+        self.chunks = chunks
+        self.closed = False
+        self.reading = threading.Event()
+        self.release = threading.Event()
+        if not hold:
+            self.release.set()
+        self.stream = self._generate()
+
+    def _generate(self):
+        try:
+            for i in range(self.chunks):
+                if i == 1:
+                    self.reading.set()
+                    self.release.wait(5)
+                yield b"x" * 1024
+        finally:
+            self.closed = True
+
+
+def _transfer():
+    return stats.start_transfer("notes.txt", "download", "192.168.1.20", 4096)
+
+
+def test_complete_download_counts_as_download():
+    source = Source(4)
+    transfer = _transfer()
+    finished = []
+
+    async def scenario():
+        return [chunk async for chunk in _relay_stream(source.stream, transfer, on_finish=finished.append)]
+
+    before = stats.metrics()
+    assert b"".join(asyncio.run(scenario())) == b"x" * 4096
+    after = stats.metrics()
+    assert after["downloads"] == before["downloads"] + 1
+    assert after["aborted"] == before["aborted"]
+    assert finished == [True]
+    assert source.closed
+    assert not stats.is_active(transfer)
+
+
+def test_client_leaving_between_chunks_aborts():
+    source = Source(4)
+    transfer = _transfer()
+    finished = []
+
+    async def scenario():
+        relay = _relay_stream(source.stream, transfer, on_finish=finished.append)
+        await anext(relay)
+        # What the server does once the client is gone
+        await relay.aclose()
+
+    before = stats.metrics()
+    asyncio.run(scenario())
+    after = stats.metrics()
+    assert after["aborted"] == before["aborted"] + 1
+    assert after["downloads"] == before["downloads"]
+    assert finished == [False]
+    assert source.closed
+    assert transfer.bytes == 1024
+
+
+def test_client_leaving_mid_read_closes_after_the_read():
+    source = Source(4, hold=True)
+    transfer = _transfer()
+
+    async def scenario():
+        async def consume():
+            async for _ in _relay_stream(source.stream, transfer):
+                pass
+
+        task = asyncio.create_task(consume())
+        await asyncio.to_thread(source.reading.wait, 5)
+        task.cancel()
+        await asyncio.gather(task, return_exceptions=True)
+        # The worker thread is still inside the generator, which can't be closed yet
+        assert not source.closed
+
+        source.release.set()
+        for _ in range(100):
+            if source.closed:
+                break
+            await asyncio.sleep(0.01)
+
+    before = stats.metrics()
+    asyncio.run(scenario())
+    assert source.closed
+    assert stats.metrics()["aborted"] == before["aborted"] + 1
@@ -0,0 +1,84 @@
+"""The live-updates hub forgets streams whose clients are gone."""
+
+import asyncio
+
+from starlette.requests import Request
+
+from flashare.api.routes import event_stream
+from flashare.config import config
+from flashare.core.events import QUEUE_SIZE, hub
+
+
+def _request(disconnected: asyncio.Event) -> Request:
+    """A GET /api/events whose client hangs up once disconnected is set."""
+    async def receive():
+        await disconnected.wait()
+        return {"type": "http.disconnect"}
+
+    scope = {
+        "type": "http",
+        "method": "GET",
+        "path": "/api/events",
+        "headers": [],
+        "query_string": b"",
+        "client": ("192.168.1.20", 50000),
+    }
+    return Request(scope, receive)
+
+
+def test_closed_client_is_unsubscribed(monkeypatch):
+    monkeypatch.setattr(config, "heartbeat_interval", 0.01)
+
+    async def scenario():
+        before = hub.count
+        disconnected = asyncio.Event()
+        response = await event_stream(_request(disconnected))
+        body = response.body_iterator
+        assert await anext(body) == "retry: 5000\n\n"
+        assert hub.count == before + 1
+
+        # Still there: heartbeats keep flowing
+        assert await anext(body) == ": ping\n\n"
+        assert hub.count == before + 1
+
+        disconnected.set()
+        async for _ in body:
+            pass
+        assert hub.count == before
+
+    asyncio.run(scenario())
+
+
+def test_subscriber_that_falls_behind_is_dropped(monkeypatch):
+    monkeypatch.setattr(config, "heartbeat_interval", 0.01)
+
+    async def scenario():
+        before = hub.count
+        response = await event_stream(_request(asyncio.Event()))
+        body = response.body_iterator
+        await anext(body)
+
+        # A client that stopped reading: the queue fills, then overflows
+        for i in range(QUEUE_SIZE + 1):
+            hub.publish("files", {"added": f"file_{i}"})
+        await asyncio.sleep(0)
+        async for _ in body:
+            pass
+        assert hub.count == before
+
+    asyncio.run(scenario())
+
+
+def test_subscriber_on_a_closed_loop_is_removed():
+    async def subscribe():
+        return hub.subscribe("192.168.1.20")
+
+    before = hub.count
+    loop = asyncio.new_event_loop()
+    subscriber = loop.run_until_complete(subscribe())
+    loop.close()
+    assert hub.count == before + 1
+
+    hub.publish("files", {"added": "photo.jpg"})
+    assert hub.count == before
+    hub.unsubscribe(subscriber)
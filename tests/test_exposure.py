@@ -0,0 +1,114 @@
+"""Refusing to share the home directory or system folders, and confirming huge ones."""
+
+import sys
+from pathlib import Path
+
+import pytest
+
+from flashare.cli import main
+from flashare.config import config
+from flashare.core.excludes import PathFilter
+from flashare.core.exposure import check_share_root, dangerous_root, estimate_exposure
+
+
+@pytest.fixture
+def home(tmp_path, monkeypatch):
+    home = tmp_path / "home"
+    home.mkdir()
+    monkeypatch.setattr(Path, "home", classmethod(lambda cls: home))
+    return home
+
+
+@pytest.fixture
+def tree(tmp_path):
+    root = tmp_path / "project"
+    (root / "node_modules" / "lib").mkdir(parents=True)
+    for n in range(10):
+        (root / f"file{n}.txt").write_bytes(b"x" * 100)
+    for n in range(50):
+        (root / "node_modules" / "lib" / f"{n}.js").write_bytes(b"x")
+    return root
+
+
+@pytest.mark.parametrize("path", ["/", "/etc", "/usr", "/home", "/etc/../usr"])
+def test_system_folders_are_dangerous(home, path):
+    assert dangerous_root(Path(path))
+
+
+def test_home_is_dangerous_but_folders_in_it_are_not(home):
+    (home / "Downloads").mkdir()
+    assert dangerous_root(home) == "your home directory"
+    assert dangerous_root(home / "Downloads" / "..") == "your home directory"
+    assert dangerous_root(home / "Downloads") == ""
+
+
+def test_link_to_home_is_dangerous(home, tmp_path):
+    (tmp_path / "shortcut").symlink_to(home)
+    assert dangerous_root(tmp_path / "shortcut") == "your home directory"
+
+
+def test_exposure_counts_only_shared_files(tree):
+    exposure = estimate_exposure(tree, PathFilter(), 0, 0)
+    assert (exposure.files, exposure.size, exposure.complete) == (10, 1000, True)
+
+
+@pytest.mark.parametrize("max_files, max_size", [(5, 0), (0, 500), (5, 500)])
+def test_exposure_scan_stops_at_a_limit(tree, max_files, max_size):
+    exposure = estimate_exposure(tree, PathFilter(), max_files, max_size)
+    assert not exposure.complete
+    assert exposure.files <= 6
+
+
+def test_check_share_root(home, tree):
+    assert check_share_root(home, PathFilter(), 5, 0).exposure is None  # Never scanned
+    assert check_share_root(tree, PathFilter(), 5, 0).too_large
+    assert not check_share_root(tree, PathFilter(), 10, 1000).too_large
+
+
+@pytest.fixture
+def limits(monkeypatch):
+    monkeypatch.setattr(config, "confirm_files_over", 5)
+    monkeypatch.setattr(config, "confirm_size_over", 0)
+
+
+class Stdin:
+    def __init__(self, tty: bool):
+        self.tty = tty
+
+    def isatty(self) -> bool:
+        return self.tty
+
+
+def test_guard_refuses_home(home, limits):
+    with pytest.raises(SystemExit) as exited:
+        main._guard_share_root(home, PathFilter(), assume_yes=True, allow_dangerous=False)
+    assert exited.value.code == 1
+
+
+def test_guard_allows_home_when_asked(home, limits):
+    main._guard_share_root(home, PathFilter(), assume_yes=False, allow_dangerous=True)
+
+
+def test_guard_lets_small_folders_through(tree, monkeypatch):
+    monkeypatch.setattr(config, "confirm_files_over", 100)
+    monkeypatch.setattr(config, "confirm_size_over", 0)
+    monkeypatch.setattr(main, "confirm", lambda *a, **k: pytest.fail("asked"))
+    main._guard_share_root(tree, PathFilter(), assume_yes=False, allow_dangerous=False)
+
+
+def test_guard_needs_yes_for_large_folders(tree, limits, monkeypatch):
+    monkeypatch.setattr(sys, "stdin", Stdin(False))
+    with pytest.raises(SystemExit):
+        main._guard_share_root(tree, PathFilter(), assume_yes=False, allow_dangerous=False)
+    main._guard_share_root(tree, PathFilter(), assume_yes=True, allow_dangerous=False)
+
+
+@pytest.mark.parametrize("answer", [True, False])
+def test_guard_asks_on_a_terminal(tree, limits, monkeypatch, answer):
+    monkeypatch.setattr(sys, "stdin", Stdin(True))
+    monkeypatch.setattr(main, "confirm", lambda *a, **k: answer)
+    if answer:
+        main._guard_share_root(tree, PathFilter(), assume_yes=False, allow_dangerous=False)
+    else:
+        with pytest.raises(SystemExit):
+            main._guard_share_root(tree, PathFilter(), assume_yes=False, allow_dangerous=False)
@@ -0,0 +1,111 @@
+"""Sharing download bandwidth between devices rather than connections."""
+
+import pytest
+
+from flashare.config import config
+from flashare.core.fairness import ESTIMATE_WINDOW, MIN_RATE, SMALL_TRANSFER, FairShare
+
+
+LIMIT = 12_000_000
+
+
+class Clock:
+    """A clock that only moves when told to."""
+
+    def __init__(self):
+        self.now = 100.0
+
+    def __call__(self) -> float:
+        return self.now
+
+
+@pytest.fixture
+def clock():
+    return Clock()
+
+
+@pytest.fixture
+def fair(clock):
+    return FairShare(clock)
+
+
+@pytest.fixture
+def limited(monkeypatch):
+    monkeypatch.setattr(config, "bandwidth_limit", LIMIT)
+
+
+def _rate(lane, count: int = 1_200_000) -> float:
+    """Bytes per second a lane is paced at, measured from two back-to-back sends."""
+    lane.take(count)
+    return count / lane.take(count)
+
+
+def test_lone_device_is_never_paced(fair):
+    lane = fair.open("phone", None)
+    assert [lane.take(10_000_000) for _ in range(5)] == [0.0] * 5
+
+
+def test_lone_device_keeps_the_configured_limit(fair, limited):
+    assert _rate(fair.open("phone", None)) == pytest.approx(LIMIT)
+
+
+def test_devices_share_equally_however_many_connections(fair, limited):
+    phone = [fair.open("phone", None) for _ in range(3)]
+    laptop = fair.open("laptop", None)
+    assert _rate(laptop) == pytest.approx(LIMIT / 2)
+    assert sum(_rate(lane) for lane in phone) == pytest.approx(LIMIT / 2)
+
+
+def test_small_transfers_get_a_boost(fair, limited):
+    phone = fair.open("phone", SMALL_TRANSFER)
+    laptop = fair.open("laptop", SMALL_TRANSFER + 1)
+    assert _rate(phone) == pytest.approx(LIMIT * 4 / 5)
+    assert _rate(laptop) == pytest.approx(LIMIT / 5)
+
+
+def test_unknown_size_counts_as_large(fair, limited):
+    phone = fair.open("phone", None)
+    laptop = fair.open("laptop", 1024)
+    assert _rate(phone) == pytest.approx(LIMIT / 5)
+    assert _rate(laptop) == pytest.approx(LIMIT * 4 / 5)
+
+
+def test_shares_follow_devices_leaving(fair, limited):
+    phone = fair.open("phone", None)
+    laptop = fair.open("laptop", None)
+    assert _rate(phone) == pytest.approx(LIMIT / 2)
+    laptop.close()
+    laptop.close()  # Closing twice is harmless
+    phone.ready_at = 0.0
+    assert _rate(phone) == pytest.approx(LIMIT)
+    # A closed lane is never held back
+    assert laptop.take(10_000_000) == 0.0
+
+
+def test_rate_never_drops_below_the_floor(fair, monkeypatch):
+    monkeypatch.setattr(config, "bandwidth_limit", 1000)
+    fair.open("laptop", None)
+    assert _rate(fair.open("phone", None), 64 * 1024) == pytest.approx(MIN_RATE)
+
+
+def test_waits_account_for_time_passing(fair, clock, limited):
+    fair.open("laptop", None)
+    phone = fair.open("phone", None)
+    assert phone.take(LIMIT // 2) == 0.0
+    clock.now += 0.25
+    assert phone.take(LIMIT // 2) == pytest.approx(0.75)
+    clock.now += 5
+    assert phone.take(LIMIT // 2) == 0.0
+
+
+def test_capacity_is_estimated_from_recent_traffic(fair, clock):
+    phone = fair.open("phone", None)
+    laptop = fair.open("laptop", None)
+    # Nothing to estimate from yet
+    assert phone.take(1_000_000) == 0.0
+    laptop.take(1_000_000)
+    assert laptop.take(1_000_000) > 0
+    # Once the traffic is older than the window, there is no estimate again
+    clock.now += ESTIMATE_WINDOW + 100
+    laptop.ready_at = 0.0
+    assert laptop.take(1_000_000) == 0.0
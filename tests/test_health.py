@@ -0,0 +1,82 @@
+"""A vanished uploads directory is a 503 'storage_unavailable', never a 500."""
+
+import errno
+import shutil
+
+import pytest
+from fastapi.testclient import TestClient
+
+from flashare.config import config
+from flashare.core.health import StorageHealth, storage_health
+
+
+@pytest.fixture(autouse=True)
+def healthy(monkeypatch, share):
+    """Start every test with the global state saying the share is fine."""
+    for name, value in (("available", True), ("reason", ""), ("_root", share), ("_mount", False), ("_listeners", [])):
+        monkeypatch.setattr(storage_health, name, value)
+    monkeypatch.setattr(config, "auto_create_dir", False)
+
+
+def test_probe_notices_a_missing_directory(share):
+    health = StorageHealth()
+    changes = []
+    health.listen(lambda available, reason: changes.append(available))
+    assert health.probe(share, write=True)
+    shutil.rmtree(share)
+    assert not health.probe(share)
+    assert not health.probe(share)
+    assert health.reason == "the uploads directory is missing"
+    share.mkdir()
+    assert health.probe(share)
+    # Listeners only hear about changes
+    assert changes == [False, True]
+    assert not list(share.iterdir())  # The write probe cleans up after itself
+
+
+def test_only_errors_about_the_directory_count(share):
+    health = StorageHealth()
+    assert not health.check_error(share, FileNotFoundError(errno.ENOENT, "one file deleted from outside"))
+    assert health.available
+    assert not health.check_error(share, PermissionError(errno.EACCES, "denied"))
+    shutil.rmtree(share)
+    assert health.check_error(share, FileNotFoundError(errno.ENOENT, "gone"))
+    assert not health.available
+
+
+def test_requests_get_503_when_the_directory_is_gone(guest, share):
+    shutil.rmtree(share)
+    response = guest.get("/api/files")
+    assert response.status_code == 503
+    assert response.json()["code"] == "storage_unavailable"
+    assert guest.post("/api/upload", files={"file": ("a.txt", b"a")}).status_code == 503
+
+
+def test_directory_can_be_recreated(guest, share, monkeypatch):
+    monkeypatch.setattr(config, "auto_create_dir", True)
+    shutil.rmtree(share)
+    assert guest.get("/api/files").status_code == 200
+    assert share.is_dir()
+
+
+@pytest.fixture
+def failing(app, share):
+    """A client for an app with a route that fails like a request whose files vanish mid-way."""
+    def fail():
+        raise FileNotFoundError(errno.ENOENT, "No such file or directory", str(share / "a.txt"))
+
+    app.add_api_route("/api/test-fail", fail)
+    return TestClient(app, raise_server_exceptions=False)
+
+
+def test_filesystem_error_from_a_vanished_directory_is_503(failing, share):
+    shutil.rmtree(share)
+    response = failing.get("/api/test-fail")
+    assert response.status_code == 503
+    assert response.json()["code"] == "storage_unavailable"
+    assert not storage_health.available
+
+
+def test_other_filesystem_errors_stay_500(failing):
+    assert failing.get("/api/test-fail").status_code == 500
+    assert storage_health.available
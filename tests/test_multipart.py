@@ -0,0 +1,48 @@
+"""Upload forms with too many parts are refused before they are read in full."""
+
+import pytest
+
+from flashare.config import config
+
+
+LIMIT = 3
+
+
+@pytest.fixture(autouse=True)
+def max_parts(monkeypatch):
+    monkeypatch.setattr(config, "max_multipart_parts", LIMIT)
+
+
+def _files(count: int) -> list[tuple]:
+    return [("files", (f"part{n}.txt", b"x")) for n in range(count)]
+
+
+def _stored(share) -> list[str]:
+    return sorted(p.name for p in share.iterdir() if not p.name.startswith("."))
+
+
+def test_up_to_the_limit_is_accepted(guest, share):
+    response = guest.post("/api/upload-multiple", files=_files(LIMIT))
+    assert response.status_code == 200
+    assert _stored(share) == ["part0.txt", "part1.txt", "part2.txt"]
+
+
+@pytest.mark.parametrize("path", ["/api/upload-multiple", "/api/upload"])
+def test_too_many_files_is_refused(guest, share, path):
+    files = _files(LIMIT + 1) + [("file", ("main.txt", b"x"))]
+    response = guest.post(path, files=files)
+    assert response.status_code == 413
+    assert "at most 3" in response.json()["detail"]
+    assert _stored(share) == []
+
+
+def test_too_many_fields_is_refused(guest, share):
+    fields = {f"field{n}": "x" for n in range(LIMIT + 1)}
+    response = guest.post("/api/upload", data=fields, files={"file": ("main.txt", b"x")})
+    assert response.status_code == 413
+    assert _stored(share) == []
+
+
+def test_form_without_a_file_is_a_bad_request(guest):
+    assert guest.post("/api/upload", data={"note": "no file"}).status_code == 400
+    assert guest.post("/api/upload-multiple", data={"note": "no file"}).status_code == 400
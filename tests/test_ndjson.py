@@ -0,0 +1,87 @@
+"""--output ndjson: one event object per line on stdout."""
+
+import io
+import json
+import sys
+from pathlib import Path
+
+import pytest
+
+from flashare.core.events import hub
+from flashare.core.ndjson import NDJSONOutput, ndjson
+
+from conftest import upload
+
+
+def _lines(out: io.StringIO) -> list[dict]:
+    return [json.loads(line) for line in out.getvalue().splitlines()]
+
+
+@pytest.fixture
+def stdout(monkeypatch):
+    """Fresh stdout and stderr, with no hub listeners left behind."""
+    out, err = io.StringIO(), io.StringIO()
+    monkeypatch.setattr(sys, "stdout", out)
+    monkeypatch.setattr(sys, "stderr", err)
+    monkeypatch.setattr(hub, "_listeners", [])
+    return out
+
+
+def test_nothing_is_written_until_started(stdout):
+    output = NDJSONOutput()
+    output.emit("listening", url="http://x")
+    assert not output.enabled
+    assert stdout.getvalue() == ""
+
+
+def test_event_shape(stdout):
+    output = NDJSONOutput()
+    output.start()
+    output.emit("listening", url="http://10.0.0.2:8000", port=8000)
+    output.emit("upload", {"file": "a.txt", "size": 1}, client="10.0.0.3", path=Path("/tmp/a.txt"))
+    listening, uploaded = _lines(stdout)
+    assert listening.pop("time") > 0
+    assert listening == {"event": "listening", "url": "http://10.0.0.2:8000", "port": 8000}
+    assert uploaded.pop("time") > 0
+    assert uploaded == {"event": "upload", "file": "a.txt", "size": 1, "client": "10.0.0.3", "path": "/tmp/a.txt"}
+
+
+def test_stdout_carries_only_events(stdout):
+    output = NDJSONOutput()
+    output.start()
+    output.start()  # Starting twice keeps the real stdout
+    print("🚀 Server started")
+    output.emit("shutdown", summary={"uploads": 2})
+    assert [line["event"] for line in _lines(stdout)] == ["shutdown"]
+    assert "Server started" in sys.stderr.getvalue()
+
+
+def test_live_updates_are_mirrored(stdout):
+    output = NDJSONOutput()
+    output.start()
+    hub.publish("files", {"added": "a.txt"})
+    hub.publish("stats")
+    events = _lines(stdout)
+    assert [(e["event"], e.get("added")) for e in events] == [("files", "a.txt"), ("stats", None)]
+
+
+def test_closed_reader_is_ignored(stdout, monkeypatch):
+    output = NDJSONOutput()
+    output.start()
+
+    def broken(text):
+        raise BrokenPipeError
+
+    monkeypatch.setattr(stdout, "write", broken)
+    output.emit("upload", file="a.txt")
+
+
+def test_upload_event(guest, monkeypatch):
+    out = io.StringIO()
+    monkeypatch.setattr(ndjson, "_out", out)
+    file_id = upload(guest, "notes.txt", b"hello")
+    event = next(line for line in _lines(out) if line["event"] == "upload")
+    assert event["file"] == "notes.txt"
+    assert event["id"] == file_id
+    assert event["size"] == 5
+    assert event["client"] == "testclient"
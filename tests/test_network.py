@@ -0,0 +1,97 @@
+"""Picking the LAN address to advertise, past virtual adapters."""
+
+import socket
+
+import pytest
+
+from flashare.config import config
+from flashare.core import doctor, network
+from flashare.core.network import _is_virtual, _windows_adapter_ips, get_local_ip
+
+
+IPCONFIG = """\
+Windows IP Configuration
+
+Ethernet adapter vEthernet (WSL):
+
+   Connection-specific DNS Suffix  . :
+   IPv4 Address. . . . . . . . . . . : 172.28.16.1
+   Subnet Mask . . . . . . . . . . . : 255.255.240.0
+
+Wireless LAN adapter Wi-Fi:
+
+   IPv4 Address. . . . . . . . . . . : 192.168.1.42
+   Default Gateway . . . . . . . . . : 192.168.1.1
+"""
+
+
+@pytest.mark.parametrize("ip, adapter, virtual", [
+    ("192.168.1.42", "", False),
+    ("10.0.0.5", "Wi-Fi", False),
+    # Real LANs use the rest of 172.16/12 too
+    ("172.20.1.10", "Ethernet", False),
+    ("172.17.0.1", "", True),
+    ("169.254.10.20", "", True),
+    ("127.0.0.1", "", True),
+    ("172.28.16.1", "vEthernet (WSL)", True),
+    ("192.168.56.1", "VirtualBox Host-Only Network", True),
+    ("100.101.102.103", "Tailscale", True),
+])
+def test_is_virtual(ip, adapter, virtual):
+    assert _is_virtual(ip, adapter) == virtual
+
+
+def test_windows_adapters_are_read_from_ipconfig(monkeypatch):
+    monkeypatch.setattr(network.subprocess, "run", lambda *a, **k: type("Done", (), {"stdout": IPCONFIG}))
+    assert _windows_adapter_ips() == {
+        "172.28.16.1": "Ethernet adapter vEthernet (WSL)",
+        "192.168.1.42": "Wireless LAN adapter Wi-Fi",
+    }
+
+
+@pytest.fixture
+def machine(monkeypatch):
+    """A machine whose default route and addresses each test sets."""
+    def setup(outbound, addresses=(), adapters=None, system="Linux"):
+        monkeypatch.setattr(network, "_outbound_ip", lambda: outbound)
+        monkeypatch.setattr(network, "_windows_adapter_ips", lambda: adapters or {})
+        monkeypatch.setattr(network.platform, "system", lambda: system)
+        monkeypatch.setattr(
+            network.socket, "getaddrinfo",
+            lambda *a, **k: [(socket.AF_INET, 0, 0, "", (ip, 0)) for ip in addresses],
+        )
+        get_local_ip.cache_clear()
+
+    yield setup
+    get_local_ip.cache_clear()
+
+
+def test_default_route_wins(machine):
+    machine("192.168.1.42", ["10.0.0.5"])
+    assert get_local_ip() == "192.168.1.42"
+
+
+def test_docker_bridge_is_skipped(machine):
+    machine("172.17.0.1", ["172.17.0.1", "192.168.1.42"])
+    assert get_local_ip() == "192.168.1.42"
+
+
+def test_wsl_adapter_is_skipped_on_windows(machine):
+    adapters = {"172.28.16.1": "Ethernet adapter vEthernet (WSL)", "192.168.1.42": "Wireless LAN adapter Wi-Fi"}
+    machine("172.28.16.1", [], adapters, system="Windows")
+    assert get_local_ip() == "192.168.1.42"
+
+
+def test_only_virtual_falls_back_to_outbound(machine):
+    machine("172.17.0.1", ["172.17.0.1"])
+    assert get_local_ip() == "172.17.0.1"
+    machine(None, [])
+    assert get_local_ip() == "127.0.0.1"
+
+
+def test_doctor_checks_the_uploads_dir(share, monkeypatch):
+    results = {r.name: r for r in doctor.run_checks(lan=False)}
+    assert results["uploads dir"].ok
+    monkeypatch.setattr(config, "uploads_dir", share / "missing")
+    results = {r.name: r for r in doctor.run_checks(lan=False)}
+    assert not results["uploads dir"].ok
@@ -0,0 +1,90 @@
+"""Folder templates, and the routes serving the nested IDs they produce."""
+
+import time
+from urllib.parse import quote
+
+import pytest
+
+from flashare.config import config
+from flashare.core.organize import InvalidTemplate, date_folder, parse_template, render_folder
+
+from conftest import upload
+
+
+# 17 May 2024, mid-morning, so no timezone moves it to another day
+WHEN = time.mktime((2024, 5, 17, 10, 30, 0, 0, 0, -1))
+
+
+@pytest.mark.parametrize("template, expected", [
+    ("{type}/{year}/{month}-{day}/", "image/2024/05-17"),
+    ("{year}", "2024"),
+    ("photos-{year}/{device}", "photos-2024/phone1"),
+    ("by type/{type}", "by type/image"),
+])
+def test_render_folder(template, expected):
+    assert render_folder(template, "image", WHEN, "phone1") == expected
+
+
+def test_render_folder_sanitizes_values():
+    assert ".." not in render_folder("{device}", "image", WHEN, "../../etc").split("/")
+    assert "/" not in render_folder("{device}", "image", WHEN, "a/b")
+    assert render_folder("{device}", "image", WHEN) == "unknown"
+
+
+@pytest.mark.parametrize("template", [
+    "",
+    "/",
+    "/abs/{year}",
+    "C:/uploads",
+    "a\\b",
+    "../{year}",
+    "{year}/../..",
+    "./{year}",
+    "a//b",
+    "{unknown}",
+    "{year:04d}",
+    "{year!r}",
+    "{year",
+])
+def test_unsafe_templates_are_refused(template):
+    with pytest.raises(InvalidTemplate):
+        parse_template(template)
+
+
+@pytest.mark.parametrize("date_format, expected", [
+    ("%Y-%m-%d", "2024-05-17"),
+    ("%Y/%m", "2024/05"),
+    ("%Y//%m/", "2024/05"),
+])
+def test_date_folder(date_format, expected):
+    assert date_folder(date_format, WHEN) == expected
+
+
+@pytest.mark.parametrize("date_format", ["uploads", "/%Y", "%Y\\%m", ".%Y", "%Y/.hidden"])
+def test_unusable_date_formats_are_refused(date_format):
+    with pytest.raises(InvalidTemplate):
+        date_folder(date_format, WHEN)
+
+
+def _nested_id_works(host, file_id: str):
+    """Every per-file route answers for a nested ID, with its '/' plain or encoded."""
+    assert "/" in file_id
+    for url_id in (file_id, quote(file_id, safe="")):
+        assert host.get(f"/api/tail/{url_id}").text == "line one\nline two\n"
+        assert host.get(f"/api/files/{url_id}").json()["id"] == file_id
+        assert host.get(f"/api/files/{url_id}/chunks").json()["total"] == 18
+        assert host.post(f"/api/files/{url_id}/claim").status_code == 200
+        assert host.post(f"/api/files/{url_id}/keep").json()["filename"] == file_id
+        assert host.put(f"/api/files/{url_id}", content=b"replacement").status_code == 201
+
+
+def test_organized_ids_reach_every_file_route(host, monkeypatch):
+    monkeypatch.setattr(config, "organize_uploads", "{type}/{year}")
+    _nested_id_works(host, upload(host, "notes.txt", b"line one\nline two\n"))
+
+
+def test_dated_ids_reach_every_file_route(host, monkeypatch):
+    monkeypatch.setattr(config, "date_folders", "%Y/%m")
+    file_id = upload(host, "notes.txt", b"line one\nline two\n")
+    assert file_id == f"{date_folder('%Y/%m')}/notes.txt"
+    _nested_id_works(host, file_id)
@@ -0,0 +1,62 @@
+"""Filenames that are safe to create on Linux, macOS and Windows."""
+
+import pytest
+
+from flashare.core.paths import content_disposition, is_hidden_name, sanitize_filename
+
+
+@pytest.mark.parametrize("name, expected", [
+    ("report.pdf", "report.pdf"),
+    # Directory parts, with either separator
+    ("../../etc/passwd", "passwd"),
+    ("C:\\Users\\me\\photo.jpg", "photo.jpg"),
+    ("mixed/dirs\\file.txt", "file.txt"),
+    # Characters Windows refuses
+    ('a<b>c:d"e|f?g*h.txt', "a_b_c_d_e_f_g_h.txt"),
+    ("tab\there.txt", "tab_here.txt"),
+    # Trailing dots and spaces vanish on Windows; leading spaces are trimmed too
+    ("notes.txt. . ", "notes.txt"),
+    ("  padded.txt", "padded.txt"),
+    # Reserved device names, with or without an extension, in any case
+    ("CON", "_CON"),
+    ("con.txt", "_con.txt"),
+    ("Com1.log", "_Com1.log"),
+    ("lpt9", "_lpt9"),
+    ("CONSOLE.txt", "CONSOLE.txt"),
+    ("COM10", "COM10"),
+    # Would be hidden
+    (".bashrc", "_.bashrc"),
+    ("..hidden", "_..hidden"),
+])
+def test_sanitize_filename(name, expected):
+    assert sanitize_filename(name) == expected
+
+
+@pytest.mark.parametrize("name", ["", "/", "\\", "...", " . "])
+def test_nothing_left_falls_back(name):
+    assert sanitize_filename(name) == "file"
+    assert sanitize_filename(name, fallback="unknown") == "unknown"
+
+
+def test_long_names_are_cut():
+    assert len(sanitize_filename("a" * 300 + ".txt")) == 255
+
+
+@pytest.mark.parametrize("name, hidden", [
+    ("a.txt", False),
+    ("dir/a.txt", False),
+    (".meta/a.txt.json", True),
+    ("sub/.meta/a.txt.json", True),
+    ("sub\\.tus\\x", True),
+    ("..", True),
+    ("a/../b", True),
+])
+def test_is_hidden_name(name, hidden):
+    assert is_hidden_name(name) == hidden
+
+
+def test_content_disposition_keeps_unicode_names():
+    header = content_disposition("café/menu?.pdf")
+    assert header == "attachment; filename=\"menu_.pdf\"; filename*=UTF-8''menu_.pdf"
+    header = content_disposition("café.pdf", "inline")
+    assert header == "inline; filename=\"caf_.pdf\"; filename*=UTF-8''caf%C3%A9.pdf"
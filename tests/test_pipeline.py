@@ -0,0 +1,139 @@
+"""Per-file decisions: the --trace stream and the dry-run table of 'flashare send'."""
+
+import io
+import json
+
+import pytest
+from rich.console import Console
+
+from flashare.cli import main
+from flashare.config import config
+from flashare.core.excludes import PathFilter
+from flashare.core.pipeline import Decision, pipeline
+
+
+@pytest.fixture
+def decisions(monkeypatch):
+    """Every decision made during the test, with no listener left behind."""
+    seen: list[Decision] = []
+    monkeypatch.setattr(pipeline, "_listeners", [seen.append])
+    return seen
+
+
+def _outcomes(decisions) -> list[tuple]:
+    return [(d.origin, d.file, d.step, d.outcome, d.detail) for d in decisions]
+
+
+def test_nothing_is_built_without_listeners(monkeypatch):
+    monkeypatch.setattr(pipeline, "_listeners", [])
+    monkeypatch.setattr("flashare.core.pipeline.Decision", None)  # Would fail if called
+    pipeline.decide("send", "a.txt", "filter", "excluded")
+
+
+def test_sanitize_reports_both_outcomes(decisions):
+    assert pipeline.sanitize("upload", "report.pdf") == "report.pdf"
+    assert pipeline.sanitize("upload", "a?b.txt") == "a_b.txt"
+    assert pipeline.sanitize("send", "CON", "docs/CON") == "_CON"
+    assert _outcomes(decisions) == [
+        ("upload", "report.pdf", "sanitize", "unchanged", ""),
+        ("upload", "a?b.txt", "sanitize", "renamed", "a_b.txt"),
+        ("send", "docs/CON", "sanitize", "renamed", "_CON"),
+    ]
+
+
+def test_unsubscribed_listeners_hear_nothing(decisions):
+    later: list[Decision] = []
+    pipeline.subscribe(later.append)
+    pipeline.decide("send", "a.txt", "filter", "excluded")
+    pipeline.unsubscribe(later.append)
+    pipeline.decide("send", "b.txt", "filter", "excluded")
+    assert [d.file for d in later] == ["a.txt"]
+    assert [d.file for d in decisions] == ["a.txt", "b.txt"]
+
+
+def test_trace_formats():
+    decision = Decision("upload", "a?b.txt", "sanitize", "renamed", "a_b.txt", time=1.0, request_id="req-1")
+    assert decision.to_text() == "🔎 [upload] a?b.txt: sanitize → renamed (a_b.txt) [req-1]"
+    assert Decision("send", "x", "filter", "excluded", request_id="").to_text() == "🔎 [send] x: filter → excluded"
+    assert json.loads(decision.to_json()) == {
+        "origin": "upload",
+        "file": "a?b.txt",
+        "step": "sanitize",
+        "outcome": "renamed",
+        "detail": "a_b.txt",
+        "time": 1.0,
+        "request_id": "req-1",
+    }
+
+
+def test_plan_renames_clashes_on_disk_and_in_the_plan(decisions, share):
+    (share / "notes.txt").write_text("already shared")
+    taken = set()
+    first = main._plan_destination("notes.txt", "notes.txt", taken)
+    second = main._plan_destination("other/notes.txt", "notes.txt", taken)
+    assert first == share / "notes_1.txt"
+    assert second == share / "other" / "notes.txt"
+    assert main._plan_destination("dup/notes.txt", "notes.txt", {share / "dup" / "notes.txt"}) == share / "dup" / "notes_1.txt"
+    assert ("send", "notes.txt", "duplicate", "renamed", "notes_1.txt") in _outcomes(decisions)
+    assert ("send", "dup/notes.txt", "duplicate", "renamed", "notes_1.txt") in _outcomes(decisions)
+    # Nothing was written
+    assert sorted(p.name for p in share.iterdir()) == ["notes.txt"]
+
+
+def test_plan_sanitizes_folders_and_names(decisions, share):
+    dest = main._plan_destination("trip:2024/pic?.jpg", "pic?.jpg", set())
+    assert dest == share / "trip_2024" / "pic_.jpg"
+    assert _outcomes(decisions) == [("send", "trip:2024/pic?.jpg", "sanitize", "renamed", "pic_.jpg")]
+
+
+def test_dry_run_table(decisions, share, tmp_path, monkeypatch):
+    root = tmp_path / "project"
+    for rel in ("README.md", "src/app.py", "src/CON.py", "node_modules/lib/index.js", ".DS_Store"):
+        (root / rel).parent.mkdir(parents=True, exist_ok=True)
+        (root / rel).write_text("x")
+    (share / "project").mkdir()
+    (share / "project" / "README.md").write_text("older")
+
+    path_filter = PathFilter()
+    skipped = lambda rel: pipeline.decide("send", f"project/{rel}", "filter", "excluded")
+    files = [(src, f"project/{rel}") for src, rel in path_filter.walk(root, skipped)]
+    taken = set()
+    planned = [(src, rel, main._plan_destination(rel, src.name, taken)) for src, rel in files]
+
+    out = io.StringIO()
+    monkeypatch.setattr(main, "console", Console(file=out, width=200))
+    main._print_send_plan(path_filter, planned, decisions)
+    rows = {line.split("│")[1].strip(): [cell.strip() for cell in line.split("│")[2:4]]
+            for line in out.getvalue().splitlines() if line.count("│") == 4}
+
+    assert rows["project/README.md"] == ["project/README_1.md", "duplicate: renamed"]
+    assert rows["project/src/CON.py"] == ["project/src/_CON.py", "sanitize: renamed"]
+    assert rows["project/src/app.py"] == ["project/src/app.py", ""]
+    assert rows["project/node_modules/"] == ["not shared", "filter: excluded"]
+    assert rows["project/.DS_Store"] == ["not shared", "filter: excluded"]
+    assert "nothing was written" in out.getvalue()
+    assert sorted(p.name for p in (share / "project").iterdir()) == ["README.md"]
+
+
+def test_upload_trace(guest, decisions):
+    response = guest.post(
+        "/api/upload",
+        files={"file": ("a?b.txt", b"data")},
+        headers={config.request_id_header: "req-42"},
+    )
+    assert response.status_code == 200
+    assert _outcomes(decisions) == [
+        ("upload", "a?b.txt", "sanitize", "renamed", "a_b.txt"),
+        ("upload", "a?b.txt", "stored", "saved", "a_b.txt"),
+    ]
+    assert {d.request_id for d in decisions} == {"req-42"}
+
+
+def test_upload_trace_reports_duplicates_and_rejections(guest, decisions, monkeypatch):
+    monkeypatch.setattr(config, "reject_empty", True)
+    guest.post("/api/upload", files={"file": ("notes.txt", b"one")})
+    guest.post("/api/upload", files={"file": ("notes.txt", b"two")})
+    guest.post("/api/upload", files={"file": ("empty.txt", b"")})
+    outcomes = _outcomes(decisions)
+    assert ("upload", "notes.txt", "duplicate", "renamed", "notes_1.txt") in outcomes
+    assert ("upload", "empty.txt", "policy", "rejected", "empty file") in outcomes
@@ -0,0 +1,103 @@
+"""The shared progress counter, and the uploads and CLI copies reporting through it."""
+
+import io
+
+from flashare.cli import main
+from flashare.config import config
+from flashare.core.progress import CountingReader, CountingWriter, ProgressCounter
+from flashare.core.stats import stats
+
+from conftest import upload
+
+
+class Reports:
+    """A progress callback recording each (delta, total)."""
+
+    def __init__(self):
+        self.calls = []
+
+    def __call__(self, delta: int, total: int):
+        self.calls.append((delta, total))
+
+
+def test_every_update_reported_without_interval():
+    reports = Reports()
+    counter = ProgressCounter(reports, interval=0)
+    for count in (10, 0, 5):
+        counter.update(count)
+    counter.close()
+    # A zero delta is never reported
+    assert reports.calls == [(10, 10), (5, 15)]
+
+
+def test_reports_are_throttled_and_close_settles_up():
+    reports = Reports()
+    counter = ProgressCounter(reports, interval=3600)
+    for _ in range(100):
+        counter.update(7)
+    assert reports.calls == []
+    counter.close()
+    assert reports.calls == [(700, 700)]
+    counter.close()
+    assert reports.calls == [(700, 700)]
+
+
+def test_reader_counts_reads():
+    reports = Reports()
+    with CountingReader(io.BytesIO(b"x" * 1000), reports, interval=0) as reader:
+        while reader.read(300):
+            pass
+    assert [delta for delta, _ in reports.calls] == [300, 300, 300, 100]
+    assert reports.calls[-1][1] == 1000
+
+
+def test_writer_counts_writes():
+    reports = Reports()
+    raw = io.BytesIO()
+    writer = CountingWriter(raw, reports, interval=3600)
+    writer.write(b"abc")
+    writer.write(memoryview(b"defg"))
+    assert raw.getvalue() == b"abcdefg"
+    writer.close()
+    assert reports.calls == [(7, 7)]
+    assert raw.closed
+
+
+def test_upload_bytes_are_counted(guest, monkeypatch):
+    monkeypatch.setattr(config, "chunk_size", 1024)
+    before = stats.metrics()["bytes_uploaded"]
+    upload(guest, "notes.txt", b"n" * 10_000)
+    assert stats.metrics()["bytes_uploaded"] == before + 10_000
+
+
+class FakeProgress:
+    """Stands in for the rich progress bar, recording what it is told."""
+
+    def __init__(self):
+        self.total = None
+        self.advanced = 0
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        return False
+
+    def add_task(self, description, total=None):
+        self.total = total
+        return 1
+
+    def update(self, task, advance=0):
+        self.advanced += advance
+
+
+def test_cli_copy_reports_every_byte(tmp_path, monkeypatch):
+    bar = FakeProgress()
+    monkeypatch.setattr(main, "create_progress", lambda *a, **k: bar)
+    src = tmp_path / "video.mp4"
+    src.write_bytes(b"v" * (3 * 1024 * 1024 + 17))
+    dest = tmp_path / "copy.mp4"
+
+    main._copy_with_quit_guard(src, dest)
+    assert dest.read_bytes() == src.read_bytes()
+    assert bar.total == bar.advanced == src.stat().st_size
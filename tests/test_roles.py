@@ -0,0 +1,184 @@
+"""Device roles, and the ways a guest might try to act as someone else."""
+
+import asyncio
+
+import pytest
+from fastapi.testclient import TestClient
+
+from flashare.api.routes import CodedHTTPException, _check_owner, _owner_problem
+from flashare.config import config
+from flashare.core.devices import DEVICE_COOKIE, devices, public_device_id
+from flashare.core.metadata import update_meta
+
+from conftest import upload
+
+
+@pytest.fixture(autouse=True)
+def roles(monkeypatch):
+    """Every test here runs with roles on, so other devices start as guests."""
+    monkeypatch.setattr(config, "device_roles", True)
+    monkeypatch.setattr(config, "strict_device_binding", False)
+    monkeypatch.setattr(config, "remember_roles", False)
+    monkeypatch.setattr(config, "owner_only_modify", False)
+
+
+def _cookie(client: TestClient) -> str:
+    """
+    The device cookie the server gave a client.
+
+    The first request only receives it; the second presents it, which
+    is when the device is known under it.
+    """
+    if DEVICE_COOKIE not in client.cookies:
+        client.get("/api/config")
+        client.get("/api/config")
+    return client.cookies[DEVICE_COOKIE]
+
+
+def _promote(host: TestClient, device: TestClient, role: str):
+    response = host.put(f"/api/devices/{public_device_id(_cookie(device))}/role", params={"role": role})
+    response.raise_for_status()
+
+
+def test_new_devices_are_guests(guest):
+    assert guest.get("/api/config").json()["role"] == "guest"
+
+
+def test_guest_cannot_delete(guest, host):
+    file_id = upload(host, "notes.txt", b"keep me")
+    assert guest.delete(f"/api/files/{file_id}").status_code == 403
+    assert host.get(f"/api/download/{file_id}").status_code == 200
+
+
+def test_promoted_device_can_delete(app, host):
+    phone = TestClient(app)
+    file_id = upload(host, "notes.txt", b"delete me")
+    _promote(host, phone, "trusted")
+    assert phone.get("/api/config").json()["role"] == "trusted"
+    assert phone.delete(f"/api/files/{file_id}").status_code == 200
+
+
+def test_guest_cannot_promote_itself(guest):
+    response = guest.put(f"/api/devices/{public_device_id(_cookie(guest))}/role", params={"role": "host"})
+    assert response.status_code == 401
+    assert guest.get("/api/config").json()["role"] == "guest"
+
+
+def test_guest_cannot_list_devices(guest):
+    assert guest.get("/api/devices").status_code == 401
+
+
+def test_device_list_never_shows_cookies(app, host):
+    phone = TestClient(app)
+    cookie = _cookie(phone)
+    listed = host.get("/api/devices").json()
+    assert public_device_id(cookie) in [d["id"] for d in listed]
+    assert cookie not in str(listed)
+
+
+def test_listed_id_does_not_act_as_the_device(app, host, guest):
+    phone = TestClient(app)
+    _promote(host, phone, "trusted")
+    file_id = upload(host, "notes.txt", b"keep me")
+    # Everything /api/devices shows about the trusted phone, used as a cookie
+    guest.cookies.set(DEVICE_COOKIE, public_device_id(_cookie(phone)))
+    assert guest.get("/api/config").json()["role"] == "guest"
+    assert guest.delete(f"/api/files/{file_id}").status_code == 403
+
+
+def test_unknown_device_id_is_refused(host):
+    assert host.put("/api/devices/0123456789abcdef/role", params={"role": "host"}).status_code == 404
+
+
+def test_replayed_cookie_from_another_address_is_a_guest(app, host, monkeypatch):
+    monkeypatch.setattr(config, "strict_device_binding", True)
+    phone = TestClient(app)
+    _promote(host, phone, "trusted")
+    cookie = _cookie(phone)
+    assert devices.role(cookie, "testclient") == "trusted"
+    assert devices.role(cookie, "192.168.1.66") == "guest"
+
+
+def test_replayed_cookie_keeps_its_role_without_strict_binding(app, host):
+    phone = TestClient(app)
+    _promote(host, phone, "trusted")
+    assert devices.role(_cookie(phone), "192.168.1.66") == "trusted"
+
+
+@pytest.mark.parametrize("path", [".meta/notes.txt.json", "sub/.meta/notes.txt.json", ".flashare/uploads.lock"])
+def test_state_folders_are_out_of_reach(app, host, path):
+    phone = TestClient(app)
+    _promote(host, phone, "trusted")
+    upload(host, "notes.txt", b"owned")
+    assert phone.get(f"/api/download/{path}").status_code in (403, 404)
+    assert phone.delete(f"/api/files/{path}").status_code in (403, 404)
+
+
+def test_trusted_device_cannot_change_others_files_when_owner_only(app, host, monkeypatch):
+    monkeypatch.setattr(config, "owner_only_modify", True)
+    owner, other = TestClient(app), TestClient(app)
+    _cookie(owner)
+    _promote(host, other, "trusted")
+    file_id = upload(owner, "mine.txt", b"owned")
+    response = other.delete(f"/api/files/{file_id}")
+    assert response.status_code == 403
+    assert response.json()["code"] == "not_owner"
+
+
+@pytest.fixture
+def owned(app, host, monkeypatch):
+    """With owner-only changes on: a file uploaded by a trusted phone, and a trusted laptop."""
+    monkeypatch.setattr(config, "owner_only_modify", True)
+    phone, laptop = TestClient(app), TestClient(app)
+    _promote(host, phone, "trusted")
+    _promote(host, laptop, "trusted")
+    return phone, laptop, upload(phone, "mine.txt", b"owned")
+
+
+def test_owner_problem(share, monkeypatch):
+    (share / "mine.txt").write_text("owned")
+    update_meta("mine.txt", owner="phone")
+    (share / "unowned.txt").write_text("from before")
+    monkeypatch.setattr(config, "owner_only_modify", True)
+    assert _owner_problem("mine.txt", "trusted", "phone") is None
+    assert "Only the device that uploaded" in _owner_problem("mine.txt", "trusted", "laptop")
+    assert _owner_problem("mine.txt", "host", "laptop") is None
+    assert _owner_problem("unowned.txt", "trusted", "laptop") is None
+    monkeypatch.setattr(config, "owner_only_modify", False)
+    assert _owner_problem("mine.txt", "trusted", "laptop") is None
+
+
+def test_check_owner_raises_not_owner(share, monkeypatch):
+    (share / "mine.txt").write_text("owned")
+    update_meta("mine.txt", owner="phone")
+    monkeypatch.setattr(config, "owner_only_modify", True)
+    asyncio.run(_check_owner("mine.txt", "trusted", "phone"))
+    with pytest.raises(CodedHTTPException) as raised:
+        asyncio.run(_check_owner("mine.txt", "trusted", "laptop"))
+    assert raised.value.status_code == 403
+    assert raised.value.code == "not_owner"
+
+
+def test_other_devices_cannot_change_an_owned_file(owned):
+    phone, laptop, file_id = owned
+    for response in (
+        laptop.delete(f"/api/files/{file_id}"),
+        laptop.post(f"/api/files/{file_id}/keep"),
+        laptop.post("/api/batch", json={"operations": [{"op": "rename", "path": file_id, "to": "theirs.txt"}]}),
+        laptop.post("/api/merge", json={"parts": [file_id], "output": "merged.txt", "delete_parts": True}),
+    ):
+        assert response.status_code in (403, 409), response.text
+    assert phone.get(f"/api/download/{file_id}").content == b"owned"
+    assert phone.delete(f"/api/files/{file_id}").status_code == 200
+
+
+def test_host_may_change_any_file(owned, host):
+    _, _, file_id = owned
+    assert host.delete(f"/api/files/{file_id}").status_code == 200
+
+
+def test_adopted_file_is_open_to_every_trusted_device(owned, host):
+    phone, laptop, file_id = owned
+    assert phone.post(f"/api/files/{file_id}/adopt").status_code in (401, 403)
+    assert host.post(f"/api/files/{file_id}/adopt").status_code == 200
+    assert laptop.delete(f"/api/files/{file_id}").status_code == 200
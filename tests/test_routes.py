@@ -0,0 +1,40 @@
+"""Paths typed by hand: trailing slashes and different case."""
+
+import pytest
+
+from flashare.config import config
+from flashare.server import normalize_route_path
+
+from conftest import upload
+
+
+@pytest.mark.parametrize("path", ["/api/files/", "/API/Files", "/Api/FILES/", "/api/TRANSFERS"])
+def test_variants_reach_the_route(guest, path):
+    assert guest.get(path).status_code == 200
+
+
+def test_parameters_keep_their_case(guest):
+    upload(guest, "Report.TXT", b"quarterly")
+    response = guest.get("/API/Download/Report.TXT")
+    assert response.status_code == 200
+    assert response.content == b"quarterly"
+    assert guest.get("/api/download/report.txt").status_code == 404
+
+
+@pytest.mark.parametrize("path, expected", [
+    ("/api/files", "/api/files"),
+    ("/api/files/", "/api/files"),
+    ("/API/FILES", "/api/files"),
+    ("/Api/Download/Report.TXT", "/api/download/Report.TXT"),
+    ("/api/files/Notes.md/Chunks", "/api/files/Notes.md/chunks"),
+    ("/", "/"),
+    ("/no/such/route", "/no/such/route"),
+])
+def test_normalize_route_path(app, path, expected):
+    assert normalize_route_path(path, app.router.routes) == expected
+
+
+def test_variants_are_not_found_when_strict(guest, monkeypatch):
+    monkeypatch.setattr(config, "lenient_routes", False)
+    assert guest.get("/API/Files").status_code == 404
+    assert guest.get("/api/files").status_code == 200
@@ -0,0 +1,172 @@
+"""
+Downloads across every combination of encoding, Range, conditional headers and method.
+
+Each case is checked against what select_representation's rules say it
+must be: the status, the encoding and range headers, and the exact body
+bytes once decoded. The file is small enough never to get a cached
+encoded copy, so a range is always cut from the identity bytes.
+"""
+
+import gzip
+import itertools
+import random
+
+import pytest
+import zstandard
+
+from flashare.api.semantics import parse_range, select_representation
+from flashare.core.compression import available_encodings
+
+from conftest import upload
+
+
+_WORDS = "flashare share file upload download phone laptop network stream chunk".split()
+
+# Text that compresses well, so the encoded body is always worth sending
+DATA = " ".join(random.Random(1234).choice(_WORDS) for _ in range(8000)).encode()
+
+ENCODINGS = ("identity", "gzip", "br", "zstd")
+RANGES = (None, "bytes=0-99", "bytes=-100", "bytes=100-", f"bytes={len(DATA)}-")
+CONDITIONS = (None, "if-none-match", "if-none-match-stale", "if-range", "if-range-stale")
+METHODS = ("GET", "HEAD")
+
+
+def _decode(body: bytes, encoding: str | None) -> bytes:
+    if encoding == "gzip":
+        return gzip.decompress(body)
+    if encoding == "br":
+        import brotli
+        return brotli.decompress(body)
+    if encoding == "zstd":
+        return zstandard.ZstdDecompressor().decompressobj().decompress(body)
+    return body
+
+
+def _expected(encoding: str, byte_range: str | None, condition: str | None) -> tuple[int, str | None, bytes]:
+    """Status, Content-Encoding and (decoded) body the rules call for."""
+    negotiated = encoding if encoding in available_encodings() else None
+    honoured = byte_range is not None and condition != "if-range-stale"
+    if honoured and byte_range == f"bytes={len(DATA)}-":
+        return 416, None, b""
+    if condition == "if-none-match":
+        return 304, None, b""
+    if honoured:
+        start, end = parse_range(byte_range, len(DATA))
+        return 206, None, DATA[start:end + 1]
+    return 200, negotiated, DATA
+
+
+@pytest.mark.parametrize(
+    "encoding, byte_range, condition, method",
+    list(itertools.product(ENCODINGS, RANGES, CONDITIONS, METHODS)),
+)
+def test_download_matrix(guest, encoding, byte_range, condition, method):
+    file_id = upload(guest, "corpus.txt", DATA)
+    url = f"/api/download/{file_id}"
+    headers = {"Accept-Encoding": encoding}
+    if byte_range:
+        headers["Range"] = byte_range
+
+    identity_etag = guest.head(url, headers={"Accept-Encoding": "identity"}).headers["ETag"]
+    if condition == "if-none-match":
+        # The validator of exactly the representation these headers select
+        probe = guest.head(url, headers=headers)
+        headers["If-None-Match"] = probe.headers.get("ETag", identity_etag)
+    elif condition == "if-none-match-stale":
+        headers["If-None-Match"] = '"stale"'
+    elif condition == "if-range":
+        headers["If-Range"] = identity_etag
+    elif condition == "if-range-stale":
+        headers["If-Range"] = '"stale"'
+
+    status, content_encoding, body = _expected(encoding, byte_range, condition)
+    with guest.stream(method, url, headers=headers) as response:
+        raw = b"".join(response.iter_raw())
+
+    assert response.status_code == status
+    assert response.headers.get("Content-Encoding") == content_encoding
+    if status == 416:
+        assert response.headers["Content-Range"] == f"bytes */{len(DATA)}"
+        return
+
+    assert response.headers["Vary"] == "Accept-Encoding"
+    assert response.headers["Accept-Ranges"] == "bytes"
+    assert response.headers["ETag"]
+    if status == 206:
+        start, end = parse_range(byte_range, len(DATA))
+        assert response.headers["Content-Range"] == f"bytes {start}-{end}/{len(DATA)}"
+        assert response.headers["Content-Length"] == str(end - start + 1)
+    else:
+        assert "Content-Range" not in response.headers
+    if content_encoding:
+        # An encoded body is another representation, with its own validator
+        assert response.headers["ETag"] != identity_etag
+    elif status != 304:
+        assert response.headers["ETag"] == identity_etag
+
+    if method == "HEAD" or status == 304:
+        assert raw == b""
+    else:
+        assert _decode(raw, content_encoding) == body
+
+
+@pytest.mark.parametrize("encoding, byte_range, condition", list(itertools.product(ENCODINGS, RANGES, CONDITIONS)))
+def test_head_matches_get(guest, encoding, byte_range, condition):
+    file_id = upload(guest, "corpus.txt", DATA)
+    url = f"/api/download/{file_id}"
+    headers = {"Accept-Encoding": encoding}
+    if byte_range:
+        headers["Range"] = byte_range
+    if condition == "if-range":
+        headers["If-Range"] = guest.head(url, headers={"Accept-Encoding": "identity"}).headers["ETag"]
+    elif condition == "if-range-stale":
+        headers["If-Range"] = '"stale"'
+
+    head = guest.head(url, headers=headers)
+    get = guest.get(url, headers=headers)
+    assert head.status_code == get.status_code
+    for name in ("ETag", "Content-Encoding", "Content-Range", "Vary", "Accept-Ranges", "Content-Disposition"):
+        assert head.headers.get(name) == get.headers.get(name)
+
+
+def test_head_does_not_count_as_download(guest, host):
+    file_id = upload(guest, "corpus.txt", DATA)
+    before = host.get("/api/metrics").json()["downloads"]
+    guest.head(f"/api/download/{file_id}")
+    assert host.get("/api/metrics").json()["downloads"] == before
+
+
+# ==================== select_representation ====================
+
+STRONG = '"abc"'
+
+
+@pytest.mark.parametrize("headers, status, encoding, etag", [
+    ({}, 200, None, STRONG),
+    ({"accept-encoding": "gzip"}, 200, "gzip", '"abc-gzip"'),
+    ({"accept-encoding": "gzip", "range": "bytes=0-9"}, 206, None, STRONG),
+    ({"accept-encoding": "gzip", "if-none-match": '"abc-gzip"'}, 304, None, '"abc-gzip"'),
+    ({"accept-encoding": "gzip", "if-none-match": STRONG}, 200, "gzip", '"abc-gzip"'),
+    ({"if-none-match": "*"}, 304, None, STRONG),
+    ({"if-none-match": f'W/{STRONG}'}, 304, None, STRONG),
+    ({"range": "bytes=0-9", "if-range": STRONG}, 206, None, STRONG),
+    ({"range": "bytes=0-9", "if-range": '"old"'}, 200, None, STRONG),
+])
+def test_select_representation(headers, status, encoding, etag):
+    representation = select_representation(headers, 100, STRONG)
+    assert representation.status == status
+    assert representation.encoding == encoding
+    assert representation.headers["ETag"] == etag
+    assert representation.headers["Vary"] == "Accept-Encoding"
+
+
+def test_weak_etag_never_satisfies_if_range():
+    representation = select_representation({"range": "bytes=0-9", "if-range": 'W/"abc"'}, 100, 'W/"abc"')
+    assert representation.status == 200
+    assert representation.length == 100
+
+
+def test_uncompressible_sends_no_vary():
+    representation = select_representation({"accept-encoding": "gzip"}, 100, STRONG, compressible=False)
+    assert representation.encoding is None
+    assert "Vary" not in representation.headers
@@ -0,0 +1,85 @@
+"""File listings in natural, stable orders."""
+
+import random
+
+import pytest
+
+from flashare.api.routes import sort_files
+from flashare.core.sorting import collation_key, natural_key
+
+from conftest import upload
+
+
+def _names(files):
+    return [f["name"] for f in files]
+
+
+def _file(name: str, modified: float = 0, file_type: str = "file", file_id: str = "") -> dict:
+    return {"name": name, "id": file_id or name, "modified": modified, "type": file_type}
+
+
+@pytest.mark.parametrize("names", [
+    ["file2", "file10", "file100"],
+    ["IMG_9.jpg", "img_10.jpg", "IMG_11.jpg"],
+    ["a", "B", "c"],
+    ["élan", "Eve", "zebra"],
+    ["Straße 2", "strasse 10"],
+    ["v1.9", "v1.10", "v2"],
+    ["2 notes", "10 notes", "notes"],
+])
+def test_natural_order(names):
+    shuffled = names[:]
+    random.Random(7).shuffle(shuffled)
+    assert sorted(shuffled, key=natural_key) == names
+
+
+def test_equal_collation_falls_back_to_the_raw_name():
+    # Total order: names that collate alike still sort the same way every time
+    assert sorted(["file1", "file01", "File1"], key=natural_key) == ["File1", "file01", "file1"]
+    assert natural_key("a.txt") != natural_key("A.txt")
+
+
+def test_collation_key_folds_case_and_accents():
+    assert collation_key("Ölçek") == collation_key("olcek")
+    assert collation_key("STRASSE") == collation_key("straße")
+
+
+def test_sort_by_name():
+    files = [_file("file10"), _file("File2"), _file("file1")]
+    assert _names(sort_files(files, "name")) == ["file1", "File2", "file10"]
+
+
+def test_sort_by_modified_keeps_name_order_within_ties():
+    files = [_file("b", 5), _file("file10", 9), _file("a", 5), _file("file2", 9), _file("c", 1)]
+    assert _names(sort_files(files, "modified")) == ["file2", "file10", "a", "b", "c"]
+
+
+def test_sort_by_type_groups_in_type_order():
+    files = [
+        _file("z.pdf", file_type="document"),
+        _file("song2.mp3", file_type="audio"),
+        _file("misc.bin"),
+        _file("b.png", file_type="image"),
+        _file("song10.mp3", file_type="audio"),
+        _file("a.jpg", file_type="image"),
+    ]
+    assert _names(sort_files(files, "type")) == ["a.jpg", "b.png", "song2.mp3", "song10.mp3", "z.pdf", "misc.bin"]
+
+
+@pytest.mark.parametrize("sort", ["modified", "name", "type"])
+def test_order_does_not_depend_on_listing_order(sort):
+    # Same names in different folders: the ID breaks the last tie
+    files = [_file("notes.txt", 3, "document", f"{folder}/notes.txt") for folder in ("a", "b", "c")]
+    files += [_file(f"x{i}", i % 2) for i in range(20)]
+    expected = sort_files(files, sort)
+    for seed in range(5):
+        shuffled = files[:]
+        random.Random(seed).shuffle(shuffled)
+        assert sort_files(shuffled, sort) == expected
+
+
+def test_listing_sorts_naturally(guest):
+    for name in ("file10.txt", "file2.txt", "File1.txt"):
+        upload(guest, name, b"x")
+    assert _names(guest.get("/api/files", params={"sort": "name"}).json()) == ["File1.txt", "file2.txt", "file10.txt"]
+    assert guest.get("/api/files", params={"sort": "size"}).status_code == 400
@@ -0,0 +1,75 @@
+"""Local storage on case-sensitive and case-insensitive filesystems."""
+
+import unicodedata
+
+import pytest
+
+from flashare.core.storage import LocalStorage, get_storage
+
+from conftest import upload
+
+
+def _storage(root, case_sensitive: bool) -> LocalStorage:
+    storage = LocalStorage(root)
+    # Decided by probing the filesystem otherwise; forced so both kinds run anywhere
+    storage._case_sensitive = case_sensitive
+    return storage
+
+
+def _write(root, name: str, data: bytes = b"x"):
+    path = root / name
+    path.parent.mkdir(parents=True, exist_ok=True)
+    path.write_bytes(data)
+
+
+@pytest.mark.parametrize("stored, asked", [
+    ("Photo.JPG", "photo.jpg"),
+    ("photo.jpg", "PHOTO.JPG"),
+    ("Foo/a.txt", "foo/a.txt"),
+    ("Foo/Bar/a.txt", "foo/BAR/A.TXT"),
+    (unicodedata.normalize("NFD", "café.txt"), unicodedata.normalize("NFC", "Café.txt")),
+    (unicodedata.normalize("NFD", "Ünïcode/a.txt"), unicodedata.normalize("NFC", "ünïcode/A.txt")),
+])
+def test_case_insensitive_names_collide(tmp_path, stored, asked):
+    _write(tmp_path, stored)
+    assert _storage(tmp_path, case_sensitive=False).exists(asked)
+
+
+@pytest.mark.parametrize("stored, asked", [
+    ("Photo.JPG", "photo.png"),
+    ("Foo/a.txt", "bar/a.txt"),
+    ("Foo/a.txt", "foo/b.txt"),
+    ("Foo/a.txt", "foo/a.txt/b"),
+    ("Foo", "foo/a.txt"),
+])
+def test_case_insensitive_misses(tmp_path, stored, asked):
+    _write(tmp_path, stored)
+    assert not _storage(tmp_path, case_sensitive=False).exists(asked)
+
+
+def test_case_sensitive_names_differ(tmp_path):
+    _write(tmp_path, "Foo/Photo.JPG")
+    storage = _storage(tmp_path, case_sensitive=True)
+    assert storage.exists("Foo/Photo.JPG")
+    assert not storage.exists("foo/photo.jpg")
+
+
+@pytest.mark.parametrize("case_sensitive", [True, False])
+def test_hidden_and_escaping_names_are_refused(tmp_path, case_sensitive):
+    storage = _storage(tmp_path, case_sensitive)
+    for name in (".meta/a.txt.json", "../outside.txt", "sub/../../outside.txt"):
+        with pytest.raises(PermissionError):
+            storage.exists(name)
+
+
+def test_case_probe_cleans_up(tmp_path):
+    storage = LocalStorage(tmp_path / "root")
+    assert storage.case_sensitive in (True, False)
+    assert list((tmp_path / "root").iterdir()) == []
+
+
+def test_upload_dedupes_names_differing_in_case(guest, share):
+    get_storage()._case_sensitive = False
+    assert upload(guest, "Photo.JPG", b"first") == "Photo.JPG"
+    assert upload(guest, "photo.jpg", b"second") == "photo_1.jpg"
+    assert (share / "Photo.JPG").read_bytes() == b"first"
@@ -0,0 +1,181 @@
+"""Planning and applying 'flashare sync' against a stand-in server."""
+
+import hashlib
+import os
+
+import pytest
+
+from flashare.cli import sync as sync_module
+from flashare.cli.sync import SyncClient, SyncError, apply, local_files, plan, sync
+
+
+def _sha(data: bytes) -> str:
+    return hashlib.sha256(data).hexdigest()
+
+
+class FakeServer:
+    """The calls SyncClient makes, answered from a dict of name -> (content, modified, hash on record)."""
+
+    def __init__(self):
+        self.stored: dict[str, tuple[bytes, float, bool]] = {}
+        self.calls: list[tuple[str, str]] = []
+        self.failing: set[str] = set()
+
+    def add(self, name: str, data: bytes, modified: float = 0, hashed: bool = True):
+        self.stored[name] = (data, modified, hashed)
+
+    def files(self) -> dict[str, dict]:
+        return {
+            name: {"name": name, "id": name, "size": len(data), "modified": modified}
+            for name, (data, modified, _) in self.stored.items()
+        }
+
+    def check(self, items: list[dict]) -> list[dict]:
+        results = []
+        for item in items:
+            result = {"name": item["name"], "status": "proceed", "match": None, "duplicate_of": None}
+            by_hash = [n for n, (data, _, hashed) in self.stored.items() if hashed and _sha(data) == item["sha256"]]
+            stored = self.stored.get(item["name"])
+            if item["name"] in by_hash:
+                result.update(status="exists", match="sha256", duplicate_of=item["name"])
+            elif by_hash:
+                result.update(status="duplicate", match="sha256", duplicate_of=by_hash[0])
+            elif stored and not stored[2] and len(stored[0]) == item["size"]:
+                result.update(status="exists", match="name_size", duplicate_of=item["name"])
+            results.append(result)
+        return results
+
+    def checksum(self, remote_id: str) -> str:
+        self.calls.append(("checksum", remote_id))
+        return _sha(self.stored[remote_id][0])
+
+    def upload(self, path, name: str) -> dict:
+        self.calls.append(("upload", name))
+        if name in self.failing:
+            raise SyncError(f"PUT /api/files/{name}: disk full (507)")
+        stored = name if name not in self.stored else f"{name}.1"
+        self.add(stored, path.read_bytes())
+        return {"id": stored}
+
+    def delete(self, remote_id: str):
+        self.calls.append(("delete", remote_id))
+        del self.stored[remote_id]
+
+
+@pytest.fixture
+def server():
+    return FakeServer()
+
+
+@pytest.fixture
+def folder(tmp_path):
+    folder = tmp_path / "outbox"
+    folder.mkdir()
+    return folder
+
+
+def _write(folder, name: str, data: bytes, mtime: float = 1_000_000):
+    (folder / name).write_bytes(data)
+    os.utime(folder / name, (mtime, mtime))
+
+
+def _kinds(result) -> dict[str, str]:
+    return {action.name: action.kind for action in result.actions}
+
+
+def test_plan_uploads_new_and_skips_unchanged(server, folder):
+    _write(folder, "new.txt", b"new")
+    _write(folder, "same.txt", b"same")
+    server.add("same.txt", b"same")
+    assert _kinds(plan(server, folder)) == {"new.txt": "upload", "same.txt": "unchanged"}
+
+
+def test_name_and_size_matches_are_confirmed_by_checksum(server, folder):
+    _write(folder, "same.txt", b"same")
+    _write(folder, "edited.txt", b"bbbb")
+    server.add("same.txt", b"same", hashed=False)
+    server.add("edited.txt", b"aaaa", hashed=False)
+    assert _kinds(plan(server, folder)) == {"same.txt": "unchanged", "edited.txt": "replace"}
+    assert sorted(server.calls) == [("checksum", "edited.txt"), ("checksum", "same.txt")]
+
+
+def test_same_content_under_another_name_is_noted(server, folder):
+    _write(folder, "copy.txt", b"content")
+    server.add("original.txt", b"content")
+    [action] = plan(server, folder).actions
+    assert (action.kind, action.note) == ("upload", "same content as original.txt")
+
+
+@pytest.mark.parametrize("conflict, kind", [("skip", "conflict"), ("rename", "keep-both"), ("overwrite", "replace")])
+def test_changed_on_both_sides(server, folder, conflict, kind):
+    _write(folder, "notes.txt", b"local edit", mtime=1_000_000)
+    server.add("notes.txt", b"server edit", modified=2_000_000)
+    [action] = plan(server, folder, conflict=conflict).actions
+    assert action.kind == kind
+
+
+def test_local_change_newer_than_the_server_replaces(server, folder):
+    _write(folder, "notes.txt", b"local edit", mtime=2_000_000)
+    server.add("notes.txt", b"server copy", modified="1970-01-12T13:46:40Z")
+    assert _kinds(plan(server, folder)) == {"notes.txt": "replace"}
+
+
+def test_delete_only_when_asked(server, folder):
+    server.add("gone.txt", b"gone")
+    assert plan(server, folder).actions == []
+    [action] = plan(server, folder, delete=True).actions
+    assert (action.kind, action.name, action.size) == ("delete", "gone.txt", 4)
+
+
+def test_unmirrorable_entries_are_skipped(folder):
+    (folder / "sub").mkdir()
+    _write(folder, ".hidden", b"x")
+    _write(folder, "CON.txt", b"x")
+    _write(folder, "ok.txt", b"x")
+    files, skipped = local_files(folder)
+    assert list(files) == ["ok.txt"]
+    assert skipped == ["CON.txt: the server would store it as _CON.txt", "sub/: folders aren't mirrored"]
+
+
+def test_apply_carries_out_the_plan(server, folder):
+    _write(folder, "new.txt", b"new")
+    _write(folder, "edited.txt", b"edited", mtime=2_000_000)
+    server.add("edited.txt", b"original", modified=1_000_000)
+    server.add("gone.txt", b"gone")
+    result = apply(server, folder, plan(server, folder, delete=True))
+    assert not result.failed
+    assert ("delete", "edited.txt") in server.calls
+    assert server.calls.index(("delete", "edited.txt")) < server.calls.index(("upload", "edited.txt"))
+    assert {name: data for name, (data, _, _) in server.stored.items()} == {"new.txt": b"new", "edited.txt": b"edited"}
+    assert result.sent == len(b"new") + len(b"edited")
+
+
+def test_a_failed_step_does_not_stop_the_rest(server, folder):
+    _write(folder, "a.txt", b"a")
+    _write(folder, "b.txt", b"b")
+    server.failing.add("a.txt")
+    reported = []
+    result = apply(server, folder, plan(server, folder), reported.append)
+    assert [action.name for action in result.failed] == ["a.txt"]
+    assert "disk full" in result.failed[0].error
+    assert "b.txt" in server.stored
+    assert [action.name for action in reported] == ["a.txt", "b.txt"]
+    assert result.count("upload") == 1
+
+
+def test_dry_run_changes_nothing(server, folder, monkeypatch):
+    monkeypatch.setattr(sync_module, "SyncClient", lambda url: server)
+    _write(folder, "new.txt", b"new")
+    server.add("gone.txt", b"gone")
+    reported = []
+    result = sync("http://10.0.0.2:8000", folder, delete=True, dry_run=True, on_action=reported.append)
+    assert _kinds(result) == {"new.txt": "upload", "gone.txt": "delete"}
+    assert len(reported) == 2
+    assert set(server.stored) == {"gone.txt"}
+    assert not [call for call in server.calls if call[0] != "checksum"]
+
+
+def test_client_keeps_the_share_key():
+    client = SyncClient("http://10.0.0.2:8000/?key=abc123")
+    assert client.base_url == "http://10.0.0.2:8000"
+    assert client._url("/api/files", sort="name") == "http://10.0.0.2:8000/api/files?sort=name&key=abc123"
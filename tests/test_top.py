@@ -0,0 +1,52 @@
+"""Paging long lists in the 'top' and 'devices' views."""
+
+import time
+
+from flashare.cli.top import PAGE_REFRESHES, _page, _render, _render_devices
+
+
+def test_short_lists_are_not_paged():
+    assert _page([1, 2, 3], 3, 0) == ([1, 2, 3], "")
+    assert _page([], 3, 99) == ([], "")
+
+
+def test_pages_advance_and_wrap():
+    rows = list(range(1, 26))
+    pages = [_page(rows, 10, tick) for tick in range(0, 4 * PAGE_REFRESHES, PAGE_REFRESHES)]
+    assert pages == [
+        (list(range(1, 11)), "1-10/25"),
+        (list(range(11, 21)), "11-20/25"),
+        ([21, 22, 23, 24, 25], "21-25/25"),
+        (list(range(1, 11)), "1-10/25"),
+    ]
+
+
+def test_a_page_stays_up_for_several_refreshes():
+    rows = list(range(100))
+    assert {_page(rows, 10, tick)[1] for tick in range(PAGE_REFRESHES)} == {"1-10/100"}
+
+
+def _device(n: int) -> dict:
+    return {
+        "id": f"{n:016x}", "cookie": True, "ip": f"10.0.0.{n}", "last_seen": time.time(),
+        "uploaded": 0, "downloaded": 0, "remaining": None,
+    }
+
+
+def test_devices_view_shows_one_page_and_its_position():
+    devices = [_device(n) for n in range(30)]
+    panel = _render_devices(devices, [], {}, page_size=12, tick=PAGE_REFRESHES)
+    assert panel.renderable.row_count == 12
+    assert "13-24/30" in panel.title
+    assert "/" not in _render_devices(devices[:5], [], {}, page_size=12).title
+
+
+def test_top_pages_transfers_and_clients():
+    transfers = [{"filename": f"f{n}", "direction": "down", "client": "10.0.0.1", "bytes": 1} for n in range(7)]
+    clients = [{"ip": f"10.0.0.{n}", "last_seen": time.time()} for n in range(15)]
+    view = _render({}, transfers, {"client_activity": clients}, (0.0, 0.0), page_size=5)
+    _, active, connected = view.renderables
+    assert active.renderable.row_count == 5
+    assert "1-5/7" in active.title
+    assert connected.renderable.row_count == 5
+    assert "1-5/15" in connected.title
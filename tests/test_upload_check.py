@@ -0,0 +1,75 @@
+"""POST /api/upload/check: which files a client can skip sending."""
+
+import hashlib
+
+import pytest
+
+from flashare.config import config
+
+from conftest import upload
+
+
+PHOTO = b"photo bytes"
+PHOTO_HASH = hashlib.sha256(PHOTO).hexdigest()
+
+
+def _check(client, *files: dict) -> list[dict]:
+    response = client.post("/api/upload/check", json=list(files))
+    assert response.status_code == 200, response.text
+    return response.json()["files"]
+
+
+def test_unknown_hash_proceeds(guest):
+    [result] = _check(guest, {"name": "new.jpg", "size": 3, "sha256": hashlib.sha256(b"new").hexdigest()})
+    assert result == {"name": "new.jpg", "status": "proceed", "match": None, "duplicate_of": None, "target": "new.jpg"}
+
+
+def test_known_hash_under_the_same_name_exists(guest):
+    upload(guest, "photo.jpg", PHOTO)
+    [result] = _check(guest, {"name": "photo.jpg", "sha256": f"sha256:{PHOTO_HASH.upper()}"})
+    assert (result["status"], result["match"], result["duplicate_of"]) == ("exists", "sha256", "photo.jpg")
+    assert result["target"] == "photo_1.jpg"
+
+
+def test_known_hash_under_another_name_is_a_duplicate(guest):
+    upload(guest, "photo.jpg", PHOTO)
+    [result] = _check(guest, {"name": "IMG_0001.jpg", "sha256": PHOTO_HASH})
+    assert (result["status"], result["match"], result["duplicate_of"]) == ("duplicate", "sha256", "photo.jpg")
+
+
+def test_name_and_size_match_without_a_hash(guest):
+    upload(guest, "photo.jpg", PHOTO)
+    same, resized = _check(guest, {"name": "photo.jpg", "size": len(PHOTO)}, {"name": "photo.jpg", "size": 1})
+    assert (same["status"], same["match"]) == ("exists", "name_size")
+    assert resized["status"] == "proceed"
+
+
+def test_recorded_hash_overrules_name_and_size(guest):
+    upload(guest, "photo.jpg", PHOTO)
+    other = b"other bytes"
+    assert len(other) == len(PHOTO)
+    [result] = _check(guest, {"name": "photo.jpg", "size": len(other), "sha256": hashlib.sha256(other).hexdigest()})
+    assert result["status"] == "proceed"
+
+
+def test_answers_keep_request_order(guest):
+    upload(guest, "photo.jpg", PHOTO)
+    results = _check(guest, {"name": "a.txt"}, {"name": "photo.jpg", "sha256": PHOTO_HASH}, {"name": "b.txt"})
+    assert [r["name"] for r in results] == ["a.txt", "photo.jpg", "b.txt"]
+    assert [r["status"] for r in results] == ["proceed", "exists", "proceed"]
+
+
+def test_no_target_when_uploads_are_sorted(guest, monkeypatch):
+    monkeypatch.setattr(config, "date_folders", "%Y/%m")
+    assert _check(guest, {"name": "a.txt"})[0]["target"] is None
+
+
+@pytest.mark.parametrize("digest", ["abc", "z" * 64, "sha1:" + "0" * 40])
+def test_malformed_hash_is_refused(guest, digest):
+    response = guest.post("/api/upload/check", json=[{"name": "a.txt", "sha256": digest}])
+    assert response.status_code == 400
+
+
+def test_too_many_files_is_refused(guest):
+    response = guest.post("/api/upload/check", json=[{"name": f"{n}.txt"} for n in range(1001)])
+    assert response.status_code == 413
@@ -0,0 +1,81 @@
+"""Zero-byte uploads, and uploads whose zero runs are stored as holes."""
+
+import pytest
+
+from flashare.api.routes import _is_zero_chunk
+from flashare.config import config
+
+from conftest import upload
+
+
+CHUNK = 4096
+
+
+@pytest.fixture
+def small_chunks(monkeypatch):
+    monkeypatch.setattr(config, "chunk_size", CHUNK)
+
+
+def _holes_supported(tmp_path) -> bool:
+    probe = tmp_path / "probe"
+    with open(probe, "wb") as f:
+        f.seek(1024 * 1024)
+        f.write(b"x")
+    return probe.stat().st_blocks * 512 < 1024 * 1024
+
+
+@pytest.mark.parametrize("chunk, zero", [
+    (b"", True),
+    (bytes(CHUNK), True),
+    (bytes(CHUNK - 1) + b"\x01", False),
+    (b"\x01" + bytes(CHUNK - 1), False),
+])
+def test_is_zero_chunk(chunk, zero):
+    assert _is_zero_chunk(chunk) == zero
+
+
+def test_zero_byte_upload_is_stored(guest, share):
+    response = guest.post("/api/upload", files={"file": ("empty.txt", b"")})
+    body = response.json()
+    assert body["success"]
+    assert body["empty"] is True
+    assert body["size"] == 0
+    assert (share / "empty.txt").stat().st_size == 0
+    assert guest.get("/api/download/empty.txt").content == b""
+
+
+def test_zero_byte_upload_can_be_refused(guest, share, monkeypatch):
+    monkeypatch.setattr(config, "reject_empty", True)
+    body = guest.post("/api/upload", files={"file": ("empty.txt", b"")}).json()
+    assert not body["success"]
+    assert body["error"] == "Empty file rejected"
+    assert not (share / "empty.txt").exists()
+
+
+@pytest.mark.parametrize("data", [
+    b"head" + bytes(CHUNK * 16) + b"tail",
+    # Ends in a hole: the file must still reach its full size
+    b"x" * CHUNK + bytes(CHUNK * 16),
+    bytes(CHUNK * 16),
+])
+def test_sparse_upload_round_trips(guest, share, small_chunks, data):
+    body = guest.post("/api/upload", files={"file": ("disk.img", data)}).json()
+    assert body["success"]
+    assert body["size"] == len(data)
+    assert body["empty"] is False
+    assert (share / "disk.img").read_bytes() == data
+    assert guest.get("/api/download/disk.img", headers={"Accept-Encoding": "identity"}).content == data
+
+
+def test_zero_runs_become_holes(guest, share, small_chunks, tmp_path):
+    if not _holes_supported(tmp_path):
+        pytest.skip("filesystem has no sparse files")
+    data = b"head" + bytes(CHUNK * 256) + b"tail"
+    upload(guest, "disk.img", data)
+    stored = share / "disk.img"
+    assert stored.stat().st_size == len(data)
+    assert stored.stat().st_blocks * 512 < len(data) // 2
+    # The holes read back as zeros
+    with open(stored, "rb") as f:
+        f.seek(CHUNK * 8)
+        assert f.read(CHUNK) == bytes(CHUNK)
@@ -0,0 +1,122 @@
+"""Noticing outside changes to the uploads directory, by polling when notifications don't arrive."""
+
+import asyncio
+import os
+import sys
+
+import pytest
+
+from flashare.config import config
+from flashare.core import watcher as watcher_module
+from flashare.core.watcher import DirectoryWatcher, diff, notifications_work, snapshot
+
+
+class Published:
+    """Stands in for hub.publish, recording every event."""
+
+    def __init__(self):
+        self.events = []
+
+    def __call__(self, kind, data=None):
+        self.events.append((kind, data))
+
+
+@pytest.fixture
+def published():
+    return Published()
+
+
+@pytest.fixture
+def watcher(published, share):
+    watcher = DirectoryWatcher(published)
+    watcher.scan(share)
+    return watcher
+
+
+def test_snapshot_skips_hidden_files_and_links(share):
+    (share / "photos").mkdir()
+    (share / "photos" / "a.jpg").write_bytes(b"jpeg")
+    (share / ".meta").mkdir()
+    (share / ".meta" / "a.jpg.json").write_text("{}")
+    (share / ".flashare-probe-1234").write_bytes(b"")
+    os.symlink(share / "photos" / "a.jpg", share / "link.jpg")
+    assert set(snapshot(share)) == {"photos/a.jpg"}
+    assert snapshot(share)["photos/a.jpg"][0] == 4
+
+
+def test_diff():
+    old = {"kept": (1, 1), "edited": (1, 1), "gone": (1, 1), "touched": (1, 1)}
+    new = {"kept": (1, 1), "edited": (2, 1), "new": (1, 1), "touched": (1, 2)}
+    assert diff(old, new) == [
+        ("changed", "edited"),
+        ("removed", "gone"),
+        ("added", "new"),
+        ("changed", "touched"),
+    ]
+
+
+def test_scan_publishes_each_change_once(watcher, published, share):
+    (share / "notes.txt").write_text("one")
+    (share / "sub").mkdir()
+    (share / "sub" / "b.txt").write_text("b")
+    assert watcher.scan(share) == [("added", "notes.txt"), ("added", "sub/b.txt")]
+
+    (share / "notes.txt").write_text("one, two")
+    (share / "sub" / "b.txt").unlink()
+    watcher.scan(share)
+    assert watcher.scan(share) == []
+    assert published.events == [
+        ("files", {"added": "notes.txt"}),
+        ("files", {"added": "sub/b.txt"}),
+        ("files", {"changed": "notes.txt"}),
+        ("files", {"removed": "sub/b.txt"}),
+    ]
+
+
+def test_same_size_rewrite_is_noticed(watcher, share):
+    (share / "a.txt").write_text("aaaa")
+    watcher.scan(share)
+    os.utime(share / "a.txt", ns=(0, 10**18))
+    assert watcher.scan(share) == [("changed", "a.txt")]
+
+
+def test_missing_root_reports_nothing(watcher, published, share, tmp_path):
+    (share / "a.txt").write_text("a")
+    watcher.scan(share)
+    share.rename(tmp_path / "unmounted")
+    assert watcher.scan(share) == []
+    # Everything is still there once it comes back
+    (tmp_path / "unmounted").rename(share)
+    assert watcher.scan(share) == []
+    assert published.events == [("files", {"added": "a.txt"})]
+
+
+def test_no_notifications_without_watchfiles(monkeypatch, share):
+    monkeypatch.setitem(sys.modules, "watchfiles", None)
+    assert asyncio.run(notifications_work(share)) is False
+
+
+def test_polling_fallback_finds_changes(published, share, monkeypatch):
+    async def no_notifications(root):
+        return False
+
+    monkeypatch.setattr(watcher_module, "notifications_work", no_notifications)
+    monkeypatch.setattr(config, "watch_interval", 0.01)
+    (share / "before.txt").write_text("x")
+    watcher = DirectoryWatcher(published)
+
+    async def drop_a_file():
+        task = asyncio.create_task(watcher.run(share))
+        while watcher.mode == "off":
+            await asyncio.sleep(0.01)
+        (share / "dropped.txt").write_text("from a file manager")
+        for _ in range(500):
+            if published.events:
+                break
+            await asyncio.sleep(0.01)
+        task.cancel()
+
+    asyncio.run(drop_a_file())
+    assert watcher.mode == "poll"
+    # Files there before the watch started aren't news
+    assert published.events == [("files", {"added": "dropped.txt"})]
@@ -0,0 +1,118 @@
+"""Folder downloads as zip, tar.gz and tar.zst archives."""
+
+import gzip
+import io
+import os
+import tarfile
+import time
+import zipfile
+
+import pytest
+import zstandard as zstd
+
+from flashare.core.zipstream import MAX_DEPTH, TooDeep, stream_archive, walk_folder
+
+from conftest import upload
+
+
+def _unpack(data: bytes, archive_format: str) -> dict[str, bytes]:
+    """Read an archive back with the standard tools for its format."""
+    if archive_format == "zip":
+        with zipfile.ZipFile(io.BytesIO(data)) as archive:
+            return {name: archive.read(name) for name in archive.namelist()}
+    if archive_format == "tar.zst":
+        data = zstd.ZstdDecompressor().stream_reader(io.BytesIO(data)).read()
+        archive_format = "tar"
+    with tarfile.open(fileobj=io.BytesIO(data), mode="r:gz" if archive_format == "tar.gz" else "r:") as archive:
+        return {member.name: archive.extractfile(member).read() for member in archive if member.isfile()}
+
+
+@pytest.fixture
+def album(share):
+    folder = share / "album"
+    (folder / "2024").mkdir(parents=True)
+    (folder / "cover.jpg").write_bytes(os.urandom(3 * 1024 * 1024 + 5))  # Spans several reads
+    (folder / "2024" / "notes.txt").write_text("beach")
+    (folder / "empty.txt").write_bytes(b"")
+    (folder / ".thumbs").mkdir()
+    (folder / ".thumbs" / "cover.jpg").write_bytes(b"hidden")
+    os.symlink("/etc/passwd", folder / "passwd")
+    return folder
+
+
+@pytest.mark.parametrize("archive_format", ["zip", "tar.gz", "tar.zst"])
+def test_round_trip(album, archive_format):
+    entries = walk_folder(album, "album")
+    contents = _unpack(b"".join(stream_archive(entries, archive_format)), archive_format)
+    assert contents == {
+        "album/2024/notes.txt": b"beach",
+        "album/cover.jpg": (album / "cover.jpg").read_bytes(),
+        "album/empty.txt": b"",
+    }
+
+
+@pytest.mark.parametrize("archive_format", ["zip", "tar.gz", "tar.zst"])
+def test_vanished_files_are_left_out(album, archive_format):
+    entries = walk_folder(album, "album")
+    (album / "empty.txt").unlink()
+    contents = _unpack(b"".join(stream_archive(entries, archive_format)), archive_format)
+    assert set(contents) == {"album/2024/notes.txt", "album/cover.jpg"}
+
+
+def test_tar_keeps_times_and_modes(album):
+    os.utime(album / "2024" / "notes.txt", (1_600_000_000, 1_600_000_000))
+    os.chmod(album / "2024" / "notes.txt", 0o640)
+    data = b"".join(stream_archive(walk_folder(album, "album"), "tar.gz"))
+    with tarfile.open(fileobj=io.BytesIO(data), mode="r:gz") as archive:
+        member = archive.getmember("album/2024/notes.txt")
+    assert member.mtime == 1_600_000_000
+    assert member.mode == 0o640
+
+
+def test_tar_is_a_whole_number_of_records(album):
+    raw = gzip.decompress(b"".join(stream_archive(walk_folder(album, "album"), "tar.gz")))
+    assert len(raw) % tarfile.RECORDSIZE == 0
+    assert raw.endswith(bytes(2 * tarfile.BLOCKSIZE))
+
+
+def test_walk_refuses_deep_nesting(share):
+    folder = share / "deep"
+    nested = folder.joinpath(*["d"] * (MAX_DEPTH + 2))
+    nested.mkdir(parents=True)
+    (nested / "bottom.txt").write_text("x")
+    with pytest.raises(TooDeep):
+        walk_folder(folder, "deep")
+
+
+@pytest.mark.parametrize("query, headers, media_type, extension", [
+    ({"format": "tar.gz"}, {}, "application/gzip", ".tar.gz"),
+    ({}, {"Accept": "application/zstd"}, "application/zstd", ".tar.zst"),
+    ({}, {"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)"}, "application/zip", ".zip"),
+    ({}, {"User-Agent": "curl/8.5.0"}, "application/zstd", ".tar.zst"),
+])
+def test_download_format(guest, album, query, headers, media_type, extension):
+    response = guest.get("/api/download-zip", params={"files": "album", **query}, headers=headers)
+    assert response.status_code == 200
+    assert response.headers["content-type"].startswith(media_type)
+    assert f'album{extension}"' in response.headers["content-disposition"]
+    contents = _unpack(response.content, extension.lstrip("."))
+    assert contents["album/2024/notes.txt"] == b"beach"
+
+
+def test_unknown_format_is_refused(guest, album):
+    response = guest.get("/api/download-zip", params={"files": "album", "format": "rar"})
+    assert response.status_code == 400
+
+
+def test_archive_job_in_another_format(guest, share):
+    file_id = upload(guest, "notes.txt", b"prepared")
+    job = guest.post("/api/archive-jobs", json={"files": [file_id], "format": "tar.zst"}).json()
+    assert job["format"] == "tar.zst"
+    for _ in range(200):
+        if guest.get(f"/api/archive-jobs/{job['id']}").json()["state"] != "building":
+            break
+        time.sleep(0.05)
+    response = guest.get(f"/api/archive-jobs/{job['id']}/download")
+    assert response.status_code == 200
+    assert response.headers["content-type"].startswith("application/zstd")
+    assert list(_unpack(response.content, "tar.zst").values()) == [b"prepared"]